@@ -0,0 +1,155 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "context"
+import "log/slog"
+import "net"
+import "sync/atomic"
+import "time"
+import "github.com/flynn/noise"
+
+/*
+A HandshakeAudit describes a single handshake attempt, see Options.Audit. The
+record of a successful handshake describes the session, it established, see
+Connection.Session.
+*/
+type HandshakeAudit struct{
+	// The address of the peer, if known.
+	RemoteAddr net.Addr
+	// The Noise protocol name, for instance Noise_XX_25519_ChaChaPoly_SHA256.
+	Protocol string
+	Initiator bool
+	// The static public key of the peer, as far as the handshake got to
+	// reveal it; nil if the peer has none. Unless the handshake succeeded,
+	// the key is merely claimed by the peer.
+	PeerStatic []byte
+	// Set, if Options.VerifyPeer accepted PeerStatic.
+	Verified bool
+	// The final handshake hash, that identifies the session, if the
+	// handshake succeeded.
+	HandshakeHash []byte
+	// Whether a pre-shared key was mixed into the handshake.
+	PresharedKey bool
+	// Whether the session uses the inner layer, see Options.InnerPSK.
+	InnerLayer bool
+	// The ID of the Connection, or of the RPC codec, see Connection.ID.
+	ConnID uint64
+	// The name of the RpcFormat of an RPC codec, "" for Connections.
+	Format string
+	// When the handshake started, and how long it took.
+	Start time.Time
+	Duration time.Duration
+	// Nil, if the handshake succeeded, otherwise the reason, it failed.
+	Err error
+}
+
+/*
+Returns the remote address of v, if v has one, as net.Conn does.
+*/
+func addrOf(v interface{}) net.Addr {
+	if a,ok := v.(interface{ RemoteAddr() net.Addr }); ok { return a.RemoteAddr() }
+	return nil
+}
+
+/*
+Starts the audit record of a handshake. Returns the record and the verifier to
+pass to runHandshake, that notes its verdict in the record.
+*/
+func startAudit(o Options, id uint64, addr net.Addr, nc noise.Config) (*HandshakeAudit,func([]byte) error) {
+	verify := o.VerifyPeer
+	a := &HandshakeAudit{ConnID:id,RemoteAddr:addr,Protocol:protocolName(nc),Initiator:nc.Initiator,Start:time.Now()}
+	a.PresharedKey = len(nc.PresharedKey)>0
+	a.InnerLayer = o.InnerPSK!=nil
+	logTo(o.Logger,slog.LevelDebug,"seep: handshake started","conn",a.ConnID,"protocol",a.Protocol,"initiator",a.Initiator)
+	if verify==nil { return a,nil }
+	return a,func(static []byte) error {
+		err := verify(static)
+		a.Verified = err==nil && len(static)>0
+		return err
+	}
+}
+
+/*
+Completes the audit record, passes it to o.Audit, if set, and logs it. Returns
+the logger of the session, see Options.Logger.
+*/
+func (a *HandshakeAudit) finish(o Options, hs *noise.HandshakeState, err error) *slog.Logger {
+	if hs!=nil { a.PeerStatic = append([]byte(nil),hs.PeerStatic()...) }
+	if len(a.PeerStatic)==0 { a.PeerStatic = nil }
+	if hs!=nil && err==nil { a.HandshakeHash = append([]byte(nil),hs.ChannelBinding()...) }
+	a.Err = err
+	a.Duration = time.Since(a.Start)
+	o.Metrics.handshake(err)
+	if o.Audit!=nil { o.Audit(a) }
+	l := a.logger(o.Logger)
+	if err!=nil {
+		logTo(l,slog.LevelWarn,"seep: handshake failed","err",err)
+	} else {
+		logTo(l,slog.LevelInfo,"seep: handshake completed")
+	}
+	return l
+}
+
+/*
+Returns the record of the handshake, that established the current session of
+the Connection (see Upgrade), or nil before the handshake. The record must not
+be modified.
+*/
+func (c *Connection) Session() *HandshakeAudit {
+	return c.session
+}
+
+var lastConnID uint64
+
+func newConnID() uint64 { return atomic.AddUint64(&lastConnID,1) }
+
+/*
+Returns the ID of the Connection: a number, unique within the process and
+assigned on first use, that identifies the Connection in the log (as the
+attribute "conn"), in HandshakeAudit.ConnID, Stats and traces. It stays the
+same across Upgrade.
+*/
+func (c *Connection) ID() uint64 {
+	if id := atomic.LoadUint64(&c.id); id!=0 { return id }
+	atomic.CompareAndSwapUint64(&c.id,0,newConnID())
+	return atomic.LoadUint64(&c.id)
+}
+
+/*
+Calls Options.Trace, if set, for a handshake of c. The returned function must
+be called with the completed record; it replaces c.Context, if the handshake
+succeeded.
+*/
+func (c *Connection) trace(o Options, a *HandshakeAudit) func(*HandshakeAudit) {
+	if o.Trace==nil { return func(*HandshakeAudit) {} }
+	ctx := c.Context
+	if ctx==nil { ctx = context.Background() }
+	end := o.Trace(ctx,a)
+	return func(a *HandshakeAudit) {
+		sctx := end(a)
+		if a.Err==nil && sctx!=nil { c.Context = sctx }
+	}
+}
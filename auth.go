@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/hmac"
+import "crypto/sha256"
+
+var ErrAuthFailed = newError(ErrHandshakeFailed,"seep: password authentication failed")
+
+/*
+Returns the proof of the given side for the password authentication.
+*/
+func authProof(password, hash []byte, initiator bool) []byte {
+	label := "seep-auth responder"
+	if initiator { label = "seep-auth initiator" }
+	m := hmac.New(sha256.New,password)
+	m.Write([]byte(label))
+	m.Write(hash)
+	return m.Sum(nil)
+}
+
+/*
+Proves to the peer, that this side knows the shared password, and verifies,
+that the peer knows it, too, as a second factor in addition to the static
+keys. Both sides must call it right after the handshake, before any other
+data is exchanged (see Options.Password, which does this automatically). It
+is not available for NoiseSocket connections.
+
+The proofs are HMAC-SHA256 over the handshake hash (the channel binding), so
+they are bound to this very session and useless elsewhere. The initiator
+proves first; the responder only sends its proof, if the initiator's was
+valid, and an empty frame otherwise. Use a high-entropy password: a peer,
+that has completed the handshake, can try to guess the password from the
+initiator's proof offline.
+*/
+func (c *Connection) AuthenticatePassword(password []byte) error {
+	w,ok := c.Writer.(*Writer)
+	if !ok { return ErrNotEstablished }
+	r,ok := c.Reader.(*Reader)
+	if !ok || len(c.hash)==0 { return ErrNotEstablished }
+	own := authProof(password,c.hash,c.initiator)
+	peer := authProof(password,c.hash,!c.initiator)
+	if c.initiator {
+		err := w.WriteMessage(own)
+		if err!=nil { return err }
+		p,err := r.readProof()
+		if err!=nil { return err }
+		if !hmac.Equal(p,peer) { return ErrAuthFailed }
+		return nil
+	}
+	p,err := r.readProof()
+	if err!=nil { return err }
+	if !hmac.Equal(p,peer) {
+		w.WriteMessage(nil)
+		return ErrAuthFailed
+	}
+	return w.WriteMessage(own)
+}
+
+/*
+Reads the next frame, bypassing the data received during the handshake.
+*/
+func (r *Reader) readProof() ([]byte,error) {
+	r.lck.Lock(); defer r.lck.Unlock()
+	return r.readFrame()
+}
@@ -0,0 +1,160 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "github.com/flynn/noise"
+
+/*
+Overwrites b with zeros, for instance a private key, that is no longer needed.
+*/
+func Wipe(b []byte) {
+	for i := range b { b[i] = 0 }
+}
+
+/*
+Zeroes a CipherState in place, wiping its key and nonce. The expanded key
+schedule, that the underlying AEAD keeps, is out of reach and left to the
+garbage collector.
+*/
+func burnCipher(cs *noise.CipherState) {
+	if cs!=nil { *cs = noise.CipherState{} }
+}
+
+/*
+Like noise.NewHandshakeState, but the handshake gets a copy of an ephemeral
+key, that the caller supplied (for instance for test vectors), so that
+burnEphemeral doesn't wipe the caller's key.
+*/
+func newHandshakeState(nc noise.Config) *noise.HandshakeState {
+	if nc.EphemeralKeypair.Private!=nil {
+		nc.EphemeralKeypair.Private = append([]byte(nil),nc.EphemeralKeypair.Private...)
+	}
+	return noise.NewHandshakeState(nc)
+}
+
+/*
+Wipes the ephemeral private key of a finished handshake, created by
+newHandshakeState. Static keys belong to the caller and are left alone.
+*/
+func burnEphemeral(hs *noise.HandshakeState) {
+	Wipe(hs.LocalEphemeral().Private)
+}
+
+/*
+Wipes the ephemeral private key of a finished handshake and drops the rest of
+its state (chaining key, handshake hash, ...), that the noise package keeps in
+unexported fields and that therefore can't be overwritten.
+*/
+func burnHandshake(hs *noise.HandshakeState) {
+	burnEphemeral(hs)
+	*hs = noise.HandshakeState{}
+}
+
+/*
+Wipes all memory of b, including the data already consumed, that Bytes no
+longer covers, and empties it. Memory, that b left behind when growing, is out
+of reach.
+*/
+func wipeBuffer(b *bytes.Buffer) {
+	if b==nil { return }
+	b.Reset()
+	p := b.Bytes()
+	Wipe(p[:cap(p)])
+}
+
+func (f *frameWriter) burn() {
+	if f.pipe!=nil {
+		f.pipe.drain()
+		f.pipe = nil
+	}
+	burnCipher(f.enc)
+	f.enc = nil
+	f.inner = nil
+	Wipe(f.ibuf[:cap(f.ibuf)])
+	f.ibuf = nil
+	f.sess.end()
+}
+func (f *frameReader) burn() {
+	if f.ahead!=nil {
+		p := f.wait()
+		if p.b!=nil {
+			Wipe((*p.b)[:cap(*p.b)])
+			readPool.Put(p.b)
+		}
+	}
+	burnCipher(f.dec)
+	f.dec = nil
+	f.stopOpener()
+	f.inner = nil
+	f.sess.end()
+}
+
+/*
+Wipes the sending key and the buffered plaintext. Frames already queued are
+written first, buffered data is discarded. The Writer is unusable afterwards;
+writes return ErrNotEstablished. Close calls Burn after sending the close
+frame.
+*/
+func (w *Writer) Burn() {
+	w.lck.Lock(); defer w.lck.Unlock()
+	if w.timer!=nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	Wipe(w.wbuf[:cap(w.wbuf)])
+	w.wbuf = nil
+	w.burn()
+}
+
+/*
+Wipes the receiving key and the plaintext, that has not been read yet. The
+Reader is unusable afterwards; reads return ErrNotEstablished, or the end of
+the stream, if it has been reached. A Reader wipes its key by itself, once it
+receives a close or error frame.
+*/
+func (r *Reader) Burn() {
+	r.lck.Lock(); defer r.lck.Unlock()
+	Wipe(r.buf.Bytes())
+	r.buf = bytes.Buffer{}
+	if r.pooled!=nil { Wipe((*r.pooled)[:cap(*r.pooled)]) }
+	r.release()
+	r.burn()
+}
+
+/*
+Burns the Writer and the Reader of the connection, the handshake payloads and
+the handshake hash. See Writer.Burn and Reader.Burn.
+*/
+func (c *Connection) Burn() {
+	if w,ok := c.Writer.(*Writer); ok { w.Burn() }
+	if r,ok := c.Reader.(*Reader); ok { r.Burn() }
+	for _,p := range c.Payloads { Wipe(p.Payload) }
+	c.Payloads = nil
+	Wipe(c.hash)
+	c.hash = nil
+	wipeBuffer(c.outbuf)
+	wipeBuffer(c.inbuf)
+}
@@ -0,0 +1,274 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Measures the handshake rate, the streaming throughput and the RPC round trip
+time of SEEP across cipher suites and RPC formats, to validate the sizing of
+the hardware of a deployment.
+
+	seep-bench                                # both sides on this host
+	seep-bench -listen :7001                  # the server side, on one host,
+	seep-bench -connect server:7001           # and the client side on another
+	seep-bench -rtt 50ms -bandwidth 1000000   # over an emulated WAN link
+
+Every connection starts with a plaintext line naming the test and the Noise
+protocol (an NN handshake, so no keys are needed), so the server serves any
+combination, that the client asks for. Only run the server in a trusted
+network.
+
+The flags -rtt, -jitter and -bandwidth shape the connections, that this side
+writes to, with seeptest.Shape.
+*/
+package main
+
+import "bufio"
+import "crypto/rand"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "net"
+import "net/rpc"
+import "strings"
+import "testing"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/seepbench"
+import "github.com/mad-day/seep/seeptest"
+import seepjson "github.com/mad-day/seep/codec/json"
+
+var (
+	listen = flag.String("listen","","run the server side only, on this address")
+	connect = flag.String("connect","","run the client side only, against this server")
+	suites = flag.String("suites","25519_ChaChaPoly_BLAKE2s,25519_AESGCM_SHA256","the cipher suites to measure, comma separated")
+	formats = flag.String("formats","xdr,gob,gob-stream,json","the RPC formats to measure, comma separated")
+	size = flag.Int("size",16384,"the size of the writes of the throughput test")
+	rpcSize = flag.Int("rpcsize",128,"the payload size of the RPC test")
+	rtt = flag.Duration("rtt",0,"the emulated round trip time")
+	jitter = flag.Duration("jitter",0,"the emulated jitter")
+	bandwidth = flag.Int64("bandwidth",0,"the emulated bandwidth in bytes per second, 0 for no limit")
+)
+
+/*
+Applies the emulated network conditions, if any, to c.
+*/
+func shape(c net.Conn) net.Conn {
+	s := seeptest.Shape{Seed:time.Now().UnixNano(),RTT:*rtt,Jitter:*jitter,Bandwidth:*bandwidth}
+	if s.RTT==0 && s.Jitter==0 && s.Bandwidth==0 { return c }
+	return s.Wrap(c)
+}
+
+var rpcFormats = map[string]func() *seep.RpcFormat{
+	"xdr": func() *seep.RpcFormat { return seep.XDRFormat },
+	"gob": func() *seep.RpcFormat { return seep.GobFormat },
+	"gob-stream": seep.NewGobStreamFormat,
+	"json": func() *seep.RpcFormat { return seepjson.Format },
+}
+
+/*
+Returns the configuration of an NN handshake using the given cipher suite, such
+as "25519_ChaChaPoly_BLAKE2s".
+*/
+func config(suite string, initiator bool) (noise.Config,error) {
+	p,err := seep.ParseProtocolName("Noise_NN_"+suite)
+	if err!=nil { return noise.Config{},err }
+	return noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Initiator:initiator,Random:rand.Reader},nil
+}
+
+/* ------------------------------------------------------------------------- */
+
+func serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	srv.Register(seepbench.Echo{})
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		c = shape(c)
+		go func() {
+			defer c.Close()
+			err := serveConn(c,srv)
+			if err!=nil && err!=io.EOF { log.Print(err) }
+		}()
+	}
+}
+
+/*
+Serves a single test: reads the line "<test> <suite>" and runs the
+responder's side of the test.
+*/
+func serveConn(c net.Conn, srv *rpc.Server) error {
+	br := bufio.NewReader(c)
+	line,err := br.ReadString('\n')
+	if err!=nil { return err }
+	var test,suite string
+	_,err = fmt.Sscan(line,&test,&suite)
+	if err!=nil { return err }
+	nc,err := config(suite,false)
+	if err!=nil { return err }
+	if strings.HasPrefix(test,"rpc-") {
+		f,ok := rpcFormats[test[4:]]
+		if !ok { return fmt.Errorf("unknown format %q",test[4:]) }
+		codec,err := seep.NewStreamRpcSource(br,c,nc,c,f(),nil)
+		if err!=nil { return err }
+		srv.ServeCodec(codec)
+		return nil
+	}
+	s := &seep.Connection{Options:&seep.Options{Typed:true}}
+	s.Init()
+	err = s.HandshakeStream(br,c,nc)
+	if err!=nil { return err }
+	switch test {
+	case "handshake":
+	case "stream":
+		// Acknowledges the close frame, that ends the stream.
+		_,err = io.Copy(ioutil.Discard,s)
+		if err!=nil { return err }
+		_,err = s.Write([]byte{1})
+	default:
+		return fmt.Errorf("unknown test %q",test)
+	}
+	return err
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+Dials the server and starts the given test.
+*/
+func dial(addr, test, suite string) (net.Conn,noise.Config,error) {
+	nc,err := config(suite,true)
+	if err!=nil { return nil,nc,err }
+	c,err := net.Dial("tcp",addr)
+	if err!=nil { return nil,nc,err }
+	c = shape(c)
+	_,err = fmt.Fprintf(c,"%s %s\n",test,suite)
+	if err!=nil { c.Close() }
+	return c,nc,err
+}
+
+func handshake(addr, suite string) error {
+	c,nc,err := dial(addr,"handshake",suite)
+	if err!=nil { return err }
+	defer c.Close()
+	s := &seep.Connection{Options:&seep.Options{Typed:true}}
+	s.Init()
+	return s.HandshakeStream(c,c,nc)
+}
+
+func benchHandshake(addr, suite string) func(*testing.B) {
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i:=0; i<b.N; i++ {
+			err := handshake(addr,suite)
+			if err!=nil { b.Fatal(err) }
+		}
+	}
+}
+
+func benchStream(addr, suite string) func(*testing.B) {
+	return func(b *testing.B) {
+		c,nc,err := dial(addr,"stream",suite)
+		if err!=nil { b.Fatal(err) }
+		defer c.Close()
+		s := &seep.Connection{Options:&seep.Options{Typed:true}}
+		s.Init()
+		err = s.HandshakeStream(c,c,nc)
+		if err!=nil { b.Fatal(err) }
+		buf := make([]byte,*size)
+		b.SetBytes(int64(*size))
+		b.ResetTimer()
+		for i:=0; i<b.N; i++ {
+			_,err = s.Write(buf)
+			if err!=nil { b.Fatal(err) }
+		}
+		err = s.Writer.(*seep.Writer).Close()
+		if err!=nil { b.Fatal(err) }
+		_,err = io.ReadFull(s,buf[:1])
+		if err!=nil { b.Fatal(err) }
+	}
+}
+
+func benchRPC(addr, suite, format string) func(*testing.B) {
+	return func(b *testing.B) {
+		c,nc,err := dial(addr,"rpc-"+format,suite)
+		if err!=nil { b.Fatal(err) }
+		codec,err := seep.NewStreamRpcClient(c,c,nc,nil,rpcFormats[format](),nil)
+		if err!=nil { c.Close(); b.Fatal(err) }
+		client := rpc.NewClientWithCodec(codec)
+		defer client.Close()
+		req := make([]byte,*rpcSize)
+		var resp []byte
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i:=0; i<b.N; i++ {
+			err = client.Call("Echo.Echo",req,&resp)
+			if err!=nil { b.Fatal(err) }
+		}
+	}
+}
+
+func report(name string, r testing.BenchmarkResult) {
+	if r.N==0 {
+		fmt.Printf("%-48s failed\n",name)
+		return
+	}
+	per := r.NsPerOp()
+	fmt.Printf("%-48s %10d ops %12.1f ops/s %10.1f µs/op",name,r.N,1e9/float64(per),float64(per)/1e3)
+	if r.Bytes>0 { fmt.Printf(" %10.1f MB/s",float64(r.Bytes)*float64(r.N)/r.T.Seconds()/1e6) }
+	fmt.Println()
+}
+
+func run(addr string) {
+	for _,suite := range strings.Split(*suites,",") {
+		_,err := config(suite,true)
+		if err!=nil { log.Fatal(err) }
+		report("handshake "+suite,testing.Benchmark(benchHandshake(addr,suite)))
+		report("stream "+suite,testing.Benchmark(benchStream(addr,suite)))
+		for _,format := range strings.Split(*formats,",") {
+			if rpcFormats[format]==nil { log.Fatalf("unknown format %q",format) }
+			report("rpc "+format+" "+suite,testing.Benchmark(benchRPC(addr,suite,format)))
+		}
+	}
+}
+
+func main() {
+	log.SetPrefix("seep-bench: ")
+	log.SetFlags(0)
+	flag.Parse()
+	switch {
+	case *listen!="":
+		l,err := net.Listen("tcp",*listen)
+		if err!=nil { log.Fatal(err) }
+		log.Fatal(serve(l))
+	case *connect!="":
+		run(*connect)
+	default:
+		l,err := net.Listen("tcp","127.0.0.1:0")
+		if err!=nil { log.Fatal(err) }
+		go serve(l)
+		run(l.Addr().String())
+	}
+}
@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Runs the conformance suite of SEEP against one or more endpoints, for
+instance other implementations of the protocol, and prints a pass/fail matrix
+with a column for every endpoint.
+
+	seep-conform -serve :7000 -key server.key      # a reference endpoint
+	seep-conform -peer <public key> host1:7000 host2:7000
+
+The endpoints must echo every data frame and answer a close frame with their
+own, see seep.ServeConformance. Keys are read as by the seep command. The
+exit status is 1, if any check failed.
+*/
+package main
+
+import "crypto/rand"
+import "errors"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "net"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+var (
+	serve = flag.String("serve","","serve the endpoint side on this address, instead of running the checks")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the endpoint's static public key")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	framing = flag.String("framing","xdr","the framing: xdr, uint16, armor or uvarint")
+	padTo = flag.Int("padto",0,"pad the frames to a multiple of this many bytes")
+	timeout = flag.Duration("timeout",2*time.Second,"the time limit of every check")
+)
+
+var framings = map[string]uint8{
+	"xdr": seep.FramingXDR,
+	"uint16": seep.FramingUint16,
+	"armor": seep.FramingArmor,
+	"uvarint": seep.FramingUvarint,
+}
+
+/*
+Builds the handshake configuration and the options from the flags.
+*/
+func config(initiator bool) (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:initiator}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	f,ok := framings[*framing]
+	if !ok { return nc,nil,fmt.Errorf("unknown framing %q",*framing) }
+	o := &seep.Options{Typed:true,Framing:f,PadTo:*padTo}
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		pre := p.Pattern.InitiatorPreMessages
+		if nc.Initiator { pre = p.Pattern.ResponderPreMessages }
+		if len(pre)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+func runServer(l net.Listener, nc noise.Config, o *seep.Options) error {
+	for {
+		conn,err := l.Accept()
+		if err!=nil { return err }
+		go func() {
+			defer conn.Close()
+			c,err := seep.NewConn(conn,nc,o)
+			if err!=nil { log.Print(err); return }
+			seep.ServeConformance(c.Connection)
+		}()
+	}
+}
+
+func main() {
+	log.SetPrefix("seep-conform: ")
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr,"usage: seep-conform [flags] address...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *serve!="" {
+		nc,o,err := config(false)
+		if err!=nil { log.Fatal(err) }
+		l,err := net.Listen("tcp",*serve)
+		if err!=nil { log.Fatal(err) }
+		log.Fatal(runServer(l,nc,o))
+	}
+	if flag.NArg()==0 { flag.Usage(); os.Exit(2) }
+	nc,o,err := config(true)
+	if err!=nil { log.Fatal(err) }
+	var matrix [][]seep.ConformanceResult
+	for _,addr := range flag.Args() {
+		addr := addr
+		matrix = append(matrix,seep.Conformance(func() (net.Conn,error) {
+			return net.DialTimeout("tcp",addr,*timeout)
+		},nc,o,*timeout))
+	}
+	fmt.Printf("%-16s","")
+	for _,addr := range flag.Args() { fmt.Printf(" %-24s",addr) }
+	fmt.Println()
+	failed := false
+	for i,r := range matrix[0] {
+		fmt.Printf("%-16s",r.Check)
+		for _,res := range matrix {
+			cell := "pass"
+			switch err := res[i].Err; {
+			case err==seep.ErrNotApplicable: cell = "n/a"
+			case err!=nil: cell = "FAIL"; failed = true
+			}
+			fmt.Printf(" %-24s",cell)
+		}
+		fmt.Println()
+	}
+	if !failed { return }
+	fmt.Println()
+	for j,res := range matrix {
+		for _,r := range res {
+			if r.Err==nil || r.Err==seep.ErrNotApplicable { continue }
+			fmt.Printf("%s %s (%s): %v\n",flag.Arg(j),r.Check,r.Description,r.Err)
+		}
+	}
+	os.Exit(1)
+}
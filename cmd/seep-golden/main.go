@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Generates golden vectors (see seep.GenerateGolden) for every supported
+pattern, cipher suite and framing, or verifies a file of them:
+
+	seep-golden > golden.json
+	seep-golden -framing uint16 -protocol Noise_XX_25519_ChaChaPoly_BLAKE2s
+	seep-golden -verify golden.json
+
+The output uses the JSON format of cacophony and snow, extended by the framing
+and the wire bytes of both sides, so seep-interop can replay it as well.
+*/
+package main
+
+import "encoding/json"
+import "flag"
+import "fmt"
+import "os"
+import "strings"
+import "github.com/mad-day/seep"
+
+var (
+	framing = flag.String("framing","","generate the vectors of this framing only (xdr, uint16, armor or uvarint)")
+	protocol = flag.String("protocol","","generate the vectors of these protocols only, comma separated")
+	verify = flag.String("verify","","verify the golden vectors in this file, instead of generating")
+)
+
+var framings = map[string]uint8{
+	"xdr":seep.FramingXDR, "uint16":seep.FramingUint16, "armor":seep.FramingArmor, "uvarint":seep.FramingUvarint,
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr,"seep-golden:",err)
+	os.Exit(1)
+}
+
+func check(name string) {
+	f,err := os.Open(name)
+	if err!=nil { fail(err) }
+	gs,err := seep.LoadGoldenVectors(f)
+	f.Close()
+	if err!=nil { fail(err) }
+	failed := 0
+	for _,g := range gs {
+		err = g.Verify()
+		if err!=nil {
+			fmt.Printf("FAIL %s (%s): %v\n",g.ProtocolName,g.Framing,err)
+			failed++
+		}
+	}
+	fmt.Printf("%s: %d ok, %d failed\n",name,len(gs)-failed,failed)
+	if failed>0 { os.Exit(1) }
+}
+
+func main() {
+	flag.Parse()
+	if *verify!="" {
+		check(*verify)
+		return
+	}
+	names := seep.GoldenProtocols()
+	if *protocol!="" { names = strings.Split(*protocol,",") }
+	frs := seep.GoldenFramings()
+	if *framing!="" {
+		fr,ok := framings[*framing]
+		if !ok { fail(fmt.Errorf("unknown framing %q",*framing)) }
+		frs = []uint8{fr}
+	}
+	var out struct{ Vectors []*seep.GoldenVector `json:"vectors"` }
+	for _,name := range names {
+		for _,fr := range frs {
+			g,err := seep.GenerateGolden(name,fr)
+			if err!=nil { fail(err) }
+			out.Vectors = append(out.Vectors,g)
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("","  ")
+	err := enc.Encode(&out)
+	if err!=nil { fail(err) }
+}
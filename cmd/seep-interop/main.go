@@ -0,0 +1,64 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Replays Noise test vector files (as published by cacophony, snow or noise-c)
+against the handshake and frame layer of SEEP.
+
+	seep-interop snow.json cacophony.json
+
+Vectors using features, that are not supported by github.com/flynn/noise
+(multiple psk modifiers, fallback patterns, 448 keys) are skipped.
+*/
+package main
+
+import "flag"
+import "fmt"
+import "os"
+import "github.com/mad-day/seep"
+
+func main() {
+	flag.Parse()
+	failed := false
+	for _,name := range flag.Args() {
+		f,err := os.Open(name)
+		if err!=nil { fmt.Fprintln(os.Stderr,err); os.Exit(2) }
+		vs,err := seep.LoadVectors(f)
+		f.Close()
+		if err!=nil { fmt.Fprintln(os.Stderr,name,err); os.Exit(2) }
+		ok,skip := 0,0
+		for _,v := range vs {
+			if !v.Supported() { skip++; continue }
+			err = v.Verify()
+			if err!=nil {
+				fmt.Println("FAIL",err)
+				failed = true
+				continue
+			}
+			ok++
+		}
+		fmt.Printf("%s: %d ok, %d skipped, %d failed\n",name,ok,skip,len(vs)-ok-skip)
+	}
+	if failed { os.Exit(1) }
+}
@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Generates static Curve25519 key pairs for SEEP, and converts them between the
+formats of seep.MarshalKey. The public key and its fingerprint are printed to
+stderr.
+
+	seep-keygen > server.key                       # base64, as WireGuard
+	seep-keygen -format encrypted > server.pem
+	seep-keygen -in server.key -format pem         # converts
+	seep-keygen -in server.key -pub                # prints the public key
+
+The passphrase of encrypted keys is read from the file given by -passfile, or
+from the environment variable SEEP_PASSPHRASE.
+*/
+package main
+
+import "crypto/rand"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "strings"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+var (
+	in = flag.String("in","","convert the key in this file, instead of generating one")
+	format = flag.String("format","base64","the output format: base64, pem or encrypted")
+	pub = flag.Bool("pub",false,"print the public key instead of the private key")
+	passFile = flag.String("passfile","","the file holding the passphrase")
+)
+
+var formats = map[string]seep.KeyFormat{
+	"base64": seep.KeyBase64,
+	"pem": seep.KeyPEM,
+	"encrypted": seep.KeyEncrypted,
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr,"seep-keygen:",err)
+	os.Exit(1)
+}
+
+func passphrase() []byte {
+	if *passFile=="" { return []byte(os.Getenv("SEEP_PASSPHRASE")) }
+	b,err := ioutil.ReadFile(*passFile)
+	if err!=nil { fail(err) }
+	return []byte(strings.TrimRight(string(b),"\r\n"))
+}
+
+func main() {
+	flag.Parse()
+	f,ok := formats[*format]
+	if !ok { fail(fmt.Errorf("unknown format %q",*format)) }
+	var k noise.DHKey
+	var err error
+	if *in!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*in)
+		if err!=nil { fail(err) }
+		k,err = seep.ParseKey(b,passphrase())
+	} else {
+		k,err = noise.DH25519.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { fail(err) }
+	var out []byte
+	if *pub {
+		out,err = seep.MarshalPublicKey(k.Public,f)
+	} else {
+		if f==seep.KeyEncrypted && len(passphrase())==0 { fail(fmt.Errorf("no passphrase, see -passfile")) }
+		out,err = seep.MarshalKey(k,f,passphrase())
+	}
+	if err!=nil { fail(err) }
+	os.Stdout.Write(out)
+	pk,_ := seep.MarshalPublicKey(k.Public,seep.KeyBase64)
+	fmt.Fprintf(os.Stderr,"public key:  %sfingerprint: %s\n",pk,seep.Fingerprint(k.Public))
+}
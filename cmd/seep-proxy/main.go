@@ -0,0 +1,178 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Forwards TCP connections through SEEP, so that applications, that don't speak
+it, are encrypted without changes. On the client side, it accepts plaintext
+connections and forwards each of them to a SEEP endpoint:
+
+	seep-proxy -key client.key -peer <server public key> -listen :5432 -connect db.example.com:7000
+
+On the server side (-reverse), it accepts SEEP connections and forwards each of
+them in plaintext to a local service:
+
+	seep-proxy -reverse -key server.key -listen :7000 -connect localhost:5432
+
+The keys are given as for seep (see cmd/seep). Half-closes are forwarded in
+both directions.
+*/
+package main
+
+import "crypto/rand"
+import "errors"
+import "flag"
+import "io"
+import "io/ioutil"
+import "log"
+import "net"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+var (
+	listen = flag.String("listen","","the address to accept connections on")
+	connect = flag.String("connect","","the address to forward connections to")
+	reverse = flag.Bool("reverse",false,"accept SEEP and forward plaintext, instead of the other way")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the peer's static public key, that is required")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	timeout = flag.Duration("timeout",10*time.Second,"the time limit of a handshake")
+)
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:!*reverse}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	o := &seep.Options{Typed:true}
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		// Set only, if the pattern has the peer's key as a pre-message.
+		pre := p.Pattern.InitiatorPreMessages
+		if nc.Initiator { pre = p.Pattern.ResponderPreMessages }
+		if len(pre)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+/*
+Ends the sending direction of c: a SEEP connection sends a close frame, a TCP
+connection a FIN.
+*/
+func closeWrite(c net.Conn) {
+	switch c := c.(type) {
+	case *seep.Conn: c.Writer.(*seep.Writer).Close()
+	case *net.TCPConn: c.CloseWrite()
+	}
+}
+
+/*
+Copies between a and b in both directions, until both are done, and closes
+them.
+*/
+func forward(a, b net.Conn) {
+	done := make(chan struct{})
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst,src)
+		closeWrite(dst)
+		done <- struct{}{}
+	}
+	go cp(a,b)
+	go cp(b,a)
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func serveClient(nc noise.Config, o *seep.Options) error {
+	l,err := net.Listen("tcp",*listen)
+	if err!=nil { return err }
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		go func() {
+			d := net.Dialer{Timeout:*timeout}
+			conn,err := d.Dial("tcp",*connect)
+			if err!=nil { log.Print(err); c.Close(); return }
+			conn.SetDeadline(time.Now().Add(*timeout))
+			s,err := seep.NewConn(conn,nc,o)
+			if err!=nil { log.Print(err); conn.Close(); c.Close(); return }
+			conn.SetDeadline(time.Time{})
+			forward(c,s)
+		}()
+	}
+}
+
+func serveReverse(nc noise.Config, o *seep.Options) error {
+	s := &seep.Server{Config:nc,Options:o,HandshakeTimeout:*timeout,Limiter:new(seep.FailureLimiter)}
+	err := s.Listen("tcp",*listen)
+	if err!=nil { return err }
+	for {
+		c,err := s.Accept()
+		if err!=nil { return err }
+		go func() {
+			p,err := net.DialTimeout("tcp",*connect,*timeout)
+			if err!=nil { log.Print(err); c.Close(); return }
+			forward(c,p)
+		}()
+	}
+}
+
+func main() {
+	log.SetPrefix("seep-proxy: ")
+	flag.Parse()
+	if *listen=="" || *connect=="" { flag.Usage(); os.Exit(2) }
+	nc,o,err := config()
+	if err!=nil { log.Fatal(err) }
+	if *reverse {
+		err = serveReverse(nc,o)
+	} else {
+		err = serveClient(nc,o)
+	}
+	log.Fatal(err)
+}
@@ -0,0 +1,59 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A relay, that forwards the ciphertext of SEEP sessions between endpoints,
+which can't reach each other directly (see package relay).
+
+	seep-relay -listen :7001 -require-proof
+
+The endpoints may be seep with -relay:
+
+	seep -relay -l -key server.key relay.example.com:7001
+	seep -relay -peer <server public key> relay.example.com:7001
+*/
+package main
+
+import "flag"
+import "log"
+import "net"
+import "time"
+import "github.com/mad-day/seep/relay"
+
+var (
+	listen = flag.String("listen",":7001","the address to accept endpoints on")
+	wait = flag.Duration("wait",time.Minute,"how long an endpoint waits for its peer")
+	maxWaiting = flag.Int("max-waiting",16,"the most endpoints waiting for the same peer")
+	maxTotal = flag.Int("max-total",1024,"the most endpoints waiting in total")
+	requireProof = flag.Bool("require-proof",false,"turn away listeners, that don't prove their key")
+)
+
+func main() {
+	log.SetPrefix("seep-relay: ")
+	flag.Parse()
+	l,err := net.Listen("tcp",*listen)
+	if err!=nil { log.Fatal(err) }
+	s := &relay.Server{Wait:*wait,MaxWaiting:*maxWaiting,MaxTotal:*maxTotal,RequireProof:*requireProof}
+	log.Fatal(s.Serve(l))
+}
@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A rendezvous Coordinator, with which peers behind NATs register, and which
+introduces them to each other for UDP hole punching (see package punch). It
+accepts SEEP sessions on TCP and the observation packets on UDP, on the same
+address.
+
+	seep-rendezvous -listen :7002 -key rendezvous.key
+
+The keys are given as for seep (see cmd/seep); peers must authenticate with
+a static key, so the protocol must transmit the initiator's one.
+*/
+package main
+
+import "crypto/rand"
+import "flag"
+import "io/ioutil"
+import "log"
+import "net"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/punch"
+
+var (
+	listen = flag.String("listen",":7002","the TCP and UDP address to accept peers on")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	timeout = flag.Duration("timeout",10*time.Second,"the time limit of a handshake")
+)
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	return nc,err
+}
+
+func main() {
+	log.SetPrefix("seep-rendezvous: ")
+	flag.Parse()
+	nc,err := config()
+	if err!=nil { log.Fatal(err) }
+	pc,err := net.ListenPacket("udp",*listen)
+	if err!=nil { log.Fatal(err) }
+	l,err := net.Listen("tcp",*listen)
+	if err!=nil { log.Fatal(err) }
+	s := &seep.Server{Config:nc,Options:&seep.Options{Typed:true},HandshakeTimeout:*timeout}
+	s.Start(l)
+	co := new(punch.Coordinator)
+	go func() { log.Fatal(co.ServePacket(pc)) }()
+	log.Fatal(co.Serve(s))
+}
@@ -0,0 +1,127 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Calls a method of a SEEP RPC server, that uses the JSON format (see
+codec/json), and prints the result: a curl for encrypted RPC services.
+
+	seep-rpc -peer <server public key> localhost:7000 Arith.Multiply '{"A":6,"B":7}'
+
+The argument defaults to null. The keys are given as for seep (see cmd/seep).
+The result is printed indented, unless -compact is set. RPC errors are
+printed to stderr, with the exit status 1.
+*/
+package main
+
+import "bytes"
+import "crypto/rand"
+import "encoding/json"
+import "errors"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "net"
+import "net/rpc"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import seepjson "github.com/mad-day/seep/codec/json"
+
+var (
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the peer's static public key, that is required")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	timeout = flag.Duration("timeout",30*time.Second,"the time limit of the call, including the handshake")
+	compact = flag.Bool("compact",false,"print the result on a single line")
+)
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr,"seep-rpc:",err)
+	os.Exit(1)
+}
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:true}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	o := new(seep.Options)
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		if len(p.Pattern.ResponderPreMessages)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr,"usage: seep-rpc [flags] address method [json-argument]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg()<2 || flag.NArg()>3 { flag.Usage(); os.Exit(2) }
+	arg := json.RawMessage("null")
+	if flag.NArg()==3 { arg = json.RawMessage(flag.Arg(2)) }
+	if !json.Valid(arg) { fail(errors.New("the argument is not valid JSON")) }
+	nc,o,err := config()
+	if err!=nil { fail(err) }
+	conn,err := net.DialTimeout("tcp",flag.Arg(0),*timeout)
+	if err!=nil { fail(err) }
+	conn.SetDeadline(time.Now().Add(*timeout))
+	codec,err := seep.NewStreamRpcClient(conn,conn,nc,nil,seepjson.Format,o)
+	if err!=nil { conn.Close(); fail(err) }
+	c := rpc.NewClientWithCodec(codec)
+	defer c.Close()
+	var reply json.RawMessage
+	err = c.Call(flag.Arg(1),arg,&reply)
+	if err!=nil { fail(err) }
+	if !*compact {
+		var b bytes.Buffer
+		if json.Indent(&b,reply,"","  ")==nil { reply = b.Bytes() }
+	}
+	os.Stdout.Write(append(reply,'\n'))
+}
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A reverse proxy, that accepts plaintext or TLS HTTP and forwards the requests
+to upstreams over SEEP, verifying their static keys.
+
+	seep-rproxy -listen :8080 -key proxy.key -upstream 10.0.0.5:7000=<public key> -upstream 10.0.0.6:7000=<public key>
+	seep-rproxy -listen :443 -cert cert.pem -certkey key.pem -key proxy.key -upstream ...
+
+The upstreams may be seephttp servers, or seep-proxy -reverse in front of a
+plaintext service. The keys are given as for seep (see cmd/seep); an upstream
+without "=<public key>" is not verified.
+*/
+package main
+
+import "crypto/rand"
+import "errors"
+import "flag"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "os"
+import "strings"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/seephttp"
+
+/*
+The upstreams of -upstream, each "address[=public key]".
+*/
+type upstreams []seephttp.Upstream
+func (u *upstreams) String() string { return "" }
+func (u *upstreams) Set(s string) error {
+	up := seephttp.Upstream{Addr:s}
+	if i := strings.Index(s,"="); i>=0 {
+		k,err := seep.ParsePublicKey([]byte(s[i+1:]))
+		if err!=nil { return err }
+		up = seephttp.Upstream{Addr:s[:i],Key:k}
+	}
+	*u = append(*u,up)
+	return nil
+}
+
+var (
+	listen = flag.String("listen",":8080","the address to accept HTTP on")
+	certFile = flag.String("cert","","the TLS certificate; serves plaintext HTTP, if empty")
+	certKey = flag.String("certkey","","the private key of the TLS certificate")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	timeout = flag.Duration("timeout",10*time.Second,"the time limit of a handshake")
+	ups upstreams
+)
+
+func init() {
+	flag.Var(&ups,"upstream","an upstream, as address=public key; may be repeated")
+}
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:true}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,err }
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,nil
+}
+
+func main() {
+	log.SetPrefix("seep-rproxy: ")
+	flag.Parse()
+	if len(ups)==0 { flag.Usage(); os.Exit(2) }
+	nc,err := config()
+	if err!=nil { log.Fatal(err) }
+	p := &seephttp.Proxy{Upstreams:ups,Config:nc,Options:&seep.Options{Typed:true},HandshakeTimeout:*timeout}
+	srv := &http.Server{Addr:*listen,Handler:p,ReadHeaderTimeout:*timeout}
+	if *certFile!="" {
+		log.Fatal(srv.ListenAndServeTLS(*certFile,*certKey))
+	}
+	log.Fatal(srv.ListenAndServe())
+}
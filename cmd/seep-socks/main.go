@@ -0,0 +1,144 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+An encrypted SOCKS5 proxy: a local SOCKS5 listener, whose connections are
+carried through a SEEP session to an exit node, that connects them to their
+destinations.
+
+	seep-socks -exit :7000 -key exit.key -allow <client public key>
+	seep-socks -key client.key -peer <exit public key> exit.example.com:7000
+	curl --socks5-hostname 127.0.0.1:1080 https://example.com/
+
+The keys are given as for seep (see cmd/seep). Without -allow, every peer,
+that completes the handshake, may use the exit node.
+*/
+package main
+
+import "crypto/rand"
+import "errors"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "net"
+import "os"
+import "strings"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/tunnel"
+
+var (
+	exit = flag.String("exit","","run the exit node, accepting sessions on this address")
+	listen = flag.String("listen","127.0.0.1:1080","the address of the SOCKS5 listener")
+	allow = flag.String("allow","","the public keys of the peers, that may use the exit node, comma separated")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the peer's static public key, that is required")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	timeout = flag.Duration("timeout",10*time.Second,"the time limit of a handshake, and of the connections of the exit node")
+)
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:*exit==""}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	o := &seep.Options{Typed:true}
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		// Set only, if the pattern has the peer's key as a pre-message.
+		pre := p.Pattern.InitiatorPreMessages
+		if nc.Initiator { pre = p.Pattern.ResponderPreMessages }
+		if len(pre)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+func runExit(nc noise.Config, o *seep.Options) error {
+	d := &net.Dialer{Timeout:*timeout}
+	e := &tunnel.Exit{Dial:d.Dial}
+	if *allow!="" {
+		var keys [][]byte
+		for _,s := range strings.Split(*allow,",") {
+			k,err := seep.ParsePublicKey([]byte(s))
+			if err!=nil { return err }
+			keys = append(keys,k)
+		}
+		pinned := seep.PinnedPeer(keys...)
+		e.Allow = func(peer []byte, addr string) error { return pinned(peer) }
+	}
+	s := &seep.Server{Config:nc,Options:o,HandshakeTimeout:*timeout,Limiter:new(seep.FailureLimiter)}
+	err := s.Listen("tcp",*exit)
+	if err!=nil { return err }
+	return e.Serve(s)
+}
+
+func runSOCKS(addr string, nc noise.Config, o *seep.Options) error {
+	l,err := net.Listen("tcp",*listen)
+	if err!=nil { return err }
+	c,err := seep.Dial("tcp",addr,nc,o)
+	if err!=nil { return err }
+	log.Printf("SOCKS5 on %s, exit %s",l.Addr(),addr)
+	return tunnel.NewSOCKS(c).Serve(l)
+}
+
+func main() {
+	log.SetPrefix("seep-socks: ")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr,"usage: seep-socks -exit address [flags]")
+		fmt.Fprintln(os.Stderr,"       seep-socks [flags] exit-address")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	nc,o,err := config()
+	if err!=nil { log.Fatal(err) }
+	if *exit!="" {
+		log.Fatal(runExit(nc,o))
+	}
+	if flag.NArg()!=1 { flag.Usage(); os.Exit(2) }
+	log.Fatal(runSOCKS(flag.Arg(0),nc,o))
+}
@@ -0,0 +1,163 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Remote port forwarding over SEEP, like "ssh -R": a host behind a NAT makes a
+local service reachable on a port of a public server.
+
+	seep-tunnel -serve :7000 -key server.key -allow <client public key>
+	seep-tunnel -key client.key -peer <server public key> -R :8080=localhost:80 public.example.com:7000
+
+-R may be repeated. The keys are given as for seep (see cmd/seep). Without
+-allow, every peer, that completes the handshake, may listen on any port.
+*/
+package main
+
+import "crypto/rand"
+import "errors"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "os"
+import "strings"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/tunnel"
+
+/*
+The forwardings of -R, each "remote=local".
+*/
+type forwards []string
+func (f *forwards) String() string { return strings.Join(*f,",") }
+func (f *forwards) Set(s string) error {
+	if !strings.Contains(s,"=") { return errors.New("expected remote=local") }
+	*f = append(*f,s)
+	return nil
+}
+
+var (
+	serve = flag.String("serve","","run the public side, accepting sessions on this address")
+	allow = flag.String("allow","","the public keys of the peers, that may forward ports, comma separated")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the peer's static public key, that is required")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	timeout = flag.Duration("timeout",10*time.Second,"the time limit of a handshake")
+	remote forwards
+)
+
+func init() {
+	flag.Var(&remote,"R","forward connections to the remote address to the local address, as remote=local")
+}
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:*serve==""}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	o := &seep.Options{Typed:true}
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		// Set only, if the pattern has the peer's key as a pre-message.
+		pre := p.Pattern.InitiatorPreMessages
+		if nc.Initiator { pre = p.Pattern.ResponderPreMessages }
+		if len(pre)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+func runServer(nc noise.Config, o *seep.Options) error {
+	srv := new(tunnel.Server)
+	if *allow!="" {
+		var keys [][]byte
+		for _,s := range strings.Split(*allow,",") {
+			k,err := seep.ParsePublicKey([]byte(s))
+			if err!=nil { return err }
+			keys = append(keys,k)
+		}
+		pinned := seep.PinnedPeer(keys...)
+		srv.Allow = func(peer []byte, addr string) error {
+			if pinned(peer)!=nil { return tunnel.ErrDenied }
+			return nil
+		}
+	}
+	s := &seep.Server{Config:nc,Options:o,HandshakeTimeout:*timeout,Limiter:new(seep.FailureLimiter)}
+	err := s.Listen("tcp",*serve)
+	if err!=nil { return err }
+	return srv.Serve(s)
+}
+
+func runClient(addr string, nc noise.Config, o *seep.Options) error {
+	c,err := seep.Dial("tcp",addr,nc,o)
+	if err!=nil { return err }
+	client := tunnel.NewClient(c)
+	defer client.Close()
+	for _,f := range remote {
+		i := strings.Index(f,"=")
+		fw,err := client.Forward(f[:i],f[i+1:])
+		if err!=nil { return err }
+		log.Printf("forwarding %s to %s",fw.Addr,fw.Local)
+	}
+	return client.Wait()
+}
+
+func main() {
+	log.SetPrefix("seep-tunnel: ")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr,"usage: seep-tunnel -serve address [flags]")
+		fmt.Fprintln(os.Stderr,"       seep-tunnel -R remote=local [flags] address")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	nc,o,err := config()
+	if err!=nil { log.Fatal(err) }
+	if *serve!="" {
+		log.Fatal(runServer(nc,o))
+	}
+	if flag.NArg()!=1 || len(remote)==0 { flag.Usage(); os.Exit(2) }
+	log.Fatal(runClient(flag.Arg(0),nc,o))
+}
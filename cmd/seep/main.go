@@ -0,0 +1,180 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+An encrypted netcat: connects to (or, with -l, listens on) an address, runs a
+SEEP handshake and copies stdin to the peer and the peer's data to stdout.
+
+	seep-keygen > server.key
+	seep -l -key server.key :7000
+	seep -peer <server public key> localhost:7000
+
+The key file may be in any format of seep-keygen; the passphrase of an
+encrypted key is read from the environment variable SEEP_PASSPHRASE. Public
+and preshared keys are base64 encoded. Without -key, a new static key is
+generated. With -peer, the peer must authenticate with that static key;
+the patterns, where the initiator knows the responder's key in advance (NK,
+KK, IK, ...), require it.
+
+With -relay, the address is that of a relay (see cmd/seep-relay), and the
+peers meet there: the listener by its static key, that should thus be given
+with -key, the other one by -peer.
+*/
+package main
+
+import "crypto/rand"
+import "encoding/base64"
+import "errors"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/relay"
+import "github.com/mad-day/seep/seepkcp"
+
+var (
+	listen = flag.Bool("l",false,"listen for a single connection instead of connecting")
+	network = flag.String("net","tcp","the network, see net.Dial, or kcp for KCP over UDP")
+	protocol = flag.String("protocol","Noise_XX_25519_ChaChaPoly_BLAKE2s","the Noise protocol name")
+	keyFile = flag.String("key","","the file holding the static private key")
+	peerKey = flag.String("peer","","the peer's static public key, that is required")
+	pskFile = flag.String("psk","","the file holding the preshared key, for psk patterns")
+	verbose = flag.Bool("v",false,"report the keys and the session on stderr")
+	viaRelay = flag.Bool("relay",false,"meet the peer at the relay at the address")
+)
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr,"seep:",err)
+	os.Exit(1)
+}
+
+/*
+Builds the handshake configuration from the flags.
+*/
+func config() (noise.Config,*seep.Options,error) {
+	p,err := seep.ParseProtocolName(*protocol)
+	if err!=nil { return noise.Config{},nil,err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Random:rand.Reader,Initiator:!*listen}
+	if *keyFile!="" {
+		var b []byte
+		b,err = ioutil.ReadFile(*keyFile)
+		if err!=nil { return nc,nil,err }
+		nc.StaticKeypair,err = seep.ParseKey(b,[]byte(os.Getenv("SEEP_PASSPHRASE")))
+	} else {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(rand.Reader)
+	}
+	if err!=nil { return nc,nil,err }
+	if *verbose { fmt.Fprintln(os.Stderr,"seep: local key",base64.StdEncoding.EncodeToString(nc.StaticKeypair.Public)) }
+	o := &seep.Options{Typed:true}
+	if *peerKey!="" {
+		k,err := seep.ParsePublicKey([]byte(*peerKey))
+		if err!=nil { return nc,nil,err }
+		// Set only, if the pattern has the peer's key as a pre-message.
+		pre := p.Pattern.InitiatorPreMessages
+		if nc.Initiator { pre = p.Pattern.ResponderPreMessages }
+		if len(pre)>0 { nc.PeerStatic = k }
+		o.VerifyPeer = seep.PinnedPeer(k)
+	}
+	if p.PSKPlacement>=0 {
+		if *pskFile=="" { return nc,nil,errors.New("the protocol requires -psk") }
+		b,err := ioutil.ReadFile(*pskFile)
+		if err!=nil { return nc,nil,err }
+		// Encoded just like a public key.
+		nc.PresharedKey,err = seep.ParsePublicKey(b)
+		if err!=nil { return nc,nil,err }
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,o,nil
+}
+
+func connect(addr string, nc noise.Config) (net.Conn,error) {
+	if *viaRelay { return connectRelay(addr,nc) }
+	if *network=="kcp" { return connectKCP(addr) }
+	if !*listen { return net.Dial(*network,addr) }
+	l,err := net.Listen(*network,addr)
+	if err!=nil { return nil,err }
+	defer l.Close()
+	return l.Accept()
+}
+
+func connectKCP(addr string) (net.Conn,error) {
+	if !*listen { return seepkcp.DialKCP(addr,&seepkcp.Fast) }
+	l,err := seepkcp.Listen(addr,&seepkcp.Fast)
+	if err!=nil { return nil,err }
+	// Not closed: the sessions share the listener's socket.
+	return l.Accept()
+}
+
+func connectRelay(addr string, nc noise.Config) (net.Conn,error) {
+	if *listen {
+		l := relay.NewKeyListener("tcp",addr,nc.StaticKeypair)
+		defer l.Close()
+		return l.Accept()
+	}
+	if *peerKey=="" { return nil,errors.New("-relay requires -peer or -l") }
+	k,err := seep.ParsePublicKey([]byte(*peerKey))
+	if err!=nil { return nil,err }
+	return relay.Dial("tcp",addr,relay.KeyToken(k))
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr,"usage: seep [flags] address")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg()!=1 { flag.Usage(); os.Exit(2) }
+	nc,o,err := config()
+	if err!=nil { fail(err) }
+	conn,err := connect(flag.Arg(0),nc)
+	if err!=nil { fail(err) }
+	c,err := seep.NewConn(conn,nc,o)
+	if err!=nil { conn.Close(); fail(err) }
+	// Over KCP, the last frames are retransmitted after Close only as long
+	// as the process lives.
+	if *network=="kcp" { defer time.Sleep(time.Second) }
+	defer c.Close()
+	if *verbose {
+		s := c.Session()
+		fmt.Fprintln(os.Stderr,"seep: established",s.Protocol)
+		if s.PeerStatic!=nil { fmt.Fprintln(os.Stderr,"seep: peer key",base64.StdEncoding.EncodeToString(s.PeerStatic)) }
+	}
+	sent := make(chan error,1)
+	go func() {
+		// Half-close: the close frame ends the peer's output only.
+		_,err := io.Copy(c,os.Stdin)
+		if err==nil { err = c.Writer.(*seep.Writer).Close() }
+		sent <- err
+	}()
+	_,err = io.Copy(os.Stdout,c)
+	if err!=nil { fail(err) }
+	// The peer may still read, until stdin ends.
+	err = <-sent
+	if err!=nil { fail(err) }
+}
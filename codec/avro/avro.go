@@ -0,0 +1,180 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+An Avro based RPC format for SEEP, with schema negotiation. Every Go type, that
+is sent as a body, is registered together with its Avro schema. During the
+setup of a codec, both sides exchange their writer schemas, and every body is
+preceded by the fingerprint of its writer schema. The receiver decodes a body
+by resolving the writer schema against the schema registered for the target
+type, so both sides may evolve their schemas independently, as long as they
+stay compatible by the Avro schema resolution rules.
+
+	s := avro.NewSchemas()
+	err := s.Register(Point{},`{"type":"record","name":"Point","fields":[...]}`)
+	codec,err := seep.NewFormatRpcClient(src,dst,cfg,conn,s.Format(),nil)
+*/
+package avro
+
+import "bytes"
+import "errors"
+import "fmt"
+import "reflect"
+import "sync"
+import "github.com/mad-day/seep"
+import "github.com/davecgh/go-xdr/xdr2"
+import havro "github.com/hamba/avro/v2"
+
+var ErrUnknownSchema = errors.New("seep/avro: unknown writer schema")
+
+type fingerprint [32]byte
+
+/*
+A set of Go types and their Avro schemas.
+*/
+type Schemas struct{
+	lck sync.RWMutex
+	types map[reflect.Type]havro.Schema
+}
+func NewSchemas() *Schemas {
+	return &Schemas{types:make(map[reflect.Type]havro.Schema)}
+}
+
+/*
+Associates the type of v (or the type v points to) with the given schema.
+*/
+func (s *Schemas) Register(v interface{}, schema string) error {
+	sch,err := havro.Parse(schema)
+	if err!=nil { return err }
+	t := reflect.TypeOf(v)
+	if t.Kind()==reflect.Ptr { t = t.Elem() }
+	s.lck.Lock(); defer s.lck.Unlock()
+	s.types[t] = sch
+	return nil
+}
+func (s *Schemas) lookup(t reflect.Type) havro.Schema {
+	if t.Kind()==reflect.Ptr { t = t.Elem() }
+	s.lck.RLock(); defer s.lck.RUnlock()
+	return s.types[t]
+}
+func (s *Schemas) canonical() []string {
+	s.lck.RLock(); defer s.lck.RUnlock()
+	l := make([]string,0,len(s.types))
+	for _,sch := range s.types { l = append(l,sch.String()) }
+	return l
+}
+
+/*
+Returns a new RpcFormat using these schemas. The format keeps the schemas of
+the peer, so every codec needs its own format.
+*/
+func (s *Schemas) Format() *seep.RpcFormat {
+	c := &conn{s:s,remote:make(map[fingerprint]havro.Schema),resolved:make(map[[2]fingerprint]havro.Schema)}
+	return &seep.RpcFormat{Name:"avro",Encode:c.encode,Decode:c.decode,Negotiate:c.negotiate}
+}
+
+type conn struct{
+	s *Schemas
+	lck sync.Mutex
+	remote map[fingerprint]havro.Schema
+	resolved map[[2]fingerprint]havro.Schema
+}
+
+func (c *conn) negotiate(initiator bool, send func([]byte) error, recv func() ([]byte,error)) error {
+	buf := new(bytes.Buffer)
+	_,err := xdr.Marshal(buf,c.s.canonical())
+	if err!=nil { return err }
+	if initiator {
+		err = send(buf.Bytes())
+		if err!=nil { return err }
+	}
+	b,err := recv()
+	if err!=nil { return err }
+	var remote []string
+	_,err = xdr.UnmarshalLimited(bytes.NewReader(b),&remote,uint(len(b)))
+	if err!=nil { return err }
+	for _,str := range remote {
+		sch,err := havro.Parse(str)
+		if err!=nil { return fmt.Errorf("seep/avro: peer schema: %w",err) }
+		c.remote[sch.Fingerprint()] = sch
+	}
+	if !initiator {
+		err = send(buf.Bytes())
+		if err!=nil { return err }
+	}
+	return nil
+}
+
+/*
+Returns the schema, that decodes data written with the writer schema fp into
+a value of the reader schema.
+*/
+func (c *conn) resolve(fp fingerprint, reader havro.Schema) (havro.Schema,error) {
+	rfp := reader.Fingerprint()
+	if rfp==fp { return reader,nil }
+	c.lck.Lock(); defer c.lck.Unlock()
+	if sch,ok := c.resolved[[2]fingerprint{fp,rfp}]; ok { return sch,nil }
+	writer,ok := c.remote[fp]
+	if !ok { return nil,ErrUnknownSchema }
+	sch,err := havro.NewSchemaCompatibility().Resolve(reader,writer)
+	if err!=nil { return nil,err }
+	c.resolved[[2]fingerprint{fp,rfp}] = sch
+	return sch,nil
+}
+
+func (c *conn) encode(h *seep.Header, i interface{}) ([]byte,error) {
+	dst := new(bytes.Buffer)
+	_,err := xdr.Marshal(dst,h)
+	if err!=nil { return nil,err }
+	switch i.(type) {
+	case nil,struct{}:
+		dst.Write(make([]byte,len(fingerprint{})))
+		return dst.Bytes(),nil
+	}
+	sch := c.s.lookup(reflect.TypeOf(i))
+	if sch==nil { return nil,fmt.Errorf("seep/avro: no schema registered for %T",i) }
+	fp := sch.Fingerprint()
+	dst.Write(fp[:])
+	buf,err := havro.Marshal(sch,i)
+	if err!=nil { return nil,err }
+	dst.Write(buf)
+	return dst.Bytes(),nil
+}
+func (c *conn) decode(b []byte,h *seep.Header) (error,func(i interface{}) error) {
+	n,err := xdr.UnmarshalLimited(bytes.NewReader(b),h,uint(len(b)))
+	if err!=nil { return err,nil }
+	b = b[n:]
+	var fp fingerprint
+	if len(b)<len(fp) { return errors.New("seep/avro: short frame"),nil }
+	copy(fp[:],b)
+	b = b[len(fp):]
+	return nil,func(i interface{}) error {
+		if i==nil || fp==(fingerprint{}) { return nil }
+		reader := c.s.lookup(reflect.TypeOf(i))
+		if reader==nil { return fmt.Errorf("seep/avro: no schema registered for %T",i) }
+		sch,err := c.resolve(fp,reader)
+		if err!=nil { return err }
+		return havro.Unmarshal(sch,b,i)
+	}
+}
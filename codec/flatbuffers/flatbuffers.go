@@ -0,0 +1,120 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A FlatBuffers based RPC format for SEEP. The Header is encoded using XDR, the
+body is a finished FlatBuffer, that follows the header in the same frame.
+
+Bodies are written from a *flatbuffers.Builder (after Finish()), a []byte
+holding a finished buffer or a Marshaler. Bodies are read into any type
+having the Init method of generated FlatBuffers tables, such as
+
+	func (rcv *Monster) Init(buf []byte, i flatbuffers.UOffsetT)
+
+The table then refers to the decrypted frame directly, so no deserialization
+copies are made on the receiving side. A *[]byte receives the raw buffer.
+*/
+package flatbuffers
+
+import "bytes"
+import "errors"
+import "io"
+import "net/rpc"
+import "github.com/mad-day/seep"
+import "github.com/flynn/noise"
+import "github.com/davecgh/go-xdr/xdr2"
+import fb "github.com/google/flatbuffers/go"
+
+var ErrUnsupportedType = errors.New("seep/flatbuffers: unsupported body type")
+
+/*
+Types implementing Marshaler serialize themselves into a Builder and
+return the offset of the root table.
+*/
+type Marshaler interface{
+	MarshalFlatBuffer(b *fb.Builder) fb.UOffsetT
+}
+
+/*
+Implemented by generated FlatBuffers tables.
+*/
+type Table interface{
+	Init(buf []byte, i fb.UOffsetT)
+}
+
+var Format = &seep.RpcFormat{Name:"flatbuffers",Encode:encode,Decode:decode}
+
+func body(i interface{}) ([]byte,error) {
+	switch v := i.(type) {
+	case nil,struct{}: return nil,nil
+	case []byte: return v,nil
+	case *fb.Builder: return v.FinishedBytes(),nil
+	case Marshaler:
+		b := fb.NewBuilder(0)
+		b.Finish(v.MarshalFlatBuffer(b))
+		return b.FinishedBytes(),nil
+	}
+	return nil,ErrUnsupportedType
+}
+func encode(h *seep.Header, i interface{}) ([]byte,error) {
+	buf,err := body(i)
+	if err!=nil { return nil,err }
+	dst := new(bytes.Buffer)
+	_,err = xdr.Marshal(dst,h)
+	if err!=nil { return nil,err }
+	dst.Write(buf)
+	return dst.Bytes(),nil
+}
+func decode(b []byte,h *seep.Header) (error,func(i interface{}) error) {
+	n,err := xdr.UnmarshalLimited(bytes.NewReader(b),h,uint(len(b)))
+	if err!=nil { return err,nil }
+	b = b[n:]
+	return nil,func(i interface{}) error {
+		switch v := i.(type) {
+		case nil: return nil
+		case *[]byte: *v = b; return nil
+		case Table:
+			if len(b)<fb.SizeUOffsetT { return io.ErrUnexpectedEOF }
+			v.Init(b,fb.GetUOffsetT(b))
+			return nil
+		}
+		return ErrUnsupportedType
+	}
+}
+
+/*
+Creates a client side RPC codec, that uses FlatBuffers as format to encode
+structures.
+*/
+func NewRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ClientCodec,error) {
+	return seep.NewFormatRpcClient(src,dst,nc,c,Format,nil)
+}
+
+/*
+Creates a server side RPC codec, that uses FlatBuffers as format to encode
+structures.
+*/
+func NewRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ServerCodec,error) {
+	return seep.NewFormatRpcSource(src,dst,nc,c,Format,nil)
+}
@@ -0,0 +1,78 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A JSON based RPC format for SEEP. Every request and response is a single JSON
+object of the form
+
+	{ "h": <header>, "b": <body> }
+
+so the frames can be read by any JSON library, and generic tools (see
+cmd/seep-rpc) can pass bodies through as json.RawMessage.
+*/
+package json
+
+import "io"
+import "net/rpc"
+import ejson "encoding/json"
+import "github.com/mad-day/seep"
+import "github.com/flynn/noise"
+import "github.com/davecgh/go-xdr/xdr2"
+
+type envelope struct{
+	H *seep.Header `json:"h"`
+	B interface{}  `json:"b"`
+}
+type rawEnvelope struct{
+	H *seep.Header       `json:"h"`
+	B ejson.RawMessage `json:"b"`
+}
+
+var Format = &seep.RpcFormat{Name:"json",Encode:encode,Decode:decode}
+
+func encode(h *seep.Header, i interface{}) ([]byte,error) {
+	return ejson.Marshal(envelope{h,i})
+}
+func decode(b []byte,h *seep.Header) (error,func(i interface{}) error) {
+	env := rawEnvelope{H:h}
+	err := ejson.Unmarshal(b,&env)
+	return err,func(i interface{}) error {
+		if i==nil || env.B==nil { return nil }
+		return ejson.Unmarshal(env.B,i)
+	}
+}
+
+/*
+Creates a client side RPC codec, that uses JSON as format to encode structures.
+*/
+func NewRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ClientCodec,error) {
+	return seep.NewFormatRpcClient(src,dst,nc,c,Format,nil)
+}
+
+/*
+Creates a server side RPC codec, that uses JSON as format to encode structures.
+*/
+func NewRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ServerCodec,error) {
+	return seep.NewFormatRpcSource(src,dst,nc,c,Format,nil)
+}
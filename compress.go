@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "compress/gzip"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "sync"
+
+/*
+A Compressor compresses and decompresses the plaintext of a frame.
+Implementations must be safe for concurrent use. Compressing before encrypting
+leaks the compressibility of the plaintext, see Options.Compression.
+*/
+type Compressor interface{
+	Compress(src []byte) ([]byte,error)
+	// Decompresses src. Output larger than max bytes must be rejected with
+	// ErrFrameTooLarge, before much more than max bytes are allocated for
+	// it, so that a small frame can't inflate to gigabytes.
+	Decompress(src []byte, max int) ([]byte,error)
+}
+
+/*
+Well known compressor ids. The id is transmitted in every compressed frame, so
+the ids must not be changed.
+*/
+const (
+	CompressionNone   uint8 = 0
+	CompressionGzip   uint8 = 1
+	CompressionSnappy uint8 = 2 // registered by package seep/compress/snappy
+	CompressionZstd   uint8 = 3 // registered by package seep/compress/zstd
+)
+
+var ErrUnknownCompression = errors.New("seep: unknown compression")
+
+var compressorLck sync.RWMutex
+var compressors = make(map[uint8]Compressor)
+
+/*
+Registers a compressor under the given id. It panics, if the id is 0 or if
+it is already registered.
+*/
+func RegisterCompressor(id uint8, c Compressor) {
+	compressorLck.Lock(); defer compressorLck.Unlock()
+	if id==CompressionNone { panic("seep: RegisterCompressor with id 0") }
+	if _,ok := compressors[id]; ok { panic(fmt.Sprintf("seep: RegisterCompressor called twice for id %d",id)) }
+	compressors[id] = c
+}
+func lookupCompressor(id uint8) Compressor {
+	compressorLck.RLock(); defer compressorLck.RUnlock()
+	return compressors[id]
+}
+
+func init() {
+	RegisterCompressor(CompressionGzip,new(gzipCompressor))
+}
+
+type gzipCompressor struct{
+	writers sync.Pool
+}
+func (g *gzipCompressor) Compress(src []byte) ([]byte,error) {
+	buf := new(bytes.Buffer)
+	w,_ := g.writers.Get().(*gzip.Writer)
+	if w==nil { w = gzip.NewWriter(buf) } else { w.Reset(buf) }
+	defer g.writers.Put(w)
+	_,err := w.Write(src)
+	if err!=nil { return nil,err }
+	err = w.Close()
+	if err!=nil { return nil,err }
+	return buf.Bytes(),nil
+}
+func (g *gzipCompressor) Decompress(src []byte, max int) ([]byte,error) {
+	r,err := gzip.NewReader(bytes.NewReader(src))
+	if err!=nil { return nil,err }
+	buf,err := ioutil.ReadAll(io.LimitReader(r,int64(max)+1))
+	if err!=nil { return nil,err }
+	if len(buf)>max { return nil,ErrFrameTooLarge }
+	return buf,nil
+}
+
+/* ------------------------------------------------------------------------- */
+
+const defaultCompressMin = 128
+
+/*
+The limit of the decompressed size of a frame, if Options.MaxFrameSize is not
+set.
+*/
+const defaultDecompressMax = 64<<20
+
+/*
+Compresses p into a tagged frame. If p is too short, or if it doesn't shrink by
+at least 1/16, it is sent uncompressed.
+*/
+func (o *Options) compress(p []byte, id uint8) ([]byte,error) {
+	min := o.CompressMin
+	if min==0 { min = defaultCompressMin }
+	if id!=CompressionNone && len(p)>=min {
+		c := lookupCompressor(id)
+		if c==nil { return nil,ErrUnknownCompression }
+		buf,err := c.Compress(p)
+		if err!=nil { return nil,err }
+		if len(buf) < len(p)-(len(p)/16) {
+			return append([]byte{id},buf...),nil
+		}
+	}
+	return append([]byte{CompressionNone},p...),nil
+}
+func (o *Options) decompress(p []byte) ([]byte,error) {
+	if len(p)==0 { return nil,ErrUnknownCompression }
+	if p[0]==CompressionNone { return p[1:],nil }
+	c := lookupCompressor(p[0])
+	if c==nil { return nil,ErrUnknownCompression }
+	max := o.MaxFrameSize
+	if max<=0 { max = defaultDecompressMax }
+	return c.Decompress(p[1:],max)
+}
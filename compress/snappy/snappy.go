@@ -0,0 +1,49 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Importing this package registers the snappy compressor as
+seep.CompressionSnappy.
+
+	import _ "github.com/mad-day/seep/compress/snappy"
+*/
+package snappy
+
+import "github.com/mad-day/seep"
+import gosnappy "github.com/golang/snappy"
+
+type compressor struct{}
+func (compressor) Compress(src []byte) ([]byte,error) {
+	return gosnappy.Encode(nil,src),nil
+}
+func (compressor) Decompress(src []byte, max int) ([]byte,error) {
+	n,err := gosnappy.DecodedLen(src)
+	if err!=nil { return nil,err }
+	if n>max { return nil,seep.ErrFrameTooLarge }
+	return gosnappy.Decode(nil,src)
+}
+
+func init() {
+	seep.RegisterCompressor(seep.CompressionSnappy,compressor{})
+}
@@ -0,0 +1,72 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Importing this package registers the zstd compressor as
+seep.CompressionZstd.
+
+	import _ "github.com/mad-day/seep/compress/zstd"
+*/
+package zstd
+
+import "sync"
+import "github.com/mad-day/seep"
+import kzstd "github.com/klauspost/compress/zstd"
+
+type compressor struct{
+	enc *kzstd.Encoder
+	lck sync.Mutex
+	// The decoders by their maximum output size. The limit is fixed, when a
+	// decoder is created, and connections rarely differ in their
+	// Options.MaxFrameSize, so there are few of them.
+	decs map[int]*kzstd.Decoder
+}
+func (c *compressor) Compress(src []byte) ([]byte,error) {
+	return c.enc.EncodeAll(src,nil),nil
+}
+func (c *compressor) decoder(max int) (*kzstd.Decoder,error) {
+	c.lck.Lock(); defer c.lck.Unlock()
+	if dec := c.decs[max]; dec!=nil { return dec,nil }
+	dec,err := kzstd.NewReader(nil,kzstd.WithDecoderMaxMemory(uint64(max)))
+	if err!=nil { return nil,err }
+	c.decs[max] = dec
+	return dec,nil
+}
+func (c *compressor) Decompress(src []byte, max int) ([]byte,error) {
+	// The decoder also limits the window to max, which can't be smaller
+	// than kzstd.MinWindowSize.
+	lim := max
+	if lim<kzstd.MinWindowSize { lim = kzstd.MinWindowSize }
+	dec,err := c.decoder(lim)
+	if err!=nil { return nil,err }
+	buf,err := dec.DecodeAll(src,nil)
+	if err==kzstd.ErrDecoderSizeExceeded || err==kzstd.ErrWindowSizeExceeded || (err==nil && len(buf)>max) { return nil,seep.ErrFrameTooLarge }
+	return buf,err
+}
+
+func init() {
+	enc,err := kzstd.NewWriter(nil)
+	if err!=nil { panic(err) }
+	seep.RegisterCompressor(seep.CompressionZstd,&compressor{enc:enc,decs:make(map[int]*kzstd.Decoder)})
+}
@@ -0,0 +1,244 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "os"
+import "time"
+import "github.com/flynn/noise"
+
+/*
+Returned by Conformance for checks, the endpoint failed.
+*/
+var ErrNonConformant = errors.New("seep: endpoint does not conform")
+
+/*
+Returned by Conformance for checks, that do not apply to the options, such as
+an oversized frame with FramingUint16, that can not express it.
+*/
+var ErrNotApplicable = errors.New("seep: check not applicable")
+
+/*
+The outcome of a conformance check. Err is nil, if the endpoint passed.
+*/
+type ConformanceResult struct{
+	Check string
+	// What the check expects of the endpoint.
+	Description string
+	Err error
+}
+
+/*
+A Framer, that can corrupt the next frame written, and write frames, the
+Writer would refuse.
+*/
+type conformFramer struct{
+	Framer
+	corrupt bool
+}
+func (f *conformFramer) WriteFrame(p []byte) error {
+	if f.corrupt && len(p)>0 {
+		f.corrupt = false
+		p = append([]byte(nil),p...)
+		p[len(p)-1] ^= 1
+	}
+	return f.Framer.WriteFrame(p)
+}
+
+/*
+A session with the endpoint, established for a single check.
+*/
+type conformSession struct{
+	c *Connection
+	w *Writer
+	f *conformFramer
+}
+
+func (s *conformSession) echo(p []byte) error {
+	_,err := s.c.Write(p)
+	if err==nil { err = s.w.Flush() }
+	if err!=nil { return err }
+	buf := make([]byte,len(p))
+	_,err = io.ReadFull(s.c,buf)
+	if err!=nil { return fmt.Errorf("%w: no echo: %v",ErrNonConformant,err) }
+	if !bytes.Equal(buf,p) { return fmt.Errorf("%w: the echo differs",ErrNonConformant) }
+	return nil
+}
+
+/*
+Expects the endpoint to end the session, without sending any more data: with
+a close or error frame, or by closing the connection.
+*/
+func (s *conformSession) closed() error {
+	var buf [1]byte
+	n,err := s.c.Read(buf[:])
+	if n>0 { return fmt.Errorf("%w: data received",ErrNonConformant) }
+	if errors.Is(err,os.ErrDeadlineExceeded) { return fmt.Errorf("%w: the session was left open",ErrNonConformant) }
+	return nil
+}
+
+/*
+Expects the endpoint not to send any more data, until it closes the session or
+the deadline passes.
+*/
+func (s *conformSession) silent() error {
+	var buf [1]byte
+	n,_ := s.c.Read(buf[:])
+	if n>0 { return fmt.Errorf("%w: data received",ErrNonConformant) }
+	return nil
+}
+
+var conformanceChecks = []struct{
+	name, desc string
+	run func(s *conformSession) error
+}{
+	{"handshake","completes the handshake",func(s *conformSession) error {
+		return nil
+	}},
+	{"echo","echoes data frames",func(s *conformSession) error {
+		err := s.echo([]byte("seep conformance"))
+		if err!=nil { return err }
+		return s.echo(bytes.Repeat([]byte{0x5a},1000))
+	}},
+	{"max-frame","accepts a frame of the largest size, noise.MaxMsgLen",func(s *conformSession) error {
+		p := make([]byte,s.w.maxPayload())
+		for i := range p { p[i] = byte(i) }
+		return s.echo(p)
+	}},
+	{"oversized-frame","ends the session after a frame larger than noise.MaxMsgLen",func(s *conformSession) error {
+		err := s.f.Framer.WriteFrame(make([]byte,noise.MaxMsgLen+1))
+		if err==ErrFrameTooLarge { return ErrNotApplicable }
+		if err!=nil { return err }
+		return s.closed()
+	}},
+	{"keepalive","ignores keepalive frames",func(s *conformSession) error {
+		err := s.w.Keepalive()
+		if err!=nil { return err }
+		return s.echo([]byte("after keepalive"))
+	}},
+	{"rekey","rekeys on rekey frames",func(s *conformSession) error {
+		for i:=0; i<3; i++ {
+			err := s.w.Rekey()
+			if err!=nil { return err }
+			err = s.echo([]byte("after rekey"))
+			if err!=nil { return err }
+		}
+		return nil
+	}},
+	{"unknown-frame","ignores frames of unknown types",func(s *conformSession) error {
+		err := s.w.WriteControl(0xff,[]byte("unknown"))
+		if err!=nil { return err }
+		return s.echo([]byte("after unknown frame"))
+	}},
+	{"corrupt-frame","drops a frame, that fails to authenticate",func(s *conformSession) error {
+		s.f.corrupt = true
+		_,err := s.c.Write([]byte("corrupt"))
+		if err==nil { err = s.w.Flush() }
+		if err!=nil { return err }
+		return s.silent()
+	}},
+	{"close","answers a close frame with its own, after the pending data",func(s *conformSession) error {
+		_,err := s.c.Write([]byte("before close"))
+		if err==nil { err = s.w.Close() }
+		if err!=nil { return err }
+		b,err := io.ReadAll(s.c)
+		if err!=nil { return fmt.Errorf("%w: %v",ErrNonConformant,err) }
+		if string(b)!="before close" { return fmt.Errorf("%w: the echo differs",ErrNonConformant) }
+		return nil
+	}},
+	{"error-frame","ends the session after an error frame",func(s *conformSession) error {
+		err := s.w.CloseWithError(1,"seep conformance")
+		if err!=nil { return err }
+		return s.closed()
+	}},
+}
+
+/*
+Runs the conformance suite against an endpoint, typically another
+implementation of the protocol, and returns the result of every check. Each
+check dials a connection of its own, runs the handshake with nc as the
+initiator, exercises one feature of the frame layer and must complete within
+timeout. The endpoint is expected to behave like ServeConformance: echo every
+data frame, and answer the end of the session with its own close frame. The
+options of the endpoint must match o, which is used with Typed set.
+
+	results := seep.Conformance(func() (net.Conn,error) {
+		return net.Dial("tcp","peer:7000")
+	},nc,nil,5*time.Second)
+	for _,r := range results {
+		fmt.Println(r.Check,r.Err)
+	}
+*/
+func Conformance(dial func() (net.Conn,error), nc noise.Config, o *Options, timeout time.Duration) []ConformanceResult {
+	opts := o.get()
+	opts.Typed = true
+	nc.Initiator = true
+	res := make([]ConformanceResult,len(conformanceChecks))
+	for i,check := range conformanceChecks {
+		res[i] = ConformanceResult{Check:check.name,Description:check.desc}
+		conn,err := dial()
+		if err!=nil { res[i].Err = err; continue }
+		conn.SetDeadline(time.Now().Add(timeout))
+		f := &conformFramer{Framer:newFramer(conn,conn,opts)}
+		c := &Connection{Options:&opts}
+		c.Init()
+		c.RemoteAddr = conn.RemoteAddr()
+		err = c.HandshakeFramer(f,nc)
+		if err==nil { err = check.run(&conformSession{c,c.Writer.(*Writer),f}) }
+		res[i].Err = err
+		conn.Close()
+	}
+	return res
+}
+
+/*
+Serves the endpoint side of the conformance suite on an established
+Connection: echoes the data, until the peer ends the session, then sends a
+close frame. If a frame can not be read, it sends an error frame instead.
+*/
+func ServeConformance(c *Connection) error {
+	w := c.Writer.(*Writer)
+	buf := make([]byte,noise.MaxMsgLen)
+	for {
+		n,err := c.Read(buf)
+		if n>0 {
+			_,werr := c.Write(buf[:n])
+			if werr!=nil { return werr }
+		}
+		var pe *PeerError
+		switch {
+		case err==nil:
+		case err==io.EOF, errors.As(err,&pe):
+			return w.Close()
+		default:
+			w.CloseWithError(1,err.Error())
+			return err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "sync/atomic"
+
+/*
+Counters of the frames exchanged over an established connection. Bytes are
+counted as encrypted frames, without the framing. The counters are maintained
+with atomic operations, so that they can be read at any time.
+*/
+type Counters struct{
+	FramesIn uint64
+	BytesIn uint64
+	FramesOut uint64
+	BytesOut uint64
+	// Received frames, that failed to decrypt (or were out of sequence).
+	DecryptFailures uint64
+	// Rekeys (see Writer.Rekey) sent and received.
+	Rekeys uint64
+}
+
+/*
+Returns a consistent copy of each counter.
+*/
+func (c *Counters) load() Counters {
+	return Counters{
+		FramesIn:atomic.LoadUint64(&c.FramesIn),
+		BytesIn:atomic.LoadUint64(&c.BytesIn),
+		FramesOut:atomic.LoadUint64(&c.FramesOut),
+		BytesOut:atomic.LoadUint64(&c.BytesOut),
+		DecryptFailures:atomic.LoadUint64(&c.DecryptFailures),
+		Rekeys:atomic.LoadUint64(&c.Rekeys),
+	}
+}
+func (c *Counters) add(o Counters) {
+	c.FramesIn += o.FramesIn
+	c.BytesIn += o.BytesIn
+	c.FramesOut += o.FramesOut
+	c.BytesOut += o.BytesOut
+	c.DecryptFailures += o.DecryptFailures
+	c.Rekeys += o.Rekeys
+}
+
+/*
+Returns the counters of the frames written. May be called concurrently with
+writes.
+*/
+func (w *Writer) Counters() Counters { return w.stats.load() }
+
+/*
+Returns the counters of the frames read. May be called concurrently with reads.
+*/
+func (r *Reader) Counters() Counters { return r.stats.load() }
+
+/*
+Returns the counters of both directions. Zero before the handshake.
+*/
+func (c *Connection) Counters() (s Counters) {
+	if w,ok := c.Writer.(*Writer); ok { s.add(w.Counters()) }
+	if r,ok := c.Reader.(*Reader); ok { s.add(r.Counters()) }
+	return
+}
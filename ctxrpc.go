@@ -0,0 +1,332 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "context"
+import "errors"
+import "fmt"
+import "net/rpc"
+import "reflect"
+import "sync"
+
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+var ErrMethodNotFound = errors.New("seep: method not found")
+
+/*
+Returned by Caller.Call, if ctx carries a Header (see WithHeader), but the
+codec is not one of this package, and can't send it.
+*/
+var ErrHeaderUnsupported = errors.New("seep: codec can't send header metadata")
+
+type outgoingHeaderKey struct{}
+type requestHeaderKey struct{}
+
+/*
+Returns a context, that makes Caller.Call send the metadata and extensions of h
+(see Header.Set) in the header of its request. The other fields of h are
+ignored.
+*/
+func WithHeader(ctx context.Context, h *Header) context.Context {
+	return context.WithValue(ctx,outgoingHeaderKey{},h)
+}
+
+/*
+Returns the header of the request, that a Service handler was called for, with
+the metadata and extensions sent by the client, or nil, if the codec is not
+one of this package. The Header must not be modified.
+*/
+func RequestHeader(ctx context.Context) *Header {
+	h,_ := ctx.Value(requestHeaderKey{}).(*Header)
+	return h
+}
+
+/*
+The codecs of this package send and receive the full Header.
+*/
+type headerWriter interface{
+	writeRequest(req *rpc.Request, x *Header, i interface{}) error
+}
+type headerReader interface{
+	requestHeader() *Header
+}
+
+/*
+Implemented by the codecs of this package: reports, whether the last response
+body ended with an error of the stream, rather than of the format's decoder.
+*/
+type bodyChecker interface{
+	bodyBroken() bool
+}
+
+type ctxHandler struct{
+	fn   reflect.Value
+	req  reflect.Type
+}
+
+/*
+A Service is a SEEP-native RPC server, whose handlers have the signature
+
+	func(ctx context.Context, req T) (resp U, err error)
+
+It is served over any rpc.ServerCodec, usually one created by NewRpcSource or
+NewGobRpcSource, so it shares the encrypted framing and the encoding formats
+with the net/rpc compatible codecs.
+
+	s := seep.NewService()
+	s.Handle("Echo",func(ctx context.Context, req string) (string,error) { return req,nil })
+	codec,err := seep.NewRpcSource(src,dst,cfg,conn)
+	// ... check error
+	s.ServeCodec(ctx,codec)
+*/
+type Service struct{
+	lck sync.RWMutex
+	handlers map[string]*ctxHandler
+}
+func NewService() *Service {
+	return &Service{handlers:make(map[string]*ctxHandler)}
+}
+
+/*
+Registers a handler function under the given name. fn must be a function of
+the form func(context.Context, T) (U, error).
+*/
+func (s *Service) Handle(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind()!=reflect.Func { return fmt.Errorf("seep: handler %q is not a function",name) }
+	if t.NumIn()!=2 || t.In(0)!=typeOfContext { return fmt.Errorf("seep: handler %q must take (context.Context, T)",name) }
+	if t.NumOut()!=2 || t.Out(1)!=typeOfError { return fmt.Errorf("seep: handler %q must return (U, error)",name) }
+	s.lck.Lock(); defer s.lck.Unlock()
+	s.handlers[name] = &ctxHandler{fn:v,req:t.In(1)}
+	return nil
+}
+func (s *Service) lookup(name string) *ctxHandler {
+	s.lck.RLock(); defer s.lck.RUnlock()
+	return s.handlers[name]
+}
+
+/*
+Serves requests from the codec until it fails or ctx is done. Every request is
+handled in its own goroutine with a context derived from ctx, that is canceled,
+once ServeCodec returns. Once ctx is done, the codec is closed, which unblocks
+the pending read, and ctx.Err() returned; otherwise, the codec is closed before
+ServeCodec returns.
+*/
+func (s *Service) ServeCodec(ctx context.Context, codec rpc.ServerCodec) error {
+	var once sync.Once
+	closeCodec := func() { once.Do(func() { codec.Close() }) }
+	stop := context.AfterFunc(ctx,closeCodec)
+	ctx,cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wm := writeLock(codec)
+	defer func(){
+		stop()
+		cancel()
+		wg.Wait()
+		closeCodec()
+	}()
+
+	reply := func(req *rpc.Request,v interface{},err error) {
+		resp := &rpc.Response{ServiceMethod:req.ServiceMethod,Seq:req.Seq}
+		if err!=nil {
+			resp.Error = err.Error()
+			v = struct{}{}
+		}
+		wm.Lock(); defer wm.Unlock()
+		codec.WriteResponse(resp,v)
+	}
+
+	for {
+		if ctx.Err()!=nil { return ctx.Err() }
+		req := new(rpc.Request)
+		err := codec.ReadRequestHeader(req)
+		if err!=nil {
+			// The read failed, because the codec was closed.
+			if ctx.Err()!=nil { return ctx.Err() }
+			return err
+		}
+		hctx := ctx
+		if hr,ok := codec.(headerReader); ok { hctx = context.WithValue(ctx,requestHeaderKey{},hr.requestHeader()) }
+		h := s.lookup(req.ServiceMethod)
+		if h==nil {
+			codec.ReadRequestBody(nil)
+			reply(req,nil,ErrMethodNotFound)
+			continue
+		}
+		arg := reflect.New(h.req)
+		err = codec.ReadRequestBody(arg.Interface())
+		if err!=nil {
+			reply(req,nil,err)
+			continue
+		}
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			out := h.fn.Call([]reflect.Value{reflect.ValueOf(hctx),arg.Elem()})
+			err,_ := out[1].Interface().(error)
+			reply(req,out[0].Interface(),err)
+		}()
+	}
+}
+
+type nopLocker struct{}
+func (nopLocker) Lock() {}
+func (nopLocker) Unlock() {}
+
+/*
+Returns the lock, that serializes the writes to codec. The codecs of this
+package serialize their writes themselves, after encoding the message (see
+Options.SendQueue), so they get a lock, that does nothing.
+*/
+func writeLock(codec interface{}) sync.Locker {
+	switch codec.(type) {
+	case *rpcClientCodec,*rpcServerCodec: return nopLocker{}
+	}
+	return new(sync.Mutex)
+}
+
+type ctxCall struct{
+	resp interface{}
+	err  error
+	done chan struct{}
+}
+
+/*
+A Caller is the client side counterpart of Service. It multiplexes concurrent
+calls over a single rpc.ClientCodec, usually one created by NewRpcClient or
+NewGobRpcClient.
+
+	codec,err := seep.NewRpcClient(src,dst,cfg,conn)
+	// ... check error
+	c := seep.NewCaller(codec)
+	var resp string
+	err = c.Call(ctx,"Echo","hello",&resp)
+*/
+type Caller struct{
+	codec rpc.ClientCodec
+	wm sync.Locker
+	lck sync.Mutex
+	seq uint64
+	pending map[uint64]*ctxCall
+	err error
+}
+func NewCaller(codec rpc.ClientCodec) *Caller {
+	c := &Caller{codec:codec,wm:writeLock(codec),pending:make(map[uint64]*ctxCall)}
+	go c.input()
+	return c
+}
+func (c *Caller) input() {
+	var err error
+	for err==nil {
+		resp := new(rpc.Response)
+		err = c.codec.ReadResponseHeader(resp)
+		if err!=nil { break }
+		c.lck.Lock()
+		call := c.pending[resp.Seq]
+		delete(c.pending,resp.Seq)
+		c.lck.Unlock()
+		if call==nil {
+			err = c.codec.ReadResponseBody(nil)
+			continue
+		}
+		if resp.Error!="" {
+			call.err = rpc.ServerError(resp.Error)
+			err = c.codec.ReadResponseBody(nil)
+		} else {
+			// A body, that fails to decode, fails its call only, unless
+			// the stream itself broke.
+			call.err = c.codec.ReadResponseBody(call.resp)
+			if bc,ok := c.codec.(bodyChecker); call.err!=nil && ok && bc.bodyBroken() { err = call.err }
+		}
+		close(call.done)
+	}
+	c.lck.Lock()
+	c.err = err
+	if c.err==nil { c.err = rpc.ErrShutdown }
+	for seq,call := range c.pending {
+		delete(c.pending,seq)
+		call.err = rpc.ErrShutdown
+		close(call.done)
+	}
+	c.lck.Unlock()
+}
+
+/*
+Invokes the named method with req and decodes the result into resp, which must
+be a pointer. If ctx is done before the response arrives, Call returns
+ctx.Err() and the late response is discarded. If ctx carries a Header (see
+WithHeader), its metadata and extensions are sent along with the request.
+*/
+func (c *Caller) Call(ctx context.Context, method string, req, resp interface{}) error {
+	x,_ := ctx.Value(outgoingHeaderKey{}).(*Header)
+	hw,hok := c.codec.(headerWriter)
+	if x!=nil && !hok { return ErrHeaderUnsupported }
+	call := &ctxCall{resp:resp,done:make(chan struct{})}
+	c.lck.Lock()
+	if c.err!=nil {
+		c.lck.Unlock()
+		return c.err
+	}
+	c.seq++
+	seq := c.seq
+	c.pending[seq] = call
+	c.lck.Unlock()
+
+	c.wm.Lock()
+	var err error
+	if x!=nil {
+		err = hw.writeRequest(&rpc.Request{ServiceMethod:method,Seq:seq},x,req)
+	} else {
+		err = c.codec.WriteRequest(&rpc.Request{ServiceMethod:method,Seq:seq},req)
+	}
+	c.wm.Unlock()
+	if err!=nil {
+		c.lck.Lock()
+		delete(c.pending,seq)
+		c.lck.Unlock()
+		return err
+	}
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+	}
+	c.lck.Lock()
+	_,ok := c.pending[seq]
+	delete(c.pending,seq)
+	c.lck.Unlock()
+	if !ok {
+		// The response is already being decoded into resp; wait for it.
+		<-call.done
+		return call.err
+	}
+	return ctx.Err()
+}
+func (c *Caller) Close() error {
+	return c.codec.Close()
+}
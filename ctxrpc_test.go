@@ -0,0 +1,131 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "context"
+import "crypto/rand"
+import "net"
+import "strings"
+import "sync"
+import "testing"
+import "github.com/flynn/noise"
+
+type testEcho struct{
+	Text string
+	N int
+}
+
+/*
+Returns a Caller connected to a Service with an Echo handler over net.Pipe.
+fi and fr return the formats of the client and the server.
+*/
+func testService(t *testing.T, fi, fr func() *RpcFormat, o Options) *Caller {
+	t.Helper()
+	a,b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	s := NewService()
+	s.Handle("Echo",func(ctx context.Context, r testEcho) (testEcho,error) {
+		r.Text = strings.ToUpper(r.Text)
+		return r,nil
+	})
+	s.Handle("Meta",func(ctx context.Context, r testEcho) (testEcho,error) {
+		h := RequestHeader(ctx)
+		if h==nil { return testEcho{},nil }
+		v,_ := h.Get(r.Text)
+		if d,ok := h.Extension(7); ok { v += "/"+string(d) }
+		return testEcho{Text:v},nil
+	})
+	go func() {
+		codec,err := NewStreamRpcSource(b,b,noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader},b,fr(),&o)
+		if err!=nil { t.Error(err); return }
+		s.ServeCodec(context.Background(),codec)
+	}()
+	codec,err := NewStreamRpcClient(a,a,noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader,Initiator:true},a,fi(),&o)
+	if err!=nil { t.Fatal(err) }
+	return NewCaller(codec)
+}
+
+/*
+Concurrent calls, with messages encoded outside of the codec's lock, where the
+format allows it.
+*/
+func TestConcurrentCalls(t *testing.T) {
+	formats := map[string]func() *RpcFormat{
+		"xdr":func() *RpcFormat { return XDRFormat },
+		"gob":func() *RpcFormat { return GobFormat },
+		"gob-stream":NewGobStreamFormat,
+	}
+	for name,f := range formats {
+		for _,o := range []Options{{},{SendQueue:8},{SendQueue:4,CryptoWorkers:2}} {
+			c := testService(t,f,f,o)
+			var wg sync.WaitGroup
+			for i:=0; i<32; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					req := testEcho{strings.Repeat("x",i*100),i}
+					var resp testEcho
+					err := c.Call(context.Background(),"Echo",req,&resp)
+					if err!=nil || resp.N!=i || resp.Text!=strings.ToUpper(req.Text) { t.Errorf("%s: call %d: %v",name,i,err) }
+				}(i)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+/*
+Metadata and extensions attached with WithHeader reach the handler.
+*/
+func TestRequestHeader(t *testing.T) {
+	xdr := func() *RpcFormat { return XDRFormat }
+	for _,o := range []Options{{},{ChunkedRPC:true},{SendQueue:4}} {
+		c := testService(t,xdr,xdr,o)
+		h := new(Header)
+		h.Set("trace","abc")
+		h.Ext = append(h.Ext,Extension{7,[]byte("ext")})
+		var r testEcho
+		err := c.Call(WithHeader(context.Background(),h),"Meta",testEcho{Text:"trace"},&r)
+		if err!=nil || r.Text!="abc/ext" { t.Fatalf("%+v: %q %v",o,r.Text,err) }
+		err = c.Call(context.Background(),"Meta",testEcho{Text:"trace"},&r)
+		if err!=nil || r.Text!="" { t.Fatalf("%+v: metadata without WithHeader: %q %v",o,r.Text,err) }
+	}
+}
+
+/*
+A response, that fails to decode, fails its own call only.
+*/
+func TestBodyDecodeError(t *testing.T) {
+	gob := func() *RpcFormat { return GobFormat }
+	for _,o := range []Options{{},{ChunkedRPC:true}} {
+		c := testService(t,gob,gob,o)
+		var n int
+		err := c.Call(context.Background(),"Echo",testEcho{Text:"a"},&n)
+		if err==nil { t.Fatalf("%+v: decoded a struct into an int",o) }
+		var r testEcho
+		err = c.Call(context.Background(),"Echo",testEcho{Text:"b"},&r)
+		if err!=nil || r.Text!="B" { t.Fatalf("%+v: after a decode error: %q %v",o,r.Text,err) }
+	}
+}
@@ -0,0 +1,124 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "fmt"
+import "strings"
+import "sync/atomic"
+
+/*
+Writes " key=value" to b for each pair of kv.
+*/
+func debugPairs(b *strings.Builder, kv ...interface{}) {
+	for i := 0; i+1<len(kv); i+=2 { fmt.Fprintf(b," %v=%v",kv[i],kv[i+1]) }
+}
+
+/*
+Describes the session of a Writer and a Reader; either may be nil. Only values,
+that are read atomically, are included, so that it is safe to call at any time.
+*/
+func debugSession(b *strings.Builder, w *frameWriter, r *frameReader) {
+	if w!=nil {
+		debugPairs(b,"frames_out",atomic.LoadUint64(&w.stats.FramesOut),"bytes_out",atomic.LoadUint64(&w.stats.BytesOut),"nonce_out",atomic.LoadUint64(&w.nonces))
+	}
+	if r!=nil {
+		debugPairs(b,"frames_in",atomic.LoadUint64(&r.stats.FramesIn),"bytes_in",atomic.LoadUint64(&r.stats.BytesIn),"nonce_in",atomic.LoadUint64(&r.nonces),
+			"decrypt_failures",atomic.LoadUint64(&r.stats.DecryptFailures))
+	}
+}
+
+/*
+Describes the handshake of a session.
+*/
+func debugAudit(b *strings.Builder, a *HandshakeAudit) {
+	if a==nil { return }
+	debugPairs(b,"protocol",a.Protocol,"initiator",a.Initiator,"handshake_time",a.Duration)
+	if a.PeerStatic!=nil { debugPairs(b,"peer",Fingerprint(a.PeerStatic)) }
+}
+
+/*
+Returns a description of the state of the Connection for bug reports: its ID,
+the phase (new, handshaking, established, closed or burned), the protocol, the
+frames and bytes exchanged, the nonces and the sizes of the buffers. It never
+includes key material or data. It may be called at any time; the buffers of a
+Writer or Reader, that is in use, are reported as "busy".
+*/
+func (c *Connection) DebugString() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b,"seep.Connection{id=%d",c.ID())
+	w,wok := c.Writer.(*Writer)
+	r,rok := c.Reader.(*Reader)
+	switch {
+	case c.Writer==nil:
+		debugPairs(b,"phase","new")
+	case !wok || !rok:
+		debugPairs(b,"phase","handshaking")
+		if c.outbuf!=nil { debugPairs(b,"pending_out",c.outbuf.Len(),"pending_in",c.inbuf.Len()) }
+	default:
+		phase,wbuf,rbuf := "established",interface{}("busy"),interface{}("busy")
+		if w.lck.TryLock() {
+			if w.enc==nil { phase = "burned" }
+			wbuf = len(w.wbuf)
+			w.lck.Unlock()
+		}
+		if r.lck.TryLock() {
+			if r.eof!=nil && phase!="burned" { phase = "closed" }
+			rbuf = r.buf.Len()+len(r.cur)
+			r.lck.Unlock()
+		}
+		debugPairs(b,"phase",phase)
+		debugAudit(b,c.session)
+		debugSession(b,&w.frameWriter,&r.frameReader)
+		debugPairs(b,"write_buffer",wbuf,"read_buffer",rbuf)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+/*
+Describes the state of the codec, like Connection.DebugString. A codec returned
+by NewRpcClient and the like implements interface{ DebugString() string }.
+*/
+func (r *rpcClientCodec) DebugString() string {
+	return debugCodec("seep.ClientCodec",r.format,&r.frameWriter,&r.frameReader)
+}
+
+/*
+Describes the state of the codec, like Connection.DebugString.
+*/
+func (r *rpcServerCodec) DebugString() string {
+	return debugCodec("seep.ServerCodec",r.format,&r.frameWriter,&r.frameReader)
+}
+
+func debugCodec(name string, fm *RpcFormat, w *frameWriter, r *frameReader) string {
+	b := new(strings.Builder)
+	b.WriteString(name+"{")
+	if r.audit!=nil { fmt.Fprintf(b,"id=%d",r.audit.ConnID) }
+	if fm!=nil { debugPairs(b,"format",fm.Name) }
+	debugAudit(b,r.audit)
+	debugSession(b,w,r)
+	b.WriteString("}")
+	return b.String()
+}
@@ -0,0 +1,64 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+
+/*
+Categories of errors. The errors of this package, that fall into one of them,
+match it with errors.Is, so callers can branch on the category, rather than on
+the individual error:
+
+	if errors.Is(err,seep.ErrHandshakeFailed) {
+		// ... the peer failed to authenticate, or was refused
+	}
+
+ErrHandshakeFailed covers handshakes, that were rejected by either side
+(ErrHandshakeAuth, ErrPeerNotPinned, ErrAuthFailed, ErrReplay, ErrBanned, ...);
+errors of the underlying stream and of custom Options.VerifyPeer functions are
+returned as they are. ErrDecryptFailed covers frames, that fail to
+authenticate (ErrFrameAuth, ErrDecryptFailures). ErrPeerClosed is matched by
+the *PeerError, that a peer closed the session with; a regular close frame
+ends the stream with io.EOF. ErrNotHandshaken covers ErrNotEstablished.
+ErrFrameTooLarge is a category of its own.
+*/
+var ErrHandshakeFailed = errors.New("seep: handshake failed")
+var ErrDecryptFailed = errors.New("seep: decryption failed")
+var ErrPeerClosed = errors.New("seep: session closed by the peer")
+var ErrNotHandshaken = errors.New("seep: handshake not completed")
+
+/*
+An error of a category, see ErrHandshakeFailed.
+*/
+type kindError struct{
+	msg string
+	kind error
+}
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+func newError(kind error, msg string) error {
+	return &kindError{msg,kind}
+}
@@ -0,0 +1,892 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bufio"
+import "bytes"
+import "encoding/base64"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "log/slog"
+import "net"
+import "sync/atomic"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/davecgh/go-xdr/xdr2"
+
+/*
+Returned for frames exceeding Options.MaxFrameSize, on the wire or once
+decompressed, and for outgoing messages, that don't fit into a frame.
+*/
+var ErrFrameTooLarge = errors.New("seep: frame too large")
+
+/*
+Framing modes, see Options.Framing.
+*/
+const (
+	// Every frame is an XDR variable-length opaque (4 byte length, padding).
+	FramingXDR uint8 = iota
+	// Every frame is preceded by a 2 byte big-endian length, as in
+	// NoiseSocket. Frames can't exceed 65535 bytes.
+	FramingUint16
+	// Every frame is a line of standard base64, terminated by "\n", see
+	// NewArmorFramer.
+	FramingArmor
+	// Every frame is preceded by its length as an unsigned varint, as in
+	// length-delimited protobuf streams.
+	FramingUvarint
+)
+
+/*
+A Framer delimits the messages on the underlying stream. The encryption and RPC
+layers only exchange whole frames with the Framer, so any framing (for
+instance COBS on serial links) can be used by implementing this interface.
+*/
+type Framer interface{
+	// Reads the next frame. Frames larger than max (if max>0) must be
+	// rejected with ErrFrameTooLarge before a buffer is allocated. The frame
+	// may be overwritten by the next call to ReadFrame.
+	ReadFrame(max int) ([]byte,error)
+	// Writes p as a single frame. p must not be retained after WriteFrame
+	// returns.
+	WriteFrame(p []byte) error
+}
+
+func newFramer(r io.Reader, w io.Writer, o Options) Framer {
+	if r!=nil && o.ReadBuffer>=0 {
+		n := o.ReadBuffer
+		if n==0 { n = 4096 }
+		r = bufio.NewReaderSize(r,n)
+	}
+	switch o.Framing {
+	case FramingUint16: return NewUint16Framer(r,w)
+	case FramingArmor: return NewArmorFramer(r,w)
+	case FramingUvarint: return NewUvarintFramer(r,w)
+	}
+	return NewXDRFramer(r,w)
+}
+
+/*
+Creates a Framer, that encodes frames as XDR variable-length opaques.
+*/
+func NewXDRFramer(r io.Reader, w io.Writer) Framer {
+	return &xdrFramer{src:xdr.NewDecoder(r),dst:xdr.NewEncoder(w),w:w,r:r}
+}
+
+/*
+Creates a Framer, that precedes every frame with a 2 byte big-endian length.
+*/
+func NewUint16Framer(r io.Reader, w io.Writer) Framer {
+	return &u16Framer{r,w}
+}
+
+/*
+Creates a Framer, that precedes every frame with its length as an unsigned
+varint, as length-delimited protobuf streams do.
+*/
+func NewUvarintFramer(r io.Reader, w io.Writer) Framer {
+	return &uvarintFramer{r,w}
+}
+
+type xdrFramer struct{
+	src *xdr.Decoder
+	dst *xdr.Encoder
+	// If not nil, frames are written to w directly, bypassing dst.
+	w io.Writer
+	// If not nil, frames are read from r directly, bypassing src, into rbuf,
+	// that is reused for every frame up to noise.MaxMsgLen.
+	r io.Reader
+	rbuf []byte
+}
+
+var ErrXDRPadding = errors.New("seep: non-zero XDR padding")
+
+var xdrPad [4]byte
+func (x *xdrFramer) ReadFrame(max int) ([]byte,error) {
+	if x.r!=nil { return x.readFrame(max) }
+	l,_,err := x.src.DecodeUint()
+	if err!=nil { return nil,err }
+	if l>0x7fffffff || (max>0 && int64(l)>int64(max)) { return nil,ErrFrameTooLarge }
+	buf,_,err := x.src.DecodeFixedOpaque(int32(l))
+	return buf,err
+}
+func (x *xdrFramer) readFrame(max int) ([]byte,error) {
+	if cap(x.rbuf)<4 { x.rbuf = make([]byte,0,512) }
+	h := x.rbuf[:4]
+	_,err := io.ReadFull(x.r,h)
+	if err!=nil { return nil,err }
+	l := binary.BigEndian.Uint32(h)
+	if l>0x7fffffff || (max>0 && int64(l)>int64(max)) { return nil,ErrFrameTooLarge }
+	n := (int(l)+3)&^3
+	buf := x.rbuf
+	if cap(buf)<n {
+		buf = make([]byte,n)
+		if n<=noise.MaxMsgLen+3 { x.rbuf = buf }
+	}
+	buf = buf[:n]
+	_,err = io.ReadFull(x.r,buf)
+	if err==io.EOF { err = io.ErrUnexpectedEOF }
+	if err!=nil { return nil,err }
+	for _,b := range buf[l:] {
+		if b!=0 { return nil,ErrXDRPadding }
+	}
+	return buf[:l:l],nil
+}
+func (x *xdrFramer) WriteFrame(p []byte) error {
+	if x.w==nil {
+		_,err := x.dst.EncodeOpaque(p)
+		return err
+	}
+	if int64(len(p))>0x7fffffff { return ErrFrameTooLarge }
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:],uint32(len(p)))
+	if n := len(p)%4; n>0 { return writeVectored(x.w,l[:],p,xdrPad[:4-n]) }
+	return writeVectored(x.w,l[:],p)
+}
+
+type u16Framer struct{
+	r io.Reader
+	w io.Writer
+}
+func (u *u16Framer) ReadFrame(max int) ([]byte,error) {
+	var l [2]byte
+	_,err := io.ReadFull(u.r,l[:])
+	if err!=nil { return nil,err }
+	n := int(binary.BigEndian.Uint16(l[:]))
+	if max>0 && n>max { return nil,ErrFrameTooLarge }
+	buf := make([]byte,n)
+	_,err = io.ReadFull(u.r,buf)
+	if err==io.EOF { err = io.ErrUnexpectedEOF }
+	return buf,err
+}
+func (u *u16Framer) WriteFrame(p []byte) error {
+	if len(p)>0xffff { return ErrFrameTooLarge }
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:],uint16(len(p)))
+	return writeVectored(u.w,l[:],p)
+}
+
+/*
+Writes a length prefix and a frame without copying them into a single buffer.
+On a net.Conn, net.Buffers passes both to the kernel with a single writev.
+*/
+func writeVectored(w io.Writer, bufs ...[]byte) error {
+	nb := net.Buffers(bufs)
+	_,err := nb.WriteTo(w)
+	return err
+}
+
+type uvarintFramer struct{
+	r io.Reader
+	w io.Writer
+}
+
+/*
+Reads the bytes of the varint one by one, so that nothing beyond the length
+prefix is consumed from the underlying reader.
+*/
+type singleByteReader struct{
+	r io.Reader
+	b [1]byte
+}
+func (s *singleByteReader) ReadByte() (byte,error) {
+	_,err := io.ReadFull(s.r,s.b[:])
+	return s.b[0],err
+}
+
+func (u *uvarintFramer) ReadFrame(max int) ([]byte,error) {
+	l,err := binary.ReadUvarint(&singleByteReader{r:u.r})
+	if err!=nil { return nil,err }
+	if l>0x7fffffff || (max>0 && l>uint64(max)) { return nil,ErrFrameTooLarge }
+	buf := make([]byte,int(l))
+	_,err = io.ReadFull(u.r,buf)
+	if err==io.EOF { err = io.ErrUnexpectedEOF }
+	return buf,err
+}
+func (u *uvarintFramer) WriteFrame(p []byte) error {
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:],uint64(len(p)))
+	return writeVectored(u.w,l[:n],p)
+}
+
+/*
+Creates a Framer, that encodes every frame as a line of standard base64
+terminated by "\n", so that SEEP can be carried over text-only channels. A
+trailing "\r" is ignored when reading, so CRLF line endings do no harm. The
+Framer reads ahead from r.
+*/
+func NewArmorFramer(r io.Reader, w io.Writer) Framer {
+	a := &armorFramer{w:w}
+	if r!=nil { a.r = bufio.NewReader(r) }
+	return a
+}
+
+type armorFramer struct{
+	r *bufio.Reader
+	w io.Writer
+}
+func (a *armorFramer) ReadFrame(max int) ([]byte,error) {
+	limit := -1
+	if max>0 { limit = base64.StdEncoding.EncodedLen(max)+2 }
+	var line []byte
+	for {
+		b,err := a.r.ReadByte()
+		if err!=nil {
+			if err==io.EOF && len(line)>0 { err = io.ErrUnexpectedEOF }
+			return nil,err
+		}
+		if b=='\n' {
+			line = bytes.TrimSuffix(line,[]byte("\r"))
+			break
+		}
+		line = append(line,b)
+		if limit>=0 && len(line)>limit { return nil,ErrFrameTooLarge }
+	}
+	buf := make([]byte,base64.StdEncoding.DecodedLen(len(line)))
+	n,err := base64.StdEncoding.Decode(buf,line)
+	if err!=nil { return nil,err }
+	if max>0 && n>max { return nil,ErrFrameTooLarge }
+	return buf[:n],nil
+}
+func (a *armorFramer) WriteFrame(p []byte) error {
+	buf := make([]byte,base64.StdEncoding.EncodedLen(len(p))+1)
+	base64.StdEncoding.Encode(buf,p)
+	buf[len(buf)-1] = '\n'
+	_,err := a.w.Write(buf)
+	return err
+}
+
+/* ------------------------------------------------------------------------- */
+
+var ErrBadPadding = errors.New("seep: invalid body length")
+
+/*
+Encodes p in the padded body format of NoiseSocket: a 2 byte big-endian body
+length, the body and zero padding up to a total length of n.
+*/
+func padBody(p []byte, n int) ([]byte,error) {
+	if len(p)>0xffff { return nil,ErrFrameTooLarge }
+	if n<2+len(p) { n = 2+len(p) }
+	buf := make([]byte,n)
+	binary.BigEndian.PutUint16(buf,uint16(len(p)))
+	copy(buf[2:],p)
+	return buf,nil
+}
+func unpadBody(p []byte) ([]byte,error) {
+	if len(p)<2 { return nil,ErrBadPadding }
+	n := int(binary.BigEndian.Uint16(p))
+	if n>len(p)-2 { return nil,ErrBadPadding }
+	return p[2:2+n],nil
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+Returned, if a handshake message can not be processed, whether it is malformed
+or fails to authenticate. Nothing is sent in response to it.
+*/
+var ErrHandshakeAuth = newError(ErrHandshakeFailed,"seep: handshake authentication failed")
+
+/*
+Runs the handshake described by nc over f. payload returns the payload of the
+next handshake message to be sent, recv receives the payloads of incoming
+handshake messages, that are wiped once it returns; both may be nil. If verify
+is not nil, it is called with the peer's static key as soon as it is known,
+and aborts the handshake with its error, before anything else is sent; if the
+handshake completes without one, it is called with nil. If replay is not nil,
+the responder refuses initiations seen before with ErrReplay, before any of
+their payload is received. Returns the finished handshake state and the
+cipher states used to encrypt outgoing and to decrypt incoming frames.
+*/
+func runHandshake(f Framer, nc noise.Config, payload func() []byte, recv func([]byte), verify func([]byte) error, replay *ReplayCache) (hs *noise.HandshakeState,enc,dec *noise.CipherState,err error) {
+	var cs1,cs2 *noise.CipherState
+	state := nc.Initiator
+	// Whether the next message read is the initiation.
+	first := !nc.Initiator
+	hs = newHandshakeState(nc)
+	verified := verify==nil
+	if !verified && len(hs.PeerStatic())>0 {
+		verified = true
+		err = verify(hs.PeerStatic())
+		if err!=nil { return }
+	}
+	for {
+		if state {
+			var p []byte
+			if payload!=nil { p = payload() }
+			var buf []byte
+			buf,cs1,cs2 = hs.WriteMessage(nil,p)
+			err = f.WriteFrame(buf)
+			if err!=nil { return }
+			state = false
+			if cs1!=nil { break }
+		}
+		var buf []byte
+		buf,err = f.ReadFrame(noise.MaxMsgLen)
+		if err!=nil { return }
+		buf,cs1,cs2,err = hs.ReadMessage(nil,buf)
+		if err!=nil { err = ErrHandshakeAuth; return }
+		if first {
+			first = false
+			err = replay.check(hs)
+			if err!=nil { return }
+		}
+		if !verified && len(hs.PeerStatic())>0 {
+			verified = true
+			err = verify(hs.PeerStatic())
+			if err!=nil { return }
+		}
+		if recv!=nil { recv(buf) }
+		Wipe(buf)
+		state = true
+		if cs1!=nil { break }
+	}
+	if !verified {
+		err = verify(nil)
+		if err!=nil { return }
+	}
+	if nc.Initiator { return hs,cs1,cs2,nil }
+	return hs,cs2,cs1,nil
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The size of the authentication tag, the AEAD ciphers of Noise append.
+*/
+const tagSize = 16
+
+/*
+Frame types, see Options.Typed.
+*/
+const (
+	FrameData uint8 = iota
+	FrameKeepalive
+	FrameRekey
+	FrameClose
+	FrameError
+)
+
+/*
+Returned for every frame, that can not be accepted: forged, corrupted,
+duplicated, dropped or reordered frames, and frames with a malformed body, all
+look the same to the peer, so that the error does not tell an attacker, which
+check failed.
+*/
+var ErrFrameAuth = newError(ErrDecryptFailed,"seep: frame authentication failed")
+
+/*
+Returned, once a session has been torn down after too many frames failed to
+decrypt, see Options.MaxDecryptFailures.
+*/
+var ErrDecryptFailures = newError(ErrDecryptFailed,"seep: too many frames failed to decrypt")
+
+var ErrUntyped = errors.New("seep: control frames require Options.Typed")
+
+/*
+Returned when a Reader, Writer or codec is used without a completed handshake.
+*/
+var ErrNotEstablished = newError(ErrNotHandshaken,"seep: connection not established")
+
+/*
+The error sent by the peer in a FrameError frame. After it, Read returns the
+*PeerError instead of io.EOF.
+*/
+type PeerError struct{
+	Code uint32
+	Message string
+}
+func (p *PeerError) Error() string {
+	return fmt.Sprintf("seep: peer error %d: %s",p.Code,p.Message)
+}
+func (p *PeerError) Unwrap() error { return ErrPeerClosed }
+func (p *PeerError) encode() []byte {
+	var b bytes.Buffer
+	xdr.Marshal(&b,p)
+	return b.Bytes()
+}
+func decodePeerError(p []byte) *PeerError {
+	e := new(PeerError)
+	_,err := xdr.UnmarshalLimited(bytes.NewReader(p),e,uint(len(p)))
+	if err!=nil { e.Message = "(malformed error frame)" }
+	return e
+}
+
+/*
+The frame layer: every frame contains one message, encrypted with the
+CipherState of the respective direction.
+*/
+type frameWriter struct{
+	// First, to be 64-bit aligned for the atomic operations.
+	stats Counters
+	// When the last frame was written, in Unix nanoseconds, and the nonce
+	// of the current key, see Connection.Stats.
+	last int64
+	nonces uint64
+	dst Framer
+	enc *noise.CipherState
+	opts Options
+	seq uint64
+	// Reused for the ciphertext of every frame.
+	scratch []byte
+	// If Options.Pipeline is set, the frames are written by the pipeline.
+	pipe *pipeline
+	// If Options.CryptoWorkers is set, the frames are encrypted by up to
+	// CryptoWorkers goroutines, using the nonce counter kept here, that
+	// continues from the CipherState's.
+	workers chan struct{}
+	nonce uint64
+	// When the current key came into use, see Options.MaxSessionAge.
+	keyTime time.Time
+	// The inner layer, see Options.InnerPSK, and the buffer for its
+	// ciphertext.
+	inner noise.Cipher
+	ibuf []byte
+	// Options.Logger with the attributes of the session.
+	log *slog.Logger
+	// The session, as counted in Options.Metrics.
+	sess *metricSession
+	// The ID of the Connection or codec, see Connection.ID.
+	conn uint64
+}
+/*
+Returns the maximum number of bytes, that fit into a single frame, so that the
+encrypted frame does not exceed noise.MaxMsgLen.
+*/
+func (f *frameWriter) maxPayload() int {
+	n := noise.MaxMsgLen-tagSize
+	if f.opts.Compression { n-- }
+	if f.opts.Typed { n-- }
+	if f.opts.padded() { n-=2 }
+	if f.opts.Sequenced { n-=8 }
+	if f.inner!=nil { n-=tagSize }
+	return n
+}
+/*
+Returns the payload size for bulk data, see Options.ChunkSize.
+*/
+func (f *frameWriter) chunkSize() int {
+	n := f.maxPayload()
+	if f.opts.ChunkSize>0 && f.opts.ChunkSize<n { n = f.opts.ChunkSize }
+	return n
+}
+func (f *frameWriter) writeFrame(p []byte, comp uint8) error {
+	if f.opts.Compression {
+		var err error
+		p,err = f.opts.compress(p,comp)
+		if err!=nil { return err }
+	}
+	if f.opts.Typed { p = append([]byte{FrameData},p...) }
+	err := f.renew()
+	if err!=nil { return err }
+	return f.seal(p)
+}
+
+/*
+Writes a control frame. A FrameRekey frame is written using the current key,
+subsequent frames are encrypted using the new key.
+*/
+func (f *frameWriter) writeControl(typ uint8, p []byte) error {
+	if !f.opts.Typed { return ErrUntyped }
+	if typ!=FrameRekey {
+		// Like data frames, keepalives and closes go out under a key, that
+		// the peer doesn't consider expired.
+		err := f.renew()
+		if err!=nil { return err }
+	}
+	err := f.seal(append([]byte{typ},p...))
+	if err!=nil { return err }
+	if typ==FrameRekey {
+		f.enc.Rekey()
+		f.keyTime = time.Now()
+		atomic.AddUint64(&f.stats.Rekeys,1)
+		f.opts.Metrics.add(rekeys,1)
+		logTo(f.log,slog.LevelDebug,"seep: rekey","direction","out")
+	}
+	return nil
+}
+func (f *frameWriter) seal(p []byte) error {
+	if f.enc==nil { return ErrNotEstablished }
+	body := p
+	if f.inner!=nil {
+		p = f.inner.Encrypt(f.ibuf[:0],f.seq,nil,p)
+		f.ibuf = p
+	}
+	if f.opts.padded() {
+		var err error
+		p,err = padBody(p,f.opts.padLen(len(p)))
+		if err!=nil { return err }
+	}
+	if f.pipe==nil {
+		n := f.opts.Pipeline
+		if n<2*f.opts.CryptoWorkers { n = 2*f.opts.CryptoWorkers }
+		if n>0 { f.pipe = newPipeline(f.dst,n) }
+	}
+	out := f.scratch
+	if f.pipe!=nil {
+		var err error
+		out,err = f.pipe.get()
+		if err!=nil { return err }
+	}
+	if cap(out)<8+len(p)+tagSize { out = make([]byte,0,8+len(p)+tagSize) }
+	if f.opts.CryptoWorkers>0 { return f.sealAsync(out,body,p) }
+	var buf []byte
+	if f.opts.Sequenced {
+		buf = out[:8]
+		binary.BigEndian.PutUint64(buf,f.seq)
+		buf = f.enc.Encrypt(buf,buf,p)
+	} else {
+		buf = f.enc.Encrypt(out[:0],nil,p)
+	}
+	if f.opts.Tap!=nil { f.opts.Tap(&TapFrame{Outgoing:true,ConnID:f.conn,Seq:f.seq,Plaintext:body,Ciphertext:buf}) }
+	f.seq++
+	f.count(len(buf))
+	if f.pipe!=nil { return f.pipe.put(buf) }
+	f.scratch = out
+	return f.dst.WriteFrame(buf)
+}
+
+func (f *frameWriter) count(n int) {
+	atomic.AddUint64(&f.stats.FramesOut,1)
+	atomic.AddUint64(&f.stats.BytesOut,uint64(n))
+	atomic.AddUint64(&f.nonces,1)
+	atomic.StoreInt64(&f.last,time.Now().UnixNano())
+	f.opts.Metrics.add(framesOut,1)
+	f.opts.Metrics.add(bytesOut,uint64(n))
+}
+
+/*
+Encrypts a frame on a worker goroutine and queues it to the pipeline. The
+CipherState's nonce can't be shared between goroutines, so the frame is
+encrypted with the underlying noise.Cipher and an explicit nonce instead. The
+frame layer is the only user of the CipherState, so this is transparent.
+*/
+func (f *frameWriter) sealAsync(out, body, p []byte) error {
+	if f.workers==nil {
+		f.workers = make(chan struct{},f.opts.CryptoWorkers)
+		// The CipherState may have been used before, for instance by
+		// NewWriterOptions; its nonces must not be used again.
+		f.nonce = f.enc.Nonce()
+	}
+	// The caller may reuse p and body as soon as we return.
+	p = append([]byte(nil),p...)
+	j := &pipeJob{done:make(chan struct{})}
+	var ad []byte
+	if f.opts.Sequenced {
+		ad = out[:8]
+		binary.BigEndian.PutUint64(ad,f.seq)
+	}
+	if f.opts.Tap!=nil {
+		tf := &TapFrame{Outgoing:true,ConnID:f.conn,Seq:f.seq,Plaintext:append([]byte(nil),body...)}
+		tap := f.opts.Tap
+		j.after = func(b []byte) { tf.Ciphertext = b; tap(tf) }
+	}
+	c,n := f.enc.Cipher(),f.nonce
+	f.nonce++
+	f.seq++
+	f.count(len(ad)+len(p)+tagSize)
+	f.workers <- struct{}{}
+	go func() {
+		j.buf = c.Encrypt(out[:len(ad)],n,ad,p)
+		<-f.workers
+		close(j.done)
+	}()
+	return f.pipe.putJob(j)
+}
+
+type frameReader struct{
+	// First, to be 64-bit aligned for the atomic operations.
+	stats Counters
+	// When the last frame was read, in Unix nanoseconds, and the nonce of
+	// the current key, see Connection.Stats.
+	last int64
+	nonces uint64
+	src Framer
+	dec *noise.CipherState
+	opts Options
+	eof error
+	seq uint64
+	// The frame in flight, if Options.ReadAhead is set, and the number of
+	// frames to read before the next prefetch.
+	ahead chan prefetched
+	backoff, skip int
+	// Consecutive frames, that failed to decrypt, see
+	// Options.MaxDecryptFailures.
+	failures int
+	// The audit record of the handshake.
+	audit *HandshakeAudit
+	// When the current key came into use, see Options.MaxSessionAge.
+	keyTime time.Time
+	// The expected sequence number as associated data, if
+	// Options.Sequenced is set.
+	seqAD [8]byte
+	// The inner layer, see Options.InnerPSK.
+	inner noise.Cipher
+	// If Options.CryptoWorkers is set, the frames are decrypted by up to
+	// CryptoWorkers goroutines, using the nonce counter kept here, that
+	// continues from the CipherState's, once seeded is set. gen counts the
+	// keys, that came into use.
+	opener *opener
+	nonce, gen uint64
+	seeded bool
+	// Options.Logger with the attributes of the session.
+	log *slog.Logger
+	// The session, as counted in Options.Metrics.
+	sess *metricSession
+	// The ID of the Connection or codec, see Connection.ID.
+	conn uint64
+}
+
+/*
+Reads the next data frame. Control frames are processed here and never
+returned: keepalives are dropped, rekeys applied, and a close frame causes
+io.EOF to be returned from now on, an error frame a *PeerError. Unknown frame
+types are ignored.
+*/
+func (f *frameReader) readFrame() ([]byte,error) {
+	return f.readFrameTo(nil)
+}
+
+/*
+Like readFrame, but decrypts into the buffer returned by dst, that is called
+with the size of the ciphertext, an upper bound of the size of the plaintext.
+*/
+func (f *frameReader) readFrameTo(dst func(n int) []byte) ([]byte,error) {
+	if f.ahead!=nil {
+		p := f.wait()
+		if p.err!=nil { return nil,p.err }
+		defer readPool.Put(p.b)
+		if dst==nil { return append([]byte(nil),p.buf...),nil }
+		return append(dst(len(p.buf)),p.buf...),nil
+	}
+	return f.readFrameNow(dst)
+}
+func (f *frameReader) readFrameNow(dst func(n int) []byte) ([]byte,error) {
+	for {
+		if f.eof!=nil { return nil,f.eof }
+		buf,err := f.open(dst)
+		if err!=nil { return nil,err }
+		// A rekey is the peer's way of retiring an old key; after an idle
+		// spell, it is the first frame, and must not end the session.
+		rekey := f.opts.Typed && len(buf)>0 && buf[0]==FrameRekey
+		if !rekey && f.expired() { return nil,f.teardown(ErrSessionExpired) }
+		if f.opts.Typed {
+			if len(buf)==0 { continue }
+			typ := buf[0]
+			buf = buf[1:]
+			switch typ {
+			case FrameData:
+			case FrameRekey:
+				f.rekey()
+				atomic.AddUint64(&f.stats.Rekeys,1)
+				f.opts.Metrics.add(rekeys,1)
+				logTo(f.log,slog.LevelDebug,"seep: rekey","direction","in")
+				continue
+			case FrameClose:
+				f.eof = io.EOF
+				burnCipher(f.dec)
+				f.stopOpener()
+				f.logClose(f.eof)
+				f.sess.end()
+				continue
+			case FrameError:
+				f.eof = decodePeerError(buf)
+				burnCipher(f.dec)
+				f.stopOpener()
+				f.logClose(f.eof)
+				f.sess.end()
+				continue
+			default:
+				continue
+			}
+		}
+		if f.opts.Compression {
+			// The compressor enforces MaxFrameSize while decompressing.
+			buf,err = f.opts.decompress(buf)
+			if err!=nil { return nil,err }
+		}
+		return buf,nil
+	}
+}
+/*
+Switches to the next receiving key. The opener, if any, is told, so that it
+guesses the new key for the frames to come.
+*/
+func (f *frameReader) rekey() {
+	f.dec.Rekey()
+	f.gen++
+	if f.opener!=nil { f.opener.guess(f) }
+	f.keyTime = time.Now()
+}
+
+/*
+Counts a frame, that failed to decrypt. After Options.MaxDecryptFailures
+consecutive failures, the session is torn down: the key is wiped, and
+ErrDecryptFailures returned from now on.
+*/
+func (f *frameReader) fail(err error) error {
+	atomic.AddUint64(&f.stats.DecryptFailures,1)
+	f.opts.Metrics.add(decryptFailures,1)
+	f.failures++
+	logTo(f.log,slog.LevelDebug,"seep: frame failed to decrypt","failures",f.failures)
+	max := f.opts.MaxDecryptFailures
+	if max==0 { max = 16 }
+	if max<0 || f.failures<max { return err }
+	return f.teardown(ErrDecryptFailures)
+}
+
+/*
+Ends the session: the key is wiped, err is returned from now on and reported
+to Options.Audit.
+*/
+func (f *frameReader) teardown(err error) error {
+	f.eof = err
+	burnCipher(f.dec)
+	f.dec = nil
+	f.stopOpener()
+	logTo(f.log,slog.LevelWarn,"seep: session torn down","err",err)
+	f.sess.end()
+	if f.audit!=nil && f.opts.Audit!=nil {
+		a := *f.audit
+		a.Err = err
+		f.opts.Audit(&a)
+	}
+	return err
+}
+func (f *frameReader) open(dst func(n int) []byte) ([]byte,error) {
+	if f.dec==nil { return nil,ErrNotEstablished }
+	if f.opts.CryptoWorkers>0 { return f.openAsync(dst) }
+	ct,err := f.src.ReadFrame(f.opts.MaxFrameSize)
+	if err!=nil { return nil,err }
+	f.received(ct)
+	ad,body,ok := f.split(ct)
+	if !ok { return nil,f.fail(ErrFrameAuth) }
+	var out []byte
+	if dst!=nil { out = dst(len(body)) }
+	buf,err := f.dec.Decrypt(out,ad,body)
+	if err!=nil { return nil,f.fail(ErrFrameAuth) }
+	return f.opened(ct,buf)
+}
+
+/*
+Like open, but the frame is taken from the opener, that has decrypted it on a
+worker goroutine. If the opener guessed the key, nonce or sequence number
+wrong, the frame is decrypted here, and the opener is corrected.
+*/
+func (f *frameReader) openAsync(dst func(n int) []byte) ([]byte,error) {
+	if !f.seeded {
+		f.seeded = true
+		f.nonce = f.dec.Nonce()
+	}
+	if f.opener==nil { f.opener = newOpener(f) }
+	j := f.opener.next()
+	if j.err!=nil {
+		// Like a Framer, the next call reads from the stream again.
+		f.opener = nil
+		return nil,j.err
+	}
+	f.received(j.ct)
+	buf,ok := j.buf,j.ok
+	if j.gen!=f.gen || j.nonce!=f.nonce || j.seq!=f.seq {
+		Wipe(buf)
+		buf,ok = nil,false
+		ad,body,valid := f.split(j.ct)
+		if valid {
+			var err error
+			buf,err = f.dec.Cipher().Decrypt(nil,f.nonce,ad,body)
+			ok = err==nil
+		}
+	}
+	if ok { f.nonce++ }
+	o := f.opener
+	defer func() {
+		if f.gen!=j.gen || f.nonce!=j.nonce+1 || f.seq!=j.seq+1 { o.guess(f) }
+	}()
+	if !ok { return nil,f.fail(ErrFrameAuth) }
+	if dst!=nil {
+		out := append(dst(len(buf)),buf...)
+		Wipe(buf)
+		buf = out
+	}
+	return f.opened(j.ct,buf)
+}
+
+/*
+Stops the opener, if any, so that the workers drop the key.
+*/
+func (f *frameReader) stopOpener() {
+	if f.opener==nil { return }
+	f.opener.close()
+	f.opener = nil
+}
+
+/*
+Counts a frame read.
+*/
+func (f *frameReader) received(ct []byte) {
+	atomic.AddUint64(&f.stats.FramesIn,1)
+	atomic.AddUint64(&f.stats.BytesIn,uint64(len(ct)))
+	atomic.StoreInt64(&f.last,time.Now().UnixNano())
+	f.opts.Metrics.add(framesIn,1)
+	f.opts.Metrics.add(bytesIn,uint64(len(ct)))
+}
+
+/*
+Splits a frame into the associated data and the ciphertext to be decrypted.
+*/
+func (f *frameReader) split(ct []byte) (ad,body []byte,ok bool) {
+	if !f.opts.Sequenced { return nil,ct,true }
+	if len(ct)<8 { return nil,nil,false }
+	/*
+	The expected number is authenticated in place of the one sent, so that a
+	frame out of sequence fails just like a forged one, after the same
+	amount of work.
+	*/
+	binary.BigEndian.PutUint64(f.seqAD[:],f.seq)
+	return f.seqAD[:],ct[8:],true
+}
+
+/*
+Finishes a frame, that decrypted fine: the padding and the inner layer are
+removed.
+*/
+func (f *frameReader) opened(ct, buf []byte) ([]byte,error) {
+	atomic.AddUint64(&f.nonces,1)
+	var err error
+	if f.opts.padded() {
+		buf,err = unpadBody(buf)
+		if err!=nil { return nil,f.fail(ErrFrameAuth) }
+	}
+	if f.inner!=nil {
+		buf,err = f.inner.Decrypt(buf[:0],f.seq,nil,buf)
+		if err!=nil { return nil,f.fail(ErrFrameAuth) }
+	}
+	f.failures = 0
+	if f.opts.Tap!=nil { f.opts.Tap(&TapFrame{ConnID:f.conn,Seq:f.seq,Plaintext:buf,Ciphertext:ct}) }
+	f.seq++
+	return buf,nil
+}
@@ -0,0 +1,149 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "io/ioutil"
+import "github.com/flynn/noise"
+
+/*
+Entry points for fuzzing the surfaces, that process data from the peer, in the
+style of go-fuzz: they return 1, if the input was parsed successfully (and
+should be preferred by the fuzzer), and 0 otherwise. They are deterministic
+and don't panic on any input, unless there is a bug.
+
+	func Fuzz(data []byte) int { return seep.FuzzFramer(data) }
+*/
+
+/*
+Parses data as a stream of frames in every framing mode and as a sequence of
+NoiseSocket handshake messages, then unpads every frame.
+*/
+func FuzzFramer(data []byte) int {
+	res := 0
+	for _,m := range []uint8{FramingXDR,FramingUint16,FramingArmor,FramingUvarint} {
+		f := newFramer(bytes.NewReader(data),nil,Options{Framing:m,ReadBuffer:-1})
+		for {
+			buf,err := f.ReadFrame(noise.MaxMsgLen)
+			if err!=nil { break }
+			res = 1
+			unpadBody(buf)
+		}
+	}
+	r := bytes.NewReader(data)
+	for {
+		_,_,err := readNLSMessage(r)
+		if err!=nil { break }
+	}
+	return res
+}
+
+/*
+An io.Reader, that yields zeros, to make the key generation of the handshake
+fuzzer deterministic.
+*/
+type fuzzRandom struct{}
+func (fuzzRandom) Read(p []byte) (int,error) {
+	for i := range p { p[i] = 0 }
+	return len(p),nil
+}
+
+var fuzzPatterns = []noise.HandshakePattern{noise.HandshakeNN,noise.HandshakeXX,noise.HandshakeNK,noise.HandshakeIK}
+
+/*
+Runs a handshake, that receives its messages from data. The first byte selects
+the pattern and the role, the rest is read as FramingUint16 frames; outgoing
+messages are discarded.
+*/
+func FuzzHandshake(data []byte) int {
+	if len(data)<1 { return 0 }
+	suite := noise.NewCipherSuite(noise.DH25519,noise.CipherChaChaPoly,noise.HashBLAKE2s)
+	key,err := suite.GenerateKeypair(fuzzRandom{})
+	if err!=nil { return 0 }
+	nc := noise.Config{
+		CipherSuite: suite,
+		Random: fuzzRandom{},
+		Pattern: fuzzPatterns[int(data[0]>>1)%len(fuzzPatterns)],
+		Initiator: data[0]&1!=0,
+		StaticKeypair: key,
+		PeerStatic: key.Public,
+	}
+	f := NewUint16Framer(bytes.NewReader(data[1:]),ioutil.Discard)
+	_,_,_,err = runHandshake(f,nc,nil,nil,nil,nil)
+	if err!=nil { return 0 }
+	return 1
+}
+
+/*
+The message body, the RPC fuzzers decode.
+*/
+type fuzzBody struct{
+	Name string
+	Seq uint64
+	Data []byte
+	Values []int32
+}
+
+/*
+Decodes data as an RPC message in the given format: the header, and the body
+into a sample structure. It can be used for any RpcFormat, including those of
+the codec packages.
+*/
+func FuzzFormat(f *RpcFormat, data []byte) int {
+	var h Header
+	err,body := f.Decode(data,&h)
+	if err!=nil { return 0 }
+	h.check()
+	if body==nil { return 0 }
+	var b fuzzBody
+	if body(&b)!=nil { return 0 }
+	return 1
+}
+
+/*
+Decodes data as an RPC message in XDRFormat.
+*/
+func FuzzXDR(data []byte) int { return FuzzFormat(XDRFormat,data) }
+
+/*
+Decodes data as an RPC message in GobFormat.
+*/
+func FuzzGob(data []byte) int { return FuzzFormat(GobFormat,data) }
+
+/*
+Decodes data as a sequence of RPC messages in a format returned by
+NewGobStreamFormat, each one preceded by its 2 byte big-endian length.
+*/
+func FuzzGobStream(data []byte) int {
+	f := NewGobStreamFormat()
+	fr := NewUint16Framer(bytes.NewReader(data),nil)
+	res := 0
+	for {
+		buf,err := fr.ReadFrame(0)
+		if err!=nil { return res }
+		if FuzzFormat(f,buf)==0 { return res }
+		res = 1
+	}
+}
@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "encoding/gob"
+import "fmt"
+import "reflect"
+import "sort"
+import "sync"
+import "github.com/davecgh/go-xdr/xdr2"
+
+/*
+Returned by the handshake of a gob codec using a TypeManifest, if the peer's
+manifest differs from the local one.
+*/
+type TypeMismatchError struct{
+	// Types registered locally, but not on the peer.
+	Missing []string
+	// Types registered on the peer, but not locally.
+	Unknown []string
+}
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("seep: gob type manifest mismatch: missing on peer %q, unknown locally %q",e.Missing,e.Unknown)
+}
+
+/*
+A TypeManifest is a set of concrete types, that are registered with gob and are
+expected to be registered on the peer as well. The codecs created with the
+manifest's Format exchange the manifests during setup, so that a missing
+registration fails the handshake, instead of failing a call with
+"gob: name not registered for interface" later.
+
+	m := seep.NewTypeManifest()
+	m.Register(new(MyType))
+	codec,err := seep.NewFormatRpcClient(src,dst,cfg,conn,m.Format(),nil)
+*/
+type TypeManifest struct{
+	lck sync.Mutex
+	names map[string]bool
+}
+func NewTypeManifest() *TypeManifest {
+	return &TypeManifest{names:make(map[string]bool)}
+}
+
+/*
+Same as gob.Register, but also adds the type to the manifest.
+*/
+func (m *TypeManifest) Register(v interface{}) {
+	gob.Register(v)
+	m.add(gobName(v))
+}
+
+/*
+Same as gob.RegisterName, but also adds the type to the manifest.
+*/
+func (m *TypeManifest) RegisterName(name string, v interface{}) {
+	gob.RegisterName(name,v)
+	m.add(name)
+}
+func (m *TypeManifest) add(name string) {
+	m.lck.Lock(); defer m.lck.Unlock()
+	m.names[name] = true
+}
+
+/*
+Returns the sorted names of the types in the manifest.
+*/
+func (m *TypeManifest) Names() []string {
+	m.lck.Lock(); defer m.lck.Unlock()
+	names := make([]string,0,len(m.names))
+	for n := range m.names { names = append(names,n) }
+	sort.Strings(names)
+	return names
+}
+
+/*
+Returns a gob RpcFormat, that verifies the manifest against the peer's.
+*/
+func (m *TypeManifest) Format() *RpcFormat {
+	return &RpcFormat{"gob",gobEncode,gobDecode,m.negotiate,gobAppend,gobEncodeTo,gobDecodeFrom,false}
+}
+
+func (m *TypeManifest) negotiate(initiator bool, send func([]byte) error, recv func() ([]byte,error)) error {
+	local := m.Names()
+	buf := new(bytes.Buffer)
+	_,err := xdr.Marshal(buf,local)
+	if err!=nil { return err }
+	var remote []string
+	if initiator {
+		err = send(buf.Bytes())
+		if err!=nil { return err }
+	}
+	b,err := recv()
+	if err!=nil { return err }
+	_,err = xdr.UnmarshalLimited(bytes.NewReader(b),&remote,uint(len(b)))
+	if err!=nil { return err }
+	if !initiator {
+		err = send(buf.Bytes())
+		if err!=nil { return err }
+	}
+
+	rset := make(map[string]bool)
+	for _,n := range remote { rset[n] = true }
+	e := new(TypeMismatchError)
+	for _,n := range local {
+		if !rset[n] { e.Missing = append(e.Missing,n) }
+		delete(rset,n)
+	}
+	for _,n := range remote {
+		if rset[n] { e.Unknown = append(e.Unknown,n) }
+	}
+	if len(e.Missing)!=0 || len(e.Unknown)!=0 { return e }
+	return nil
+}
+
+/*
+Computes the name, gob.Register uses for the type of v. Like gob, pointers to
+named types are named by their rt.String(), for instance "*pkg.T".
+*/
+func gobName(v interface{}) string {
+	rt := reflect.TypeOf(v)
+	if rt.Name()=="" { return rt.String() }
+	if rt.PkgPath()=="" { return rt.Name() }
+	return rt.PkgPath()+"."+rt.Name()
+}
@@ -0,0 +1,242 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/sha256"
+import "encoding/json"
+import "fmt"
+import "io"
+import "net"
+import "sort"
+import "github.com/flynn/noise"
+
+var framingNames = map[uint8]string{
+	FramingXDR:"xdr", FramingUint16:"uint16", FramingArmor:"armor", FramingUvarint:"uvarint",
+}
+
+/*
+A GoldenVector is a Vector generated by this package from fixed keys and
+payloads, that additionally records the framing: the bytes, that each side
+wrote to the stream. Implementations in other languages can check their
+handshake and transport messages against the Vector, and their framing
+against the wire bytes. Tools for the common format ignore the extra fields.
+*/
+type GoldenVector struct{
+	Vector
+	// The name of the framing: "xdr", "uint16", "armor" or "uvarint".
+	Framing  string   `json:"framing"`
+	InitWire HexBytes `json:"init_wire"`
+	RespWire HexBytes `json:"resp_wire"`
+}
+
+/*
+Loads a file of golden vectors ({"vectors":[...]}).
+*/
+func LoadGoldenVectors(r io.Reader) ([]*GoldenVector,error) {
+	var f struct{ Vectors []*GoldenVector `json:"vectors"` }
+	err := json.NewDecoder(r).Decode(&f)
+	return f.Vectors,err
+}
+
+/*
+Returns the names of all protocols, that golden vectors are generated for:
+every pattern, without and with a psk0 modifier, with every cipher suite.
+*/
+func GoldenProtocols() []string {
+	var names []string
+	for pat := range patterns {
+		for _,psk := range []string{"","psk0"} {
+			for dh := range dhFuncs {
+				for c := range cipherFuncs {
+					for h := range hashFuncs { names = append(names,"Noise_"+pat+psk+"_"+dh+"_"+c+"_"+h) }
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Returns the framings, that golden vectors are generated for.
+*/
+func GoldenFramings() []uint8 {
+	return []uint8{FramingXDR,FramingUint16,FramingArmor,FramingUvarint}
+}
+
+/*
+The fixed secret with the given label.
+*/
+func goldenBytes(label string) []byte {
+	h := sha256.Sum256([]byte("seep golden "+label))
+	return h[:]
+}
+
+/*
+Reports, whether the given side uses a static key in the pattern.
+*/
+func usesStatic(p noise.HandshakePattern, initiator bool) bool {
+	pre := p.ResponderPreMessages
+	if initiator { pre = p.InitiatorPreMessages }
+	for _,t := range pre {
+		if t==noise.MessagePatternS { return true }
+	}
+	for i,m := range p.Messages {
+		if (i%2==0)!=initiator { continue }
+		for _,t := range m {
+			if t==noise.MessagePatternS { return true }
+		}
+	}
+	return false
+}
+
+/*
+Generates the golden vector of the given protocol and framing: two transport
+messages follow the handshake, and every message carries the payload "seep
+golden message <n>".
+*/
+func GenerateGolden(protocol string, framing uint8) (*GoldenVector,error) {
+	name,ok := framingNames[framing]
+	if !ok { return nil,fmt.Errorf("seep: unknown framing %d",framing) }
+	p,err := ParseProtocolName(protocol)
+	if err!=nil { return nil,err }
+	g := &GoldenVector{Framing:name}
+	v := &g.Vector
+	v.ProtocolName = protocol
+	v.InitPrologue = goldenBytes("prologue")
+	v.RespPrologue = v.InitPrologue
+	v.InitEphemeral = goldenBytes("init ephemeral")
+	if len(p.Pattern.Messages)>1 { v.RespEphemeral = goldenBytes("resp ephemeral") }
+	if usesStatic(p.Pattern,true) { v.InitStatic = goldenBytes("init static") }
+	if usesStatic(p.Pattern,false) { v.RespStatic = goldenBytes("resp static") }
+	if len(p.Pattern.InitiatorPreMessages)>0 {
+		k,err := keypair(p.CipherSuite,v.InitStatic)
+		if err!=nil { return nil,err }
+		v.RespRemoteStatic = k.Public
+	}
+	if len(p.Pattern.ResponderPreMessages)>0 {
+		k,err := keypair(p.CipherSuite,v.RespStatic)
+		if err!=nil { return nil,err }
+		v.InitRemoteStatic = k.Public
+	}
+	if p.PSKPlacement>=0 {
+		psk := goldenBytes("psk")
+		v.InitPSKs,v.RespPSKs = []HexBytes{psk},[]HexBytes{psk}
+	}
+	for i := 0; i<len(p.Pattern.Messages)+2; i++ {
+		v.Messages = append(v.Messages,VectorMessage{Payload:[]byte(fmt.Sprintf("seep golden message %d",i))})
+	}
+	a,b := net.Pipe()
+	var iw,rw bytes.Buffer
+	ch := make(chan error,1)
+	go func() {
+		defer b.Close()
+		_,err := g.play(false,b,&rw,framing)
+		ch <- err
+	}()
+	v.HandshakeHash,err = g.play(true,a,&iw,framing)
+	a.Close()
+	if e := <-ch; err==nil { err = e }
+	if err!=nil { return nil,fmt.Errorf("seep: %s: %w",protocol,err) }
+	g.InitWire,g.RespWire = iw.Bytes(),rw.Bytes()
+	return g,nil
+}
+
+/*
+A Framer, that records the ciphertext of every message written in the vector.
+*/
+type goldenFramer struct{
+	Framer
+	v *Vector
+	i int
+}
+func (f *goldenFramer) ReadFrame(max int) ([]byte,error) {
+	f.i++
+	return f.Framer.ReadFrame(max)
+}
+func (f *goldenFramer) WriteFrame(p []byte) error {
+	f.v.Messages[f.i].Ciphertext = append([]byte(nil),p...)
+	f.i++
+	return f.Framer.WriteFrame(p)
+}
+
+/*
+Plays one side of the session over conn, copying the bytes written to wire.
+Returns the handshake hash.
+*/
+func (g *GoldenVector) play(initiator bool, conn io.ReadWriter, wire io.Writer, framing uint8) ([]byte,error) {
+	v := &g.Vector
+	nc,err := v.Config(initiator)
+	if err!=nil { return nil,err }
+	fr := newFramer(conn,io.MultiWriter(wire,conn),Options{Framing:framing,ReadBuffer:-1})
+	f := &goldenFramer{Framer:fr,v:v}
+	oneway := len(nc.Pattern.Messages)==1
+	sender := func(i int) bool { return oneway || i%2==0 }
+	payload := func() []byte { return v.Messages[f.i].Payload }
+	hs,enc,dec,err := runHandshake(f,nc,payload,nil,nil,nil)
+	if err!=nil { return nil,err }
+	w := &frameWriter{dst:f,enc:enc}
+	r := &frameReader{src:f,dec:dec}
+	for f.i<len(v.Messages) {
+		if sender(f.i)==initiator {
+			err = w.writeFrame(v.Messages[f.i].Payload,CompressionNone)
+		} else {
+			_,err = r.readFrame()
+		}
+		if err!=nil { return nil,err }
+	}
+	return hs.ChannelBinding(),nil
+}
+
+/*
+Verifies the vector (see Vector.Verify), and that the wire bytes of each side
+are exactly its messages in the framing of the vector.
+*/
+func (g *GoldenVector) Verify() error {
+	err := g.Vector.Verify()
+	if err!=nil { return err }
+	framing,ok := uint8(0),false
+	for k,n := range framingNames {
+		if n==g.Framing { framing,ok = k,true }
+	}
+	if !ok { return fmt.Errorf("seep: unknown framing %q",g.Framing) }
+	// Verify has parsed the name already.
+	p,_ := ParseProtocolName(g.ProtocolName)
+	oneway := len(p.Pattern.Messages)==1
+	for _,initiator := range []bool{true,false} {
+		wire := g.RespWire
+		if initiator { wire = g.InitWire }
+		f := newFramer(bytes.NewReader(wire),nil,Options{Framing:framing,ReadBuffer:-1})
+		for i,m := range g.Messages {
+			if (oneway || i%2==0)!=initiator { continue }
+			p,err := f.ReadFrame(0)
+			if err!=nil { return fmt.Errorf("seep: %s: message %d: %w",g.ProtocolName,i,err) }
+			if !bytes.Equal(p,m.Ciphertext) { return fmt.Errorf("seep: %s: message %d: framing %w",g.ProtocolName,i,ErrVectorMismatch) }
+		}
+		if _,err := f.ReadFrame(0); err!=io.EOF { return fmt.Errorf("seep: %s: trailing wire bytes: %w",g.ProtocolName,ErrVectorMismatch) }
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Group encryption for broadcasts with sender keys. A Hub hands every member a
+symmetric key of its own and the keys of all other members over their
+pairwise SEEP sessions. A member encrypts a broadcast once, with its key, and
+the Hub fans the same ciphertext out to the others, instead of encrypting it
+for each of them:
+
+	h := new(group.Hub)
+	s := &seep.Server{Config:cfg}
+	err := s.Listen("tcp",":7000")
+	// ... check error
+	go h.Serve(s)
+
+	c,err := seep.Dial("tcp","hub:7000",cfg,nil)
+	// ... check error
+	m,err := group.Join(c,cs)
+	err = m.Broadcast([]byte("hello"))
+	msg,err := m.Next()
+
+Whenever a member joins or leaves, the Hub starts a new epoch with fresh keys
+for everyone, so that members can't read the broadcasts from before they
+joined or after they left. Broadcasts sealed with the keys of the previous
+epoch are still accepted, as they may have been in flight.
+
+Sealed broadcasts (see Member.Seal and Member.Open) may be carried by other
+means than the Hub as well, for instance by UDP multicast. Since all members
+hold all keys, the sender of such a broadcast is only as trustworthy as the
+members are; the Hub makes sure, that the broadcasts it relays carry the ID
+of the member, that sent them.
+
+A sealed broadcast is the 4 byte sender ID, the 4 byte epoch and an 8 byte
+counter, all big-endian, followed by the ciphertext. The header is the
+additional data of the AEAD, the counter its nonce.
+*/
+package group
+
+import "encoding/binary"
+import "errors"
+import "github.com/flynn/noise"
+
+var ErrProtocol = errors.New("group: protocol violation")
+
+/*
+Returned by Open, for broadcasts of unknown senders or epochs, and for
+broadcasts, that were tampered with or replayed.
+*/
+var ErrOpen = errors.New("group: can't open broadcast")
+
+var ErrDenied = errors.New("group: not allowed to join")
+
+/*
+Disconnects members, that do not keep up with the broadcasts.
+*/
+var ErrSlowConsumer = errors.New("group: member too slow")
+
+const (
+	// hub to member: the keys of an epoch.
+	opKeys uint8 = iota+1
+	// both ways: a sealed broadcast.
+	opBroadcast
+)
+
+const (
+	keyLen = 32
+	headerLen = 16
+	// An entry of the keys: member ID and key.
+	keyEntryLen = 4+keyLen
+	// The broadcasts, that may arrive late, see window.
+	windowSize = 64
+)
+
+/*
+The keys of an epoch, as sent to a member: the op, the epoch, the member's
+own ID and the entries of all members.
+*/
+func encodeKeys(epoch, self uint32, keys map[uint32]*[keyLen]byte) []byte {
+	b := make([]byte,9,9+len(keys)*keyEntryLen)
+	b[0] = opKeys
+	binary.BigEndian.PutUint32(b[1:],epoch)
+	binary.BigEndian.PutUint32(b[5:],self)
+	for id,k := range keys {
+		b = binary.BigEndian.AppendUint32(b,id)
+		b = append(b,k[:]...)
+	}
+	return b
+}
+
+func decodeKeys(b []byte) (epoch, self uint32, keys map[uint32]*[keyLen]byte, err error) {
+	if len(b)<9 || (len(b)-9)%keyEntryLen!=0 { return 0,0,nil,ErrProtocol }
+	epoch = binary.BigEndian.Uint32(b[1:])
+	self = binary.BigEndian.Uint32(b[5:])
+	keys = make(map[uint32]*[keyLen]byte)
+	for p := b[9:]; len(p)>0; p = p[keyEntryLen:] {
+		k := new([keyLen]byte)
+		copy(k[:],p[4:keyEntryLen])
+		keys[binary.BigEndian.Uint32(p)] = k
+	}
+	if keys[self]==nil { return 0,0,nil,ErrProtocol }
+	return
+}
+
+func header(b []byte) (sender, epoch uint32, counter uint64, ok bool) {
+	if len(b)<headerLen { return 0,0,0,false }
+	return binary.BigEndian.Uint32(b),binary.BigEndian.Uint32(b[4:]),binary.BigEndian.Uint64(b[8:]),true
+}
+
+func seal(c noise.Cipher, sender, epoch uint32, counter uint64, p []byte) []byte {
+	b := make([]byte,headerLen,headerLen+len(p)+16)
+	binary.BigEndian.PutUint32(b,sender)
+	binary.BigEndian.PutUint32(b[4:],epoch)
+	binary.BigEndian.PutUint64(b[8:],counter)
+	return c.Encrypt(b,counter,b[:headerLen],p)
+}
+
+/*
+Rejects replayed broadcasts of a sender: the ones at or below the highest
+counter seen, that are either older than windowSize or already seen.
+*/
+type window struct{
+	max uint64
+	seen uint64
+	any bool
+}
+
+func (w *window) check(n uint64) bool {
+	if !w.any || n>w.max { return true }
+	d := w.max-n
+	return d<windowSize && w.seen&(1<<d)==0
+}
+
+func (w *window) mark(n uint64) {
+	if !w.any {
+		w.any,w.max,w.seen = true,n,1
+		return
+	}
+	if n>w.max {
+		d := n-w.max
+		if d>=windowSize { w.seen = 0 } else { w.seen <<= d }
+		w.max = n
+		w.seen |= 1
+		return
+	}
+	w.seen |= 1<<(w.max-n)
+}
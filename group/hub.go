@@ -0,0 +1,214 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package group
+
+import "crypto/rand"
+import "io"
+import "net"
+import "sync"
+import "github.com/mad-day/seep"
+
+/*
+A Hub hands out the keys of the members and relays their broadcasts. It only
+holds the keys, until they are sent. The zero value is ready to use.
+*/
+type Hub struct{
+	// The source of the keys, crypto/rand.Reader if nil.
+	Random io.Reader
+	// Decides, who may join, by the static key of the peer. Nil allows
+	// everybody.
+	Allow func(peer []byte) bool
+	// The number of frames queued for a member. A member, whose queue is
+	// full, is disconnected with ErrSlowConsumer, so that it can't hold up
+	// the others. 0 means 256.
+	QueueLen int
+
+	lck sync.Mutex
+	members map[uint32]*session
+	next uint32
+	epoch uint32
+}
+
+/*
+The Hub's side of a member's session.
+*/
+type session struct{
+	id uint32
+	c *seep.Conn
+	out chan []byte
+	// Closed by ServeConn, when the session ends.
+	done chan struct{}
+	once sync.Once
+	// The error, that ended the session, if the Hub ended it.
+	err error
+}
+
+/*
+Ends the session with err.
+*/
+func (s *session) kill(err error) {
+	s.once.Do(func() {
+		s.err = err
+		s.c.Close()
+	})
+}
+
+func (s *session) write() {
+	w := s.c.Writer.(*seep.Writer)
+	for {
+		select {
+		case p := <-s.out:
+			err := w.WriteMessage(p)
+			if err!=nil { s.kill(err); return }
+		case <-s.done:
+			return
+		}
+	}
+}
+
+/*
+Queues p for the member, or disconnects it, if its queue is full.
+*/
+func (s *session) send(p []byte) {
+	select {
+	case s.out <- p:
+	default:
+		go s.kill(ErrSlowConsumer)
+	}
+}
+
+/*
+Accepts sessions from l, typically a seep.Server, and serves each of them, until
+l fails. Connections, that are not *seep.Conn, are closed.
+*/
+func (h *Hub) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go h.ServeConn(sc)
+	}
+}
+
+/*
+Adds the peer of c to the group, relays its broadcasts, until it leaves, and
+closes c. Returns nil, if the member closed the session.
+*/
+func (h *Hub) ServeConn(c *seep.Conn) error {
+	if h.Allow!=nil && !h.Allow(c.Session().PeerStatic) {
+		c.Close()
+		return ErrDenied
+	}
+	n := h.QueueLen
+	if n==0 { n = 256 }
+	s := &session{c:c,out:make(chan []byte,n),done:make(chan struct{})}
+	err := h.join(s)
+	if err!=nil {
+		c.Close()
+		return err
+	}
+	go s.write()
+	defer func() {
+		h.leave(s)
+		close(s.done)
+		s.kill(nil)
+	}()
+	r := c.Reader.(*seep.Reader)
+	for {
+		p,err := r.ReadMessage()
+		if err==io.EOF { return s.err }
+		if err!=nil {
+			if s.err!=nil { return s.err }
+			return err
+		}
+		if len(p)==0 || p[0]!=opBroadcast { return ErrProtocol }
+		sender,epoch,_,ok := header(p[1:])
+		if !ok || sender!=s.id { return ErrProtocol }
+		h.relay(s,epoch,p)
+	}
+}
+
+/*
+Passes a broadcast on to the other members, unless it is sealed with the keys
+of an epoch before the previous one.
+*/
+func (h *Hub) relay(from *session, epoch uint32, p []byte) {
+	h.lck.Lock(); defer h.lck.Unlock()
+	if epoch!=h.epoch && epoch!=h.epoch-1 { return }
+	for id,s := range h.members {
+		if id!=from.id { s.send(p) }
+	}
+}
+
+/*
+Returns the number of members.
+*/
+func (h *Hub) Members() int {
+	h.lck.Lock(); defer h.lck.Unlock()
+	return len(h.members)
+}
+
+func (h *Hub) join(s *session) error {
+	h.lck.Lock(); defer h.lck.Unlock()
+	if h.members==nil { h.members = make(map[uint32]*session) }
+	h.next++
+	s.id = h.next
+	h.members[s.id] = s
+	err := h.rotate()
+	if err!=nil { delete(h.members,s.id) }
+	return err
+}
+
+func (h *Hub) leave(s *session) {
+	h.lck.Lock(); defer h.lck.Unlock()
+	delete(h.members,s.id)
+	if len(h.members)>0 { h.rotate() }
+}
+
+/*
+Starts a new epoch and sends its keys to the members. They are queued in line
+with the broadcasts, so that a member has the keys of a broadcast, before it
+receives it.
+*/
+func (h *Hub) rotate() error {
+	src := h.Random
+	if src==nil { src = rand.Reader }
+	keys := make(map[uint32]*[keyLen]byte,len(h.members))
+	defer func() {
+		for _,k := range keys { seep.Wipe(k[:]) }
+	}()
+	for id := range h.members {
+		k := new([keyLen]byte)
+		_,err := io.ReadFull(src,k[:])
+		if err!=nil { return err }
+		keys[id] = k
+	}
+	h.epoch++
+	for id,s := range h.members {
+		s.send(encodeKeys(h.epoch,id,keys))
+	}
+	return nil
+}
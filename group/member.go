@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package group
+
+import "io"
+import "sync"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+/*
+A broadcast received from another member.
+*/
+type Message struct{
+	// The ID of the sender, see Member.ID.
+	From uint32
+	Data []byte
+}
+
+/*
+The keys of an epoch.
+*/
+type epochKeys struct{
+	epoch uint32
+	ciphers map[uint32]noise.Cipher
+	windows map[uint32]*window
+}
+
+/*
+A member of a group. Its methods may be called concurrently. The broadcasts
+are read in line with the keys, so a Member, whose broadcasts are not
+consumed by Next, stalls, once a few of them are buffered.
+*/
+type Member struct{
+	c *seep.Conn
+	w *seep.Writer
+	cs noise.CipherSuite
+	// Held from sealing a broadcast until it is written, so that the
+	// counters go out in order.
+	wlck sync.Mutex
+	lck sync.Mutex
+	id uint32
+	cur,prev *epochKeys
+	counter uint64
+	msgs chan Message
+	// The error, that ended the session.
+	err error
+}
+
+/*
+Joins the group of the Hub, c is connected to, and waits for the keys. cs must
+be the same for all members. On failure, c is closed; ErrDenied means, that
+the Hub closed the session, before it sent the keys.
+*/
+func Join(c *seep.Conn, cs noise.CipherSuite) (*Member,error) {
+	m := &Member{c:c,w:c.Writer.(*seep.Writer),cs:cs,msgs:make(chan Message,64)}
+	p,err := c.Reader.(*seep.Reader).ReadMessage()
+	if err==nil { err = m.rekey(p) }
+	if err!=nil {
+		c.Close()
+		if err==io.EOF { err = ErrDenied }
+		return nil,err
+	}
+	go m.read()
+	return m,nil
+}
+
+func (m *Member) rekey(p []byte) error {
+	if len(p)==0 || p[0]!=opKeys { return ErrProtocol }
+	epoch,self,keys,err := decodeKeys(p)
+	seep.Wipe(p)
+	if err!=nil { return err }
+	e := &epochKeys{epoch:epoch,ciphers:make(map[uint32]noise.Cipher,len(keys)),windows:make(map[uint32]*window,len(keys))}
+	for id,k := range keys {
+		e.ciphers[id] = m.cs.Cipher(*k)
+		e.windows[id] = new(window)
+		seep.Wipe(k[:])
+	}
+	m.lck.Lock(); defer m.lck.Unlock()
+	m.prev,m.cur = m.cur,e
+	m.id = self
+	m.counter = 0
+	return nil
+}
+
+func (m *Member) read() {
+	r := m.c.Reader.(*seep.Reader)
+	var err error
+	for {
+		var p []byte
+		p,err = r.ReadMessage()
+		if err!=nil { break }
+		if len(p)>0 && p[0]==opKeys {
+			err = m.rekey(p)
+			if err!=nil { break }
+			continue
+		}
+		if len(p)==0 || p[0]!=opBroadcast { err = ErrProtocol; break }
+		// Broadcasts of stale epochs are dropped.
+		msg,oerr := m.Open(p[1:])
+		if oerr==nil { m.msgs <- *msg }
+	}
+	m.lck.Lock()
+	m.err = err
+	m.lck.Unlock()
+	close(m.msgs)
+}
+
+/*
+Returns the member's ID in the group. It stays the same across epochs.
+*/
+func (m *Member) ID() uint32 {
+	m.lck.Lock(); defer m.lck.Unlock()
+	return m.id
+}
+
+/*
+Encrypts p for the other members with the member's key of the current epoch.
+*/
+func (m *Member) Seal(p []byte) []byte {
+	m.lck.Lock(); defer m.lck.Unlock()
+	n := m.counter
+	m.counter++
+	return seal(m.cur.ciphers[m.id],m.id,m.cur.epoch,n,p)
+}
+
+/*
+Decrypts a broadcast sealed by a member of the current or the previous epoch.
+*/
+func (m *Member) Open(b []byte) (*Message,error) {
+	sender,epoch,n,ok := header(b)
+	if !ok { return nil,ErrOpen }
+	m.lck.Lock(); defer m.lck.Unlock()
+	e := m.cur
+	if e.epoch!=epoch { e = m.prev }
+	if e==nil || e.epoch!=epoch { return nil,ErrOpen }
+	c,w := e.ciphers[sender],e.windows[sender]
+	if c==nil || !w.check(n) { return nil,ErrOpen }
+	p,err := c.Decrypt(nil,n,b[:headerLen],b[headerLen:])
+	if err!=nil { return nil,ErrOpen }
+	w.mark(n)
+	return &Message{From:sender,Data:p},nil
+}
+
+/*
+Seals p and sends it to the other members through the Hub.
+*/
+func (m *Member) Broadcast(p []byte) error {
+	m.wlck.Lock(); defer m.wlck.Unlock()
+	return m.w.WriteMessage(append([]byte{opBroadcast},m.Seal(p)...))
+}
+
+/*
+Returns the next broadcast of another member. After the session ended, it
+returns the error, that ended it (io.EOF, if the Hub closed it).
+*/
+func (m *Member) Next() (Message,error) {
+	msg,ok := <-m.msgs
+	if ok { return msg,nil }
+	m.lck.Lock(); defer m.lck.Unlock()
+	return Message{},m.err
+}
+
+func (m *Member) Close() error {
+	return m.c.Close()
+}
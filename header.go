@@ -0,0 +1,129 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "net/rpc"
+
+/*
+The version of the RPC header envelope, that is written by this package. Peers
+reject headers with a different version; backwards compatible additions are
+made through Flags, Meta and Ext instead.
+*/
+const HeaderVersion = 1
+
+var ErrHeaderVersion = errors.New("seep: unsupported RPC header version")
+
+/*
+Returned, if a client codec receives a request or a server codec a response,
+that is, both peers use the same kind of codec.
+*/
+var ErrWrongRole = errors.New("seep: RPC codec used in the wrong role")
+
+/*
+Returned, if a body is read without reading its header first.
+*/
+var ErrNoHeader = errors.New("seep: RPC body read before its header")
+
+const (
+	// Set on headers of responses.
+	FlagResponse uint32 = 1<<iota
+)
+
+type Metadata struct{
+	Key   string
+	Value string
+}
+
+/*
+An extension field of a Header. Receivers ignore extensions of unknown types.
+*/
+type Extension struct{
+	Type uint32
+	Data []byte
+}
+
+/*
+The Header is the envelope, that precedes every request and response body on
+the wire, instead of the bare rpc.Request or rpc.Response structure. Callers
+attach metadata and extensions to a request with WithHeader, and Service
+handlers read them with RequestHeader.
+*/
+type Header struct{
+	Version uint32
+	Flags   uint32
+	Method  string
+	Seq     uint64
+	Error   string
+	Meta    []Metadata
+	Ext     []Extension
+}
+
+/*
+Returns the value of the first metadata entry with the given key.
+*/
+func (h *Header) Get(key string) (string,bool) {
+	for _,m := range h.Meta {
+		if m.Key==key { return m.Value,true }
+	}
+	return "",false
+}
+func (h *Header) Set(key, value string) {
+	for i := range h.Meta {
+		if h.Meta[i].Key==key { h.Meta[i].Value = value; return }
+	}
+	h.Meta = append(h.Meta,Metadata{key,value})
+}
+
+/*
+Returns the data of the first extension of the given type.
+*/
+func (h *Header) Extension(t uint32) ([]byte,bool) {
+	for _,e := range h.Ext {
+		if e.Type==t { return e.Data,true }
+	}
+	return nil,false
+}
+
+func (h *Header) check() error {
+	if h.Version!=HeaderVersion { return ErrHeaderVersion }
+	return nil
+}
+
+func (h *Header) fromRequest(r *rpc.Request) {
+	*h = Header{Version:HeaderVersion,Method:r.ServiceMethod,Seq:r.Seq}
+}
+func (h *Header) toRequest(r *rpc.Request) {
+	r.ServiceMethod = h.Method
+	r.Seq = h.Seq
+}
+func (h *Header) fromResponse(r *rpc.Response) {
+	*h = Header{Version:HeaderVersion,Flags:FlagResponse,Method:r.ServiceMethod,Seq:r.Seq,Error:r.Error}
+}
+func (h *Header) toResponse(r *rpc.Response) {
+	r.ServiceMethod = h.Method
+	r.Seq = h.Seq
+	r.Error = h.Error
+}
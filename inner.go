@@ -0,0 +1,64 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/hmac"
+import "crypto/sha256"
+import "errors"
+import "strings"
+import "github.com/flynn/noise"
+
+var ErrInnerKey = errors.New("seep: InnerPSK must be 32 bytes")
+
+/*
+Derives the key of the inner layer for one direction from the pre-shared key
+and the handshake hash.
+*/
+func innerKey(psk, hash []byte, label string) (k [32]byte) {
+	m := hmac.New(sha256.New,psk)
+	m.Write([]byte(label))
+	m.Write(hash)
+	copy(k[:],m.Sum(nil))
+	return
+}
+
+/*
+Sets up the inner layer (see Options.InnerPSK) of a freshly established
+session, given its handshake hash. Frames are encrypted with the frame
+sequence number as nonce, which never repeats for a key, as every handshake
+yields new keys. The length of the key has been checked by checkConfig.
+*/
+func setInner(w *frameWriter, r *frameReader, o Options, nc noise.Config, hash []byte) {
+	if o.InnerPSK==nil { return }
+	cf := noise.CipherChaChaPoly
+	if o.FIPS || strings.Contains(string(nc.CipherSuite.Name()),"ChaChaPoly") { cf = noise.CipherAESGCM }
+	out,in := "seep-inner i2r","seep-inner r2i"
+	if !nc.Initiator { out,in = in,out }
+	k := innerKey(o.InnerPSK,hash,out)
+	w.inner = cf.Cipher(k)
+	k = innerKey(o.InnerPSK,hash,in)
+	r.inner = cf.Cipher(k)
+	Wipe(k[:])
+}
@@ -0,0 +1,212 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "encoding/hex"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "github.com/flynn/noise"
+
+/*
+Byte strings in test vectors are hex encoded.
+*/
+type HexBytes []byte
+func (h HexBytes) MarshalJSON() ([]byte,error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+func (h *HexBytes) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b,&s)
+	if err!=nil { return err }
+	*h,err = hex.DecodeString(s)
+	return err
+}
+
+type VectorMessage struct{
+	Payload    HexBytes `json:"payload"`
+	Ciphertext HexBytes `json:"ciphertext"`
+}
+
+/*
+A Vector is a recorded Noise session in the JSON format used by cacophony,
+snow and noise-c ("protocol_name", "init_ephemeral", "messages", ...). The
+messages alternate between initiator and responder, starting with the
+initiator, except for one-way patterns, where all messages are sent by the
+initiator.
+*/
+type Vector struct{
+	ProtocolName     string          `json:"protocol_name"`
+	InitPrologue     HexBytes        `json:"init_prologue"`
+	InitPSKs         []HexBytes      `json:"init_psks,omitempty"`
+	InitStatic       HexBytes        `json:"init_static,omitempty"`
+	InitEphemeral    HexBytes        `json:"init_ephemeral"`
+	InitRemoteStatic HexBytes        `json:"init_remote_static,omitempty"`
+	RespPrologue     HexBytes        `json:"resp_prologue"`
+	RespPSKs         []HexBytes      `json:"resp_psks,omitempty"`
+	RespStatic       HexBytes        `json:"resp_static,omitempty"`
+	RespEphemeral    HexBytes        `json:"resp_ephemeral,omitempty"`
+	RespRemoteStatic HexBytes        `json:"resp_remote_static,omitempty"`
+	HandshakeHash    HexBytes        `json:"handshake_hash,omitempty"`
+	Messages         []VectorMessage `json:"messages"`
+}
+
+type vectorFile struct{
+	Vectors []*Vector `json:"vectors"`
+}
+
+var ErrVectorMismatch = errors.New("seep: output differs from the test vector")
+
+/*
+Loads a test vector file ({"vectors":[...]}).
+*/
+func LoadVectors(r io.Reader) ([]*Vector,error) {
+	var f vectorFile
+	err := json.NewDecoder(r).Decode(&f)
+	return f.Vectors,err
+}
+
+func keypair(cs noise.CipherSuite, priv []byte) (noise.DHKey,error) {
+	if len(priv)==0 { return noise.DHKey{},nil }
+	return cs.GenerateKeypair(bytes.NewReader(priv))
+}
+
+/*
+Returns the configuration of the given side of the vector.
+*/
+func (v *Vector) Config(initiator bool) (noise.Config,error) {
+	p,err := ParseProtocolName(v.ProtocolName)
+	if err!=nil { return noise.Config{},err }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:p.Pattern,Initiator:initiator}
+	static,eph,remote,psks := v.RespStatic,v.RespEphemeral,v.RespRemoteStatic,v.RespPSKs
+	nc.Prologue = v.RespPrologue
+	if initiator {
+		static,eph,remote,psks = v.InitStatic,v.InitEphemeral,v.InitRemoteStatic,v.InitPSKs
+		nc.Prologue = v.InitPrologue
+	}
+	nc.StaticKeypair,err = keypair(p.CipherSuite,static)
+	if err!=nil { return nc,err }
+	// The ephemeral key is generated while writing the first message, so it is
+	// fed through the random source.
+	if len(eph)>0 { nc.Random = bytes.NewReader(eph) }
+	nc.PeerStatic = remote
+	if p.PSKPlacement>=0 {
+		if len(psks)!=1 { return nc,fmt.Errorf("seep: %s: only a single psk is supported",v.ProtocolName) }
+		nc.PresharedKey = psks[0]
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return nc,nil
+}
+
+/*
+A Framer, that plays one side of a vector: frames written are compared with the
+recorded ciphertexts, frames read are taken from the recording.
+*/
+type vectorFramer struct{
+	v *Vector
+	initiator bool
+	oneway bool
+	i int
+}
+func (f *vectorFramer) sender(i int) bool {
+	return f.oneway || i%2==0
+}
+func (f *vectorFramer) ReadFrame(max int) ([]byte,error) {
+	if f.i>=len(f.v.Messages) { return nil,io.EOF }
+	if f.sender(f.i)==f.initiator { return nil,fmt.Errorf("seep: message %d: expected to write",f.i) }
+	m := f.v.Messages[f.i]
+	f.i++
+	return m.Ciphertext,nil
+}
+func (f *vectorFramer) WriteFrame(p []byte) error {
+	if f.i>=len(f.v.Messages) { return fmt.Errorf("seep: message %d: unexpected",f.i) }
+	if f.sender(f.i)!=f.initiator { return fmt.Errorf("seep: message %d: expected to read",f.i) }
+	m := f.v.Messages[f.i]
+	f.i++
+	if !bytes.Equal(p,m.Ciphertext) { return fmt.Errorf("seep: message %d: %w",f.i-1,ErrVectorMismatch) }
+	return nil
+}
+
+/*
+Replays the vector against the handshake and frame layer of this package,
+playing the given side. Every message written must be identical to the
+recorded ciphertext, and every message read must decrypt to the recorded
+payload.
+*/
+func (v *Vector) VerifySide(initiator bool) error {
+	nc,err := v.Config(initiator)
+	if err!=nil { return err }
+	f := &vectorFramer{v:v,initiator:initiator,oneway:len(nc.Pattern.Messages)==1}
+	var rerr error
+	payload := func() []byte {
+		if f.i>=len(v.Messages) { return nil }
+		return v.Messages[f.i].Payload
+	}
+	recv := func(b []byte) {
+		if rerr==nil && !bytes.Equal(b,v.Messages[f.i-1].Payload) {
+			rerr = fmt.Errorf("seep: message %d: payload %w",f.i-1,ErrVectorMismatch)
+		}
+	}
+	_,enc,dec,err := runHandshake(f,nc,payload,recv,nil,nil)
+	if err!=nil { return err }
+	if rerr!=nil { return rerr }
+	w := &frameWriter{dst:f,enc:enc}
+	r := &frameReader{src:f,dec:dec}
+	for f.i<len(v.Messages) {
+		m := v.Messages[f.i]
+		if f.sender(f.i)==initiator {
+			err = w.writeFrame(m.Payload,CompressionNone)
+			if err!=nil { return err }
+		} else {
+			b,err := r.readFrame()
+			if err!=nil { return err }
+			if !bytes.Equal(b,m.Payload) { return fmt.Errorf("seep: message %d: payload %w",f.i-1,ErrVectorMismatch) }
+		}
+	}
+	return nil
+}
+
+/*
+Reports, whether the vector can be replayed: it must not use features, that are
+not supported by github.com/flynn/noise (multiple psk modifiers, fallback
+patterns, 448 keys).
+*/
+func (v *Vector) Supported() bool {
+	_,err := ParseProtocolName(v.ProtocolName)
+	return err==nil && len(v.InitPSKs)<=1 && len(v.RespPSKs)<=1
+}
+
+/*
+Replays the vector playing both sides.
+*/
+func (v *Vector) Verify() error {
+	err := v.VerifySide(true)
+	if err!=nil { return fmt.Errorf("%s (initiator): %w",v.ProtocolName,err) }
+	err = v.VerifySide(false)
+	if err!=nil { return fmt.Errorf("%s (responder): %w",v.ProtocolName,err) }
+	return nil
+}
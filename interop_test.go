@@ -0,0 +1,52 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "os"
+import "path/filepath"
+import "testing"
+
+/*
+Replays the vector files of other Noise implementations in testdata, see
+testdata/README.md.
+*/
+func TestVectors(t *testing.T) {
+	files,err := filepath.Glob(filepath.Join("testdata","*.txt"))
+	if err!=nil { t.Fatal(err) }
+	if len(files)==0 { t.Skip("no vector files in testdata") }
+	for _,name := range files {
+		f,err := os.Open(name)
+		if err!=nil { t.Fatal(err) }
+		vs,err := LoadVectors(f)
+		f.Close()
+		if err!=nil { t.Fatalf("%s: %v",name,err) }
+		if len(vs)==0 { t.Fatalf("%s: no vectors",name) }
+		for i,v := range vs {
+			if !v.Supported() { continue }
+			err = v.Verify()
+			if err!=nil { t.Errorf("%s: vector %d: %v",name,i,err) }
+		}
+	}
+}
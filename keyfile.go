@@ -0,0 +1,168 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/rand"
+import "encoding/base64"
+import "encoding/pem"
+import "errors"
+import "fmt"
+import "github.com/flynn/noise"
+import "golang.org/x/crypto/scrypt"
+
+var ErrKeyFormat = errors.New("seep: invalid key")
+var ErrKeyPassphrase = errors.New("seep: wrong passphrase or corrupt key")
+
+/*
+The encodings of Curve25519 keys, see MarshalKey and ParseKey.
+*/
+type KeyFormat int
+const (
+	// The key, base64 encoded, on a line of its own, as used by WireGuard.
+	KeyBase64 KeyFormat = iota
+	// A PEM block of the type "SEEP PRIVATE KEY" or "SEEP PUBLIC KEY".
+	KeyPEM
+	// A PEM block of the type "SEEP ENCRYPTED PRIVATE KEY": the private key,
+	// encrypted with ChaCha20-Poly1305 under a key derived from a
+	// passphrase with scrypt. The salt and the scrypt parameters are kept
+	// in the headers of the block.
+	KeyEncrypted
+)
+
+const (
+	pemPrivate = "SEEP PRIVATE KEY"
+	pemPublic = "SEEP PUBLIC KEY"
+	pemEncrypted = "SEEP ENCRYPTED PRIVATE KEY"
+	// The scrypt parameters of new encrypted keys, and the maximum cost
+	// accepted from a key file. scrypt takes 128*N*r bytes of memory.
+	scryptN, scryptR, scryptP = 1<<15, 8, 1
+	scryptMaxN = 1<<20
+	scryptMaxMem = 256<<20
+)
+
+func encryptionKey(passphrase, salt []byte, n, r, p int) ([32]byte,error) {
+	var k [32]byte
+	b,err := scrypt.Key(passphrase,salt,n,r,p,32)
+	if err!=nil { return k,err }
+	copy(k[:],b)
+	Wipe(b)
+	return k,nil
+}
+
+/*
+Encodes the private key of k in the given format. The passphrase is used by
+KeyEncrypted only.
+*/
+func MarshalKey(k noise.DHKey, f KeyFormat, passphrase []byte) ([]byte,error) {
+	if len(k.Private)!=32 { return nil,ErrKeyFormat }
+	switch f {
+	case KeyBase64: return []byte(base64.StdEncoding.EncodeToString(k.Private)+"\n"),nil
+	case KeyPEM: return pem.EncodeToMemory(&pem.Block{Type:pemPrivate,Bytes:k.Private}),nil
+	case KeyEncrypted:
+		salt := make([]byte,16)
+		_,err := rand.Read(salt)
+		if err!=nil { return nil,err }
+		key,err := encryptionKey(passphrase,salt,scryptN,scryptR,scryptP)
+		if err!=nil { return nil,err }
+		defer Wipe(key[:])
+		ct := noise.CipherChaChaPoly.Cipher(key).Encrypt(nil,0,[]byte(pemEncrypted),k.Private)
+		return pem.EncodeToMemory(&pem.Block{Type:pemEncrypted,Headers:map[string]string{
+			"Kdf":fmt.Sprintf("scrypt %d %d %d",scryptN,scryptR,scryptP),
+			"Salt":base64.StdEncoding.EncodeToString(salt),
+		},Bytes:ct}),nil
+	}
+	return nil,ErrKeyFormat
+}
+
+/*
+Encodes a public key in the format KeyBase64 or KeyPEM.
+*/
+func MarshalPublicKey(pub []byte, f KeyFormat) ([]byte,error) {
+	if len(pub)!=32 { return nil,ErrKeyFormat }
+	switch f {
+	case KeyBase64: return []byte(base64.StdEncoding.EncodeToString(pub)+"\n"),nil
+	case KeyPEM: return pem.EncodeToMemory(&pem.Block{Type:pemPublic,Bytes:pub}),nil
+	}
+	return nil,ErrKeyFormat
+}
+
+/*
+Decodes a private key in any of the formats, and returns the key pair. The
+passphrase is needed for KeyEncrypted only.
+*/
+func ParseKey(b, passphrase []byte) (noise.DHKey,error) {
+	priv,err := parseKey(b,passphrase)
+	if err!=nil { return noise.DHKey{},err }
+	defer Wipe(priv)
+	// The public key is derived by "generating" the key pair from priv.
+	return noise.DH25519.GenerateKeypair(bytes.NewReader(priv))
+}
+func parseKey(b, passphrase []byte) ([]byte,error) {
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b,[]byte("-----BEGIN")) { return decodeKeyBase64(b) }
+	blk,_ := pem.Decode(b)
+	if blk==nil { return nil,ErrKeyFormat }
+	switch blk.Type {
+	case pemPrivate:
+		if len(blk.Bytes)!=32 { return nil,ErrKeyFormat }
+		return blk.Bytes,nil
+	case pemEncrypted:
+		var n,r,p int
+		_,err := fmt.Sscanf(blk.Headers["Kdf"],"scrypt %d %d %d",&n,&r,&p)
+		// scrypt panics on r or p of 0, and a forged file could make it
+		// allocate gigabytes, so the parameters of the file are checked
+		// before it is trusted with them.
+		if err!=nil || n<2 || n&(n-1)!=0 || n>scryptMaxN || r<1 || p<1 || r>64 || p>64 || r*p>64 { return nil,ErrKeyFormat }
+		if 128*int64(n)*int64(r)>scryptMaxMem { return nil,ErrKeyFormat }
+		salt,err := base64.StdEncoding.DecodeString(blk.Headers["Salt"])
+		if err!=nil { return nil,ErrKeyFormat }
+		key,err := encryptionKey(passphrase,salt,n,r,p)
+		if err!=nil { return nil,ErrKeyFormat }
+		defer Wipe(key[:])
+		priv,err := noise.CipherChaChaPoly.Cipher(key).Decrypt(nil,0,[]byte(pemEncrypted),blk.Bytes)
+		if err!=nil { return nil,ErrKeyPassphrase }
+		if len(priv)!=32 { return nil,ErrKeyFormat }
+		return priv,nil
+	}
+	return nil,ErrKeyFormat
+}
+
+/*
+Decodes a public key in the format KeyBase64 or KeyPEM.
+*/
+func ParsePublicKey(b []byte) ([]byte,error) {
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b,[]byte("-----BEGIN")) { return decodeKeyBase64(b) }
+	blk,_ := pem.Decode(b)
+	if blk==nil || blk.Type!=pemPublic || len(blk.Bytes)!=32 { return nil,ErrKeyFormat }
+	return blk.Bytes,nil
+}
+
+func decodeKeyBase64(b []byte) ([]byte,error) {
+	k,err := base64.StdEncoding.DecodeString(string(b))
+	if err!=nil || len(k)!=32 { return nil,ErrKeyFormat }
+	return k,nil
+}
@@ -0,0 +1,55 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/rand"
+import "encoding/pem"
+import "testing"
+
+func TestEncryptedKey(t *testing.T) {
+	k,_ := testSuite.GenerateKeypair(rand.Reader)
+	b,err := MarshalKey(k,KeyEncrypted,[]byte("secret"))
+	if err!=nil { t.Fatal(err) }
+	k2,err := ParseKey(b,[]byte("secret"))
+	if err!=nil || !bytes.Equal(k2.Private,k.Private) { t.Fatal(err) }
+	if _,err = ParseKey(b,[]byte("wrong")); err!=ErrKeyPassphrase { t.Fatal(err) }
+
+	// Forged parameters are refused before scrypt runs.
+	blk,_ := pem.Decode(b)
+	for _,kdf := range []string{
+		"scrypt 32768 0 1",
+		"scrypt 32768 8 0",
+		"scrypt 1000 8 1",
+		"scrypt 1 8 1",
+		"scrypt 2097152 1 1",
+		"scrypt 1048576 64 1",
+		"scrypt 1048576 8 1",
+		"scrypt 32768 4611686018427387904 4",
+	} {
+		blk.Headers["Kdf"] = kdf
+		if _,err = ParseKey(pem.EncodeToMemory(blk),[]byte("secret")); err!=ErrKeyFormat { t.Errorf("%s: %v",kdf,err) }
+	}
+}
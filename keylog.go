@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "encoding/hex"
+import "io"
+import "github.com/flynn/noise"
+
+/*
+Labels of the key log.
+
+The key log, written to Options.KeyLogWriter, records the secrets of
+completed handshakes in the style of SSLKEYLOGFILE: one line per secret,
+
+	<label> <session> <value>
+
+where session is the hex encoded handshake hash, identifying the session, and
+value is hex encoded (except for PROTOCOL, that carries the Noise protocol
+name). The Noise implementation doesn't expose the traffic keys, so the
+results of the DH operations are logged instead, named after the tokens of
+the pattern (DH_ES is the DH of the initiator's ephemeral and the
+responder's static key, whichever side logs it): together with the captured
+handshake messages, they suffice to recompute the handshake and thus all
+traffic keys. Only the ones involving an ephemeral key are logged, so that a
+leaked key log compromises the logged sessions, but not the parties. Patterns
+with an ss token or a psk modifier need the long-term secrets as well, that
+are only logged with Options.KeyLogStatic. Lines with other labels must be
+ignored by readers.
+
+All lines of a session are written with a single call to Write.
+*/
+const (
+	KeyLogProtocol       = "PROTOCOL"
+	KeyLogLocalEphemeral = "LOCAL_EPHEMERAL_PRIVATE_KEY"
+	KeyLogRemoteStatic   = "REMOTE_STATIC_PUBLIC_KEY"
+	KeyLogDHEE           = "DH_EE"
+	KeyLogDHES           = "DH_ES"
+	KeyLogDHSE           = "DH_SE"
+	// The long-term secrets, only logged with Options.KeyLogStatic.
+	KeyLogDHSS           = "DH_SS"
+	KeyLogLocalStatic    = "LOCAL_STATIC_PRIVATE_KEY"
+	KeyLogPresharedKey   = "PRESHARED_KEY"
+)
+
+func logKeys(w io.Writer, static bool, hs *noise.HandshakeState, nc noise.Config) {
+	if w==nil { return }
+	var b bytes.Buffer
+	session := hex.EncodeToString(hs.ChannelBinding())
+	line := func(label, value string) {
+		b.WriteString(label+" "+session+" "+value+"\n")
+	}
+	line(KeyLogProtocol,protocolName(nc))
+	e,s := hs.LocalEphemeral().Private,nc.StaticKeypair.Private
+	re,rs := hs.PeerEphemeral(),hs.PeerStatic()
+	if len(e)>0 { line(KeyLogLocalEphemeral,hex.EncodeToString(e)) }
+	if len(rs)>0 { line(KeyLogRemoteStatic,hex.EncodeToString(rs)) }
+	// Logs the DH of the local and the remote key, that a token stands for.
+	dh := func(label string, priv, pub []byte) {
+		if len(priv)==0 || len(pub)==0 { return }
+		k,err := nc.CipherSuite.DH(priv,pub)
+		if err!=nil { return }
+		line(label,hex.EncodeToString(k))
+		Wipe(k)
+	}
+	done := make(map[noise.MessagePattern]bool)
+	for _,msg := range nc.Pattern.Messages {
+		for _,t := range msg {
+			if done[t] { continue }
+			done[t] = true
+			switch {
+			case t==noise.MessagePatternDHEE: dh(KeyLogDHEE,e,re)
+			case t==noise.MessagePatternDHES && nc.Initiator: dh(KeyLogDHES,e,rs)
+			case t==noise.MessagePatternDHES: dh(KeyLogDHES,s,re)
+			case t==noise.MessagePatternDHSE && nc.Initiator: dh(KeyLogDHSE,s,re)
+			case t==noise.MessagePatternDHSE: dh(KeyLogDHSE,e,rs)
+			case t==noise.MessagePatternDHSS && static: dh(KeyLogDHSS,s,rs)
+			}
+		}
+	}
+	if static {
+		if len(s)>0 { line(KeyLogLocalStatic,hex.EncodeToString(s)) }
+		if len(nc.PresharedKey)>0 { line(KeyLogPresharedKey,hex.EncodeToString(nc.PresharedKey)) }
+	}
+	w.Write(b.Bytes())
+	wipeBuffer(&b)
+}
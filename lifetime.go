@@ -0,0 +1,57 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "time"
+
+/*
+Returned, once a key has outlived Options.MaxSessionAge.
+*/
+var ErrSessionExpired = errors.New("seep: session exceeded its maximum age")
+
+/*
+Rekeys, if the current key has reached Options.MaxSessionAge. The first frame
+starts the clock.
+*/
+func (f *frameWriter) renew() error {
+	if f.opts.MaxSessionAge<=0 { return nil }
+	now := time.Now()
+	if f.keyTime.IsZero() { f.keyTime = now }
+	if now.Sub(f.keyTime)<f.opts.MaxSessionAge { return nil }
+	if !f.opts.Typed { return ErrSessionExpired }
+	return f.writeControl(FrameRekey,nil)
+}
+
+/*
+Reports whether the frame just received was encrypted with a key, that the
+peer should have replaced by now.
+*/
+func (f *frameReader) expired() bool {
+	if f.opts.MaxSessionAge<=0 { return false }
+	now := time.Now()
+	if f.keyTime.IsZero() { f.keyTime = now }
+	return now.Sub(f.keyTime)>f.opts.MaxSessionAge+f.opts.MaxSessionAge/4
+}
@@ -0,0 +1,108 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "sync"
+import "time"
+
+/*
+A FailureLimiter counts failures (failed handshakes, rejected keys, sessions
+torn down after decryption failures) per peer, and bans peers, that fail too
+often, for a while. Peers are identified by arbitrary strings, such as an IP
+address or a key fingerprint. The zero value is ready to use, with the
+defaults given below. A FailureLimiter may be used concurrently.
+*/
+type FailureLimiter struct{
+	// The number of failures within Window, after which a peer is banned.
+	// Defaults to 10.
+	MaxFailures int
+	// Defaults to a minute.
+	Window time.Duration
+	// How long a peer stays banned. Defaults to 10 minutes.
+	BanTime time.Duration
+	// The maximum number of peers tracked. If exceeded, expired entries are
+	// dropped, and if that doesn't suffice, arbitrary ones. Defaults to
+	// 65536.
+	MaxPeers int
+
+	lck sync.Mutex
+	peers map[string]*peerFailures
+}
+
+type peerFailures struct{
+	n int
+	// The start of the current window, and the end of the ban, if any.
+	start, until time.Time
+}
+
+func (l *FailureLimiter) limits() (max int, window, ban time.Duration, peers int) {
+	max,window,ban,peers = l.MaxFailures,l.Window,l.BanTime,l.MaxPeers
+	if max<=0 { max = 10 }
+	if window<=0 { window = time.Minute }
+	if ban<=0 { ban = 10*time.Minute }
+	if peers<=0 { peers = 65536 }
+	return
+}
+
+/*
+Records a failure of peer.
+*/
+func (l *FailureLimiter) Fail(peer string) {
+	max,window,ban,peers := l.limits()
+	now := time.Now()
+	l.lck.Lock(); defer l.lck.Unlock()
+	if l.peers==nil { l.peers = make(map[string]*peerFailures) }
+	p := l.peers[peer]
+	if p==nil {
+		if len(l.peers)>=peers { l.prune(now,window,peers) }
+		p = &peerFailures{start:now}
+		l.peers[peer] = p
+	}
+	if now.Sub(p.start)>window {
+		p.n = 0
+		p.start = now
+	}
+	p.n++
+	if p.n>=max { p.until = now.Add(ban) }
+}
+
+/*
+Reports whether peer is currently banned.
+*/
+func (l *FailureLimiter) Banned(peer string) bool {
+	l.lck.Lock(); defer l.lck.Unlock()
+	p := l.peers[peer]
+	return p!=nil && time.Now().Before(p.until)
+}
+
+func (l *FailureLimiter) prune(now time.Time, window time.Duration, peers int) {
+	for k,p := range l.peers {
+		if now.Sub(p.start)>window && !now.Before(p.until) { delete(l.peers,k) }
+	}
+	for k := range l.peers {
+		if len(l.peers)<peers { break }
+		delete(l.peers,k)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "context"
+import "log/slog"
+import "sync/atomic"
+
+/*
+Logs to l, if it is not nil.
+*/
+func logTo(l *slog.Logger, level slog.Level, msg string, args ...interface{}) {
+	if l==nil { return }
+	l.Log(context.Background(),level,msg,args...)
+}
+
+/*
+Returns the logger of a session: Options.Logger with the attributes of its
+handshake.
+*/
+func (a *HandshakeAudit) logger(l *slog.Logger) *slog.Logger {
+	if l==nil { return nil }
+	args := []interface{}{"conn",a.ConnID,"protocol",a.Protocol,"initiator",a.Initiator}
+	if a.RemoteAddr!=nil { args = append(args,"remote",a.RemoteAddr.String()) }
+	if a.PeerStatic!=nil { args = append(args,"peer",Fingerprint(a.PeerStatic)) }
+	return l.With(args...)
+}
+
+func (f *frameWriter) logClose(typ uint8) {
+	if f.log==nil { return }
+	level,msg := slog.LevelInfo,"seep: session closed"
+	if typ==FrameError { level,msg = slog.LevelWarn,"seep: session closed with an error" }
+	logTo(f.log,level,msg,"frames_out",atomic.LoadUint64(&f.stats.FramesOut),"bytes_out",atomic.LoadUint64(&f.stats.BytesOut))
+}
+
+func (f *frameReader) logClose(err error) {
+	if f.log==nil { return }
+	args := []interface{}{"frames_in",atomic.LoadUint64(&f.stats.FramesIn),"bytes_in",atomic.LoadUint64(&f.stats.BytesIn)}
+	if pe,ok := err.(*PeerError); ok {
+		logTo(f.log,slog.LevelWarn,"seep: session closed by the peer with an error",append(args,"code",pe.Code,"message",pe.Message)...)
+		return
+	}
+	logTo(f.log,slog.LevelInfo,"seep: session closed by the peer",args...)
+}
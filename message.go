@@ -0,0 +1,229 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "errors"
+import "io"
+import "sync"
+import "time"
+
+/*
+Chunk tags of the message API. Every chunk of a message starts with one of
+them.
+*/
+const (
+	chunkMessage uint8 = iota
+	chunkFinal
+)
+
+var ErrTruncated = errors.New("seep: message truncated")
+var ErrBadChunk = errors.New("seep: invalid chunk")
+
+/*
+Writes a message as a sequence of chunks, each one a frame starting with a
+chunk tag. If rekey is set, the key is ratcheted forward after every chunk.
+*/
+type chunkWriter struct{
+	w *frameWriter
+	buf []byte
+	max int
+	rekey bool
+	// Set, once a chunk has been written.
+	started bool
+}
+func newChunkWriter(w *frameWriter, rekey bool) *chunkWriter {
+	max := w.chunkSize()-1
+	if max<1 { max = 1 }
+	return &chunkWriter{w:w,buf:make([]byte,1,max+1),max:max,rekey:rekey}
+}
+func (c *chunkWriter) Write(p []byte) (n int, err error) {
+	for len(p)>0 {
+		if len(c.buf)>c.max {
+			err = c.flush(chunkMessage)
+			if err!=nil { return }
+		}
+		l := copy(c.buf[len(c.buf):c.max+1],p)
+		c.buf = c.buf[:len(c.buf)+l]
+		n += l
+		p = p[l:]
+	}
+	return
+}
+func (c *chunkWriter) flush(tag uint8) error {
+	c.buf[0] = tag
+	err := c.w.writeFrame(c.buf,c.w.opts.Compressor)
+	if err!=nil { return err }
+	if c.rekey {
+		c.w.enc.Rekey()
+		c.w.keyTime = time.Now()
+	}
+	c.buf = c.buf[:1]
+	c.started = tag!=chunkFinal
+	return nil
+}
+
+/*
+Writes the final chunk.
+*/
+func (c *chunkWriter) close() error {
+	return c.flush(chunkFinal)
+}
+
+/*
+Abandons the current message: if no chunk has been written yet, it is
+discarded, otherwise it is terminated, so that the stream stays in sync.
+*/
+func (c *chunkWriter) abort() {
+	if c.started {
+		c.close()
+		return
+	}
+	c.buf = c.buf[:1]
+}
+
+/*
+Reads a message written by a chunkWriter. Read returns io.EOF after the final
+chunk, and ErrTruncated, if the stream ends before it.
+*/
+type chunkReader struct{
+	r *frameReader
+	buf []byte
+	done bool
+	rekey bool
+	// If not nil, held while reading a frame.
+	lck sync.Locker
+	// If not 0, the maximum size of the message, and the bytes read so far.
+	max,n int
+}
+func (c *chunkReader) Read(p []byte) (n int, err error) {
+	for len(c.buf)==0 {
+		if c.done { return 0,io.EOF }
+		err = c.next()
+		if err!=nil { return }
+	}
+	n = copy(p,c.buf)
+	c.buf = c.buf[n:]
+	return
+}
+func (c *chunkReader) next() error {
+	if c.lck!=nil {
+		c.lck.Lock(); defer c.lck.Unlock()
+	}
+	buf,err := c.r.readFrame()
+	if err==io.EOF { return ErrTruncated }
+	if err!=nil { return err }
+	if len(buf)==0 { return ErrBadChunk }
+	if c.rekey { c.r.rekey() }
+	switch buf[0] {
+	case chunkMessage:
+	case chunkFinal: c.done = true
+	default: return ErrBadChunk
+	}
+	c.buf = buf[1:]
+	c.n += len(c.buf)
+	if c.max>0 && c.n>c.max {
+		c.buf = nil
+		return ErrFrameTooLarge
+	}
+	return nil
+}
+
+/*
+Reads the rest of the message, discarding it, even if it is too large.
+*/
+func (c *chunkReader) drain() error {
+	for !c.done {
+		err := c.next()
+		if err!=nil && err!=ErrFrameTooLarge { return err }
+	}
+	c.buf = nil
+	return nil
+}
+
+/*
+A MessageWriter writes a single, arbitrarily large message as a sequence of
+chunks, in the style of libsodium's secretstream: every chunk is a frame of its
+own (and thus authenticated on its own), carries a tag, that marks the last
+chunk of the message, and the key is ratcheted forward after every chunk. The
+peer reads the message using a MessageReader.
+
+While a MessageWriter is open, it has exclusive use of its Writer. It must be
+closed to complete the message.
+
+	mw := w.NewMessage()
+	_,err := io.Copy(mw,file)
+	// ... check error
+	err = mw.Close()
+*/
+type MessageWriter struct{
+	w *Writer
+	cw *chunkWriter
+	closed bool
+	err error
+}
+
+/*
+Starts a new message. The Writer is locked until the MessageWriter is closed.
+*/
+func (w *Writer) NewMessage() *MessageWriter {
+	w.lck.Lock()
+	return &MessageWriter{w:w,cw:newChunkWriter(&w.frameWriter,true),err:w.flush()}
+}
+func (m *MessageWriter) Write(p []byte) (n int, err error) {
+	if m.closed { return 0,io.ErrClosedPipe }
+	if m.err!=nil { return 0,m.err }
+	return m.cw.Write(p)
+}
+
+/*
+Writes the final chunk and releases the Writer.
+*/
+func (m *MessageWriter) Close() error {
+	if m.closed { return nil }
+	m.closed = true
+	defer m.w.lck.Unlock()
+	if m.err!=nil { return m.err }
+	return m.cw.close()
+}
+
+/*
+A MessageReader reads a message written by a MessageWriter. Read returns
+io.EOF after the final chunk, and ErrTruncated, if the stream ends before it.
+*/
+type MessageReader struct{
+	chunkReader
+}
+
+/*
+Starts reading the next message. Data, that has been read from the Reader, but
+not yet consumed by Read, is discarded.
+*/
+func (r *Reader) NextMessage() *MessageReader {
+	r.lck.Lock(); defer r.lck.Unlock()
+	r.buf = bytes.Buffer{}
+	r.release()
+	return &MessageReader{chunkReader{r:&r.frameReader,rekey:true,lck:&r.lck}}
+}
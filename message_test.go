@@ -0,0 +1,98 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/rand"
+import "io"
+import "net"
+import "testing"
+import "github.com/flynn/noise"
+
+var testSuite = noise.NewCipherSuite(noise.DH25519,noise.CipherChaChaPoly,noise.HashBLAKE2s)
+
+/*
+Returns both ends of a connection over net.Pipe, after an NN handshake, with
+the options oi of the initiator and or of the responder.
+*/
+func testPair(t *testing.T, oi, or Options) (*Conn,*Conn) {
+	t.Helper()
+	a,b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	ch := make(chan *Conn,1)
+	go func() {
+		c,err := NewConn(b,noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader},&or)
+		if err!=nil { t.Error(err) }
+		ch <- c
+	}()
+	c,err := NewConn(a,noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader,Initiator:true},&oi)
+	if err!=nil { t.Fatal(err) }
+	d := <-ch
+	if d==nil { t.FailNow() }
+	return c,d
+}
+
+func testData(n int) []byte {
+	p := make([]byte,n)
+	rand.Read(p)
+	return p
+}
+
+/*
+Sends msgs as chunked messages and checks, that they arrive intact.
+*/
+func testMessages(t *testing.T, a, b *Conn, msgs [][]byte) {
+	t.Helper()
+	errs := make(chan error,1)
+	go func() {
+		w := a.Writer.(*Writer)
+		for _,m := range msgs {
+			mw := w.NewMessage()
+			_,err := mw.Write(m)
+			if err==nil { err = mw.Close() }
+			if err!=nil { errs <- err; return }
+		}
+		errs <- w.Flush()
+	}()
+	r := b.Reader.(*Reader)
+	for i,m := range msgs {
+		got,err := io.ReadAll(r.NextMessage())
+		if err!=nil { t.Fatalf("message %d: %v",i,err) }
+		if !bytes.Equal(got,m) { t.Fatalf("message %d differs",i) }
+	}
+	if err := <-errs; err!=nil { t.Fatal(err) }
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	for _,o := range []Options{
+		{},
+		{ReadAhead:true},
+		{CryptoWorkers:2},
+		{CryptoWorkers:4,Sequenced:true},
+	} {
+		a,b := testPair(t,o,o)
+		testMessages(t,a,b,[][]byte{testData(10),testData(200000),nil,testData(70000)})
+	}
+}
@@ -0,0 +1,152 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "io"
+import "net"
+import "sync"
+import "sync/atomic"
+
+/*
+Metrics aggregates the counters of all sessions, that share it through
+Options.Metrics, for monitoring, see the packages metrics/prometheus and
+metrics/expvar. The zero value is ready to use. The fields are maintained with
+atomic operations; use Load to read them.
+*/
+type Metrics struct{
+	// First, to be 64-bit aligned for the atomic operations. The totals of
+	// the frames of all sessions.
+	Counters
+	// Sessions established, that have not been closed, burned or torn down
+	// yet.
+	Active int64
+	// Successful handshakes, including Connection.Upgrade.
+	Handshakes uint64
+
+	lck sync.Mutex
+	failures map[string]uint64
+}
+
+/*
+Reasons of failed handshakes, see Metrics.HandshakeFailures.
+*/
+const (
+	// The peer's handshake messages failed to authenticate.
+	FailureAuth = "auth"
+	// The peer was refused: by Options.VerifyPeer, a FailureLimiter, or
+	// the password authentication.
+	FailureRefused = "refused"
+	// The initiation was a replay, see Options.ReplayCache.
+	FailureReplay = "replay"
+	// The handshake was evicted by a Server, see PendingLimits.
+	FailureEvicted = "evicted"
+	// The configuration was refused, see Options.FIPS and
+	// Options.RequirePeerAuth.
+	FailurePolicy = "policy"
+	// The underlying stream failed or ended.
+	FailureIO = "io"
+	FailureOther = "other"
+)
+
+func failureReason(err error) string {
+	is := func(targets ...error) bool {
+		for _,t := range targets {
+			if errors.Is(err,t) { return true }
+		}
+		return false
+	}
+	var ne net.Error
+	switch {
+	case is(ErrHandshakeAuth,ErrNLSProtocol): return FailureAuth
+	case is(ErrPeerNotPinned,ErrBanned,ErrAuthFailed,ErrNLSRejected): return FailureRefused
+	case is(ErrReplay): return FailureReplay
+	case is(ErrHandshakeEvicted): return FailureEvicted
+	case is(ErrSuiteNotAllowed,ErrUnauthenticatedPattern,ErrNoVerifier,ErrInnerKey): return FailurePolicy
+	case is(io.EOF,io.ErrUnexpectedEOF), errors.As(err,&ne): return FailureIO
+	}
+	return FailureOther
+}
+
+/*
+Returns a consistent copy of each counter.
+*/
+func (m *Metrics) Load() (c Counters, active int64, handshakes uint64) {
+	return m.Counters.load(),atomic.LoadInt64(&m.Active),atomic.LoadUint64(&m.Handshakes)
+}
+
+/*
+Returns the number of failed handshakes by reason (FailureAuth, ...).
+*/
+func (m *Metrics) HandshakeFailures() map[string]uint64 {
+	m.lck.Lock(); defer m.lck.Unlock()
+	r := make(map[string]uint64,len(m.failures))
+	for k,v := range m.failures { r[k] = v }
+	return r
+}
+
+func (m *Metrics) handshake(err error) {
+	if m==nil { return }
+	if err==nil {
+		atomic.AddUint64(&m.Handshakes,1)
+		return
+	}
+	m.lck.Lock(); defer m.lck.Unlock()
+	if m.failures==nil { m.failures = make(map[string]uint64) }
+	m.failures[failureReason(err)]++
+}
+
+/*
+Adds n to the counter of m selected by field, if m is not nil.
+*/
+func (m *Metrics) add(field func(*Counters) *uint64, n uint64) {
+	if m==nil { return }
+	atomic.AddUint64(field(&m.Counters),n)
+}
+
+func framesIn(c *Counters) *uint64 { return &c.FramesIn }
+func bytesIn(c *Counters) *uint64 { return &c.BytesIn }
+func framesOut(c *Counters) *uint64 { return &c.FramesOut }
+func bytesOut(c *Counters) *uint64 { return &c.BytesOut }
+func decryptFailures(c *Counters) *uint64 { return &c.DecryptFailures }
+func rekeys(c *Counters) *uint64 { return &c.Rekeys }
+
+/*
+An established session, counted in Metrics.Active until it ends.
+*/
+type metricSession struct{
+	m *Metrics
+	ended int32
+}
+
+func (m *Metrics) start() *metricSession {
+	if m==nil { return nil }
+	atomic.AddInt64(&m.Active,1)
+	return &metricSession{m:m}
+}
+func (s *metricSession) end() {
+	if s==nil || !atomic.CompareAndSwapInt32(&s.ended,0,1) { return }
+	atomic.AddInt64(&s.m.Active,-1)
+}
@@ -0,0 +1,71 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Publishes transport metrics of seep via expvar, for basic visibility without
+the Prometheus client library. Importing this package publishes Metrics under
+the name "seep"; sessions are counted in it, if it is set as Options.Metrics:
+
+	import seepexpvar "github.com/mad-day/seep/metrics/expvar"
+
+	o := &seep.Options{Metrics:seepexpvar.Metrics}
+
+The variable is a JSON object with the fields active, handshakes,
+handshake_failures (by reason), frames_in, frames_out, bytes_in, bytes_out,
+decrypt_failures and rekeys.
+*/
+package expvar
+
+import "expvar"
+import "github.com/mad-day/seep"
+
+/*
+The package-level metrics, published as "seep".
+*/
+var Metrics = new(seep.Metrics)
+
+func init() {
+	Publish("seep",Metrics)
+}
+
+/*
+Publishes m under name, like expvar.Publish, which panics, if the name is
+already in use.
+*/
+func Publish(name string, m *seep.Metrics) {
+	expvar.Publish(name,expvar.Func(func() interface{} {
+		c,active,handshakes := m.Load()
+		return map[string]interface{}{
+			"active":active,
+			"handshakes":handshakes,
+			"handshake_failures":m.HandshakeFailures(),
+			"frames_in":c.FramesIn,
+			"frames_out":c.FramesOut,
+			"bytes_in":c.BytesIn,
+			"bytes_out":c.BytesOut,
+			"decrypt_failures":c.DecryptFailures,
+			"rekeys":c.Rekeys,
+		}
+	}))
+}
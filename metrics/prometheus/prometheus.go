@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A Prometheus collector for the transport metrics of seep. It lives in a
+package of its own, so that the seep package doesn't depend on the Prometheus
+client library.
+
+	import seepprom "github.com/mad-day/seep/metrics/prometheus"
+
+	m := new(seep.Metrics)
+	o := &seep.Options{Metrics:m}
+	prometheus.MustRegister(seepprom.NewCollector(m,nil))
+*/
+package prometheus
+
+import "github.com/mad-day/seep"
+import prom "github.com/prometheus/client_golang/prometheus"
+
+var reasons = []string{
+	seep.FailureAuth,
+	seep.FailureRefused,
+	seep.FailureReplay,
+	seep.FailureEvicted,
+	seep.FailurePolicy,
+	seep.FailureIO,
+	seep.FailureOther,
+}
+
+type collector struct{
+	m *seep.Metrics
+	active, handshakes, failures, frames, bytes, decrypt, rekeys *prom.Desc
+}
+
+/*
+Returns a collector exporting m:
+
+	seep_active_sessions                      gauge
+	seep_handshakes_total                     counter
+	seep_handshake_failures_total{reason}     counter
+	seep_frames_total{direction}              counter, direction "in" or "out"
+	seep_bytes_total{direction}               counter
+	seep_decrypt_failures_total               counter
+	seep_rekeys_total                         counter
+
+labels are added to every metric, for instance to tell several Metrics apart.
+*/
+func NewCollector(m *seep.Metrics, labels prom.Labels) prom.Collector {
+	desc := func(name, help string, vars ...string) *prom.Desc {
+		return prom.NewDesc("seep_"+name,help,vars,labels)
+	}
+	return &collector{
+		m:m,
+		active:desc("active_sessions","Sessions established and not yet closed."),
+		handshakes:desc("handshakes_total","Successful handshakes."),
+		failures:desc("handshake_failures_total","Failed handshakes by reason.","reason"),
+		frames:desc("frames_total","Frames exchanged.","direction"),
+		bytes:desc("bytes_total","Bytes of encrypted frames exchanged.","direction"),
+		decrypt:desc("decrypt_failures_total","Received frames, that failed to decrypt."),
+		rekeys:desc("rekeys_total","Rekeys sent and received."),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prom.Desc) {
+	for _,d := range []*prom.Desc{c.active,c.handshakes,c.failures,c.frames,c.bytes,c.decrypt,c.rekeys} { ch <- d }
+}
+
+func (c *collector) Collect(ch chan<- prom.Metric) {
+	cnt,active,handshakes := c.m.Load()
+	counter := func(d *prom.Desc, v uint64, lv ...string) {
+		ch <- prom.MustNewConstMetric(d,prom.CounterValue,float64(v),lv...)
+	}
+	ch <- prom.MustNewConstMetric(c.active,prom.GaugeValue,float64(active))
+	counter(c.handshakes,handshakes)
+	failures := c.m.HandshakeFailures()
+	for _,r := range reasons { counter(c.failures,failures[r],r) }
+	counter(c.frames,cnt.FramesIn,"in")
+	counter(c.frames,cnt.FramesOut,"out")
+	counter(c.bytes,cnt.BytesIn,"in")
+	counter(c.bytes,cnt.BytesOut,"out")
+	counter(c.decrypt,cnt.DecryptFailures)
+	counter(c.rekeys,cnt.Rekeys)
+}
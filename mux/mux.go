@@ -0,0 +1,248 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A stream multiplexer over a SEEP session: any number of Streams, each a
+net.Conn of its own, share one encrypted connection. Either side opens
+Streams, with a piece of metadata (for instance the address to connect to),
+that the other side receives with Accept.
+
+	sess := mux.New(c)                        // c is a *seep.Conn
+	st,err := sess.Open([]byte("localhost:80"))
+	// ... check error, use st like a net.Conn
+
+Every Stream has a receive window: a side sends no more data, than the other
+side has room for, so a Stream, whose data is not read, holds up neither the
+session nor the other Streams.
+
+Every frame is a single frame of the session (see seep.Writer.WriteMessage):
+a frame type, a 4 byte big-endian stream ID and the payload. The initiator of
+the handshake uses odd stream IDs, the responder even ones.
+*/
+package mux
+
+import "encoding/binary"
+import "errors"
+import "sync"
+import "github.com/mad-day/seep"
+
+var ErrSessionClosed = errors.New("mux: session closed")
+var ErrProtocol = errors.New("mux: protocol violation")
+
+/*
+Returned by the Stream, after the peer reset it, see Stream.Reset.
+*/
+var ErrReset = errors.New("mux: stream reset by the peer")
+
+/*
+Returned by Accept of the peer, or by Read and Write of the Stream opened, if
+the peer's backlog of Streams waiting for Accept was full.
+*/
+var ErrRefused = errors.New("mux: stream refused")
+
+const (
+	// Opens a stream. The payload is the metadata.
+	frameOpen uint8 = iota
+	frameData
+	// Ends the sending direction of a stream.
+	frameFin
+	// Aborts a stream in both directions. The payload is a reason code.
+	frameReset
+	// Grants the peer more room in the receive window. The payload is the
+	// 4 byte big-endian number of bytes.
+	frameWindow
+)
+
+/*
+Reason codes of frameReset.
+*/
+const (
+	resetAbort uint8 = iota
+	resetRefused
+)
+
+const (
+	// The receive window of every Stream.
+	window = 256<<10
+	// The largest payload of a data frame.
+	maxData = 16<<10
+	// The number of opened Streams, that may wait for Accept.
+	backlog = 64
+)
+
+/*
+A Session multiplexes Streams over a SEEP session.
+*/
+type Session struct{
+	c *seep.Conn
+	w *seep.Writer
+	lck sync.Mutex
+	streams map[uint32]*Stream
+	next uint32
+	accept chan *Stream
+	done chan struct{}
+	err error
+}
+
+/*
+Starts a Session on an established session. The Session owns c from now on:
+it reads from it, and closes it, when the Session ends.
+*/
+func New(c *seep.Conn) *Session {
+	s := &Session{c:c,w:c.Writer.(*seep.Writer),streams:make(map[uint32]*Stream),accept:make(chan *Stream,backlog),done:make(chan struct{})}
+	s.next = 2
+	if c.Session().Initiator { s.next = 1 }
+	go s.read()
+	return s
+}
+
+func (s *Session) write(typ uint8, id uint32, p []byte) error {
+	b := make([]byte,5,5+len(p))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:],id)
+	err := s.w.WriteMessage(append(b,p...))
+	if err!=nil {
+		s.shutdown(err)
+		return s.Err()
+	}
+	return nil
+}
+
+func (s *Session) read() {
+	r := s.c.Reader.(*seep.Reader)
+	for {
+		p,err := r.ReadMessage()
+		if err==nil { err = s.handle(p) }
+		if err!=nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handle(p []byte) error {
+	if len(p)<5 { return ErrProtocol }
+	typ,id,p := p[0],binary.BigEndian.Uint32(p[1:]),p[5:]
+	s.lck.Lock()
+	st := s.streams[id]
+	s.lck.Unlock()
+	if typ==frameOpen {
+		// The peer's IDs have the other parity.
+		if st!=nil || id%2==s.next%2 { return ErrProtocol }
+		st = newStream(s,id,append([]byte(nil),p...))
+		s.lck.Lock()
+		s.streams[id] = st
+		s.lck.Unlock()
+		select {
+		case s.accept <- st:
+		default:
+			st.reset(resetRefused)
+		}
+		return nil
+	}
+	if st==nil {
+		// A stream, that has already been closed on this side.
+		return nil
+	}
+	switch typ {
+	case frameData: return st.received(p)
+	case frameFin: st.finished()
+	case frameReset:
+		err := ErrReset
+		if len(p)==1 && p[0]==resetRefused { err = ErrRefused }
+		st.fail(err)
+	case frameWindow:
+		if len(p)!=4 { return ErrProtocol }
+		st.grant(binary.BigEndian.Uint32(p))
+	default:
+		return ErrProtocol
+	}
+	return nil
+}
+
+func (s *Session) remove(id uint32) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	delete(s.streams,id)
+}
+
+func (s *Session) shutdown(err error) {
+	s.lck.Lock()
+	if s.err!=nil { s.lck.Unlock(); return }
+	s.err = err
+	streams := s.streams
+	s.streams = map[uint32]*Stream{}
+	close(s.done)
+	s.lck.Unlock()
+	for _,st := range streams { st.fail(ErrSessionClosed) }
+	s.c.Close()
+}
+
+/*
+Returns the error, that ended the Session, or nil, while it runs.
+*/
+func (s *Session) Err() error {
+	s.lck.Lock(); defer s.lck.Unlock()
+	return s.err
+}
+
+/*
+Returns a channel, that is closed, when the Session ends.
+*/
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+/*
+Opens a new Stream, passing meta to the peer's Accept.
+*/
+func (s *Session) Open(meta []byte) (*Stream,error) {
+	if len(meta)>maxData { return nil,seep.ErrFrameTooLarge }
+	s.lck.Lock()
+	if s.err!=nil { s.lck.Unlock(); return nil,ErrSessionClosed }
+	id := s.next
+	s.next += 2
+	st := newStream(s,id,meta)
+	s.streams[id] = st
+	s.lck.Unlock()
+	err := s.write(frameOpen,id,meta)
+	if err!=nil { return nil,err }
+	return st,nil
+}
+
+/*
+Returns the next Stream opened by the peer.
+*/
+func (s *Session) Accept() (*Stream,error) {
+	select {
+	case st := <-s.accept: return st,nil
+	case <-s.done:
+	}
+	return nil,ErrSessionClosed
+}
+
+/*
+Ends the Session, all of its Streams, and closes the underlying connection.
+*/
+func (s *Session) Close() error {
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
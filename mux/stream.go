@@ -0,0 +1,263 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mux
+
+import "bytes"
+import "encoding/binary"
+import "io"
+import "net"
+import "os"
+import "sync"
+import "time"
+
+/*
+A Stream is a bidirectional byte stream within a Session. It implements
+net.Conn; CloseWrite ends the sending direction only.
+*/
+type Stream struct{
+	s *Session
+	id uint32
+	meta []byte
+
+	lck sync.Mutex
+	buf bytes.Buffer
+	// The bytes received, that have not been credited back to the peer,
+	// and the part of them, that has been read.
+	pending, unacked int
+	// The bytes, that may be sent.
+	credit int
+	finSent, finRecv bool
+	// Set by Close.
+	closed bool
+	// Set, once the Stream failed (reset, or the Session ended).
+	err error
+	// Signalled, when there is something to read, or to write.
+	rnotify, wnotify chan struct{}
+	rdl, wdl deadline
+}
+
+func newStream(s *Session, id uint32, meta []byte) *Stream {
+	st := &Stream{s:s,id:id,meta:meta,credit:window,rnotify:make(chan struct{},1),wnotify:make(chan struct{},1)}
+	st.rdl.c,st.wdl.c = make(chan struct{}),make(chan struct{})
+	return st
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+/*
+Returns the metadata, the Stream was opened with.
+*/
+func (st *Stream) Meta() []byte { return st.meta }
+
+func (st *Stream) received(p []byte) error {
+	st.lck.Lock(); defer st.lck.Unlock()
+	if st.finRecv { return ErrProtocol }
+	st.pending += len(p)
+	if st.pending>window { return ErrProtocol }
+	if st.closed || st.err!=nil { return nil }
+	st.buf.Write(p)
+	signal(st.rnotify)
+	return nil
+}
+
+func (st *Stream) finished() {
+	st.lck.Lock()
+	st.finRecv = true
+	done := st.finSent
+	st.lck.Unlock()
+	signal(st.rnotify)
+	if done { st.s.remove(st.id) }
+}
+
+func (st *Stream) grant(n uint32) {
+	st.lck.Lock()
+	st.credit += int(n)
+	st.lck.Unlock()
+	signal(st.wnotify)
+}
+
+func (st *Stream) fail(err error) {
+	st.lck.Lock()
+	if st.err==nil { st.err = err }
+	st.lck.Unlock()
+	signal(st.rnotify)
+	signal(st.wnotify)
+	st.s.remove(st.id)
+}
+
+/*
+Aborts the Stream in both directions, with the given reason code.
+*/
+func (st *Stream) reset(code uint8) {
+	st.fail(net.ErrClosed)
+	st.s.write(frameReset,st.id,[]byte{code})
+}
+
+func (st *Stream) Read(p []byte) (int,error) {
+	for {
+		st.lck.Lock()
+		if st.closed { st.lck.Unlock(); return 0,net.ErrClosed }
+		if st.buf.Len()>0 {
+			n,_ := st.buf.Read(p)
+			st.unacked += n
+			upd := 0
+			if st.unacked>=window/2 {
+				upd = st.unacked
+				st.pending -= upd
+				st.unacked = 0
+			}
+			st.lck.Unlock()
+			if upd>0 {
+				var b [4]byte
+				binary.BigEndian.PutUint32(b[:],uint32(upd))
+				st.s.write(frameWindow,st.id,b[:])
+			}
+			return n,nil
+		}
+		finRecv,err := st.finRecv,st.err
+		st.lck.Unlock()
+		if finRecv { return 0,io.EOF }
+		if err!=nil { return 0,err }
+		select {
+		case <-st.rnotify:
+		case <-st.rdl.wait():
+			return 0,os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (st *Stream) Write(p []byte) (n int, err error) {
+	for len(p)>0 {
+		st.lck.Lock()
+		if st.closed || st.finSent { st.lck.Unlock(); return n,net.ErrClosed }
+		if st.err!=nil { err = st.err; st.lck.Unlock(); return }
+		if st.credit>0 {
+			m := len(p)
+			if m>st.credit { m = st.credit }
+			if m>maxData { m = maxData }
+			st.credit -= m
+			st.lck.Unlock()
+			err = st.s.write(frameData,st.id,p[:m])
+			if err!=nil { return }
+			n += m
+			p = p[m:]
+			continue
+		}
+		st.lck.Unlock()
+		select {
+		case <-st.wnotify:
+		case <-st.wdl.wait():
+			return n,os.ErrDeadlineExceeded
+		}
+	}
+	return
+}
+
+/*
+Ends the sending direction of the Stream: the peer reads io.EOF, once it has
+read the data sent before.
+*/
+func (st *Stream) CloseWrite() error {
+	st.lck.Lock()
+	if st.finSent || st.err!=nil { st.lck.Unlock(); return nil }
+	st.finSent = true
+	done := st.finRecv
+	st.lck.Unlock()
+	err := st.s.write(frameFin,st.id,nil)
+	if done { st.s.remove(st.id) }
+	return err
+}
+
+/*
+Closes the Stream. If the peer has not ended its direction, the Stream is
+reset, and the peer's Read and Write return ErrReset.
+*/
+func (st *Stream) Close() error {
+	st.lck.Lock()
+	if st.closed { st.lck.Unlock(); return net.ErrClosed }
+	st.closed = true
+	finRecv,err := st.finRecv,st.err
+	st.lck.Unlock()
+	signal(st.rnotify)
+	signal(st.wnotify)
+	if err!=nil { return nil }
+	if !finRecv {
+		st.reset(resetAbort)
+		return nil
+	}
+	return st.CloseWrite()
+}
+
+func (st *Stream) LocalAddr() net.Addr { return st.s.c.LocalAddr() }
+func (st *Stream) RemoteAddr() net.Addr { return st.s.c.RemoteAddr() }
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.rdl.set(t)
+	st.wdl.set(t)
+	return nil
+}
+func (st *Stream) SetReadDeadline(t time.Time) error { st.rdl.set(t); return nil }
+func (st *Stream) SetWriteDeadline(t time.Time) error { st.wdl.set(t); return nil }
+
+/*
+A deadline of a Stream, as in net.Pipe: a channel, that is closed, once the
+deadline passed.
+*/
+type deadline struct{
+	lck sync.Mutex
+	t *time.Timer
+	c chan struct{}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.lck.Lock(); defer d.lck.Unlock()
+	if d.t!=nil && !d.t.Stop() {
+		// The timer fired; wait for it to close c.
+		<-d.c
+	}
+	d.t = nil
+	closed := false
+	select {
+	case <-d.c: closed = true
+	default:
+	}
+	if closed { d.c = make(chan struct{}) }
+	if t.IsZero() { return }
+	if dur := time.Until(t); dur>0 {
+		c := d.c
+		d.t = time.AfterFunc(dur,func() { close(c) })
+		return
+	}
+	close(d.c)
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.lck.Lock(); defer d.lck.Unlock()
+	return d.c
+}
@@ -0,0 +1,354 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "encoding/binary"
+import "io"
+import "github.com/flynn/noise"
+
+/*
+Decisions in the NoiseSocket negotiation.
+*/
+const (
+	// Continue with the protocol, the initiator has chosen.
+	NLSAccept = iota
+	// The responder becomes the initiator of a different (fallback) protocol,
+	// such as XXfallback in Noise Pipes.
+	NLSSwitch
+	// The responder asks the initiator to start over with a different
+	// protocol.
+	NLSRetry
+	// The responder refuses the connection.
+	NLSReject
+)
+
+var ErrNLSRejected = newError(ErrHandshakeFailed,"seep: NoiseSocket handshake rejected")
+var ErrNLSProtocol = newError(ErrHandshakeFailed,"seep: NoiseSocket protocol violation")
+
+type NLSDecision struct{
+	Action int
+	// The protocol to continue with (NLSAccept, NLSSwitch) or to retry with
+	// (NLSRetry, initiator only). The Prologue is overwritten.
+	Config noise.Config
+	// The negotiation data sent to the peer.
+	NegotiationData []byte
+}
+
+/*
+The initiator side of a NoiseSocket handshake.
+*/
+type NLSInitiator struct{
+	// The initial protocol. The Prologue is overwritten.
+	Config noise.Config
+	// The negotiation data of the initial message.
+	NegotiationData []byte
+	// Called with the responder's negotiation data. If the responder sent an
+	// empty noise message (retry or reject), empty is true and Respond must
+	// return an NLSRetry decision or an error. Otherwise it returns
+	// NLSAccept or NLSSwitch; a nil decision without an error fails with
+	// ErrNLSProtocol. If nil, the initiator always accepts and never
+	// retries.
+	Respond func(negData []byte, empty bool) (*NLSDecision,error)
+}
+
+/*
+The responder side of a NoiseSocket handshake.
+*/
+type NLSResponder struct{
+	// Called with the negotiation data and the noise message of the
+	// initiator's first message. retried is true, if this is the message sent
+	// after an NLSRetry, in which case NLSRetry and NLSSwitch are not allowed.
+	// A nil decision without an error fails with ErrNLSProtocol.
+	Negotiate func(negData, noiseMsg []byte, retried bool) (*NLSDecision,error)
+}
+
+/*
+Handshake messages are encoded as
+
+	negotiation_data_len (2 bytes) || negotiation_data ||
+	noise_message_len (2 bytes) || noise_message
+
+where negotiation_data is empty after the first message of each side.
+*/
+func writeNLSMessage(w io.Writer, neg, msg []byte) error {
+	if len(neg)>0xffff || len(msg)>0xffff { return ErrFrameTooLarge }
+	buf := make([]byte,0,4+len(neg)+len(msg))
+	buf = appendU16(buf,neg)
+	buf = appendU16(buf,msg)
+	_,err := w.Write(buf)
+	return err
+}
+func readNLSMessage(r io.Reader) (neg, msg []byte, err error) {
+	f := &u16Framer{r:r}
+	neg,err = f.ReadFrame(0)
+	if err!=nil { return }
+	msg,err = f.ReadFrame(0)
+	if err==io.EOF { err = io.ErrUnexpectedEOF }
+	return
+}
+func appendU16(buf, p []byte) []byte {
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:],uint16(len(p)))
+	return append(append(buf,l[:]...),p...)
+}
+
+/*
+Prologues: "NoiseSocketInit1" followed by the initial negotiation data for the
+initial handshake. "NoiseSocketInit2" (switch) and "NoiseSocketInit3" (retry)
+followed by the initial negotiation data, the initial noise message and the
+responder's negotiation data, all of them length-prefixed.
+*/
+func nlsPrologue(kind string, parts ...[]byte) []byte {
+	buf := []byte(kind)
+	for _,p := range parts { buf = appendU16(buf,p) }
+	return buf
+}
+
+/*
+Runs the rest of a NoiseSocket handshake. write indicates, whether the next
+message is to be written. Handshake payloads are encoded in the padded body
+format.
+*/
+func nlsLoop(r io.Reader, w io.Writer, hs *noise.HandshakeState, initiator, write bool, payload func() []byte, recv func([]byte)) (enc,dec *noise.CipherState,err error) {
+	var cs1,cs2 *noise.CipherState
+	for cs1==nil {
+		if write {
+			var p []byte
+			p,err = padBody(payload(),0)
+			if err!=nil { return }
+			var buf []byte
+			buf,cs1,cs2 = hs.WriteMessage(nil,p)
+			err = writeNLSMessage(w,nil,buf)
+			if err!=nil { return }
+		} else {
+			var neg,buf []byte
+			neg,buf,err = readNLSMessage(r)
+			if err!=nil { return }
+			if len(neg)!=0 || len(buf)==0 { err = ErrNLSProtocol; return }
+			buf,cs1,cs2,err = hs.ReadMessage(nil,buf)
+			if err==nil { buf,err = unpadBody(buf) }
+			if err!=nil { err = ErrHandshakeAuth; return }
+			recv(buf)
+		}
+		write = !write
+	}
+	if initiator { return cs1,cs2,nil }
+	return cs2,cs1,nil
+}
+
+/*
+Performs the initiator side of a NoiseSocket handshake. Data written to the
+Connection before is sent as handshake payload. The transport messages are
+plain NoiseSocket messages, regardless of c.Options, see nlsOptions.
+*/
+func (c *Connection) HandshakeNLS(r io.Reader, w io.Writer, n *NLSInitiator) error {
+	payload := func() []byte { return c.outbuf.Next(0x1000) }
+	recv := func(b []byte) { c.inbuf.Write(b) }
+
+	nc := n.Config
+	err := checkSuite(c.Options.get(),nc)
+	if err!=nil { return err }
+	nc.Initiator = true
+	nc.Prologue = nlsPrologue("NoiseSocketInit1",n.NegotiationData)
+	hs := newHandshakeState(nc)
+	early := payload()
+	p,err := padBody(early,0)
+	if err!=nil { return err }
+	msg0,cs1,cs2 := hs.WriteMessage(nil,p)
+	err = writeNLSMessage(w,n.NegotiationData,msg0)
+	if err!=nil { return err }
+	if cs1!=nil { return c.finishNLS(r,w,hs,cs1,cs2) }
+
+	neg,msg,err := readNLSMessage(r)
+	if err!=nil { return err }
+	d := &NLSDecision{Action:NLSAccept}
+	if len(msg)==0 {
+		if n.Respond==nil { return ErrNLSRejected }
+		d,err = n.Respond(neg,true)
+		if err!=nil { return err }
+		if d==nil { return ErrNLSProtocol }
+		if d.Action!=NLSRetry { return ErrNLSRejected }
+		c.unread(early)
+		nc = d.Config
+		err = checkSuite(c.Options.get(),nc)
+		if err!=nil { return err }
+		nc.Initiator = true
+		nc.Prologue = nlsPrologue("NoiseSocketInit3",n.NegotiationData,msg0,neg)
+		hs = newHandshakeState(nc)
+		p,err = padBody(payload(),0)
+		if err!=nil { return err }
+		var buf []byte
+		buf,cs1,cs2 = hs.WriteMessage(nil,p)
+		err = writeNLSMessage(w,d.NegotiationData,buf)
+		if err!=nil { return err }
+		if cs1!=nil { return c.finishNLS(r,w,hs,cs1,cs2) }
+		neg,msg,err = readNLSMessage(r)
+		if err!=nil { return err }
+		if len(msg)==0 { return ErrNLSRejected }
+		d = &NLSDecision{Action:NLSAccept}
+	} else if n.Respond!=nil {
+		d,err = n.Respond(neg,false)
+		if err!=nil { return err }
+		if d==nil { return ErrNLSProtocol }
+	}
+	initiator := true
+	switch d.Action {
+	case NLSAccept:
+	case NLSSwitch:
+		c.unread(early)
+		nc = d.Config
+		err = checkSuite(c.Options.get(),nc)
+		if err!=nil { return err }
+		nc.Initiator = false
+		nc.Prologue = nlsPrologue("NoiseSocketInit2",n.NegotiationData,msg0,neg)
+		hs = newHandshakeState(nc)
+		initiator = false
+	default:
+		return ErrNLSProtocol
+	}
+	buf,cs1,cs2,err := hs.ReadMessage(nil,msg)
+	if err==nil { buf,err = unpadBody(buf) }
+	if err!=nil { return ErrHandshakeAuth }
+	recv(buf)
+	if cs1!=nil {
+		if !initiator { cs1,cs2 = cs2,cs1 }
+		return c.finishNLS(r,w,hs,cs1,cs2)
+	}
+	enc,dec,err := nlsLoop(r,w,hs,initiator,true,payload,recv)
+	if err!=nil { return err }
+	return c.finishNLS(r,w,hs,enc,dec)
+}
+
+/*
+Performs the responder side of a NoiseSocket handshake. Data written to the
+Connection before is sent as handshake payload. The transport messages are
+plain NoiseSocket messages, regardless of c.Options, see nlsOptions.
+*/
+func (c *Connection) AcceptNLS(r io.Reader, w io.Writer, n *NLSResponder) error {
+	payload := func() []byte { return c.outbuf.Next(0x1000) }
+	recv := func(b []byte) { c.inbuf.Write(b) }
+
+	neg0,msg0,err := readNLSMessage(r)
+	if err!=nil { return err }
+	d,err := n.Negotiate(neg0,msg0,false)
+	if err!=nil { return err }
+	if d==nil { return ErrNLSProtocol }
+	prologue := nlsPrologue("NoiseSocketInit1",neg0)
+	neg,msg := neg0,msg0
+	if d.Action==NLSRetry {
+		err = writeNLSMessage(w,d.NegotiationData,nil)
+		if err!=nil { return err }
+		prologue = nlsPrologue("NoiseSocketInit3",neg0,msg0,d.NegotiationData)
+		neg,msg,err = readNLSMessage(r)
+		if err!=nil { return err }
+		d,err = n.Negotiate(neg,msg,true)
+		if err!=nil { return err }
+		if d==nil { return ErrNLSProtocol }
+		if d.Action==NLSRetry || d.Action==NLSSwitch { return ErrNLSProtocol }
+	}
+	nc := d.Config
+	err = checkSuite(c.Options.get(),nc)
+	if err!=nil { return err }
+	switch d.Action {
+	case NLSAccept:
+		nc.Initiator = false
+		nc.Prologue = prologue
+		hs := newHandshakeState(nc)
+		buf,cs1,cs2,err := hs.ReadMessage(nil,msg)
+		if err==nil { buf,err = unpadBody(buf) }
+		if err!=nil { return ErrHandshakeAuth }
+		err = c.Options.get().ReplayCache.check(hs)
+		if err!=nil { return err }
+		recv(buf)
+		if cs1!=nil { return c.finishNLS(r,w,hs,cs2,cs1) }
+		p,err := padBody(payload(),0)
+		if err!=nil { return err }
+		buf,cs1,cs2 = hs.WriteMessage(nil,p)
+		err = writeNLSMessage(w,d.NegotiationData,buf)
+		if err!=nil { return err }
+		if cs1!=nil { return c.finishNLS(r,w,hs,cs2,cs1) }
+		enc,dec,err := nlsLoop(r,w,hs,false,false,payload,recv)
+		if err!=nil { return err }
+		return c.finishNLS(r,w,hs,enc,dec)
+	case NLSSwitch:
+		nc.Initiator = true
+		nc.Prologue = nlsPrologue("NoiseSocketInit2",neg0,msg0,d.NegotiationData)
+		hs := newHandshakeState(nc)
+		p,err := padBody(payload(),0)
+		if err!=nil { return err }
+		buf,cs1,cs2 := hs.WriteMessage(nil,p)
+		err = writeNLSMessage(w,d.NegotiationData,buf)
+		if err!=nil { return err }
+		if cs1!=nil { return c.finishNLS(r,w,hs,cs1,cs2) }
+		enc,dec,err := nlsLoop(r,w,hs,true,false,payload,recv)
+		if err!=nil { return err }
+		return c.finishNLS(r,w,hs,enc,dec)
+	}
+	writeNLSMessage(w,d.NegotiationData,nil)
+	return ErrNLSRejected
+}
+
+/*
+Puts the payload of a discarded handshake message back into the output buffer.
+*/
+func (c *Connection) unread(p []byte) {
+	if len(p)==0 { return }
+	c.outbuf = bytes.NewBuffer(append(append([]byte(nil),p...),c.outbuf.Bytes()...))
+}
+
+/*
+Returns the options of a NoiseSocket session. Its transport messages are
+NoiseSocket messages (FramingUint16 and the Padded body format), that other
+implementations can read, so the options, that would change them, are turned
+off. PadTo and Padding only choose the amount of padding, and are kept.
+*/
+func nlsOptions(o Options) Options {
+	o.Framing = FramingUint16
+	o.Padded = true
+	o.Typed = false
+	o.Sequenced = false
+	o.Compression = false
+	o.InnerPSK = nil
+	return o
+}
+
+func (c *Connection) finishNLS(r io.Reader, w io.Writer, hs *noise.HandshakeState, enc,dec *noise.CipherState) error {
+	opts := nlsOptions(c.Options.get())
+	err := verifyPeer(opts.VerifyPeer,hs.PeerStatic())
+	if err!=nil { return err }
+	burnHandshake(hs)
+	f := &u16Framer{r,w}
+	wr := &Writer{frameWriter:frameWriter{dst:f,enc:enc,opts:opts}}
+	rd := &Reader{frameReader:frameReader{src:f,dec:dec,opts:opts}}
+	rd.buf.ReadFrom(c.inbuf)
+	c.Writer = wr
+	c.Reader = rd
+	wipeBuffer(c.inbuf)
+	wipeBuffer(c.outbuf)
+	c.inbuf = nil
+	c.outbuf = nil
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/rand"
+import "io"
+import "net"
+import "testing"
+import "github.com/flynn/noise"
+
+/*
+Runs a NoiseSocket handshake over net.Pipe, with the options oi of the
+initiator and or of the responder.
+*/
+func testNLS(t *testing.T, oi, or Options, neg func(negData, noiseMsg []byte, retried bool) (*NLSDecision,error)) (*Connection,*Connection,error) {
+	t.Helper()
+	a,b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	nc := noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader}
+	ci,cr := &Connection{Options:&oi},&Connection{Options:&or}
+	ci.Init()
+	cr.Init()
+	errs := make(chan error,1)
+	go func() {
+		err := ci.HandshakeNLS(a,a,&NLSInitiator{Config:nc})
+		if err!=nil { a.Close() }
+		errs <- err
+	}()
+	err := cr.AcceptNLS(b,b,&NLSResponder{Negotiate:neg})
+	if err!=nil {
+		b.Close()
+		<-errs
+		return nil,nil,err
+	}
+	return ci,cr,<-errs
+}
+
+func TestNLSPlainTransport(t *testing.T) {
+	accept := func(negData, noiseMsg []byte, retried bool) (*NLSDecision,error) {
+		return &NLSDecision{Action:NLSAccept,Config:noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader}},nil
+	}
+	// Options, that change the transport, must not apply to NoiseSocket,
+	// so a peer without them can still read it.
+	o := Options{Typed:true,Sequenced:true,Compression:true,InnerPSK:make([]byte,32)}
+	ci,cr,err := testNLS(t,o,Options{},accept)
+	if err!=nil { t.Fatal(err) }
+	msg := testData(1000)
+	go func() {
+		ci.Writer.Write(msg)
+		ci.Flush()
+	}()
+	got := make([]byte,len(msg))
+	_,err = io.ReadFull(cr.Reader,got)
+	if err!=nil || string(got)!=string(msg) { t.Fatal(err) }
+}
+
+func TestNLSNilDecision(t *testing.T) {
+	_,_,err := testNLS(t,Options{},Options{},func(negData, noiseMsg []byte, retried bool) (*NLSDecision,error) { return nil,nil })
+	if err!=ErrNLSProtocol { t.Fatal(err) }
+}
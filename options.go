@@ -0,0 +1,361 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "context"
+import "crypto/rand"
+import "encoding/binary"
+import "io"
+import "log/slog"
+import "time"
+import "github.com/flynn/noise"
+
+/*
+Options modify the frame layer of a connection. They change the wire format,
+so both sides of a connection must use the same options. A nil *Options is
+equivalent to the zero value.
+*/
+type Options struct{
+	// If true, every frame carries a one byte compressor id in front of its
+	// (possibly compressed) payload. Off by default: the size of a
+	// compressed frame leaks, how well its content compresses, which is the
+	// length oracle of CRIME and BREACH. Enable it only, if no frame mixes
+	// data, an attacker can influence, with secrets (tokens, cookies,
+	// keys); padding (see Options.Padding) blurs the oracle, but does not
+	// close it.
+	Compression bool
+
+	// The compressor used for outgoing frames (see RegisterCompressor). It is
+	// only used, if Compression is true.
+	Compressor uint8
+
+	// Frames with fewer bytes than this are never compressed.
+	// Defaults to 128.
+	CompressMin int
+
+	// The maximum size of a received frame. Larger frames are rejected with
+	// ErrFrameTooLarge before any buffer is allocated for them. If
+	// Compression is enabled, it also limits the decompressed size, which
+	// the compressor enforces while decompressing. 0 means no limit on the
+	// frame and a limit of 64 MiB on the decompressed size.
+	MaxFrameSize int
+
+	// The payload size of the frames, into which bulk data is split: large
+	// writes, ReadFrom, WriteBuffer and messages (see NewMessage). Smaller
+	// frames suit lossy links, where a lost segment stalls the whole frame,
+	// larger ones reduce the per-frame overhead. Capped at (and defaults
+	// to) the largest payload, that fits into noise.MaxMsgLen, just under
+	// 64 KiB. This does not affect the wire format; a peer's Reader (and
+	// WriteTo) accepts frames of any size.
+	ChunkSize int
+
+	// The framing used by the stream based constructors, such as
+	// NewStreamReader and Connection.HandshakeStream. One of FramingXDR
+	// (the default), FramingUint16, FramingArmor or FramingUvarint.
+	Framing uint8
+
+	// The size of the buffer, through which the stream based constructors
+	// read, so that a frame doesn't cost separate reads for its length and
+	// its body. 0 means 4096 bytes, a negative value disables the buffer,
+	// so that nothing is read from the stream beyond the current frame.
+	ReadBuffer int
+
+	// If true, the plaintext of every frame starts with a 2 byte big-endian
+	// body length, followed by the body and optional padding, as in the
+	// transport messages of NoiseSocket.
+	Padded bool
+
+	// If not 0, the plaintext of every frame is padded to a multiple of
+	// PadTo bytes (for instance 256), to hide the exact length of messages.
+	// Implies Padded.
+	PadTo int
+
+	// If not nil, the padding policy. It is called with the length of the
+	// body of every frame and returns the length, the body is padded to.
+	// Results smaller than plainLen mean no padding, results exceeding the
+	// maximum frame size are truncated. Takes precedence over PadTo and
+	// implies Padded. See PadBucket and PadRandom.
+	Padding func(plainLen int) int
+
+	// If true, the plaintext of every frame starts with a frame type
+	// (FrameData, FrameKeepalive, ...), so that control frames can be sent
+	// in-band. Control frames are never returned by Read.
+	Typed bool
+
+	// If true, every frame starts with its 8 byte big-endian sequence
+	// number in the clear, authenticated as associated data. Frames, that
+	// are duplicated, dropped or reordered on the way, fail to decrypt and
+	// are reported as ErrFrameAuth, like any other frame, that does not
+	// authenticate.
+	Sequenced bool
+
+	// If true, the RPC codecs send every message as a sequence of chunks of
+	// at most the maximum frame size, each one a frame of its own, instead
+	// of a single frame. Formats providing EncodeTo and DecodeFrom encode
+	// and decode directly from the chunks, so large bodies are never held
+	// in memory as a whole.
+	ChunkedRPC bool
+
+	// With ChunkedRPC, the maximum size of a received RPC message. Larger
+	// messages, and elements claiming to be larger, are rejected with
+	// ErrFrameTooLarge. 0 means 64 MiB, a negative value no limit.
+	MaxMessageSize int
+
+	// If not 0, concurrent calls on an RPC codec encode their messages
+	// outside of the codec's lock and only serialize for the encryption;
+	// the frames are queued and written by a dedicated goroutine, with up
+	// to SendQueue frames in flight (unless Pipeline is set). Messages are
+	// only encoded concurrently by Service and Caller; the Client and
+	// Server of net/rpc write one message at a time, so they only gain the
+	// queued writes. Has no effect with ChunkedRPC, and on the encoding of
+	// stateful formats (see RpcFormat.Stateful). This does not affect the
+	// wire format.
+	SendQueue int
+
+	// If not 0, Write buffers data and writes it as a single frame, once
+	// WriteBuffer bytes have accumulated (at most the maximum frame size),
+	// or Flush is called. Other writes and control frames flush the buffer
+	// first. This does not affect the wire format.
+	WriteBuffer int
+
+	// If not 0, data buffered by Write is flushed automatically, at most
+	// FlushDelay after it was written (for instance time.Millisecond), so
+	// that it goes out even if Flush is never called.
+	FlushDelay time.Duration
+
+	// If not 0, frames are written to the underlying stream by a separate
+	// goroutine, with up to Pipeline encrypted frames in flight, so that
+	// encryption overlaps with writing. Write errors are reported by later
+	// writes and by Flush, which waits for all frames to be written. This
+	// does not affect the wire format.
+	Pipeline int
+
+	// If not 0, outgoing frames are encrypted by up to CryptoWorkers
+	// goroutines in parallel, and written in order by the pipeline (see
+	// Pipeline, which is raised to at least 2*CryptoWorkers), and incoming
+	// frames are read ahead, up to 2*CryptoWorkers, and decrypted by up to
+	// CryptoWorkers goroutines, so that a single connection can use more
+	// than one core for crypto. The key, under which a frame was sent, is
+	// only known once the frames before it (rekeys, upgrades) have been
+	// processed: frames are decrypted under the key in use when they are
+	// read, and decrypted again in order, where that turns out wrong. Like
+	// ReadAhead, a Reader keeps a goroutine blocked on the stream until the
+	// next frame arrives or the stream is closed. This does not affect the
+	// wire format.
+	CryptoWorkers int
+
+	// If true, Read blocks until len(p) bytes have been read, pulling as
+	// many frames as needed, like io.ReadFull. This does not affect the wire
+	// format.
+	ReadFull bool
+
+	// If true, Read decrypts the next frame in the background, while the
+	// application is still consuming the current one. This hides the
+	// decryption latency for back-to-back frames; when the frames don't
+	// arrive faster than they are consumed, prefetching backs off. A
+	// prefetching Reader keeps a goroutine blocked on the stream until the
+	// next frame arrives or the stream is closed. This does not affect the
+	// wire format.
+	ReadAhead bool
+
+	// If not nil, called with the peer's static public key as soon as the
+	// handshake reveals it (with nil, if the peer doesn't have one), for
+	// instance PinnedPeer. An error aborts the handshake and is returned.
+	// With NoiseSocket (HandshakeNLS, AcceptNLS), it is only called once the
+	// handshake is complete.
+	VerifyPeer func(static []byte) error
+
+	// If not nil, Connections, that respond to a handshake, call it with
+	// the payload of the initiation (the first handshake message), which is
+	// then not taken as early data. The bytes returned are sent as the
+	// payload of the response, ahead of the early data, so that it can
+	// depend on the initiation, for instance to select an application
+	// protocol (see the seephttp package). With one-way patterns, there is
+	// no response to send them in. NoiseSocket handshakes and RPC codecs
+	// don't use it.
+	Respond func(initiation []byte) []byte
+
+	// If true, handshakes are refused before anything is sent, with
+	// ErrUnauthenticatedPattern, if the pattern leaves the peer
+	// unauthenticated (for instance NN, or NX on the responder side), and
+	// with ErrNoVerifier, if VerifyPeer is not set, unless the peer's
+	// static key is pinned by a pre-message (noise.Config.PeerStatic, for
+	// instance on the initiator side of NK, KK, IK or XK). This guards
+	// against accidentally anonymous deployments. NoiseSocket handshakes,
+	// whose pattern is only known after the negotiation, are not checked.
+	RequirePeerAuth bool
+
+	// If not nil, Connections run the password authentication (see
+	// Connection.AuthenticatePassword) right after the handshake, and the
+	// handshake fails with ErrAuthFailed, unless both sides share the
+	// password.
+	Password []byte
+
+	// If true, handshakes are refused with ErrSuiteNotAllowed, unless the
+	// cipher suite uses AESGCM and SHA256 or SHA512, the FIPS-approved
+	// subset of the Noise primitives. The only DH function, 25519, can't be
+	// restricted; compliance beyond the primitives (validated modules) is
+	// up to the deployment.
+	FIPS bool
+
+	// If set, every frame is additionally encrypted inside the Noise
+	// channel with an independent AEAD, whose keys are derived from this
+	// 32 byte pre-shared key and the handshake hash, so that the session
+	// stays confidential, if either layer fails. The inner cipher is the
+	// one of AESGCM and ChaChaPoly, that the cipher suite doesn't use
+	// (always AESGCM with FIPS). Both sides must use the same key. Each
+	// frame grows by 16 bytes. NoiseSocket connections don't use it.
+	InnerPSK []byte
+
+	// If not nil, Connections, that respond to a handshake (including
+	// AcceptNLS), refuse initiations recorded in the cache with ErrReplay,
+	// so that the early data of the first handshake message is accepted at
+	// most once within the window of the cache. Share one cache among all
+	// Connections, that accept the same static key.
+	ReplayCache *ReplayCache
+
+	// If not nil, called after every handshake attempt, successful or not,
+	// of Connections (including Upgrade) and RPC codecs, with the
+	// information needed for security monitoring. NoiseSocket handshakes
+	// are not audited. It is also called, when an established session is
+	// torn down (see MaxDecryptFailures and MaxSessionAge), with the record
+	// of its handshake and Err set to the reason.
+	Audit func(*HandshakeAudit)
+
+	// If not nil, the lifecycle events of Connections and RPC codecs
+	// (handshakes, rekeys, closes and errors) are logged to it, with the
+	// protocol, the peer's address and key fingerprint as attributes.
+	// Handshakes are logged at Info level (Warn, if they fail), rekeys and
+	// single decryption failures at Debug level.
+	Logger *slog.Logger
+
+	// If not nil, the frames, handshakes and sessions are counted in it as
+	// well. Share one Metrics among all sessions to be monitored together.
+	Metrics *Metrics
+
+	// If not nil, called, when a Connection starts a handshake (including
+	// Upgrade), with the context of the Connection (see
+	// Connection.Context) and the audit record as far as known. The
+	// function it returns is called with the completed record and returns
+	// the context of the session, that the Connection carries from then
+	// on. See the tracing/otel package.
+	Trace func(ctx context.Context, a *HandshakeAudit) func(*HandshakeAudit) context.Context
+
+	// The number of consecutive received frames, that may fail to decrypt
+	// (or arrive out of sequence), before the session is torn down: its
+	// receiving key is wiped, and reads return ErrDecryptFailures. 0 means
+	// 16, a negative value means no limit.
+	MaxDecryptFailures int
+
+	// If not 0, the maximum time a key may be used. A Writer, whose key is
+	// older, rekeys (see Writer.Rekey) before it sends the next frame; without
+	// Typed, it can't, and writes return ErrSessionExpired, until the
+	// session is renewed by Upgrade. Frames received under a key older than
+	// MaxSessionAge plus a quarter of it (leaving room for latency), other
+	// than the rekey replacing it, end the session with ErrSessionExpired,
+	// like MaxDecryptFailures does.
+	MaxSessionAge time.Duration
+
+	// If not nil, the secrets of every completed handshake are written to
+	// it, see KeyLogProtocol. This does not affect the wire format. Use for
+	// debugging only, it compromises the security of the connection.
+	KeyLogWriter io.Writer
+
+	// If true, the key log (see KeyLogWriter) also records the long-term
+	// secrets, that patterns with an ss token or a psk modifier need: the
+	// local static private key, the preshared key and the static-static DH.
+	// A leaked key log then compromises the identity, not just the logged
+	// sessions.
+	KeyLogStatic bool
+
+	// If not nil, called with every frame sent or received after the
+	// handshake, see TapFrame. It may be called concurrently for both
+	// directions and must not retain the buffers of the TapFrame. This does
+	// not affect the wire format.
+	Tap func(f *TapFrame)
+}
+
+func (o *Options) get() Options {
+	if o==nil { return Options{} }
+	return *o
+}
+
+func (o *Options) padded() bool {
+	return o.Padded || o.PadTo>0 || o.Padding!=nil
+}
+
+/*
+Returns the total length of the padded plaintext of a frame with a body of n
+bytes, not exceeding the maximum Noise message size.
+*/
+func (o *Options) padLen(n int) int {
+	if o.Padding!=nil {
+		if p := o.Padding(n); p>n { n = p }
+		n += 2
+	} else {
+		n += 2
+		if o.PadTo>0 { n = ((n+o.PadTo-1)/o.PadTo)*o.PadTo }
+	}
+	max := noise.MaxMsgLen-tagSize
+	if o.Sequenced { max-=8 }
+	if n>max { n = max }
+	return n
+}
+
+/*
+Returns a padding policy, that pads the body to the next multiple of size.
+*/
+func PadBucket(size int) func(int) int {
+	return func(n int) int {
+		return ((n+size-1)/size)*size
+	}
+}
+
+/*
+Returns a padding policy, that adds a uniformly distributed random amount of
+0 to max bytes of padding. The amount is drawn from crypto/rand, so that it
+can't be predicted from earlier frames and subtracted from the length.
+*/
+func PadRandom(max int) func(int) int {
+	return func(n int) int {
+		return n+randIntn(max+1)
+	}
+}
+
+/*
+Returns a uniformly distributed number in [0,n), drawn from crypto/rand.
+*/
+func randIntn(n int) int {
+	if n<=1 { return 0 }
+	// Rejects the top values, that would bias the modulo.
+	lim := ^uint64(0)-^uint64(0)%uint64(n)
+	var b [8]byte
+	for {
+		_,err := io.ReadFull(rand.Reader,b[:])
+		if err!=nil { panic("seep: crypto/rand failed: "+err.Error()) }
+		v := binary.LittleEndian.Uint64(b[:])
+		if v<lim { return int(v%uint64(n)) }
+	}
+}
@@ -0,0 +1,114 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "encoding/binary"
+import "io"
+import "sync"
+import "time"
+
+/*
+The link type of the packets written by TapPcapng: LINKTYPE_USER0, which
+Wireshark lets users map to a dissector of their own (see "DLT_USER" in its
+preferences). Every packet consists of a pseudo-header, followed by the
+plaintext of the frame as in TapFrame:
+
+	connection ID   8 bytes, big-endian, see Connection.ID
+	direction       1 byte, 1 for frames sent, 0 for frames received
+	sequence        8 bytes, big-endian, see TapFrame.Seq
+*/
+const PcapLinkType = 147
+
+const (
+	pcapSHB = 0x0A0D0D0A
+	pcapIDB = 1
+	pcapEPB = 6
+	// The length of the pseudo-header of a packet.
+	pcapHeader = 17
+)
+
+/*
+Appends a pcapng block of the given type to b, padding the body to 4 bytes.
+*/
+func pcapBlock(b []byte, typ uint32, body []byte) []byte {
+	pad := (4-len(body)%4)%4
+	n := uint32(12+len(body)+pad)
+	b = binary.LittleEndian.AppendUint32(b,typ)
+	b = binary.LittleEndian.AppendUint32(b,n)
+	b = append(b,body...)
+	b = append(b,make([]byte,pad)...)
+	return binary.LittleEndian.AppendUint32(b,n)
+}
+
+/*
+Returns a tap for Options.Tap, that writes the decrypted frames, with their
+timestamps, directions and connection IDs, to w as a pcapng capture with a
+single interface of the link type PcapLinkType, so that the protocol above
+the encryption layer can be analyzed in Wireshark. The section and interface
+headers are written right away. Write errors are ignored. Like KeyLogWriter,
+it defeats the encryption and must only be used for debugging.
+
+	f,_ := os.Create("session.pcapng")
+	o := &seep.Options{Tap: seep.TapPcapng(f)}
+*/
+func TapPcapng(w io.Writer) func(*TapFrame) {
+	var shb,idb []byte
+	shb = binary.LittleEndian.AppendUint32(shb,0x1A2B3C4D)
+	shb = binary.LittleEndian.AppendUint16(shb,1)
+	shb = binary.LittleEndian.AppendUint16(shb,0)
+	// The section length is unknown.
+	shb = binary.LittleEndian.AppendUint64(shb,^uint64(0))
+	idb = binary.LittleEndian.AppendUint16(idb,PcapLinkType)
+	idb = binary.LittleEndian.AppendUint16(idb,0)
+	// No snap length; the timestamps are in microseconds, the default.
+	idb = binary.LittleEndian.AppendUint32(idb,0)
+	w.Write(pcapBlock(pcapBlock(nil,pcapSHB,shb),pcapIDB,idb))
+	var lck sync.Mutex
+	return func(t *TapFrame) {
+		ts := uint64(time.Now().UnixNano()/1000)
+		n := uint32(pcapHeader+len(t.Plaintext))
+		var epb []byte
+		epb = binary.LittleEndian.AppendUint32(epb,0)
+		epb = binary.LittleEndian.AppendUint32(epb,uint32(ts>>32))
+		epb = binary.LittleEndian.AppendUint32(epb,uint32(ts))
+		epb = binary.LittleEndian.AppendUint32(epb,n)
+		epb = binary.LittleEndian.AppendUint32(epb,n)
+		epb = binary.BigEndian.AppendUint64(epb,t.ConnID)
+		dir,flags := byte(0),uint32(1)
+		if t.Outgoing { dir,flags = 1,2 }
+		epb = append(epb,dir)
+		epb = binary.BigEndian.AppendUint64(epb,t.Seq)
+		epb = append(epb,t.Plaintext...)
+		epb = append(epb,make([]byte,(4-len(epb)%4)%4)...)
+		// The option epb_flags with the direction (inbound 1, outbound 2),
+		// and the end of the options.
+		epb = binary.LittleEndian.AppendUint16(epb,2)
+		epb = binary.LittleEndian.AppendUint16(epb,4)
+		epb = binary.LittleEndian.AppendUint32(epb,flags)
+		epb = binary.LittleEndian.AppendUint32(epb,0)
+		lck.Lock(); defer lck.Unlock()
+		w.Write(pcapBlock(nil,pcapEPB,epb))
+	}
+}
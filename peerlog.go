@@ -0,0 +1,144 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bufio"
+import "errors"
+import "io"
+import "strings"
+import "sync"
+import "time"
+
+var ErrPeerLogFormat = errors.New("seep: malformed peer log")
+
+/*
+A PeerLog records, which static keys have been observed at which endpoint,
+in the style of certificate transparency: observations are only ever added,
+and a change of the key of a known endpoint raises an alert, so that a fleet
+can be monitored for unexpected key changes. A PeerLog may be used
+concurrently.
+
+If W is set, every new (endpoint, key) pair is appended to it as a line
+
+	<time> <endpoint> <fingerprint>
+
+with the time in RFC 3339 format and the key as returned by Fingerprint. A
+PeerLog is restored from such a log with Load.
+
+	l := &seep.PeerLog{W:file,OnChange:alert}
+	err := l.Load(file)
+	// ... check error
+	o := &seep.Options{Audit:l.Audit}
+*/
+type PeerLog struct{
+	// If not nil, new observations are appended to it.
+	W io.Writer
+	// If not nil, called, when an endpoint presents a key, that hasn't been
+	// observed there before, although others have.
+	OnChange func(*KeyChange)
+
+	lck sync.Mutex
+	// The fingerprints observed per endpoint, oldest first.
+	keys map[string][]string
+}
+
+/*
+An alert of a PeerLog.
+*/
+type KeyChange struct{
+	Time time.Time
+	Endpoint string
+	// The fingerprints observed at the endpoint before, oldest first.
+	Previous []string
+	// The fingerprint of the new key.
+	Key string
+}
+
+/*
+Records, that endpoint presented the static public key key. Reports, whether
+the endpoint had presented other keys before; if so, OnChange is called.
+Returns the error of W, if any; the observation is recorded nonetheless.
+*/
+func (l *PeerLog) Observe(endpoint string, key []byte) (changed bool, err error) {
+	return l.observe(time.Now(),endpoint,Fingerprint(key),true)
+}
+
+func (l *PeerLog) observe(t time.Time, endpoint, fp string, write bool) (bool,error) {
+	l.lck.Lock()
+	if l.keys==nil { l.keys = make(map[string][]string) }
+	prev := l.keys[endpoint]
+	for _,k := range prev {
+		if k==fp {
+			l.lck.Unlock()
+			return false,nil
+		}
+	}
+	l.keys[endpoint] = append(prev,fp)
+	var err error
+	if write && l.W!=nil {
+		_,err = io.WriteString(l.W,t.UTC().Format(time.RFC3339)+" "+endpoint+" "+fp+"\n")
+	}
+	l.lck.Unlock()
+	if len(prev)==0 { return false,err }
+	if write && l.OnChange!=nil {
+		l.OnChange(&KeyChange{t,endpoint,append([]string(nil),prev...),fp})
+	}
+	return true,err
+}
+
+/*
+Returns the fingerprints observed at endpoint, oldest first.
+*/
+func (l *PeerLog) Keys(endpoint string) []string {
+	l.lck.Lock(); defer l.lck.Unlock()
+	return append([]string(nil),l.keys[endpoint]...)
+}
+
+/*
+Reads a log written by a PeerLog and records its observations, without
+writing them to W again or raising alerts.
+*/
+func (l *PeerLog) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line=="" { continue }
+		i,j := strings.IndexByte(line,' '),strings.LastIndexByte(line,' ')
+		if i<0 || j<=i { return ErrPeerLogFormat }
+		t,err := time.Parse(time.RFC3339,line[:i])
+		if err!=nil { return ErrPeerLogFormat }
+		l.observe(t,line[i+1:j],line[j+1:],false)
+	}
+	return s.Err()
+}
+
+/*
+Observes the peer of a successful handshake, identified by its address. It
+has the signature of Options.Audit.
+*/
+func (l *PeerLog) Audit(a *HandshakeAudit) {
+	if a.Err!=nil || a.RemoteAddr==nil || len(a.PeerStatic)==0 { return }
+	l.Observe(a.RemoteAddr.String(),a.PeerStatic)
+}
@@ -0,0 +1,192 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "container/list"
+import "net"
+import "sync"
+import "sync/atomic"
+
+/*
+Returned by the reads of a handshake, that has been evicted from the
+pending-handshake table of a Server, see PendingLimits.
+*/
+var ErrHandshakeEvicted = newError(ErrHandshakeFailed,"seep: handshake evicted")
+
+/*
+Bounds the memory held by the handshakes in progress on a Server. A handshake
+is charged for the read buffer of its connection (see Options.ReadBuffer) and
+the bytes received so far. If a limit is exceeded, the handshake, that has
+been idle for the longest time, is evicted and its connection closed; if a
+peer (an IP address) exceeds MaxPerPeer, its own longest idle handshake is
+evicted instead, so that a single peer can't crowd out the others. The zero
+value uses the defaults given below.
+*/
+type PendingLimits struct{
+	// The maximum number of handshakes in progress. Defaults to 1024.
+	MaxHandshakes int
+	// The maximum number of bytes held by handshakes in progress. Defaults
+	// to 16 MiB.
+	MaxBytes int64
+	// The maximum number of handshakes in progress per IP address. Defaults
+	// to 16.
+	MaxPerPeer int
+}
+
+func (l *PendingLimits) limits() (max int, bytes int64, peer int) {
+	max,bytes,peer = l.MaxHandshakes,l.MaxBytes,l.MaxPerPeer
+	if max<=0 { max = 1024 }
+	if bytes<=0 { bytes = 16<<20 }
+	if peer<=0 { peer = 16 }
+	return
+}
+
+/*
+The handshakes in progress, least recently active last.
+*/
+type pendingTable struct{
+	max, peer int
+	maxBytes int64
+	// The charge for the read buffer.
+	base int64
+
+	lck sync.Mutex
+	lru list.List
+	peers map[string]int
+	bytes int64
+}
+
+func newPendingTable(l PendingLimits, o Options) *pendingTable {
+	t := &pendingTable{peers:make(map[string]int)}
+	t.max,t.maxBytes,t.peer = l.limits()
+	switch {
+	case o.ReadBuffer==0: t.base = 4096
+	case o.ReadBuffer>0: t.base = int64(o.ReadBuffer)
+	}
+	return t
+}
+
+/*
+A connection, whose handshake is in progress. Reads are charged to the table
+until done is set.
+*/
+type pendingConn struct{
+	net.Conn
+	t *pendingTable
+	key string
+	elem *list.Element
+	size int64
+	// Set, once the handshake is over.
+	done int32
+	evicted bool
+}
+
+func (p *pendingConn) Read(b []byte) (int,error) {
+	n,err := p.Conn.Read(b)
+	if atomic.LoadInt32(&p.done)!=0 { return n,err }
+	if !p.t.read(p,n) { return 0,ErrHandshakeEvicted }
+	return n,err
+}
+
+/*
+Adds conn to the table, evicting other handshakes as needed.
+*/
+func (t *pendingTable) add(conn net.Conn) *pendingConn {
+	p := &pendingConn{Conn:conn,t:t,key:addrKey(conn.RemoteAddr()),size:t.base}
+	var evict []*pendingConn
+	t.lck.Lock()
+	if t.peers[p.key]>=t.peer {
+		for e := t.lru.Back(); e!=nil; e = e.Prev() {
+			if q := e.Value.(*pendingConn); q.key==p.key {
+				evict = append(evict,t.evict(q))
+				break
+			}
+		}
+	}
+	for t.lru.Len()>=t.max {
+		evict = append(evict,t.evict(t.lru.Back().Value.(*pendingConn)))
+	}
+	p.elem = t.lru.PushFront(p)
+	t.peers[p.key]++
+	t.bytes += p.size
+	evict = t.shrink(evict)
+	t.lck.Unlock()
+	closeEvicted(evict)
+	return p
+}
+
+/*
+Charges n bytes read to p and marks it as the most recently active handshake.
+Returns false, if p has been evicted.
+*/
+func (t *pendingTable) read(p *pendingConn, n int) bool {
+	var evict []*pendingConn
+	t.lck.Lock()
+	if p.evicted {
+		t.lck.Unlock()
+		return false
+	}
+	p.size += int64(n)
+	t.bytes += int64(n)
+	t.lru.MoveToFront(p.elem)
+	evict = t.shrink(evict)
+	ok := !p.evicted
+	t.lck.Unlock()
+	closeEvicted(evict)
+	return ok
+}
+
+/*
+Removes p from the table, once its handshake is over.
+*/
+func (t *pendingTable) remove(p *pendingConn) {
+	t.lck.Lock(); defer t.lck.Unlock()
+	atomic.StoreInt32(&p.done,1)
+	if !p.evicted { t.unlink(p) }
+}
+
+/*
+Evicts the least recently active handshakes, until the byte limit is met.
+*/
+func (t *pendingTable) shrink(evict []*pendingConn) []*pendingConn {
+	for t.bytes>t.maxBytes && t.lru.Len()>0 {
+		evict = append(evict,t.evict(t.lru.Back().Value.(*pendingConn)))
+	}
+	return evict
+}
+func (t *pendingTable) evict(p *pendingConn) *pendingConn {
+	t.unlink(p)
+	p.evicted = true
+	return p
+}
+func (t *pendingTable) unlink(p *pendingConn) {
+	t.lru.Remove(p.elem)
+	t.bytes -= p.size
+	t.peers[p.key]--
+	if t.peers[p.key]==0 { delete(t.peers,p.key) }
+}
+func closeEvicted(evict []*pendingConn) {
+	for _,p := range evict { p.Conn.Close() }
+}
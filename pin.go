@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/sha256"
+import "crypto/subtle"
+import "encoding/base64"
+
+var ErrPeerNotPinned = newError(ErrHandshakeFailed,"seep: peer's static key is not pinned")
+
+/*
+Reports whether two keys are equal, in time independent of their contents (but
+not of their lengths). Use it instead of bytes.Equal for keys and secrets.
+*/
+func KeysEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a,b)==1
+}
+
+/*
+Returns the canonical fingerprint of a public key: "SHA256:" followed by the
+unpadded base64 encoding of the key's SHA-256 hash, as used by OpenSSH.
+*/
+func Fingerprint(key []byte) string {
+	h := sha256.Sum256(key)
+	return "SHA256:"+base64.RawStdEncoding.EncodeToString(h[:])
+}
+
+/*
+Returns a verifier for Options.VerifyPeer, that accepts the given static
+public keys only. Peers, that don't authenticate with a static key, are
+rejected as well.
+
+	o := &seep.Options{VerifyPeer:seep.PinnedPeer(serverKey)}
+*/
+func PinnedPeer(keys ...[]byte) func(static []byte) error {
+	pinned := make([][]byte,len(keys))
+	for i,k := range keys { pinned[i] = append([]byte(nil),k...) }
+	return func(static []byte) error {
+		ok := 0
+		for _,k := range pinned { ok |= subtle.ConstantTimeCompare(k,static) }
+		if ok==0 || len(static)==0 { return ErrPeerNotPinned }
+		return nil
+	}
+}
+
+/*
+Calls verify, if it is not nil, see Options.VerifyPeer.
+*/
+func verifyPeer(verify func([]byte) error, static []byte) error {
+	if verify==nil { return nil }
+	return verify(static)
+}
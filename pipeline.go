@@ -0,0 +1,298 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "encoding/binary"
+import "sync"
+import "github.com/flynn/noise"
+
+/*
+A pipeline writes encrypted frames to a Framer in a separate goroutine, so that
+the encryption of the next frame overlaps with the write of the previous one.
+The buffers circulate in a ring of fixed size: the encrypting side takes a free
+buffer, fills it and queues it, the writing goroutine writes it and returns it
+to the free list. The goroutine exits, whenever the queue runs empty.
+
+With Options.CryptoWorkers, the buffers are filled by worker goroutines; the
+writing goroutine waits for each frame in turn, so that the frames are written
+in the order, in which they were queued.
+*/
+type pipeline struct{
+	dst Framer
+	free chan []byte
+	queue chan *pipeJob
+	lck sync.Mutex
+	idle *sync.Cond
+	running bool
+	err error
+}
+func newPipeline(dst Framer, n int) *pipeline {
+	p := &pipeline{dst:dst,free:make(chan []byte,n),queue:make(chan *pipeJob,n)}
+	p.idle = sync.NewCond(&p.lck)
+	for i:=0; i<n; i++ { p.free <- nil }
+	return p
+}
+
+/*
+Returns a free buffer, blocking while all buffers are in flight.
+*/
+func (p *pipeline) get() ([]byte,error) {
+	b := <-p.free
+	p.lck.Lock(); defer p.lck.Unlock()
+	if p.err!=nil {
+		p.free <- b
+		return nil,p.err
+	}
+	return b,nil
+}
+
+/*
+A queued frame. If done is not nil, buf is valid once done is closed. If after
+is not nil, it is called with the frame right before it is written.
+*/
+type pipeJob struct{
+	buf []byte
+	done chan struct{}
+	after func(b []byte)
+}
+
+/*
+Queues a filled buffer, obtained by get.
+*/
+func (p *pipeline) put(b []byte) error {
+	return p.putJob(&pipeJob{buf:b})
+}
+func (p *pipeline) putJob(j *pipeJob) error {
+	p.lck.Lock(); defer p.lck.Unlock()
+	if p.err!=nil {
+		if j.done!=nil { <-j.done }
+		p.free <- j.buf[:0]
+		return p.err
+	}
+	p.queue <- j
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+	return nil
+}
+func (p *pipeline) run() {
+	for {
+		select {
+		case j := <-p.queue:
+			if j.done!=nil { <-j.done }
+			b := j.buf
+			if j.after!=nil { j.after(b) }
+			err := p.dst.WriteFrame(b)
+			if err!=nil {
+				p.lck.Lock()
+				if p.err==nil { p.err = err }
+				p.lck.Unlock()
+			}
+			p.free <- b[:0]
+			continue
+		default:
+		}
+		p.lck.Lock()
+		if len(p.queue)==0 {
+			p.running = false
+			p.idle.Broadcast()
+			p.lck.Unlock()
+			return
+		}
+		p.lck.Unlock()
+	}
+}
+
+/*
+Waits until all queued frames have been written. Returns the first write
+error, if any.
+*/
+func (p *pipeline) drain() error {
+	p.lck.Lock(); defer p.lck.Unlock()
+	for p.running { p.idle.Wait() }
+	return p.err
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The read side of Options.CryptoWorkers: a goroutine reads the frames from the
+Framer and hands each to a worker, that decrypts it under the key, nonce and
+sequence number, that it is due under, unless a frame before it changes them.
+The frames are consumed in order by frameReader.openAsync, which checks the
+guess and decrypts the frame again itself, where a rekey, an upgrade or a
+frame, that failed to decrypt, proved it wrong.
+*/
+type opener struct{
+	src Framer
+	max int
+	sequenced bool
+	queue chan *openJob
+	workers chan struct{}
+	lck sync.Mutex
+	// The guess for the next frame, and the number of frames guessed, but
+	// not yet consumed.
+	c noise.Cipher
+	gen, nonce, seq uint64
+	queued uint64
+	// Closed by close.
+	stop chan struct{}
+}
+
+/*
+A frame read by the opener. If err is nil, buf holds the plaintext once done
+is closed, or ok is false, if the frame failed to decrypt under the guess.
+*/
+type openJob struct{
+	ct, buf []byte
+	ok bool
+	gen, nonce, seq uint64
+	err error
+	done chan struct{}
+}
+
+func newOpener(f *frameReader) *opener {
+	n := f.opts.CryptoWorkers
+	o := &opener{src:f.src,max:f.opts.MaxFrameSize,sequenced:f.opts.Sequenced,queue:make(chan *openJob,2*n),workers:make(chan struct{},n),stop:make(chan struct{})}
+	o.guess(f)
+	go o.run()
+	return o
+}
+
+/*
+Bases the guess for the next frame to be read on the state of f, assuming,
+that every frame queued before it decrypts fine.
+*/
+func (o *opener) guess(f *frameReader) {
+	o.lck.Lock(); defer o.lck.Unlock()
+	// After a teardown, the frames are dropped anyway.
+	if f.dec!=nil { o.c = f.dec.Cipher() }
+	o.gen,o.nonce,o.seq = f.gen,f.nonce+o.queued,f.seq+o.queued
+}
+
+func (o *opener) run() {
+	for {
+		ct,err := o.src.ReadFrame(o.max)
+		j := &openJob{err:err,done:make(chan struct{})}
+		if err!=nil {
+			close(j.done)
+			o.put(j)
+			return
+		}
+		j.ct = append([]byte(nil),ct...)
+		o.lck.Lock()
+		if o.stopped() {
+			o.lck.Unlock()
+			return
+		}
+		c := o.c
+		j.gen,j.nonce,j.seq = o.gen,o.nonce,o.seq
+		o.nonce++
+		o.seq++
+		o.queued++
+		o.lck.Unlock()
+		select {
+		case o.workers <- struct{}{}:
+		case <-o.stop:
+			return
+		}
+		go func() {
+			var ad,body []byte = nil,j.ct
+			if o.sequenced && len(body)>=8 {
+				ad,body = make([]byte,8),body[8:]
+				binary.BigEndian.PutUint64(ad,j.seq)
+			}
+			if !o.sequenced || len(j.ct)>=8 {
+				var err error
+				j.buf,err = c.Decrypt(nil,j.nonce,ad,body)
+				j.ok = err==nil
+			}
+			o.lck.Lock()
+			if o.stopped() {
+				Wipe(j.buf)
+				j.buf,j.ok = nil,false
+			}
+			o.lck.Unlock()
+			<-o.workers
+			close(j.done)
+		}()
+		if !o.put(j) { return }
+	}
+}
+
+/*
+Queues a frame. Returns false, if the opener has been stopped.
+*/
+func (o *opener) put(j *openJob) bool {
+	select {
+	case o.queue <- j:
+		return true
+	case <-o.stop:
+		return false
+	}
+}
+func (o *opener) stopped() bool {
+	select {
+	case <-o.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Returns the next frame in order, once it is decrypted.
+*/
+func (o *opener) next() *openJob {
+	j := <-o.queue
+	<-j.done
+	if j.err==nil {
+		o.lck.Lock()
+		o.queued--
+		o.lck.Unlock()
+	}
+	return j
+}
+
+/*
+Stops decrypting and wipes the frames decrypted ahead. The goroutine stays
+blocked on the stream until the next frame arrives or the stream is closed.
+*/
+func (o *opener) close() {
+	o.lck.Lock()
+	close(o.stop)
+	o.c = nil
+	o.lck.Unlock()
+	for {
+		select {
+		case j := <-o.queue:
+			<-j.done
+			Wipe(j.buf)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/rand"
+import "encoding/hex"
+import "errors"
+import "io"
+import "net/rpc"
+import "os"
+import "os/exec"
+import "github.com/flynn/noise"
+
+const pluginEnv = "SEEP_PLUGIN_PSK"
+
+var ErrNotPlugin = errors.New("seep: process was not started as a plugin")
+
+/*
+The cipher suite used between a plugin and its parent. Both processes must use
+the same suite.
+*/
+var PluginCipherSuite = noise.NewCipherSuite(noise.DH25519,noise.CipherChaChaPoly,noise.HashBLAKE2s)
+
+func pluginConfig(psk []byte, initiator bool) noise.Config {
+	return noise.Config{
+		CipherSuite: PluginCipherSuite,
+		Pattern: noise.HandshakeNN,
+		Initiator: initiator,
+		Prologue: []byte("seep-plugin"),
+		PresharedKey: psk,
+		PresharedKeyPlacement: 0,
+	}
+}
+
+type pluginCloser struct{
+	stdin io.Closer
+	cmd *exec.Cmd
+}
+func (p *pluginCloser) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+/*
+Starts cmd as a plugin subprocess and returns an RPC client, that talks to it
+over its stdin and stdout. A fresh preshared key is passed to the child in its
+environment, and the connection is encrypted using the NNpsk0 pattern. The
+child must call NewPluginSource with the same format and options. Closing the
+client closes the child's stdin and waits for it to exit.
+
+If f is nil, XDRFormat is used.
+*/
+func StartPlugin(cmd *exec.Cmd, f *RpcFormat, o *Options) (*rpc.Client,error) {
+	psk := make([]byte,32)
+	_,err := io.ReadFull(rand.Reader,psk)
+	if err!=nil { return nil,err }
+	if cmd.Env==nil { cmd.Env = os.Environ() }
+	cmd.Env = append(cmd.Env,pluginEnv+"="+hex.EncodeToString(psk))
+	if cmd.Stderr==nil { cmd.Stderr = os.Stderr }
+	stdin,err := cmd.StdinPipe()
+	if err!=nil { return nil,err }
+	stdout,err := cmd.StdoutPipe()
+	if err!=nil { return nil,err }
+	err = cmd.Start()
+	if err!=nil { return nil,err }
+	codec,err := NewStreamRpcClient(stdout,stdin,pluginConfig(psk,true),&pluginCloser{stdin,cmd},f,o)
+	if err!=nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil,err
+	}
+	return rpc.NewClientWithCodec(codec),nil
+}
+
+/*
+Creates the server side RPC codec of a plugin, started by StartPlugin, over
+os.Stdin and os.Stdout. The plugin must not write anything else to os.Stdout.
+Returns ErrNotPlugin, if the process wasn't started by StartPlugin.
+
+If f is nil, XDRFormat is used.
+*/
+func NewPluginSource(f *RpcFormat, o *Options) (rpc.ServerCodec,error) {
+	s := os.Getenv(pluginEnv)
+	if s=="" { return nil,ErrNotPlugin }
+	os.Unsetenv(pluginEnv)
+	psk,err := hex.DecodeString(s)
+	if err!=nil { return nil,err }
+	return NewStreamRpcSource(os.Stdin,os.Stdout,pluginConfig(psk,false),os.Stdin,f,o)
+}
+
+/*
+Serves a plugin's RPC server over os.Stdin and os.Stdout until the parent
+closes the connection.
+*/
+func ServePlugin(s *rpc.Server, f *RpcFormat, o *Options) error {
+	codec,err := NewPluginSource(f,o)
+	if err!=nil { return err }
+	s.ServeCodec(codec)
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "fmt"
+import "strconv"
+import "strings"
+import "github.com/flynn/noise"
+
+var patterns = map[string]noise.HandshakePattern{
+	"NN": noise.HandshakeNN, "KN": noise.HandshakeKN, "NK": noise.HandshakeNK,
+	"KK": noise.HandshakeKK, "NX": noise.HandshakeNX, "KX": noise.HandshakeKX,
+	"XN": noise.HandshakeXN, "IN": noise.HandshakeIN, "XK": noise.HandshakeXK,
+	"IK": noise.HandshakeIK, "XX": noise.HandshakeXX, "IX": noise.HandshakeIX,
+	"N": noise.HandshakeN, "K": noise.HandshakeK, "X": noise.HandshakeX,
+}
+var dhFuncs = map[string]noise.DHFunc{
+	"25519": noise.DH25519,
+}
+var cipherFuncs = map[string]noise.CipherFunc{
+	"ChaChaPoly": noise.CipherChaChaPoly, "AESGCM": noise.CipherAESGCM,
+}
+var hashFuncs = map[string]noise.HashFunc{
+	"SHA256": noise.HashSHA256, "SHA512": noise.HashSHA512,
+	"BLAKE2b": noise.HashBLAKE2b, "BLAKE2s": noise.HashBLAKE2s,
+}
+
+/*
+A Protocol is the parsed form of a Noise protocol name, such as
+"Noise_XXpsk3_25519_ChaChaPoly_BLAKE2s".
+*/
+type Protocol struct{
+	Pattern noise.HandshakePattern
+	CipherSuite noise.CipherSuite
+	// The placement of the preshared key, or -1 if the pattern has no psk
+	// modifier.
+	PSKPlacement int
+}
+
+/*
+Parses a Noise protocol name. Only the patterns, functions and modifiers
+supported by github.com/flynn/noise are accepted (a single psk modifier).
+*/
+func ParseProtocolName(name string) (*Protocol,error) {
+	parts := strings.Split(name,"_")
+	if len(parts)!=5 || parts[0]!="Noise" { return nil,fmt.Errorf("seep: invalid protocol name %q",name) }
+	p := &Protocol{PSKPlacement:-1}
+	pat := parts[1]
+	if i := strings.Index(pat,"psk"); i>=0 {
+		n,err := strconv.Atoi(pat[i+3:])
+		if err!=nil { return nil,fmt.Errorf("seep: unsupported modifier in %q",name) }
+		p.PSKPlacement = n
+		pat = pat[:i]
+	}
+	var ok bool
+	p.Pattern,ok = patterns[pat]
+	if !ok { return nil,fmt.Errorf("seep: unsupported pattern in %q",name) }
+	dh,ok := dhFuncs[parts[2]]
+	if !ok { return nil,fmt.Errorf("seep: unsupported DH function in %q",name) }
+	c,ok := cipherFuncs[parts[3]]
+	if !ok { return nil,fmt.Errorf("seep: unsupported cipher in %q",name) }
+	h,ok := hashFuncs[parts[4]]
+	if !ok { return nil,fmt.Errorf("seep: unsupported hash in %q",name) }
+	p.CipherSuite = noise.NewCipherSuite(dh,c,h)
+	return p,nil
+}
+
+/*
+Returns the Noise protocol name of the handshake described by nc.
+*/
+func protocolName(nc noise.Config) string {
+	psk := ""
+	if len(nc.PresharedKey)>0 { psk = "psk"+strconv.Itoa(nc.PresharedKeyPlacement) }
+	return "Noise_"+nc.Pattern.Name+psk+"_"+string(nc.CipherSuite.Name())
+}
@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pubsub
+
+import "strings"
+import "sync"
+import "github.com/mad-day/seep"
+
+/*
+Permissions on a topic.
+*/
+type Perm uint8
+const (
+	Subscribe Perm = 1<<iota
+	Publish
+)
+
+/*
+An ACL grants permissions on topics to peers, identified by their static
+keys. Everything, that is not granted, is denied. A nil *ACL allows every
+peer everything. An ACL may be changed, while the Broker is running; the
+subscriptions already made stay in place.
+*/
+type ACL struct{
+	lck sync.RWMutex
+	rules []aclRule
+}
+
+type aclRule struct{
+	pattern string
+	perm Perm
+	// The fingerprints (see seep.Fingerprint) of the keys; nil means every
+	// peer, including those without a static key.
+	keys map[string]bool
+}
+
+/*
+Grants the permissions p on the topics matching pattern to the peers with the
+given static keys, or to every peer, if no keys are given. A pattern matches
+the topic equal to it, or, if it ends in "*", every topic starting with the
+rest of it: "sensors/*" matches "sensors/temperature", "*" every topic.
+*/
+func (a *ACL) Allow(pattern string, p Perm, keys ...[]byte) {
+	r := aclRule{pattern:pattern,perm:p}
+	if len(keys)>0 {
+		r.keys = make(map[string]bool)
+		for _,k := range keys { r.keys[seep.Fingerprint(k)] = true }
+	}
+	a.lck.Lock(); defer a.lck.Unlock()
+	a.rules = append(a.rules,r)
+}
+
+/*
+Reports, whether the peer with the static key peer (nil, if it has none) has
+the permission p on the topic.
+*/
+func (a *ACL) Allowed(topic string, p Perm, peer []byte) bool {
+	if a==nil { return true }
+	fp := ""
+	if peer!=nil { fp = seep.Fingerprint(peer) }
+	a.lck.RLock(); defer a.lck.RUnlock()
+	for _,r := range a.rules {
+		if r.perm&p!=p || !matchTopic(r.pattern,topic) { continue }
+		if r.keys==nil || (fp!="" && r.keys[fp]) { return true }
+	}
+	return false
+}
+
+func matchTopic(pattern, topic string) bool {
+	if strings.HasSuffix(pattern,"*") { return strings.HasPrefix(topic,pattern[:len(pattern)-1]) }
+	return pattern==topic
+}
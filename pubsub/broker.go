@@ -0,0 +1,206 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pubsub
+
+import "io"
+import "net"
+import "sync"
+import "github.com/mad-day/seep"
+
+/*
+A Broker relays the messages published on a topic to the subscribers of the
+topic. The zero value is ready to use.
+*/
+type Broker struct{
+	// Decides, who may subscribe and publish. Nil allows everything.
+	ACL *ACL
+	// The number of frames queued for a subscriber. A subscriber, whose
+	// queue is full, is disconnected with ErrSlowConsumer, so that it
+	// can't hold up the publishers. 0 means 256.
+	QueueLen int
+
+	lck sync.Mutex
+	topics map[string]map[*session]struct{}
+}
+
+/*
+The Broker's side of a client's session.
+*/
+type session struct{
+	c *seep.Conn
+	peer []byte
+	out chan []byte
+	// Closed by ServeConn, when the session ends.
+	done chan struct{}
+	// Closed by the writer, when it stops.
+	dead chan struct{}
+	once sync.Once
+	// The error, that ended the session, if the Broker ended it.
+	err error
+	// The topics subscribed to; only used by ServeConn.
+	subs map[string]bool
+}
+
+/*
+Ends the session with err.
+*/
+func (s *session) kill(err error) {
+	s.once.Do(func() {
+		s.err = err
+		s.c.Close()
+	})
+}
+
+func (s *session) write() {
+	defer close(s.dead)
+	w := s.c.Writer.(*seep.Writer)
+	for {
+		select {
+		case p := <-s.out:
+			err := w.WriteMessage(p)
+			if err!=nil { s.kill(err); return }
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) reply(topic string, code uint8) {
+	p,_ := encode(opReply,topic,[]byte{code})
+	select {
+	case s.out <- p:
+	case <-s.dead:
+	}
+}
+
+/*
+Accepts sessions from l, typically a seep.Server, and serves each of them, until
+l fails. Connections, that are not *seep.Conn, are closed.
+*/
+func (b *Broker) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go b.ServeConn(sc)
+	}
+}
+
+/*
+Serves the requests of a client on an established session, until it is
+closed, and closes it. Returns nil, if the client closed the session.
+*/
+func (b *Broker) ServeConn(c *seep.Conn) error {
+	n := b.QueueLen
+	if n==0 { n = 256 }
+	s := &session{c:c,peer:c.Session().PeerStatic,out:make(chan []byte,n),done:make(chan struct{}),dead:make(chan struct{}),subs:make(map[string]bool)}
+	go s.write()
+	defer func() {
+		b.unsubscribeAll(s)
+		close(s.done)
+		s.kill(nil)
+	}()
+	r := c.Reader.(*seep.Reader)
+	for {
+		p,err := r.ReadMessage()
+		if err==io.EOF { return s.err }
+		if err!=nil {
+			if s.err!=nil { return s.err }
+			return err
+		}
+		op,topic,payload,err := decode(p)
+		if err!=nil {
+			s.reply("?",replyBadRequest)
+			continue
+		}
+		switch op {
+		case opSubscribe:
+			if !b.ACL.Allowed(topic,Subscribe,s.peer) {
+				s.reply(topic,replyDenied)
+				continue
+			}
+			if !s.subs[topic] {
+				s.subs[topic] = true
+				b.subscribe(topic,s)
+			}
+		case opUnsubscribe:
+			if s.subs[topic] {
+				delete(s.subs,topic)
+				b.unsubscribe(topic,s)
+			}
+		case opPublish:
+			if !b.ACL.Allowed(topic,Publish,s.peer) {
+				s.reply(topic,replyDenied)
+				continue
+			}
+			b.Publish(topic,payload)
+		default:
+			s.reply(topic,replyBadRequest)
+			continue
+		}
+		s.reply(topic,replyOK)
+	}
+}
+
+func (b *Broker) subscribe(topic string, s *session) {
+	b.lck.Lock(); defer b.lck.Unlock()
+	if b.topics==nil { b.topics = make(map[string]map[*session]struct{}) }
+	m := b.topics[topic]
+	if m==nil {
+		m = make(map[*session]struct{})
+		b.topics[topic] = m
+	}
+	m[s] = struct{}{}
+}
+
+func (b *Broker) unsubscribe(topic string, s *session) {
+	b.lck.Lock(); defer b.lck.Unlock()
+	m := b.topics[topic]
+	delete(m,s)
+	if len(m)==0 { delete(b.topics,topic) }
+}
+
+func (b *Broker) unsubscribeAll(s *session) {
+	for topic := range s.subs { b.unsubscribe(topic,s) }
+}
+
+/*
+Publishes a message on a topic, on behalf of the Broker itself, without
+checking the ACL.
+*/
+func (b *Broker) Publish(topic string, data []byte) error {
+	p,err := encode(opMessage,topic,data)
+	if err!=nil { return err }
+	b.lck.Lock(); defer b.lck.Unlock()
+	for s := range b.topics[topic] {
+		select {
+		case s.out <- p:
+		default:
+			go s.kill(ErrSlowConsumer)
+		}
+	}
+	return nil
+}
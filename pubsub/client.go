@@ -0,0 +1,143 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pubsub
+
+import "sync"
+import "github.com/mad-day/seep"
+
+/*
+A message received from the Broker.
+*/
+type Message struct{
+	Topic string
+	Data []byte
+}
+
+/*
+The client side of a pub/sub session. Its methods may be called concurrently.
+The replies of the Broker are read in line with the messages, so a Client,
+whose messages are not consumed by Next, stalls, once a few of them are
+buffered.
+*/
+type Client struct{
+	c *seep.Conn
+	w *seep.Writer
+	msgs chan Message
+	lck sync.Mutex
+	// The requests waiting for their replies, in the order they were sent.
+	pending []chan error
+	// The error, that ended the session.
+	err error
+}
+
+/*
+Starts a Client on an established session with the Broker.
+*/
+func NewClient(c *seep.Conn) *Client {
+	cl := &Client{c:c,w:c.Writer.(*seep.Writer),msgs:make(chan Message,64)}
+	go cl.read()
+	return cl
+}
+
+func (c *Client) read() {
+	r := c.c.Reader.(*seep.Reader)
+	var err error
+	for {
+		var p []byte
+		p,err = r.ReadMessage()
+		if err!=nil { break }
+		op,topic,payload,derr := decode(p)
+		if derr!=nil { err = derr; break }
+		if op==opMessage {
+			c.msgs <- Message{topic,payload}
+			continue
+		}
+		if op!=opReply || len(payload)!=1 { err = ErrBadRequest; break }
+		c.lck.Lock()
+		if len(c.pending)==0 { c.lck.Unlock(); err = ErrBadRequest; break }
+		ch := c.pending[0]
+		c.pending = c.pending[1:]
+		c.lck.Unlock()
+		switch payload[0] {
+		case replyOK: ch <- nil
+		case replyDenied: ch <- ErrDenied
+		default: ch <- ErrBadRequest
+		}
+	}
+	c.lck.Lock()
+	c.err = err
+	for _,ch := range c.pending { ch <- err }
+	c.pending = nil
+	c.lck.Unlock()
+	close(c.msgs)
+}
+
+func (c *Client) request(op uint8, topic string, data []byte) error {
+	p,err := encode(op,topic,data)
+	if err!=nil { return err }
+	ch := make(chan error,1)
+	c.lck.Lock()
+	if c.err!=nil { err = c.err; c.lck.Unlock(); return err }
+	err = c.w.WriteMessage(p)
+	if err!=nil { c.lck.Unlock(); return err }
+	c.pending = append(c.pending,ch)
+	c.lck.Unlock()
+	return <-ch
+}
+
+/*
+Subscribes to a topic. Returns ErrDenied, if the ACL of the Broker does not
+allow it.
+*/
+func (c *Client) Subscribe(topic string) error {
+	return c.request(opSubscribe,topic,nil)
+}
+
+func (c *Client) Unsubscribe(topic string) error {
+	return c.request(opUnsubscribe,topic,nil)
+}
+
+/*
+Publishes data on a topic and waits for the Broker to pass it on to the
+subscribers. Returns ErrDenied, if the ACL of the Broker does not allow it.
+*/
+func (c *Client) Publish(topic string, data []byte) error {
+	return c.request(opPublish,topic,data)
+}
+
+/*
+Returns the next message of the topics subscribed to. After the session ended,
+it returns the error, that ended it (io.EOF, if the Broker closed it).
+*/
+func (c *Client) Next() (Message,error) {
+	m,ok := <-c.msgs
+	if ok { return m,nil }
+	c.lck.Lock(); defer c.lck.Unlock()
+	return Message{},c.err
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
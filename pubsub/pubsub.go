@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A small publish/subscribe bus over SEEP sessions. Clients subscribe to topics
+and publish messages on them; the Broker fans the messages out to the
+subscribers over their encrypted sessions. Who may subscribe or publish to a
+topic is decided by an ACL on the static keys, the peers authenticated with.
+
+	acl := new(pubsub.ACL)
+	acl.Allow("sensors/*",pubsub.Publish,sensorKey)
+	acl.Allow("sensors/*",pubsub.Subscribe)
+	s := &seep.Server{Config:cfg}
+	err := s.Listen("tcp",":7000")
+	// ... check error
+	go (&pubsub.Broker{ACL:acl}).Serve(s)
+
+	c,err := seep.Dial("tcp","broker:7000",cfg,nil)
+	// ... check error
+	client := pubsub.NewClient(c)
+	err = client.Subscribe("sensors/temperature")
+	m,err := client.Next()
+
+Every request and message is a single frame: an operation byte, the topic,
+preceded by its 2 byte big-endian length, and the payload. Messages are thus
+limited to the maximum frame size, less the topic.
+*/
+package pubsub
+
+import "encoding/binary"
+import "errors"
+
+var ErrDenied = errors.New("pubsub: permission denied")
+var ErrBadRequest = errors.New("pubsub: malformed request")
+var ErrTopic = errors.New("pubsub: invalid topic")
+
+/*
+Disconnects subscribers, that do not keep up with their messages.
+*/
+var ErrSlowConsumer = errors.New("pubsub: subscriber too slow")
+
+const (
+	opSubscribe uint8 = iota+1
+	opUnsubscribe
+	opPublish
+	// A message published on a topic, sent to the subscribers.
+	opMessage
+	// The reply to a request, with a one byte reply code as payload.
+	opReply
+)
+
+/*
+Reply codes.
+*/
+const (
+	replyOK uint8 = iota
+	replyDenied
+	replyBadRequest
+)
+
+func encode(op uint8, topic string, payload []byte) ([]byte,error) {
+	if len(topic)==0 || len(topic)>0xffff { return nil,ErrTopic }
+	b := make([]byte,3,3+len(topic)+len(payload))
+	b[0] = op
+	binary.BigEndian.PutUint16(b[1:],uint16(len(topic)))
+	b = append(b,topic...)
+	return append(b,payload...),nil
+}
+
+func decode(b []byte) (op uint8, topic string, payload []byte, err error) {
+	if len(b)<3 { return 0,"",nil,ErrBadRequest }
+	n := int(binary.BigEndian.Uint16(b[1:]))
+	if n==0 || len(b)<3+n { return 0,"",nil,ErrBadRequest }
+	return b[0],string(b[3:3+n]),b[3+n:],nil
+}
@@ -0,0 +1,185 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package punch
+
+import "crypto/rand"
+import "net"
+import "sync"
+import "time"
+import "github.com/mad-day/seep"
+
+/*
+A Coordinator registers peers, observes their public endpoints and introduces
+them to each other. The zero value is ready to use.
+*/
+type Coordinator struct{
+	// Decides, who may register, by static key. Nil allows every peer
+	// with a static key.
+	Allow func(peer []byte) bool
+
+	lck sync.Mutex
+	peers map[string]*registration
+	byNonce map[nonce]*registration
+}
+
+/*
+A registered peer.
+*/
+type registration struct{
+	c *seep.Conn
+	key []byte
+	nonce nonce
+	endpoint string
+	locals []string
+}
+
+/*
+Accepts sessions from l, typically a seep.Server, and serves each of them, until
+l fails. Connections, that are not *seep.Conn, are closed.
+*/
+func (co *Coordinator) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go co.ServeConn(sc)
+	}
+}
+
+/*
+Registers the peer of c, until the session ends, and closes c. A peer, that
+registers again, replaces its earlier session.
+*/
+func (co *Coordinator) ServeConn(c *seep.Conn) error {
+	defer c.Close()
+	key := c.Session().PeerStatic
+	if key==nil { return ErrNoKey }
+	if co.Allow!=nil && !co.Allow(key) { return ErrDenied }
+	r := &registration{c:c,key:key}
+	_,err := rand.Read(r.nonce[:])
+	if err!=nil { return err }
+	co.register(r)
+	defer co.unregister(r)
+	w := c.Writer.(*seep.Writer)
+	err = w.WriteMessage(encode(opObserve,r.nonce[:]))
+	if err!=nil { return err }
+	rd := c.Reader.(*seep.Reader)
+	for {
+		p,err := rd.ReadMessage()
+		if err!=nil { return err }
+		op,fields,err := decode(p)
+		if err!=nil { return err }
+		switch op {
+		case opHello:
+			co.lck.Lock()
+			r.locals = fields2strings(fields)
+			co.lck.Unlock()
+		case opConnect:
+			if len(fields)!=1 { return ErrProtocol }
+			err = co.introduce(r,fields[0])
+			if err!=nil { return err }
+		default:
+			return ErrProtocol
+		}
+	}
+}
+
+func (co *Coordinator) register(r *registration) {
+	co.lck.Lock(); defer co.lck.Unlock()
+	if co.peers==nil {
+		co.peers = make(map[string]*registration)
+		co.byNonce = make(map[nonce]*registration)
+	}
+	if old := co.peers[string(r.key)]; old!=nil {
+		delete(co.byNonce,old.nonce)
+		// Ends the old ServeConn.
+		old.c.SetDeadline(time.Now())
+	}
+	co.peers[string(r.key)] = r
+	co.byNonce[r.nonce] = r
+}
+
+func (co *Coordinator) unregister(r *registration) {
+	co.lck.Lock(); defer co.lck.Unlock()
+	if co.peers[string(r.key)]==r { delete(co.peers,string(r.key)) }
+	if co.byNonce[r.nonce]==r { delete(co.byNonce,r.nonce) }
+}
+
+/*
+Introduces the peer of r and the one with the static key target to each
+other, with a fresh nonce.
+*/
+func (co *Coordinator) introduce(r *registration, target []byte) error {
+	co.lck.Lock()
+	t := co.peers[string(target)]
+	if t==nil {
+		co.lck.Unlock()
+		return r.c.Writer.(*seep.Writer).WriteMessage(encode(opUnknown,target))
+	}
+	var n nonce
+	_,err := rand.Read(n[:])
+	if err!=nil { co.lck.Unlock(); return err }
+	toInit := &intro{nonce:n,role:roleInitiator,key:t.key,cands:t.candidates()}
+	toResp := &intro{nonce:n,role:roleResponder,key:r.key,cands:r.candidates()}
+	co.lck.Unlock()
+	// The responder starts probing first, as the initiator waits for its
+	// answer anyway.
+	t.c.Writer.(*seep.Writer).WriteMessage(toResp.encode())
+	return r.c.Writer.(*seep.Writer).WriteMessage(toInit.encode())
+}
+
+/*
+The endpoints, a peer may be reached at, the observed one first.
+*/
+func (r *registration) candidates() []string {
+	var c []string
+	if r.endpoint!="" { c = append(c,r.endpoint) }
+	for _,l := range r.locals {
+		if l!=r.endpoint { c = append(c,l) }
+	}
+	return c
+}
+
+/*
+Observes the endpoints of the peers on pc, the UDP socket, whose address the
+peers are given to Peer.Start, until pc fails.
+*/
+func (co *Coordinator) ServePacket(pc net.PacketConn) error {
+	buf := make([]byte,packetLen+1)
+	for {
+		n,from,err := pc.ReadFrom(buf)
+		if err!=nil { return err }
+		typ,nc,ok := parsePacket(buf[:n])
+		if !ok || typ!=pktObserve { continue }
+		ep := from.String()
+		co.lck.Lock()
+		r := co.byNonce[nc]
+		changed := r!=nil && r.endpoint!=ep
+		if changed { r.endpoint = ep }
+		co.lck.Unlock()
+		if changed { r.c.Writer.(*seep.Writer).WriteMessage(encode(opEndpoint,[]byte(ep))) }
+	}
+}
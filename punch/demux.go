@@ -0,0 +1,151 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package punch
+
+import "bytes"
+import "errors"
+import "net"
+import "sync"
+import "time"
+
+var errDeadline = errors.New("punch: deadlines not supported")
+
+/*
+Shares a UDP socket between the punching and the KCP sessions on it: the
+punching packets go to a function, the others to a virtual PacketConn, the
+sessions dialed on the socket each get one of their own, the KCP listener
+the default one.
+*/
+type demux struct{
+	pc net.PacketConn
+	punch func(b []byte, from net.Addr)
+	lck sync.Mutex
+	routes map[string]*vconn
+	def *vconn
+}
+
+func newDemux(pc net.PacketConn, punch func(b []byte, from net.Addr)) *demux {
+	d := &demux{pc:pc,punch:punch,routes:make(map[string]*vconn)}
+	d.def = d.newVconn("")
+	go d.run()
+	return d
+}
+
+func (d *demux) newVconn(remote string) *vconn {
+	return &vconn{d:d,remote:remote,in:make(chan datagram,256),closed:make(chan struct{})}
+}
+
+/*
+Returns a PacketConn, that receives the packets of remote.
+*/
+func (d *demux) route(remote net.Addr) *vconn {
+	v := d.newVconn(remote.String())
+	d.lck.Lock()
+	old := d.routes[v.remote]
+	d.routes[v.remote] = v
+	d.lck.Unlock()
+	if old!=nil { old.Close() }
+	return v
+}
+
+func (d *demux) run() {
+	buf := make([]byte,64<<10)
+	for {
+		n,from,err := d.pc.ReadFrom(buf)
+		if err!=nil { break }
+		b := append([]byte(nil),buf[:n]...)
+		if bytes.HasPrefix(b,[]byte(magic)) {
+			d.punch(b,from)
+			continue
+		}
+		d.lck.Lock()
+		v := d.routes[from.String()]
+		d.lck.Unlock()
+		if v==nil { v = d.def }
+		v.deliver(datagram{b,from})
+	}
+	d.lck.Lock()
+	vs := []*vconn{d.def}
+	for _,v := range d.routes { vs = append(vs,v) }
+	d.lck.Unlock()
+	for _,v := range vs { v.Close() }
+}
+
+type datagram struct{
+	b []byte
+	from net.Addr
+}
+
+/*
+A PacketConn fed by a demux. Its deadlines are not supported, as KCP does not
+use them.
+*/
+type vconn struct{
+	d *demux
+	remote string
+	in chan datagram
+	once sync.Once
+	closed chan struct{}
+}
+
+func (v *vconn) deliver(p datagram) {
+	select {
+	case v.in <- p:
+	case <-v.closed:
+	default:
+		// Dropped, like on a full socket buffer.
+	}
+}
+
+func (v *vconn) ReadFrom(p []byte) (int,net.Addr,error) {
+	select {
+	case dg := <-v.in: return copy(p,dg.b),dg.from,nil
+	case <-v.closed: return 0,nil,net.ErrClosed
+	}
+}
+
+func (v *vconn) WriteTo(p []byte, addr net.Addr) (int,error) {
+	select {
+	case <-v.closed: return 0,net.ErrClosed
+	default:
+	}
+	return v.d.pc.WriteTo(p,addr)
+}
+
+func (v *vconn) Close() error {
+	v.once.Do(func() {
+		close(v.closed)
+		if v.remote=="" { return }
+		v.d.lck.Lock()
+		if v.d.routes[v.remote]==v { delete(v.d.routes,v.remote) }
+		v.d.lck.Unlock()
+	})
+	return nil
+}
+
+func (v *vconn) LocalAddr() net.Addr { return v.d.pc.LocalAddr() }
+func (v *vconn) SetDeadline(t time.Time) error { return errDeadline }
+func (v *vconn) SetReadDeadline(t time.Time) error { return errDeadline }
+func (v *vconn) SetWriteDeadline(t time.Time) error { return errDeadline }
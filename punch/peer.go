@@ -0,0 +1,474 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package punch
+
+import "net"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/relay"
+import "github.com/mad-day/seep/seepkcp"
+
+/*
+A peer, that dials and accepts direct sessions with the help of a
+Coordinator, and through a relay, where that fails. A Peer is a
+net.Listener, whose Accept returns the sessions of the peers, that dialed
+it, as *seep.Conn.
+*/
+type Peer struct{
+	// The handshake configuration of the sessions; its static key must be
+	// the one, the Peer registers with. Initiator is ignored.
+	Config noise.Config
+	Options *seep.Options
+	// The tuning of the direct sessions, seepkcp.Fast if nil. All peers
+	// must agree on DataShards and ParityShards.
+	KCP *seepkcp.Config
+	// How long Dial waits for the introduction, and how long the punching
+	// takes at most. 0 means 5 seconds.
+	Timeout time.Duration
+	// The time limit of the handshakes. 0 means 10 seconds.
+	HandshakeTimeout time.Duration
+	// If not empty, the address of a relay, that the Peer listens at and
+	// Dial falls back to.
+	Relay string
+
+	c *seep.Conn
+	pc net.PacketConn
+	coord net.Addr
+	d *demux
+	server *seep.Server
+	lck sync.Mutex
+	nonce *nonce
+	endpoint string
+	// Closed, once the Coordinator observed the endpoint.
+	known chan struct{}
+	// The Dials waiting for their introductions, by the peer's key. A nil
+	// intro means, that the peer is not registered.
+	dials map[string][]chan *intro
+	// The punchings in progress, by nonce.
+	punches map[nonce]chan probe
+	// Closed, when the session with the Coordinator ends.
+	lost chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+/*
+A probe or answer received.
+*/
+type probe struct{
+	typ uint8
+	from net.Addr
+}
+
+func (p *Peer) timeout() time.Duration {
+	if p.Timeout>0 { return p.Timeout }
+	return 5*time.Second
+}
+
+func (p *Peer) handshakeTimeout() time.Duration {
+	if p.HandshakeTimeout>0 { return p.HandshakeTimeout }
+	return 10*time.Second
+}
+
+func (p *Peer) kcp() *seepkcp.Config {
+	if p.KCP!=nil { return p.KCP }
+	return &seepkcp.Fast
+}
+
+/*
+Registers with the Coordinator over c, and starts listening on pc, the UDP
+socket of the direct sessions, and at the relay. coordinator is the UDP
+address of the Coordinator's ServePacket. The Peer owns c and pc from then
+on.
+*/
+func (p *Peer) Start(c *seep.Conn, pc net.PacketConn, coordinator string) error {
+	coord,err := net.ResolveUDPAddr("udp",coordinator)
+	if err!=nil { return err }
+	p.c,p.pc,p.coord = c,pc,coord
+	p.known = make(chan struct{})
+	p.dials = make(map[string][]chan *intro)
+	p.punches = make(map[nonce]chan probe)
+	p.lost = make(chan struct{})
+	p.done = make(chan struct{})
+	p.d = newDemux(pc,p.packet)
+	kl,err := seepkcp.NewListener(p.d.def,p.kcp())
+	if err!=nil { return err }
+	ls := []net.Listener{kl}
+	if p.Relay!="" { ls = append(ls,relay.NewKeyListener("tcp",p.Relay,p.Config.StaticKeypair)) }
+	nc := p.Config
+	nc.Initiator = false
+	p.server = &seep.Server{Config:nc,Options:p.Options,HandshakeTimeout:p.handshakeTimeout()}
+	p.server.Start(newMergeListener(ls...))
+	err = c.Writer.(*seep.Writer).WriteMessage(encode(opHello,strings2fields(locals(pc))...))
+	if err!=nil { return err }
+	go p.read()
+	go p.observe()
+	return nil
+}
+
+/*
+The addresses of pc on the local interfaces, for the peers in the same
+network, whose packets may not make it through the NAT and back.
+*/
+func locals(pc net.PacketConn) []string {
+	la,ok := pc.LocalAddr().(*net.UDPAddr)
+	if !ok { return nil }
+	if !la.IP.IsUnspecified() { return []string{la.String()} }
+	addrs,_ := net.InterfaceAddrs()
+	var l []string
+	for _,a := range addrs {
+		ipn,ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLinkLocalUnicast() { continue }
+		l = append(l,(&net.UDPAddr{IP:ipn.IP,Port:la.Port}).String())
+		if len(l)==8 { break }
+	}
+	return l
+}
+
+/*
+Sends the observation packets to the Coordinator, often until it observed the
+endpoint, then rarely, to keep the NAT's mapping.
+*/
+func (p *Peer) observe() {
+	for {
+		p.lck.Lock()
+		n,known := p.nonce,p.endpoint!=""
+		p.lck.Unlock()
+		if n!=nil { p.pc.WriteTo(packet(pktObserve,*n),p.coord) }
+		d := 200*time.Millisecond
+		if known { d = 15*time.Second }
+		select {
+		case <-time.After(d):
+		case <-p.lost: return
+		case <-p.done: return
+		}
+	}
+}
+
+func (p *Peer) read() {
+	defer close(p.lost)
+	r := p.c.Reader.(*seep.Reader)
+	for {
+		b,err := r.ReadMessage()
+		if err!=nil { return }
+		op,fields,err := decode(b)
+		if err!=nil { return }
+		switch op {
+		case opObserve:
+			if len(fields)!=1 || len(fields[0])!=len(nonce{}) { return }
+			n := new(nonce)
+			copy(n[:],fields[0])
+			p.lck.Lock()
+			p.nonce = n
+			p.lck.Unlock()
+		case opEndpoint:
+			if len(fields)!=1 { return }
+			p.lck.Lock()
+			if p.endpoint=="" { close(p.known) }
+			p.endpoint = string(fields[0])
+			p.lck.Unlock()
+		case opIntroduce:
+			i,err := decodeIntro(fields)
+			if err!=nil { return }
+			if i.role==roleResponder {
+				go p.punch(i)
+				continue
+			}
+			p.introduced(string(i.key),i)
+		case opUnknown:
+			if len(fields)!=1 { return }
+			p.introduced(string(fields[0]),nil)
+		default:
+			return
+		}
+	}
+}
+
+/*
+Hands an introduction to the Dial, that waits longest for the peer.
+*/
+func (p *Peer) introduced(key string, i *intro) {
+	p.lck.Lock(); defer p.lck.Unlock()
+	q := p.dials[key]
+	if len(q)==0 { return }
+	q[0] <- i
+	if len(q)==1 {
+		delete(p.dials,key)
+	} else {
+		p.dials[key] = q[1:]
+	}
+}
+
+func (p *Peer) forget(key string, ch chan *intro) {
+	p.lck.Lock(); defer p.lck.Unlock()
+	q := p.dials[key]
+	for j,x := range q {
+		if x!=ch { continue }
+		q = append(q[:j:j],q[j+1:]...)
+		if len(q)==0 {
+			delete(p.dials,key)
+		} else {
+			p.dials[key] = q
+		}
+		return
+	}
+}
+
+/*
+Handles the punching packets: answers the probes of the punchings in
+progress and passes them on.
+*/
+func (p *Peer) packet(b []byte, from net.Addr) {
+	typ,n,ok := parsePacket(b)
+	if !ok || (typ!=pktProbe && typ!=pktAck) { return }
+	p.lck.Lock()
+	ch := p.punches[n]
+	p.lck.Unlock()
+	if ch==nil { return }
+	if typ==pktProbe { p.pc.WriteTo(packet(pktAck,n),from) }
+	select {
+	case ch <- probe{typ,from}:
+	default:
+	}
+}
+
+/*
+Probes the candidate endpoints of the peer of i, until the peer answers a
+probe (for the initiator) or sends one (for the responder), and returns the
+peer's endpoint, the packets came from. The probes of the peer are answered
+for the whole timeout, in case the answers get lost.
+*/
+func (p *Peer) punch(i *intro) (net.Addr,error) {
+	ch := make(chan probe,16)
+	p.lck.Lock()
+	p.punches[i.nonce] = ch
+	p.lck.Unlock()
+	time.AfterFunc(p.timeout(),func() {
+		p.lck.Lock(); defer p.lck.Unlock()
+		delete(p.punches,i.nonce)
+	})
+	var addrs []net.Addr
+	for _,c := range i.cands {
+		a,err := net.ResolveUDPAddr("udp",c)
+		if err==nil { addrs = append(addrs,a) }
+	}
+	b := packet(pktProbe,i.nonce)
+	send := func() {
+		for _,a := range addrs { p.pc.WriteTo(b,a) }
+	}
+	send()
+	tick := time.NewTicker(100*time.Millisecond)
+	defer tick.Stop()
+	timer := time.NewTimer(p.timeout())
+	defer timer.Stop()
+	for {
+		select {
+		case pr := <-ch:
+			if i.role==roleResponder || pr.typ==pktAck { return pr.from,nil }
+		case <-tick.C:
+			send()
+		case <-timer.C:
+			return nil,ErrUnreachable
+		case <-p.done:
+			return nil,net.ErrClosed
+		}
+	}
+}
+
+/*
+Options, that require the peer to authenticate with the static key peer, in
+addition to the checks of p.Options.
+*/
+func (p *Peer) pinned(peer []byte) *seep.Options {
+	var o seep.Options
+	if p.Options!=nil { o = *p.Options }
+	verify,pin := o.VerifyPeer,seep.PinnedPeer(peer)
+	o.VerifyPeer = func(static []byte) error {
+		err := pin(static)
+		if err==nil && verify!=nil { err = verify(static) }
+		return err
+	}
+	return &o
+}
+
+/*
+Connects to the peer with the static key peer: directly, if the punching
+succeeds, otherwise through the relay. Returns ErrUnknownPeer or
+ErrUnreachable, if there is no relay.
+*/
+func (p *Peer) Dial(peer []byte) (*seep.Conn,error) {
+	o := p.pinned(peer)
+	c,err := p.dialDirect(peer,o)
+	if err==nil || p.Relay=="" { return c,err }
+	return relay.DialPeer("tcp",p.Relay,peer,p.Config,o)
+}
+
+func (p *Peer) dialDirect(peer []byte, o *seep.Options) (*seep.Conn,error) {
+	timer := time.NewTimer(p.timeout())
+	defer timer.Stop()
+	// The peer must know the endpoint, to probe it.
+	select {
+	case <-p.known:
+	case <-timer.C: return nil,ErrUnreachable
+	case <-p.lost: return nil,ErrUnreachable
+	}
+	ch := make(chan *intro,1)
+	key := string(peer)
+	p.lck.Lock()
+	p.dials[key] = append(p.dials[key],ch)
+	p.lck.Unlock()
+	defer p.forget(key,ch)
+	err := p.c.Writer.(*seep.Writer).WriteMessage(encode(opConnect,peer))
+	if err!=nil { return nil,err }
+	var i *intro
+	select {
+	case i = <-ch:
+		if i==nil { return nil,ErrUnknownPeer }
+	case <-timer.C: return nil,ErrUnreachable
+	case <-p.lost: return nil,ErrUnreachable
+	}
+	addr,err := p.punch(i)
+	if err!=nil { return nil,err }
+	v := p.d.route(addr)
+	s,err := seepkcp.DialPacketKCP(v,addr,p.kcp())
+	if err!=nil {
+		v.Close()
+		return nil,err
+	}
+	conn := &routedConn{Conn:s,v:v,linger:p.kcp().Linger}
+	conn.SetDeadline(time.Now().Add(p.handshakeTimeout()))
+	nc := p.Config
+	nc.Initiator = true
+	c,err := seep.NewConn(conn,nc,o)
+	if err!=nil {
+		conn.Close()
+		return nil,err
+	}
+	conn.SetDeadline(time.Time{})
+	return c,nil
+}
+
+/*
+A dialed KCP session, whose route is closed, once the session stopped
+lingering (see seepkcp.Config.Linger).
+*/
+type routedConn struct{
+	net.Conn
+	v *vconn
+	linger time.Duration
+	once sync.Once
+}
+func (c *routedConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		err = c.Conn.Close()
+		d := c.linger
+		if d==0 { d = seepkcp.DefaultLinger }
+		if d<0 { d = 0 }
+		time.AfterFunc(d,func() { c.v.Close() })
+	})
+	return err
+}
+
+/*
+Returns the next session, a peer dialed directly or through the relay.
+*/
+func (p *Peer) Accept() (net.Conn,error) { return p.server.Accept() }
+
+/*
+Returns the address of the socket of the direct sessions.
+*/
+func (p *Peer) Addr() net.Addr { return p.pc.LocalAddr() }
+
+/*
+Returns the public endpoint of the socket, as the Coordinator observed it, or
+"", until it did.
+*/
+func (p *Peer) Endpoint() string {
+	p.lck.Lock(); defer p.lck.Unlock()
+	return p.endpoint
+}
+
+/*
+Unregisters from the Coordinator and stops listening. Closes the socket and
+thereby the direct sessions.
+*/
+func (p *Peer) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.server.Close()
+		p.c.Close()
+		p.pc.Close()
+	})
+	return nil
+}
+
+/*
+Accepts the connections of several listeners.
+*/
+type mergeListener struct{
+	ls []net.Listener
+	conns chan net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func newMergeListener(ls ...net.Listener) *mergeListener {
+	m := &mergeListener{ls:ls,conns:make(chan net.Conn),done:make(chan struct{})}
+	for _,l := range ls { go m.run(l) }
+	return m
+}
+
+func (m *mergeListener) run(l net.Listener) {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return }
+		select {
+		case m.conns <- c:
+		case <-m.done:
+			c.Close()
+			return
+		}
+	}
+}
+
+func (m *mergeListener) Accept() (net.Conn,error) {
+	select {
+	case c := <-m.conns: return c,nil
+	case <-m.done: return nil,net.ErrClosed
+	}
+}
+
+func (m *mergeListener) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+		for _,l := range m.ls { l.Close() }
+	})
+	return nil
+}
+
+func (m *mergeListener) Addr() net.Addr { return m.ls[0].Addr() }
@@ -0,0 +1,196 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Direct sessions between peers behind NATs by UDP hole punching. The peers
+register with a public Coordinator over SEEP sessions, which also observes
+the public UDP endpoints, their NATs map their sockets to. When a peer dials
+another one, the Coordinator introduces them to each other; both send probes
+to the endpoints of the other one at the same time, so that each NAT lets the
+probes of the other side in. The session then runs over KCP (see package
+seepkcp) on the punched sockets, the handshake included. Where punching
+fails, as with symmetric NATs, the peers meet at a relay instead (see package
+relay).
+
+	co := new(punch.Coordinator)
+	go co.ServePacket(udp) // on coordinator.example.com:7002
+	go co.Serve(seepServer)
+
+	c,err := seep.Dial("tcp","coordinator.example.com:7002",cfg,nil)
+	// ... check error
+	sock,err := net.ListenPacket("udp",":0")
+	// ... check error
+	p := &punch.Peer{Config:cfg,Relay:"relay.example.com:7001"}
+	err = p.Start(c,sock,"coordinator.example.com:7002")
+	// ... check error
+	s,err := p.Dial(peerKey) // or p.Accept()
+
+Peers are known to the Coordinator by the static keys, they authenticated
+with; cfg must have the same key for the direct sessions.
+
+The packets of the punching, which share the socket with KCP, start with the
+8 byte magic "SEEPPNCH", followed by their type and a 16 byte nonce, that the
+Coordinator handed out over the SEEP session, in the clear. The messages
+between the peers and the Coordinator are single frames: an operation byte
+and fields, each preceded by its 2 byte big-endian length.
+*/
+package punch
+
+import "encoding/binary"
+import "errors"
+
+var ErrProtocol = errors.New("punch: protocol violation")
+
+/*
+Returned by the Coordinator to peers without a static key, and by ServeConn
+for them.
+*/
+var ErrNoKey = errors.New("punch: peer has no static key")
+
+var ErrDenied = errors.New("punch: not allowed to register")
+
+/*
+Returned by Dial, when the peer is not registered with the Coordinator, and
+there is no relay.
+*/
+var ErrUnknownPeer = errors.New("punch: peer not registered")
+
+/*
+Returned by Dial, when punching failed, and there is no relay.
+*/
+var ErrUnreachable = errors.New("punch: no direct path to peer")
+
+/*
+Session operations.
+*/
+const (
+	// coordinator to peer: the nonce to observe the peer's endpoint with.
+	opObserve uint8 = iota+1
+	// coordinator to peer: the endpoint observed.
+	opEndpoint
+	// peer to coordinator: the local addresses of the peer's socket.
+	opHello
+	// peer to coordinator: dial the peer with the key of the first field.
+	opConnect
+	// coordinator to peer: the introduction to a peer, see intro.
+	opIntroduce
+	// coordinator to peer: the peer of the first field is not registered.
+	opUnknown
+)
+
+const magic = "SEEPPNCH"
+
+/*
+Packet types.
+*/
+const (
+	// peer to coordinator: observe my endpoint.
+	pktObserve uint8 = 'O'
+	// peer to peer: a probe.
+	pktProbe uint8 = 'P'
+	// peer to peer: the answer to a probe.
+	pktAck uint8 = 'A'
+)
+
+type nonce [16]byte
+
+const packetLen = len(magic)+1+len(nonce{})
+
+func packet(typ uint8, n nonce) []byte {
+	b := make([]byte,0,packetLen)
+	b = append(b,magic...)
+	b = append(b,typ)
+	return append(b,n[:]...)
+}
+
+func parsePacket(b []byte) (typ uint8, n nonce, ok bool) {
+	if len(b)!=packetLen || string(b[:len(magic)])!=magic { return 0,n,false }
+	copy(n[:],b[len(magic)+1:])
+	return b[len(magic)],n,true
+}
+
+func encode(op uint8, fields ...[]byte) []byte {
+	b := []byte{op}
+	for _,f := range fields {
+		b = binary.BigEndian.AppendUint16(b,uint16(len(f)))
+		b = append(b,f...)
+	}
+	return b
+}
+
+func decode(b []byte) (op uint8, fields [][]byte, err error) {
+	if len(b)==0 { return 0,nil,ErrProtocol }
+	op = b[0]
+	for p := b[1:]; len(p)>0; {
+		if len(p)<2 { return 0,nil,ErrProtocol }
+		n := int(binary.BigEndian.Uint16(p))
+		if len(p)<2+n { return 0,nil,ErrProtocol }
+		fields = append(fields,p[2:2+n])
+		p = p[2+n:]
+	}
+	return
+}
+
+func strings2fields(s []string) [][]byte {
+	f := make([][]byte,len(s))
+	for i := range s { f[i] = []byte(s[i]) }
+	return f
+}
+
+func fields2strings(f [][]byte) []string {
+	s := make([]string,len(f))
+	for i := range f { s[i] = string(f[i]) }
+	return s
+}
+
+/*
+Roles in an introduction.
+*/
+const (
+	roleInitiator uint8 = 'I'
+	roleResponder uint8 = 'R'
+)
+
+/*
+An introduction to a peer: the nonce of the probes, the role, the peer's key
+and its candidate endpoints, the observed one first.
+*/
+type intro struct{
+	nonce nonce
+	role uint8
+	key []byte
+	cands []string
+}
+
+func (i *intro) encode() []byte {
+	f := [][]byte{i.nonce[:],{i.role},i.key}
+	return encode(opIntroduce,append(f,strings2fields(i.cands)...)...)
+}
+
+func decodeIntro(fields [][]byte) (*intro,error) {
+	if len(fields)<3 || len(fields[0])!=len(nonce{}) || len(fields[1])!=1 { return nil,ErrProtocol }
+	i := &intro{role:fields[1][0],key:fields[2],cands:fields2strings(fields[3:])}
+	copy(i.nonce[:],fields[0])
+	return i,nil
+}
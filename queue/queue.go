@@ -0,0 +1,106 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+At-least-once delivery of messages over SEEP sessions, for instance of
+telemetry, that must not be lost, when the network fails for a while. A
+Sender holds every message, optionally in an on-disk Spool, until the
+Receiver acknowledges it, and sends the messages, that are not acknowledged
+yet, again on the next session:
+
+	spool,err := queue.OpenDirSpool("/var/spool/telemetry")
+	// ... check error
+	s,err := queue.NewSender(spool)
+	// ... check error
+	go func() {
+		for {
+			c,err := seep.Dial("tcp","collector:7000",cfg,nil)
+			if err==nil { s.Run(c) }
+			time.Sleep(time.Second)
+		}
+	}()
+	id,err := s.Send(sample)
+
+	r := &queue.Receiver{Handler:func(m *queue.Message) error { return store(m.ID,m.Data) }}
+	err = r.Serve(listener)
+
+A message, that was handled, but whose acknowledgement got lost, is delivered
+again; the IDs let Handlers tell such duplicates apart.
+
+Every message and acknowledgement is a single frame: an operation byte and the
+8 byte big-endian message ID, followed by the message data. Messages are thus
+limited to MaxSize bytes, so that they fit into a frame, whatever the Options
+of the session.
+*/
+package queue
+
+import "encoding/binary"
+import "errors"
+import "github.com/flynn/noise"
+
+var ErrProtocol = errors.New("queue: protocol violation")
+var ErrClosed = errors.New("queue: sender closed")
+
+/*
+Returned by Send, if the Sender holds Limit messages, that are not
+acknowledged yet.
+*/
+var ErrFull = errors.New("queue: too many pending messages")
+
+/*
+Returned by Send for messages larger than MaxSize.
+*/
+var ErrTooLarge = errors.New("queue: message too large")
+
+/*
+Returned by Run, if the Sender already runs on another session.
+*/
+var ErrBusy = errors.New("queue: sender already running")
+
+const (
+	opMessage uint8 = iota+1
+	opAck
+)
+
+const headerLen = 9
+
+/*
+The largest message, that Send accepts: a frame, less the header and the
+largest overhead, that seep.Options can add to it (the tags of the frame and
+of the inner layer, the frame type, the compressor id, the body length and
+the sequence number).
+*/
+const MaxSize = noise.MaxMsgLen-16-16-1-1-2-8-headerLen
+
+func encode(op uint8, id uint64, data []byte) []byte {
+	b := make([]byte,headerLen,headerLen+len(data))
+	b[0] = op
+	binary.BigEndian.PutUint64(b[1:],id)
+	return append(b,data...)
+}
+
+func decode(b []byte) (op uint8, id uint64, data []byte, err error) {
+	if len(b)<headerLen { return 0,0,nil,ErrProtocol }
+	return b[0],binary.BigEndian.Uint64(b[1:]),b[headerLen:],nil
+}
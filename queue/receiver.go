@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package queue
+
+import "net"
+import "github.com/mad-day/seep"
+
+/*
+A message received from a Sender.
+*/
+type Message struct{
+	// Unique per Sender and increasing in the order of Send.
+	ID uint64
+	Data []byte
+	// The static key of the Sender's peer, if it authenticated.
+	Peer []byte
+}
+
+/*
+Receives the messages of Senders and acknowledges them, once they are
+handled.
+*/
+type Receiver struct{
+	// Handles a message. Returning nil acknowledges it; an error ends the
+	// session, and the message is delivered again on the next one.
+	Handler func(m *Message) error
+}
+
+/*
+Serves the sessions accepted by l, a seep.Server, each in its own goroutine.
+*/
+func (r *Receiver) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go r.ServeConn(sc)
+	}
+}
+
+/*
+Receives the messages of a single session, until it ends, and closes it.
+*/
+func (r *Receiver) ServeConn(c *seep.Conn) error {
+	defer c.Close()
+	rd := c.Reader.(*seep.Reader)
+	w := c.Writer.(*seep.Writer)
+	peer := c.Session().PeerStatic
+	for {
+		p,err := rd.ReadMessage()
+		if err!=nil { return err }
+		op,id,data,err := decode(p)
+		if err!=nil { return err }
+		if op!=opMessage { return ErrProtocol }
+		err = r.Handler(&Message{ID:id,Data:data,Peer:peer})
+		if err!=nil { return err }
+		err = w.WriteMessage(encode(opAck,id,nil))
+		if err!=nil { return err }
+	}
+}
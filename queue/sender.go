@@ -0,0 +1,256 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package queue
+
+import "context"
+import "sync"
+import "time"
+import "github.com/mad-day/seep"
+
+/*
+A message held by the Sender, until it is acknowledged.
+*/
+type entry struct{
+	id uint64
+	data []byte
+	acked bool
+}
+
+/*
+The sending side of a queue. Messages are held, until the Receiver
+acknowledges them, and sent on the session, Run is called with, in the order
+of Send; the ones, that are not acknowledged, when the session ends, are sent
+again on the next one. The methods of a Sender may be called concurrently.
+*/
+type Sender struct{
+	// The most messages held, or 0 for no limit.
+	Limit int
+	// The most messages sent on a session and not acknowledged yet. 64 if
+	// 0.
+	Window int
+
+	spool Spool
+	lck sync.Mutex
+	cond *sync.Cond
+	// The messages not acknowledged yet, in the order of their IDs. The
+	// acknowledged ones are dropped from the front only.
+	queue []*entry
+	byID map[uint64]*entry
+	held int
+	next uint64
+	// The index into queue of the next message to send on the session.
+	cursor int
+	inflight int
+	running bool
+	// Set, when the session of Run fails.
+	broken bool
+	closed bool
+}
+
+/*
+Returns a Sender, that holds the messages in spool, if not nil, and sends the
+ones found in it first. The IDs of new messages follow the highest one in
+spool, or start at the current time in nanoseconds, so that they keep
+increasing across restarts.
+*/
+func NewSender(spool Spool) (*Sender,error) {
+	s := &Sender{spool:spool,byID:make(map[uint64]*entry)}
+	s.cond = sync.NewCond(&s.lck)
+	s.next = uint64(time.Now().UnixNano())
+	if spool==nil { return s,nil }
+	err := spool.Walk(func(id uint64, data []byte) error {
+		s.add(id,data)
+		if id>=s.next { s.next = id+1 }
+		return nil
+	})
+	if err!=nil { return nil,err }
+	return s,nil
+}
+
+func (s *Sender) add(id uint64, data []byte) {
+	e := &entry{id:id,data:data}
+	s.queue = append(s.queue,e)
+	s.byID[id] = e
+	s.held++
+}
+
+/*
+Queues data for sending and returns its ID. With a Spool, data is stored in
+it, before Send returns. Data larger than MaxSize is rejected with
+ErrTooLarge, as it could never be sent.
+*/
+func (s *Sender) Send(data []byte) (uint64,error) {
+	if len(data)>MaxSize { return 0,ErrTooLarge }
+	s.lck.Lock(); defer s.lck.Unlock()
+	if s.closed { return 0,ErrClosed }
+	if s.Limit>0 && s.held>=s.Limit { return 0,ErrFull }
+	id := s.next
+	data = append([]byte(nil),data...)
+	if s.spool!=nil {
+		err := s.spool.Put(id,data)
+		if err!=nil { return 0,err }
+	}
+	s.next++
+	s.add(id,data)
+	s.cond.Broadcast()
+	return id,nil
+}
+
+/*
+Returns the number of messages, that are not acknowledged yet.
+*/
+func (s *Sender) Pending() int {
+	s.lck.Lock(); defer s.lck.Unlock()
+	return s.held
+}
+
+/*
+Waits, until all messages sent so far are acknowledged, or ctx is done.
+*/
+func (s *Sender) Flush(ctx context.Context) error {
+	stop := context.AfterFunc(ctx,func() {
+		s.lck.Lock(); defer s.lck.Unlock()
+		s.cond.Broadcast()
+	})
+	defer stop()
+	s.lck.Lock(); defer s.lck.Unlock()
+	for s.held>0 {
+		if err := ctx.Err(); err!=nil { return err }
+		s.cond.Wait()
+	}
+	return nil
+}
+
+/*
+Sends the messages on c, the ones, that are not acknowledged yet, first,
+until c fails or the Sender is closed, and closes c. Returns the error, that
+ended the session.
+*/
+func (s *Sender) Run(c *seep.Conn) error {
+	s.lck.Lock()
+	if s.closed { s.lck.Unlock(); c.Close(); return ErrClosed }
+	if s.running { s.lck.Unlock(); c.Close(); return ErrBusy }
+	s.running = true
+	s.broken = false
+	s.cursor = 0
+	s.inflight = 0
+	s.lck.Unlock()
+
+	// The side, that stops first, wakes up the other one with the
+	// deadline, and its error ends the session.
+	var first error
+	var once sync.Once
+	stop := func(err error) {
+		once.Do(func() {
+			first = err
+			c.SetDeadline(time.Now())
+			s.lck.Lock()
+			s.broken = true
+			s.cond.Broadcast()
+			s.lck.Unlock()
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		stop(s.write(c.Writer.(*seep.Writer)))
+		close(done)
+	}()
+	stop(s.read(c.Reader.(*seep.Reader)))
+	<-done
+	c.Close()
+
+	s.lck.Lock(); defer s.lck.Unlock()
+	s.running = false
+	if s.closed { return ErrClosed }
+	return first
+}
+
+func (s *Sender) window() int {
+	if s.Window>0 { return s.Window }
+	return 64
+}
+
+func (s *Sender) write(w *seep.Writer) error {
+	s.lck.Lock(); defer s.lck.Unlock()
+	for {
+		for !s.broken && !s.closed && (s.cursor>=len(s.queue) || s.inflight>=s.window()) {
+			s.cond.Wait()
+		}
+		if s.closed { return ErrClosed }
+		if s.broken { return nil }
+		e := s.queue[s.cursor]
+		s.cursor++
+		if e.acked { continue }
+		s.inflight++
+		s.lck.Unlock()
+		err := w.WriteMessage(encode(opMessage,e.id,e.data))
+		s.lck.Lock()
+		if err!=nil { return err }
+	}
+}
+
+func (s *Sender) read(r *seep.Reader) error {
+	for {
+		p,err := r.ReadMessage()
+		if err!=nil { return err }
+		op,id,_,err := decode(p)
+		if err!=nil { return err }
+		if op!=opAck { return ErrProtocol }
+		s.ack(id)
+	}
+}
+
+func (s *Sender) ack(id uint64) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	e,ok := s.byID[id]
+	if !ok { return }
+	if s.spool!=nil {
+		// A message, that stays in the spool, is sent again after a
+		// restart, which at-least-once delivery allows for.
+		s.spool.Delete(id)
+	}
+	e.acked = true
+	e.data = nil
+	delete(s.byID,id)
+	s.held--
+	if s.inflight>0 { s.inflight-- }
+	for len(s.queue)>0 && s.queue[0].acked {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		if s.cursor>0 { s.cursor-- }
+	}
+	s.cond.Broadcast()
+}
+
+/*
+Stops Run and makes Send fail with ErrClosed. The messages, that are not
+acknowledged, stay in the Spool.
+*/
+func (s *Sender) Close() error {
+	s.lck.Lock(); defer s.lck.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package queue
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+/*
+Stores the messages of a Sender, until they are acknowledged, so that they
+survive a restart.
+*/
+type Spool interface{
+	// Stores a message, before it is sent.
+	Put(id uint64, data []byte) error
+	// Deletes an acknowledged message.
+	Delete(id uint64) error
+	// Calls fn with the stored messages in the order of their IDs.
+	Walk(fn func(id uint64, data []byte) error) error
+}
+
+/*
+A Spool, that stores each message in a file of its own in a directory. A
+message is written to a temporary file first and renamed, so that a crash
+leaves no partial messages.
+*/
+type DirSpool struct{
+	// Syncs each message to disk, before Put returns, so that it survives
+	// power loss, not just a crash of the process.
+	Sync bool
+
+	dir string
+}
+
+const spoolExt = ".msg"
+
+/*
+Opens the spool in dir, creating dir, if it does not exist.
+*/
+func OpenDirSpool(dir string) (*DirSpool,error) {
+	err := os.MkdirAll(dir,0700)
+	if err!=nil { return nil,err }
+	return &DirSpool{dir:dir},nil
+}
+
+func (d *DirSpool) path(id uint64) string {
+	// Fixed width, so that the names sort like the IDs.
+	return filepath.Join(d.dir,fmt.Sprintf("%016x",id)+spoolExt)
+}
+
+func (d *DirSpool) Put(id uint64, data []byte) error {
+	p := d.path(id)
+	f,err := os.CreateTemp(d.dir,".tmp-")
+	if err!=nil { return err }
+	tmp := f.Name()
+	_,err = f.Write(data)
+	if err==nil && d.Sync { err = f.Sync() }
+	if cerr := f.Close(); err==nil { err = cerr }
+	if err==nil { err = os.Rename(tmp,p) }
+	if err!=nil { os.Remove(tmp) }
+	return err
+}
+
+func (d *DirSpool) Delete(id uint64) error {
+	err := os.Remove(d.path(id))
+	if os.IsNotExist(err) { return nil }
+	return err
+}
+
+/*
+Calls fn with the stored messages and removes the temporary files, that a
+crash left behind.
+*/
+func (d *DirSpool) Walk(fn func(id uint64, data []byte) error) error {
+	ents,err := os.ReadDir(d.dir)
+	if err!=nil { return err }
+	for _,e := range ents {
+		name := e.Name()
+		if strings.HasPrefix(name,".tmp-") {
+			os.Remove(filepath.Join(d.dir,name))
+			continue
+		}
+		if !strings.HasSuffix(name,spoolExt) { continue }
+		id,err := strconv.ParseUint(strings.TrimSuffix(name,spoolExt),16,64)
+		if err!=nil { continue }
+		data,err := os.ReadFile(filepath.Join(d.dir,name))
+		if err!=nil { return err }
+		err = fn(id,data)
+		if err!=nil { return err }
+	}
+	return nil
+}
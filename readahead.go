@@ -0,0 +1,82 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+/*
+A frame read and decrypted ahead of time by the prefetch goroutine. buf lies in
+(or, if decompressed, was decrypted via) the pooled buffer b.
+*/
+type prefetched struct{
+	buf []byte
+	b *[]byte
+	err error
+}
+
+/*
+The longest run of frames read without prefetching, after prefetches kept
+missing, see Options.ReadAhead.
+*/
+const maxAheadBackoff = 64
+
+/*
+Starts reading and decrypting the next frame in the background. At most one
+frame is in flight; every other use of the frameReader waits for it first, so
+that frames are still processed strictly in order and under the right key.
+*/
+func (f *frameReader) prefetch() {
+	if f.skip>0 {
+		f.skip--
+		return
+	}
+	ch := make(chan prefetched,1)
+	f.ahead = ch
+	go func() {
+		b := readPool.Get().(*[]byte)
+		buf,err := f.readFrameNow(func(int) []byte { return (*b)[:0] })
+		ch <- prefetched{buf,b,err}
+	}()
+}
+
+/*
+Waits for the frame in flight. If it wasn't ready yet, the link rather than
+the decryption is the bottleneck, and prefetching backs off exponentially.
+*/
+func (f *frameReader) wait() (p prefetched) {
+	select {
+	case p = <-f.ahead:
+		f.backoff = 0
+	default:
+		p = <-f.ahead
+		f.backoff = 2*f.backoff+1
+		if f.backoff>maxAheadBackoff { f.backoff = maxAheadBackoff }
+		f.skip = f.backoff
+	}
+	f.ahead = nil
+	if p.err!=nil {
+		readPool.Put(p.b)
+		p.b = nil
+	}
+	return
+}
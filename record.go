@@ -0,0 +1,195 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/rand"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "sync"
+import "github.com/flynn/noise"
+
+var ErrReplayMismatch = errors.New("seep: output differs from the recorded session")
+
+type TranscriptFrame struct{
+	Outgoing bool     `json:"outgoing"`
+	Data     HexBytes `json:"data"`
+}
+
+/*
+A Transcript is a recorded session of one side of a connection: the secrets
+needed to repeat its handshake and every frame (including the handshake
+messages) in the order, they were sent or received. Transcripts contain
+private keys and must only be recorded in tests or with throwaway keys.
+*/
+type Transcript struct{
+	Protocol     string            `json:"protocol"`
+	Initiator    bool              `json:"initiator"`
+	Prologue     HexBytes          `json:"prologue"`
+	Static       HexBytes          `json:"static,omitempty"`
+	RemoteStatic HexBytes          `json:"remote_static,omitempty"`
+	PSK          HexBytes          `json:"psk,omitempty"`
+	// The random bytes consumed by the handshake (the ephemeral key).
+	Random       HexBytes          `json:"random"`
+	Frames       []TranscriptFrame `json:"frames"`
+}
+
+/*
+Loads a transcript saved with (*Transcript).Save.
+*/
+func LoadTranscript(r io.Reader) (*Transcript,error) {
+	t := new(Transcript)
+	err := json.NewDecoder(r).Decode(t)
+	return t,err
+}
+func (t *Transcript) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(t)
+}
+
+/*
+A Recorder is a Framer, that records all frames passing through it.
+*/
+type Recorder struct{
+	f Framer
+	lck sync.Mutex
+	t Transcript
+}
+
+/*
+Wraps f into a Recorder. The returned Config must be used for the handshake:
+it records the random bytes consumed by the handshake.
+
+	rec,cfg := seep.NewRecorder(seep.NewXDRFramer(conn,conn),cfg)
+	err := c.HandshakeFramer(rec,cfg)
+	// ...
+	rec.Transcript().Save(file)
+*/
+func NewRecorder(f Framer, nc noise.Config) (*Recorder,noise.Config) {
+	r := &Recorder{f:f}
+	r.t = Transcript{
+		Protocol:protocolName(nc),
+		Initiator:nc.Initiator,
+		Prologue:nc.Prologue,
+		Static:nc.StaticKeypair.Private,
+		RemoteStatic:nc.PeerStatic,
+		PSK:nc.PresharedKey,
+	}
+	rnd := nc.Random
+	if rnd==nil { rnd = rand.Reader }
+	nc.Random = io.TeeReader(rnd,recordRandom{r})
+	return r,nc
+}
+
+type recordRandom struct{ r *Recorder }
+func (r recordRandom) Write(p []byte) (int,error) {
+	r.r.lck.Lock(); defer r.r.lck.Unlock()
+	r.r.t.Random = append(r.r.t.Random,p...)
+	return len(p),nil
+}
+
+func (r *Recorder) record(out bool, p []byte) {
+	r.lck.Lock(); defer r.lck.Unlock()
+	r.t.Frames = append(r.t.Frames,TranscriptFrame{out,append([]byte(nil),p...)})
+}
+func (r *Recorder) ReadFrame(max int) ([]byte,error) {
+	p,err := r.f.ReadFrame(max)
+	if err==nil { r.record(false,p) }
+	return p,err
+}
+func (r *Recorder) WriteFrame(p []byte) error {
+	r.record(true,p)
+	return r.f.WriteFrame(p)
+}
+
+/*
+Returns a copy of the transcript recorded so far.
+*/
+func (r *Recorder) Transcript() *Transcript {
+	r.lck.Lock(); defer r.lck.Unlock()
+	t := r.t
+	t.Frames = append([]TranscriptFrame(nil),t.Frames...)
+	return &t
+}
+
+/*
+Returns a Framer and a Config, that replay the recorded session: the Framer
+plays the peer, returning the recorded incoming frames from ReadFrame (and
+io.EOF after the last one), and checking, that every frame written is
+identical to the recorded one. Using them in place of the original ones, the
+handshake and every frame are repeated exactly, as long as the same Options
+are used and the padding policy is deterministic.
+*/
+func (t *Transcript) Replay() (Framer,noise.Config,error) {
+	p,err := ParseProtocolName(t.Protocol)
+	if err!=nil { return nil,noise.Config{},err }
+	nc := noise.Config{
+		CipherSuite:p.CipherSuite,
+		Pattern:p.Pattern,
+		Initiator:t.Initiator,
+		Prologue:t.Prologue,
+		PeerStatic:t.RemoteStatic,
+		Random:bytes.NewReader(t.Random),
+	}
+	if len(t.Static)>0 {
+		nc.StaticKeypair,err = p.CipherSuite.GenerateKeypair(bytes.NewReader(t.Static))
+		if err!=nil { return nil,nc,err }
+	}
+	if p.PSKPlacement>=0 {
+		nc.PresharedKey = t.PSK
+		nc.PresharedKeyPlacement = p.PSKPlacement
+	}
+	return &replayFramer{t:t},nc,nil
+}
+
+type replayFramer struct{
+	lck sync.Mutex
+	t *Transcript
+	i int
+}
+func (r *replayFramer) next(out bool) ([]byte,error) {
+	r.lck.Lock(); defer r.lck.Unlock()
+	if r.i>=len(r.t.Frames) {
+		if out { return nil,fmt.Errorf("seep: frame %d: %w",r.i,ErrReplayMismatch) }
+		return nil,io.EOF
+	}
+	f := r.t.Frames[r.i]
+	if f.Outgoing!=out { return nil,fmt.Errorf("seep: frame %d: %w",r.i,ErrReplayMismatch) }
+	r.i++
+	return f.Data,nil
+}
+func (r *replayFramer) ReadFrame(max int) ([]byte,error) {
+	p,err := r.next(false)
+	if err!=nil { return nil,err }
+	if max>0 && len(p)>max { return nil,ErrFrameTooLarge }
+	return append([]byte(nil),p...),nil
+}
+func (r *replayFramer) WriteFrame(p []byte) error {
+	q,err := r.next(true)
+	if err!=nil { return err }
+	if !bytes.Equal(p,q) { return fmt.Errorf("seep: frame %d: %w",r.i-1,ErrReplayMismatch) }
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package relay
+
+import "encoding/hex"
+import "io"
+import "net"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+/*
+Sends the preamble and waits, until the relay paired conn with its peer. If key
+is not nil, the endpoint listens with its token and proves, that it holds
+it.
+*/
+func rendezvous(conn net.Conn, role uint8, t Token, key *noise.DHKey) error {
+	if key!=nil {
+		role = roleProve
+		copy(t[:],key.Public)
+	}
+	b := make([]byte,0,preambleLen)
+	b = append(b,magic...)
+	b = append(b,role)
+	b = append(b,t[:]...)
+	_,err := conn.Write(b)
+	if err!=nil { return err }
+	if key!=nil {
+		err = prove(conn,key)
+		if err!=nil { return err }
+	}
+	var st [1]byte
+	_,err = io.ReadFull(conn,st[:])
+	if err==io.EOF { return ErrProtocol }
+	if err!=nil { return err }
+	return statusErr(st[0])
+}
+
+/*
+Answers the challenge of the relay.
+*/
+func prove(conn net.Conn, key *noise.DHKey) error {
+	var e [32]byte
+	_,err := io.ReadFull(conn,e[:])
+	// A relay, that doesn't know the role, closes the connection.
+	if err==io.EOF { return ErrProtocol }
+	if err!=nil { return err }
+	shared,err := noise.DH25519.DH(key.Private,e[:])
+	if err!=nil { return ErrProtocol }
+	_,err = conn.Write(proof(shared,e[:],key.Public))
+	return err
+}
+
+/*
+Connects to the relay and waits, until it paired the connection with a
+listener of the token. The handshake runs over the returned connection.
+*/
+func Dial(network, addr string, t Token) (net.Conn,error) {
+	conn,err := net.Dial(network,addr)
+	if err!=nil { return nil,err }
+	err = rendezvous(conn,roleDial,t,nil)
+	if err!=nil {
+		conn.Close()
+		return nil,err
+	}
+	return conn,nil
+}
+
+/*
+Connects through the relay to the listener with the static public key peer
+and performs the handshake as initiator. Pin peer in o (see seep.PinnedPeer),
+as anybody can listen with any token, unless the relay requires proof.
+*/
+func DialPeer(network, addr string, peer []byte, nc noise.Config, o *seep.Options) (*seep.Conn,error) {
+	conn,err := Dial(network,addr,KeyToken(peer))
+	if err!=nil { return nil,err }
+	nc.Initiator = true
+	c,err := seep.NewConn(conn,nc,o)
+	if err!=nil { conn.Close() }
+	return c,err
+}
+
+/*
+Listens for peers through a relay, by registering with it, one connection at a
+time. Accept returns the connections paired with a peer; start a seep.Server
+with the Listener to perform the handshakes.
+*/
+type Listener struct{
+	network,addr string
+	token Token
+	// If not nil, the key, the Listener proves to hold.
+	key *noise.DHKey
+
+	lck sync.Mutex
+	// The registration in progress.
+	conn net.Conn
+	closed bool
+	done chan struct{}
+}
+
+/*
+Returns a Listener, that registers with the relay at addr with token t.
+*/
+func NewListener(network, addr string, t Token) *Listener {
+	return &Listener{network:network,addr:addr,token:t,done:make(chan struct{})}
+}
+
+/*
+Returns a Listener, that registers with the relay at addr with the token of
+the Curve25519 key key, proving to the relay, that it holds the private key,
+so that others can't take its place (see Server.RequireProof).
+*/
+func NewKeyListener(network, addr string, key noise.DHKey) *Listener {
+	return &Listener{network:network,addr:addr,token:KeyToken(key.Public),key:&key,done:make(chan struct{})}
+}
+
+/*
+Returns the next connection, that the relay paired with a peer. While the
+relay can't be reached, or is busy, Accept tries again after a pause, that
+grows up to a minute.
+*/
+func (l *Listener) Accept() (net.Conn,error) {
+	var pause time.Duration
+	for {
+		conn,err := l.register()
+		if err==nil { return conn,nil }
+		select {
+		case <-l.done: return nil,net.ErrClosed
+		default:
+		}
+		switch {
+		case err==ErrTimeout:
+			pause = 0
+			continue
+		case err==ErrProtocol || err==ErrDenied:
+			return nil,err
+		case pause==0:
+			pause = time.Second
+		case pause<time.Minute:
+			pause *= 2
+		}
+		select {
+		case <-time.After(pause):
+		case <-l.done: return nil,net.ErrClosed
+		}
+	}
+}
+
+func (l *Listener) register() (net.Conn,error) {
+	conn,err := net.Dial(l.network,l.addr)
+	if err!=nil { return nil,err }
+	l.lck.Lock()
+	if l.closed {
+		l.lck.Unlock()
+		conn.Close()
+		return nil,net.ErrClosed
+	}
+	l.conn = conn
+	l.lck.Unlock()
+	err = rendezvous(conn,roleListen,l.token,l.key)
+	l.lck.Lock()
+	l.conn = nil
+	l.lck.Unlock()
+	if err!=nil {
+		conn.Close()
+		return nil,err
+	}
+	return conn,nil
+}
+
+/*
+Stops registering and makes Accept return net.ErrClosed. Connections already
+accepted are not affected.
+*/
+func (l *Listener) Close() error {
+	l.lck.Lock(); defer l.lck.Unlock()
+	if l.closed { return nil }
+	l.closed = true
+	close(l.done)
+	if l.conn!=nil { l.conn.Close() }
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return &Addr{Relay:l.addr,Token:l.token} }
+
+/*
+The address of a Listener: the relay and the token.
+*/
+type Addr struct{
+	Relay string
+	Token Token
+}
+func (a *Addr) Network() string { return "relay" }
+func (a *Addr) String() string { return a.Relay+"/"+hex.EncodeToString(a.Token[:]) }
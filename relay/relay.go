@@ -0,0 +1,177 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Rendezvous through a public relay, that forwards the ciphertext of SEEP
+sessions between two endpoints, which can't reach each other directly, for
+instance as both are behind NATs. The endpoints run the handshake with each
+other through the relay, so the relay never sees the plaintext, nor has it
+the keys to modify it undetected.
+
+	go (&relay.Server{}).Serve(l)
+
+	// The listening endpoint registers with the token of its static key,
+	// proving, that it holds it.
+	s := &seep.Server{Config:cfg}
+	s.Start(relay.NewKeyListener("tcp","relay.example.com:7001",cfg.StaticKeypair))
+
+	// The dialing endpoint needs the listener's key anyway.
+	c,err := relay.DialPeer("tcp","relay.example.com:7001",peerKey,cfg,&seep.Options{VerifyPeer:seep.PinnedPeer(peerKey)})
+
+An endpoint connects to the relay and sends a preamble: the 8 byte magic
+"SEEPRLY1", its role (listen or dial) and the 32 byte token of the
+rendezvous, in the clear. The relay pairs it with an endpoint of the other
+role and the same token, answers both with a status byte and copies the
+bytes between them from then on.
+
+Anybody, who knows a listener's public key, can register with its token,
+intercept its dialers (which then fail the handshake, as they pin the key)
+and fill its places in the queue, so that the listener is turned away as
+busy. A listener created by NewKeyListener therefore proves, that it holds
+the private key: it sends its role as "prove" and its public key in place of
+the token, the relay answers with a fresh ephemeral public key, and the
+listener with the HMAC-SHA256, keyed with the DH of the two keys, of the
+string "seep relay proof", the ephemeral and the static public key. A
+Server with RequireProof turns away listeners, that don't. Dialers can't
+prove anything, as they don't own the key, and thus can still fill the
+dialers' places of a token.
+
+The tokens are visible to observers of the connections to the relay and to
+the relay itself, which learns thereby, who talks to whom, though not what
+they say.
+*/
+package relay
+
+import "crypto/hmac"
+import "crypto/sha256"
+import "errors"
+import "io"
+import "net"
+
+var ErrProtocol = errors.New("relay: protocol violation")
+
+/*
+Returned by the endpoints, when no peer arrived at the relay in time.
+*/
+var ErrTimeout = errors.New("relay: no peer arrived")
+
+/*
+Returned by the endpoints, when the relay turned them away, as too many
+endpoints wait for the token, or in total.
+*/
+var ErrBusy = errors.New("relay: too many waiting")
+
+/*
+Returned by the listeners, that didn't prove their key to a relay, that
+requires it, or failed to.
+*/
+var ErrDenied = errors.New("relay: proof of the key required")
+
+/*
+The token of a rendezvous.
+*/
+type Token [32]byte
+
+/*
+Returns the token, an endpoint listens with, that has the static public key
+pub.
+*/
+func KeyToken(pub []byte) Token {
+	h := sha256.New()
+	h.Write([]byte("seep relay token\x00"))
+	h.Write(pub)
+	var t Token
+	h.Sum(t[:0])
+	return t
+}
+
+const magic = "SEEPRLY1"
+
+const (
+	roleListen uint8 = 'L'
+	roleDial uint8 = 'D'
+	// Listen with the token of the key in the preamble, proving it.
+	roleProve uint8 = 'K'
+)
+
+const preambleLen = len(magic)+1+len(Token{})
+
+/*
+Status bytes.
+*/
+const (
+	statusOK uint8 = iota
+	statusTimeout
+	statusBusy
+	statusDenied
+)
+
+func statusErr(b uint8) error {
+	switch b {
+	case statusOK: return nil
+	case statusTimeout: return ErrTimeout
+	case statusBusy: return ErrBusy
+	case statusDenied: return ErrDenied
+	}
+	return ErrProtocol
+}
+
+/*
+Returns the proof, that a listener with the static key pub sends to a relay,
+that challenged it with the ephemeral key e. shared is the DH of the two.
+*/
+func proof(shared, e, pub []byte) []byte {
+	m := hmac.New(sha256.New,shared)
+	m.Write([]byte("seep relay proof"))
+	m.Write(e)
+	m.Write(pub)
+	return m.Sum(nil)
+}
+
+func closeWrite(c net.Conn) {
+	if cw,ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+/*
+Copies between a and b in both directions, passing half-closes on, until both
+are done, and closes them.
+*/
+func join(a, b net.Conn) {
+	done := make(chan struct{},2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst,src)
+		closeWrite(dst)
+		done <- struct{}{}
+	}
+	go cp(a,b)
+	go cp(b,a)
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
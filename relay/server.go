@@ -0,0 +1,228 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package relay
+
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "io"
+import "net"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+
+/*
+A relay. The zero value is ready to use.
+*/
+type Server struct{
+	// How long an endpoint waits for its peer. Listeners register again,
+	// when it ends. 0 means a minute.
+	Wait time.Duration
+	// The most endpoints waiting for a token in the same role. 0 means 16.
+	MaxWaiting int
+	// The most endpoints waiting in total. 0 means 1024.
+	MaxTotal int
+	// If true, listeners must prove, that they hold the key of their
+	// token (see NewKeyListener); the others are turned away.
+	RequireProof bool
+
+	lck sync.Mutex
+	waiting map[waitKey][]*waiter
+	total int
+}
+
+type waitKey struct{
+	token Token
+	role uint8
+}
+
+/*
+An endpoint waiting for its peer.
+*/
+type waiter struct{
+	conn net.Conn
+	// Closed, once the waiter was taken from the queue by its peer, that
+	// relays the session from then on.
+	paired chan struct{}
+}
+
+/*
+The time limit of the preamble.
+*/
+const preambleTimeout = 10*time.Second
+
+/*
+Accepts connections from l and serves each of them, until l fails.
+*/
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		go s.ServeConn(c)
+	}
+}
+
+/*
+Reads the preamble of an endpoint and pairs it with its peer, as soon as that
+arrives. One of the two endpoints relays the session, until it ends; it
+returns nil afterwards, the other one, as soon as it is paired.
+*/
+func (s *Server) ServeConn(conn net.Conn) error {
+	var b [preambleLen]byte
+	conn.SetReadDeadline(time.Now().Add(preambleTimeout))
+	_,err := io.ReadFull(conn,b[:])
+	if err!=nil {
+		conn.Close()
+		return err
+	}
+	role := b[len(magic)]
+	if string(b[:len(magic)])!=magic || (role!=roleListen && role!=roleDial && role!=roleProve) {
+		conn.Close()
+		return ErrProtocol
+	}
+	var t Token
+	copy(t[:],b[len(magic)+1:])
+	switch {
+	case role==roleProve:
+		err = challenge(conn,t[:])
+		if err!=nil {
+			if err==ErrDenied { conn.Write([]byte{statusDenied}) }
+			conn.Close()
+			return err
+		}
+		role,t = roleListen,KeyToken(t[:])
+	case role==roleListen && s.RequireProof:
+		conn.Write([]byte{statusDenied})
+		conn.Close()
+		return ErrDenied
+	}
+	conn.SetReadDeadline(time.Time{})
+	other := roleListen
+	if role==roleListen { other = roleDial }
+
+	if w := s.take(waitKey{t,other}); w!=nil {
+		close(w.paired)
+		ok := []byte{statusOK}
+		_,err = conn.Write(ok)
+		if err==nil { _,err = w.conn.Write(ok) }
+		if err!=nil {
+			conn.Close()
+			w.conn.Close()
+			return err
+		}
+		join(conn,w.conn)
+		return nil
+	}
+
+	k := waitKey{t,role}
+	w := &waiter{conn:conn,paired:make(chan struct{})}
+	if !s.put(k,w) {
+		conn.Write([]byte{statusBusy})
+		conn.Close()
+		return ErrBusy
+	}
+	d := s.Wait
+	if d==0 { d = time.Minute }
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-w.paired: return nil
+	case <-timer.C:
+	}
+	if !s.remove(k,w) {
+		// Taken meanwhile.
+		<-w.paired
+		return nil
+	}
+	conn.Write([]byte{statusTimeout})
+	conn.Close()
+	return ErrTimeout
+}
+
+/*
+Challenges a listener to prove, that it holds the private key of pub.
+*/
+func challenge(conn net.Conn, pub []byte) error {
+	e,err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err!=nil { return err }
+	_,err = conn.Write(e.Public)
+	if err!=nil { return err }
+	var p [sha256.Size]byte
+	_,err = io.ReadFull(conn,p[:])
+	if err!=nil { return err }
+	shared,err := noise.DH25519.DH(e.Private,pub)
+	if err!=nil { return ErrDenied }
+	if !hmac.Equal(p[:],proof(shared,e.Public,pub)) { return ErrDenied }
+	return nil
+}
+
+/*
+Takes the endpoint, that waits longest, from the queue of k.
+*/
+func (s *Server) take(k waitKey) *waiter {
+	s.lck.Lock(); defer s.lck.Unlock()
+	q := s.waiting[k]
+	if len(q)==0 { return nil }
+	w := q[0]
+	s.total--
+	if len(q)==1 {
+		delete(s.waiting,k)
+	} else {
+		s.waiting[k] = q[1:]
+	}
+	return w
+}
+
+func (s *Server) put(k waitKey, w *waiter) bool {
+	s.lck.Lock(); defer s.lck.Unlock()
+	n,total := s.MaxWaiting,s.MaxTotal
+	if n==0 { n = 16 }
+	if total==0 { total = 1024 }
+	if len(s.waiting[k])>=n || s.total>=total { return false }
+	if s.waiting==nil { s.waiting = make(map[waitKey][]*waiter) }
+	s.waiting[k] = append(s.waiting[k],w)
+	s.total++
+	return true
+}
+
+/*
+Removes w from the queue of k. Returns false, if it is not in it.
+*/
+func (s *Server) remove(k waitKey, w *waiter) bool {
+	s.lck.Lock(); defer s.lck.Unlock()
+	q := s.waiting[k]
+	for i,x := range q {
+		if x!=w { continue }
+		s.total--
+		q = append(q[:i:i],q[i+1:]...)
+		if len(q)==0 {
+			delete(s.waiting,k)
+		} else {
+			s.waiting[k] = q
+		}
+		return true
+	}
+	return false
+}
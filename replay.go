@@ -0,0 +1,108 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/sha256"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+
+var ErrReplay = newError(ErrHandshakeFailed,"seep: replayed handshake")
+
+/*
+A ReplayCache remembers the initiations, a responder has accepted, so that a
+recorded first handshake message, and the early data it carries, can't be
+accepted twice within Window. Initiations are identified by the initiator's
+ephemeral public key, which is unique to every handshake. The zero value is
+ready to use, with the defaults given below. A ReplayCache may be used
+concurrently, and shared by all listeners of a server. See
+Options.ReplayCache.
+*/
+type ReplayCache struct{
+	// How long an initiation is remembered. Defaults to 10 minutes.
+	Window time.Duration
+	// The maximum number of initiations remembered. Once as many are
+	// remembered within Window, the cache fails closed: every new
+	// initiation is refused as a replay, until the oldest ones expire.
+	// Forgetting them early instead would let a flood of handshakes clear
+	// the way for a replay. Defaults to 65536.
+	MaxEntries int
+
+	lck sync.Mutex
+	seen map[[32]byte]time.Time
+	// The keys in seen, oldest first, from queue[head] on.
+	queue []replayEntry
+	head int
+}
+
+type replayEntry struct{
+	key [32]byte
+	t time.Time
+}
+
+func (c *ReplayCache) limits() (window time.Duration, max int) {
+	window,max = c.Window,c.MaxEntries
+	if window<=0 { window = 10*time.Minute }
+	if max<=0 { max = 65536 }
+	return
+}
+
+/*
+Reports, whether id has been seen within the window, and records it. If the
+cache is full, every id, that is not recorded, is reported as seen.
+*/
+func (c *ReplayCache) Seen(id []byte) bool {
+	window,max := c.limits()
+	key := sha256.Sum256(id)
+	now := time.Now()
+	c.lck.Lock(); defer c.lck.Unlock()
+	if c.seen==nil { c.seen = make(map[[32]byte]time.Time) }
+	for c.head<len(c.queue) && now.Sub(c.queue[c.head].t)>window {
+		delete(c.seen,c.queue[c.head].key)
+		c.head++
+	}
+	// Once half of the queue has expired, the rest is moved to a new
+	// array, so that the old one is released.
+	if c.head>0 && c.head>=len(c.queue)/2 {
+		c.queue = append([]replayEntry(nil),c.queue[c.head:]...)
+		c.head = 0
+	}
+	if _,ok := c.seen[key]; ok { return true }
+	if len(c.queue)-c.head>=max { return true }
+	c.seen[key] = now
+	c.queue = append(c.queue,replayEntry{key,now})
+	return false
+}
+
+/*
+Checks the first handshake message, that a responder has read, against c.
+*/
+func (c *ReplayCache) check(hs *noise.HandshakeState) error {
+	if c==nil { return nil }
+	e := hs.PeerEphemeral()
+	if len(e)==0 { return nil }
+	if c.Seen(e) { return ErrReplay }
+	return nil
+}
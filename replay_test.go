@@ -0,0 +1,55 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "fmt"
+import "testing"
+import "time"
+
+func TestReplayCache(t *testing.T) {
+	c := &ReplayCache{Window:50*time.Millisecond,MaxEntries:4}
+	for i:=0; i<4; i++ {
+		if c.Seen([]byte{byte(i)}) { t.Fatalf("fresh id %d reported as seen",i) }
+	}
+	if !c.Seen([]byte{0}) { t.Fatal("replay not detected") }
+	// Full: new ids are refused, and don't push out the ones recorded.
+	for i:=4; i<100; i++ {
+		if !c.Seen([]byte{byte(i)}) { t.Fatalf("id %d accepted by a full cache",i) }
+	}
+	if !c.Seen([]byte{1}) { t.Fatal("replay not detected after a flood") }
+	time.Sleep(60*time.Millisecond)
+	if c.Seen([]byte{0}) { t.Fatal("id not forgotten after the window") }
+	if c.Seen([]byte{200}) { t.Fatal("new id refused after the window") }
+}
+
+func TestReplayCacheCompact(t *testing.T) {
+	c := &ReplayCache{Window:time.Millisecond,MaxEntries:1<<20}
+	for i:=0; i<5000; i++ {
+		if c.Seen([]byte(fmt.Sprint(i))) { t.Fatalf("fresh id %d reported as seen",i) }
+		if i%500==0 { time.Sleep(2*time.Millisecond) }
+	}
+	if n := len(c.queue); n>1000 { t.Fatalf("queue holds %d entries",n) }
+	if len(c.queue)-c.head!=len(c.seen) { t.Fatal("queue and map out of sync") }
+}
@@ -25,11 +25,13 @@ SOFTWARE.
 package seep
 
 import "io"
+import "io/ioutil"
 import "sync"
 import "bytes"
 import "github.com/flynn/noise"
 import "github.com/davecgh/go-xdr/xdr2"
 import "net/rpc"
+import "net"
 import "encoding/gob"
 
 type rpcCloser struct {}
@@ -40,236 +42,530 @@ var rpcCloserInst = &rpcCloser{}
 type rpcClientCodec struct{
 	io.Closer
 	wm sync.Mutex
-	src *xdr.Decoder
-	dst *xdr.Encoder
-	enc,dec *noise.CipherState
-	encode func(*rpc.Request, interface{}) ([]byte,error)
-	decode func([]byte,*rpc.Response) (error,func(i interface{}) error)
+	frameWriter
+	frameReader
+	format *RpcFormat
 	decode2 func(i interface{}) error
+	wstate writeState
+	// Set, once a response body ended with an error of the stream.
+	broken bool
 }
 func (r *rpcClientCodec) WriteRequest(req *rpc.Request, i interface{}) error {
+	return r.writeRequest(req,nil,i)
+}
+
+/*
+Like WriteRequest, but the header carries the metadata and extensions of x, if
+not nil, see WithHeader.
+*/
+func (r *rpcClientCodec) writeRequest(req *rpc.Request, x *Header, i interface{}) error {
+	var h Header
+	h.fromRequest(req)
+	if x!=nil { h.Meta,h.Ext = x.Meta,x.Ext }
+	if r.frameWriter.opts.SendQueue>0 && !r.frameWriter.opts.ChunkedRPC && !r.format.Stateful {
+		// Encode outside of the lock.
+		buf,err := r.format.encode(new([]byte),&h,i)
+		if err!=nil { return err }
+		r.wm.Lock(); defer r.wm.Unlock()
+		return r.writeFrame(buf,r.frameWriter.opts.Compressor)
+	}
 	r.wm.Lock(); defer r.wm.Unlock()
-	buf,err := r.encode(req,i)
-	if err!=nil { return err }
-	buf = r.enc.Encrypt(nil,nil,buf)
-	_,err = r.dst.EncodeOpaque(buf)
-	return err
+	return r.format.write(&r.frameWriter,&r.wstate,&h,i)
 }
 func (r *rpcClientCodec) ReadResponseHeader(resp *rpc.Response) error {
-	buf,_,err := r.src.DecodeOpaque()
-	if err!=nil { return err }
-	buf,err = r.dec.Decrypt(nil,nil,buf)
+	var h Header
+	err,dc2 := r.format.read(&r.frameReader,&h)
 	if err!=nil { return err }
-	
-	err,dc2 := r.decode(buf,resp)
+	err = h.check()
 	if err!=nil { return err }
+	if h.Flags&FlagResponse==0 { return ErrWrongRole }
+	h.toResponse(resp)
 	r.decode2 = dc2
 	return nil
 }
+/*
+Ends the session, as counted in Options.Metrics, and closes the underlying
+Closer.
+*/
+func (r *rpcClientCodec) Close() error {
+	r.frameReader.sess.end()
+	return r.Closer.Close()
+}
 func (r *rpcClientCodec) ReadResponseBody(i interface{}) error {
-	return r.decode2(i)
-}
-func (r *rpcClientCodec) handshake(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config) error {
-	state := nc.Initiator
-	hs := noise.NewHandshakeState(nc)
-	for {
-		if state {
-			var buf []byte
-			buf,r.enc,r.dec = hs.WriteMessage(nil,nil)
-			_,e := dst.EncodeOpaque(buf)
-			if e!=nil { return e }
-			state = false
-			if r.enc!=nil { break }
-		}
-		buf,_,e := src.DecodeOpaque()
-		if e!=nil { return e }
-		_,r.enc,r.dec,e = hs.ReadMessage(nil,buf)
-		if e!=nil { return e }
-		state = true
-		if r.enc!=nil { break }
-	}
-	return nil
+	dc2 := r.decode2
+	if dc2==nil { return ErrNoHeader }
+	r.decode2 = nil
+	err,broken := unwrapStream(dc2(i))
+	if broken { r.broken = true }
+	return err
+}
+func (r *rpcClientCodec) bodyBroken() bool {
+	return r.broken
 }
 
 
 type rpcServerCodec struct{
 	io.Closer
 	rm sync.Mutex
-	src *xdr.Decoder
-	dst *xdr.Encoder
-	enc,dec *noise.CipherState
-	encode func(*rpc.Response, interface{}) ([]byte,error)
-	decode func([]byte,*rpc.Request) (error,func(i interface{}) error)
+	frameWriter
+	frameReader
+	format *RpcFormat
 	decode2 func(i interface{}) error
+	wstate writeState
+	// The header of the request read last.
+	header Header
 }
 func (r *rpcServerCodec) WriteResponse(resp *rpc.Response, i interface{}) error {
+	var h Header
+	h.fromResponse(resp)
+	if r.frameWriter.opts.SendQueue>0 && !r.frameWriter.opts.ChunkedRPC && !r.format.Stateful {
+		// Encode outside of the lock.
+		buf,err := r.format.encode(new([]byte),&h,i)
+		if err!=nil { return err }
+		r.rm.Lock(); defer r.rm.Unlock()
+		return r.writeFrame(buf,r.frameWriter.opts.Compressor)
+	}
 	r.rm.Lock(); defer r.rm.Unlock()
-	buf,err := r.encode(resp,i)
-	if err!=nil { return err }
-	buf = r.enc.Encrypt(nil,nil,buf)
-	_,err = r.dst.EncodeOpaque(buf)
-	return err
+	return r.format.write(&r.frameWriter,&r.wstate,&h,i)
 }
 func (r *rpcServerCodec) ReadRequestHeader(req *rpc.Request) error {
-	buf,_,err := r.src.DecodeOpaque()
-	if err!=nil { return err }
-	buf,err = r.dec.Decrypt(nil,nil,buf)
+	var h Header
+	err,dc2 := r.format.read(&r.frameReader,&h)
 	if err!=nil { return err }
-	
-	err,dc2 := r.decode(buf,req)
+	err = h.check()
 	if err!=nil { return err }
+	if h.Flags&FlagResponse!=0 { return ErrWrongRole }
+	h.toRequest(req)
+	r.header = h
 	r.decode2 = dc2
 	return nil
 }
+
+/*
+Returns the header of the request read last, see RequestHeader.
+*/
+func (r *rpcServerCodec) requestHeader() *Header {
+	h := r.header
+	return &h
+}
+func (r *rpcServerCodec) Close() error {
+	r.frameReader.sess.end()
+	return r.Closer.Close()
+}
 func (r *rpcServerCodec) ReadRequestBody(i interface{}) error {
-	return r.decode2(i)
-}
-func (r *rpcServerCodec) handshake(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config) error {
-	state := nc.Initiator
-	hs := noise.NewHandshakeState(nc)
-	for {
-		if state {
-			var buf []byte
-			buf,r.dec,r.enc = hs.WriteMessage(nil,nil)
-			_,e := dst.EncodeOpaque(buf)
-			if e!=nil { return e }
-			state = false
-			if r.enc!=nil { break }
-		}
-		buf,_,e := src.DecodeOpaque()
-		if e!=nil { return e }
-		_,r.dec,r.enc,e = hs.ReadMessage(nil,buf)
-		if e!=nil { return e }
-		state = true
-		if r.enc!=nil { break }
-	}
-	return nil
+	dc2 := r.decode2
+	if dc2==nil { return ErrNoHeader }
+	r.decode2 = nil
+	err,_ := unwrapStream(dc2(i))
+	return err
 }
 
-/* ------------------------------------------------------------------------- */
+/*
+An RpcFormat defines, how the Header and the body of a request or response are
+encoded into the plaintext of a frame.
+*/
+type RpcFormat struct{
+	Name string
+	Encode func(h *Header, i interface{}) ([]byte,error)
+	// Decodes the header and returns a function decoding the body.
+	Decode func(b []byte,h *Header) (error,func(i interface{}) error)
+	// If not nil, called once after the handshake to exchange format specific
+	// information with the peer. send and recv transmit single frames.
+	Negotiate func(initiator bool, send func([]byte) error, recv func() ([]byte,error)) error
+	// Optional. Like Encode, but appends to dst, so that codecs can reuse
+	// their buffer from call to call.
+	Append func(dst []byte, h *Header, i interface{}) ([]byte,error)
+	// Optional streaming variants of Encode and Decode, used with
+	// Options.ChunkedRPC, so that large bodies are encoded and decoded
+	// without holding the whole message in memory.
+	EncodeTo func(w io.Writer, h *Header, i interface{}) error
+	DecodeFrom func(r io.Reader, h *Header) (error,func(i interface{}) error)
+	// If true, the encoder keeps state from message to message (as
+	// NewGobStreamFormat does), so messages are encoded under the codec's
+	// lock, in the order they are sent, even with Options.SendQueue.
+	Stateful bool
+}
 
-func xdrEncReq(r *rpc.Request, i interface{}) ([]byte,error) {
-	dst := new(bytes.Buffer)
-	enc := xdr.NewEncoder(dst)
-	_,err := enc.Encode(r)
-	if err!=nil { return nil,err }
-	_,err = enc.Encode(i)
+/*
+The buffers, a codec reuses for writing messages.
+*/
+type writeState struct{
+	ebuf []byte
+	cw *chunkWriter
+}
+
+func (r *RpcFormat) encode(buf *[]byte, h *Header, i interface{}) ([]byte,error) {
+	if r.Append==nil { return r.Encode(h,i) }
+	b,err := r.Append((*buf)[:0],h,i)
 	if err!=nil { return nil,err }
-	return dst.Bytes(),nil
+	*buf = b
+	return b,nil
 }
-func xdrDecReq(b []byte,r *rpc.Request) (error,func(i interface{}) error) {
-	dec := xdr.NewDecoder(bytes.NewReader(b))
-	_,err := dec.Decode(r)
-	return err,func(i interface{}) error {
-		_,err := dec.Decode(i)
+
+/*
+Writes a message, either as a single frame or, with Options.ChunkedRPC, as a
+sequence of chunks.
+*/
+func (r *RpcFormat) write(w *frameWriter, ws *writeState, h *Header, i interface{}) error {
+	if !w.opts.ChunkedRPC {
+		buf,err := r.encode(&ws.ebuf,h,i)
+		if err!=nil { return err }
+		return w.writeFrame(buf,w.opts.Compressor)
+	}
+	if ws.cw==nil { ws.cw = newChunkWriter(w,false) }
+	var err error
+	if r.EncodeTo!=nil {
+		err = r.EncodeTo(ws.cw,h,i)
+	} else {
+		var buf []byte
+		buf,err = r.encode(&ws.ebuf,h,i)
+		if err==nil { _,err = ws.cw.Write(buf) }
+	}
+	if err!=nil {
+		ws.cw.abort()
 		return err
 	}
+	return ws.cw.close()
 }
 
-func xdrEncResp(r *rpc.Response, i interface{}) ([]byte,error) {
-	dst := new(bytes.Buffer)
-	enc := xdr.NewEncoder(dst)
-	_,err := enc.Encode(r)
-	if err!=nil { return nil,err }
-	_,err = enc.Encode(i)
-	if err!=nil { return nil,err }
-	return dst.Bytes(),nil
+const defaultMessageMax = 64<<20
+
+/*
+Returns the limit of a chunked RPC message, see Options.MaxMessageSize, 0 if
+there is none.
+*/
+func (o *Options) maxMessage() int {
+	if o.MaxMessageSize==0 { return defaultMessageMax }
+	if o.MaxMessageSize<0 { return 0 }
+	return o.MaxMessageSize
 }
-func xdrDecResp(b []byte,r *rpc.Response) (error,func(i interface{}) error) {
-	dec := xdr.NewDecoder(bytes.NewReader(b))
-	_,err := dec.Decode(r)
-	return err,func(i interface{}) error {
-		_,err := dec.Decode(i)
+
+/*
+Reads a message written by write and decodes its header.
+*/
+func (r *RpcFormat) read(rd *frameReader, h *Header) (error,func(i interface{}) error) {
+	if !rd.opts.ChunkedRPC {
+		buf,err := rd.readFrame()
+		if err!=nil { return err,nil }
+		return r.Decode(buf,h)
+	}
+	cr := &chunkReader{r:rd,max:rd.opts.maxMessage()}
+	if r.DecodeFrom==nil {
+		// cr fails, once more than max bytes have been read.
+		buf,err := ioutil.ReadAll(cr)
+		if err!=nil {
+			cr.drain()
+			return err,nil
+		}
+		return r.Decode(buf,h)
+	}
+	err,dc2 := r.DecodeFrom(cr,h)
+	if err!=nil {
+		cr.drain()
+		return err,nil
+	}
+	return nil,func(i interface{}) error {
+		err := dc2(i)
+		if e := cr.drain(); e!=nil { return &streamError{e} }
 		return err
 	}
 }
 
 /*
-Creates a client side RPC codec, that uses XDR as format to encode structures.
+An error of the stream, that a message body ended with. Unlike an error of the
+format's decoder, it leaves the stream out of sync.
 */
-func NewRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ClientCodec,error) {
+type streamError struct{
+	err error
+}
+func (e *streamError) Error() string { return e.err.Error() }
+
+/*
+Unwraps a streamError, and reports, whether err was one.
+*/
+func unwrapStream(err error) (error,bool) {
+	if e,ok := err.(*streamError); ok { return e.err,true }
+	return err,false
+}
+
+/*
+An io.Writer appending to a byte slice.
+*/
+type appendWriter struct{
+	b []byte
+}
+func (a *appendWriter) Write(p []byte) (int,error) {
+	a.b = append(a.b,p...)
+	return len(p),nil
+}
+
+/*
+Runs the handshake and the format negotiation of a codec over f. addr is the
+peer's address for Options.Audit, if known.
+*/
+func setupCodec(w *frameWriter, rd *frameReader, f Framer, nc noise.Config, fm *RpcFormat, addr net.Addr) (err error) {
+	w.dst = f
+	rd.src = f
+	err = checkConfig(w.opts,nc)
+	if err!=nil { return }
+	audit,verify := startAudit(w.opts,newConnID(),addr,nc)
+	audit.Format = fm.Name
+	w.conn,rd.conn = audit.ConnID,audit.ConnID
+	var hs *noise.HandshakeState
+	defer func() { w.log = audit.finish(w.opts,hs,err); rd.log = w.log }()
+	hs,w.enc,rd.dec,err = runHandshake(f,nc,nil,nil,verify,nil)
+	if err!=nil { return }
+	logKeys(w.opts.KeyLogWriter,w.opts.KeyLogStatic,hs,nc)
+	setInner(w,rd,w.opts,nc,hs.ChannelBinding())
+	burnEphemeral(hs)
+	rd.audit = audit
+	err = fm.negotiate(nc.Initiator,w,rd)
+	if err!=nil { return }
+	w.sess = w.opts.Metrics.start()
+	rd.sess = w.sess
+	return
+}
+
+func (r *RpcFormat) negotiate(initiator bool, w *frameWriter, rd *frameReader) error {
+	if r.Negotiate==nil { return nil }
+	send := func(b []byte) error { return w.writeFrame(b,CompressionNone) }
+	return r.Negotiate(initiator,send,rd.readFrame)
+}
+
+/*
+Returns the options of the writing side of a codec.
+*/
+func (o *Options) codec() Options {
+	opts := o.get()
+	if opts.SendQueue>0 && opts.Pipeline==0 { opts.Pipeline = opts.SendQueue }
+	return opts
+}
+
+func newRpcClient(fr Framer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ClientCodec,error) {
 	if c==nil { c = rpcCloserInst }
+	if f==nil { f = XDRFormat }
 	r := new(rpcClientCodec)
 	r.Closer = c
-	r.encode = xdrEncReq
-	r.decode = xdrDecResp
-	err := r.handshake(src,dst,nc)
+	r.format = f
+	r.frameWriter.opts = o.codec()
+	r.frameReader.opts = o.get()
+	err := setupCodec(&r.frameWriter,&r.frameReader,fr,nc,f,addrOf(c))
 	return r,err
 }
-
-/*
-Creates a client side RPC codec, that uses XDR as format to encode structures.
-*/
-func NewRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ServerCodec,error) {
+func newRpcSource(fr Framer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ServerCodec,error) {
 	if c==nil { c = rpcCloserInst }
+	if f==nil { f = XDRFormat }
 	r := new(rpcServerCodec)
 	r.Closer = c
-	r.encode = xdrEncResp
-	r.decode = xdrDecReq
-	err := r.handshake(src,dst,nc)
+	r.format = f
+	r.frameWriter.opts = o.codec()
+	r.frameReader.opts = o.get()
+	err := setupCodec(&r.frameWriter,&r.frameReader,fr,nc,f,addrOf(c))
 	return r,err
 }
 
+/*
+Creates a client side RPC codec, that uses the given format and options.
+*/
+func NewFormatRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ClientCodec,error) {
+	return newRpcClient(&xdrFramer{src:src,dst:dst},nc,c,f,o)
+}
+
+/*
+Creates a server side RPC codec, that uses the given format and options.
+*/
+func NewFormatRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ServerCodec,error) {
+	return newRpcSource(&xdrFramer{src:src,dst:dst},nc,c,f,o)
+}
+
+/*
+Creates a client side RPC codec, that exchanges frames using fr. If f is nil,
+XDRFormat is used.
+*/
+func NewFramedRpcClient(fr Framer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ClientCodec,error) {
+	return newRpcClient(fr,nc,c,f,o)
+}
+
+/*
+Creates a server side RPC codec, that exchanges frames using fr. If f is nil,
+XDRFormat is used.
+*/
+func NewFramedRpcSource(fr Framer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ServerCodec,error) {
+	return newRpcSource(fr,nc,c,f,o)
+}
+
+/*
+Creates a client side RPC codec, that reads from r and writes to w directly,
+using the framing selected by o.Framing. If c is nil and r implements
+io.Closer, closing the codec closes r. If f is nil, XDRFormat is used.
+
+	codec,err := seep.NewStreamRpcClient(conn,conn,cfg,nil,nil,nil)
+*/
+func NewStreamRpcClient(r io.Reader, w io.Writer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ClientCodec,error) {
+	if c==nil { c,_ = r.(io.Closer) }
+	return newRpcClient(newFramer(r,w,o.get()),nc,c,f,o)
+}
+
+/*
+Creates a server side RPC codec, that reads from r and writes to w directly,
+using the framing selected by o.Framing. If c is nil and r implements
+io.Closer, closing the codec closes r. If f is nil, XDRFormat is used.
+*/
+func NewStreamRpcSource(r io.Reader, w io.Writer,nc noise.Config,c io.Closer,f *RpcFormat,o *Options) (rpc.ServerCodec,error) {
+	if c==nil { c,_ = r.(io.Closer) }
+	return newRpcSource(newFramer(r,w,o.get()),nc,c,f,o)
+}
+
 /* ------------------------------------------------------------------------- */
 
-func gobEncReq(r *rpc.Request, i interface{}) ([]byte,error) {
-	dst := new(bytes.Buffer)
-	enc := gob.NewEncoder(dst)
-	err := enc.Encode(r)
-	if err!=nil { return nil,err }
-	err = enc.Encode(i)
+var XDRFormat = &RpcFormat{"xdr",xdrEncode,xdrDecode,nil,xdrAppend,xdrEncodeTo,xdrDecodeFrom,false}
+
+func xdrEncode(h *Header, i interface{}) ([]byte,error) {
+	return xdrAppend(nil,h,i)
+}
+func xdrAppend(dst []byte, h *Header, i interface{}) ([]byte,error) {
+	w := &appendWriter{dst}
+	err := xdrEncodeTo(w,h,i)
 	if err!=nil { return nil,err }
-	return dst.Bytes(),nil
+	return w.b,nil
 }
-func gobDecReq(b []byte,r *rpc.Request) (error,func(i interface{}) error) {
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	err := dec.Decode(r)
+func xdrEncodeTo(w io.Writer, h *Header, i interface{}) error {
+	enc := xdr.NewEncoder(w)
+	_,err := enc.Encode(h)
+	if err!=nil { return err }
+	_,err = enc.Encode(i)
+	return err
+}
+/*
+No element of the message can be larger than the message itself, so the
+decoder is limited to its size, rather than allocating whatever length the
+peer claims.
+*/
+func xdrDecode(b []byte,h *Header) (error,func(i interface{}) error) {
+	return xdrDecodeWith(xdr.NewDecoderLimited(bytes.NewReader(b),uint(len(b))),h)
+}
+/*
+Likewise, the elements of a chunked message are limited to the maximum size of
+the message.
+*/
+func xdrDecodeFrom(r io.Reader,h *Header) (error,func(i interface{}) error) {
+	max := defaultMessageMax
+	if cr,ok := r.(*chunkReader); ok { max = cr.max }
+	return xdrDecodeWith(xdr.NewDecoderLimited(r,uint(max)),h)
+}
+func xdrDecodeWith(dec *xdr.Decoder,h *Header) (error,func(i interface{}) error) {
+	_,err := dec.Decode(h)
 	return err,func(i interface{}) error {
-		return dec.Decode(i)
+		if i==nil { return nil }
+		_,err := dec.Decode(i)
+		return err
 	}
 }
 
-func gobEncResp(r *rpc.Response, i interface{}) ([]byte,error) {
-	dst := new(bytes.Buffer)
-	enc := gob.NewEncoder(dst)
-	err := enc.Encode(r)
-	if err!=nil { return nil,err }
-	err = enc.Encode(i)
+/*
+Creates a client side RPC codec, that uses XDR as format to encode structures.
+*/
+func NewRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ClientCodec,error) {
+	return NewFormatRpcClient(src,dst,nc,c,XDRFormat,nil)
+}
+
+/*
+Creates a server side RPC codec, that uses XDR as format to encode structures.
+*/
+func NewRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ServerCodec,error) {
+	return NewFormatRpcSource(src,dst,nc,c,XDRFormat,nil)
+}
+
+/* ------------------------------------------------------------------------- */
+
+var GobFormat = &RpcFormat{"gob",gobEncode,gobDecode,nil,gobAppend,gobEncodeTo,gobDecodeFrom,false}
+
+func gobEncode(h *Header, i interface{}) ([]byte,error) {
+	return gobAppend(nil,h,i)
+}
+func gobAppend(dst []byte, h *Header, i interface{}) ([]byte,error) {
+	w := &appendWriter{dst}
+	err := gobEncodeTo(w,h,i)
 	if err!=nil { return nil,err }
-	return dst.Bytes(),nil
+	return w.b,nil
+}
+
+/*
+Every message is a gob stream of its own, so a fresh Encoder is needed for
+every call.
+*/
+func gobEncodeTo(w io.Writer, h *Header, i interface{}) error {
+	enc := gob.NewEncoder(w)
+	err := enc.Encode(h)
+	if err!=nil { return err }
+	return enc.Encode(i)
 }
-func gobDecResp(b []byte,r *rpc.Response) (error,func(i interface{}) error) {
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	err := dec.Decode(r)
+func gobDecode(b []byte,h *Header) (error,func(i interface{}) error) {
+	return gobDecodeFrom(bytes.NewReader(b),h)
+}
+func gobDecodeFrom(r io.Reader,h *Header) (error,func(i interface{}) error) {
+	dec := gob.NewDecoder(r)
+	err := dec.Decode(h)
 	return err,func(i interface{}) error {
 		return dec.Decode(i)
 	}
 }
 
+/*
+Returns a gob format, that treats all frames of a connection as a single gob
+stream: type information is sent only once per connection and the Encoder and
+Decoder are reused for all calls. It is not compatible with GobFormat, and
+every codec needs a format of its own:
+
+	codec,err := seep.NewStreamRpcClient(conn,conn,cfg,nil,seep.NewGobStreamFormat(),nil)
+*/
+func NewGobStreamFormat() *RpcFormat {
+	w := new(appendWriter)
+	enc := gob.NewEncoder(w)
+	in := new(frameFeed)
+	dec := gob.NewDecoder(in)
+	f := &RpcFormat{Name:"gob-stream",Stateful:true}
+	f.Append = func(dst []byte, h *Header, i interface{}) ([]byte,error) {
+		w.b = dst
+		err := enc.Encode(h)
+		if err!=nil { return nil,err }
+		err = enc.Encode(i)
+		if err!=nil { return nil,err }
+		return w.b,nil
+	}
+	f.Encode = func(h *Header, i interface{}) ([]byte,error) {
+		return f.Append(nil,h,i)
+	}
+	f.Decode = func(b []byte, h *Header) (error,func(i interface{}) error) {
+		in.Reset(b)
+		err := dec.Decode(h)
+		// The body must be consumed even if it is not wanted, to keep the
+		// stream in sync; Decode(nil) discards it.
+		return err,dec.Decode
+	}
+	return f
+}
+
+/*
+Feeds the frames to the gob Decoder of NewGobStreamFormat. As it implements
+io.ByteReader, the Decoder doesn't read ahead.
+*/
+type frameFeed struct{
+	bytes.Reader
+}
+
 /*
 Creates a client side RPC codec, that uses GOB as format to encode structures.
 */
 func NewGobRpcClient(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ClientCodec,error) {
-	if c==nil { c = rpcCloserInst }
-	r := new(rpcClientCodec)
-	r.Closer = c
-	r.encode = gobEncReq
-	r.decode = gobDecResp
-	err := r.handshake(src,dst,nc)
-	return r,err
+	return NewFormatRpcClient(src,dst,nc,c,GobFormat,nil)
 }
 
 /*
 Creates a server side RPC codec, that uses GOB as format to encode structures.
 */
 func NewGobRpcSource(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config,c io.Closer) (rpc.ServerCodec,error) {
-	if c==nil { c = rpcCloserInst }
-	r := new(rpcServerCodec)
-	r.Closer = c
-	r.encode = gobEncResp
-	r.decode = gobDecReq
-	err := r.handshake(src,dst,nc)
-	return r,err
+	return NewFormatRpcSource(src,dst,nc,c,GobFormat,nil)
 }
 
 /* ------------------------------------------------------------------------- */
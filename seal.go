@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "errors"
+import "io"
+import "github.com/flynn/noise"
+
+/*
+The magic string at the start of a sealed file.
+*/
+const fileMagic = "seep-file/1\n"
+
+var ErrNotSealed = errors.New("seep: not a sealed file")
+var ErrWrongRecipient = errors.New("seep: file is sealed to another key")
+
+var fileOptions = &Options{MaxFrameSize:noise.MaxMsgLen}
+
+func filePrologue(name, recipient []byte) []byte {
+	p := append([]byte(fileMagic),name...)
+	return append(append(p,0),recipient...)
+}
+
+/*
+Encrypts src to the static public key recipient and writes the result to dst.
+
+The file starts with a magic string, followed by uvarint-delimited frames: the
+Noise protocol name (always using the one-way pattern N), the recipient's public
+key, the handshake message carrying the ephemeral key, and the body, encrypted
+in chunks as written by a MessageWriter, so that truncation is detected. The
+header is bound to the handshake as prologue.
+*/
+func SealFile(dst io.Writer, src io.Reader, recipient []byte, cs noise.CipherSuite) error {
+	name := []byte("Noise_N_"+string(cs.Name()))
+	_,err := io.WriteString(dst,fileMagic)
+	if err!=nil { return err }
+	f := NewUvarintFramer(nil,dst)
+	err = f.WriteFrame(name)
+	if err!=nil { return err }
+	err = f.WriteFrame(recipient)
+	if err!=nil { return err }
+	nc := noise.Config{CipherSuite:cs,Pattern:noise.HandshakeN,Initiator:true,PeerStatic:recipient,Prologue:filePrologue(name,recipient)}
+	hs,enc,_,err := runHandshake(f,nc,nil,nil,nil,nil)
+	if err!=nil { return err }
+	burnEphemeral(hs)
+	w := NewFramedWriter(f,enc,fileOptions)
+	mw := w.NewMessage()
+	_,err = io.Copy(mw,src)
+	if err!=nil { return err }
+	err = mw.Close()
+	w.Burn()
+	return err
+}
+
+/*
+Decrypts a file written by SealFile using the recipient's static key and
+writes the plaintext to dst. Data is written to dst as soon as a chunk has
+been authenticated; if the file turns out to be truncated, ErrTruncated is
+returned.
+*/
+func OpenFile(dst io.Writer, src io.Reader, key noise.DHKey) error {
+	magic := make([]byte,len(fileMagic))
+	_,err := io.ReadFull(src,magic)
+	if err!=nil || string(magic)!=fileMagic { return ErrNotSealed }
+	f := NewUvarintFramer(src,nil)
+	name,err := f.ReadFrame(256)
+	if err!=nil { return err }
+	p,err := ParseProtocolName(string(name))
+	if err!=nil { return err }
+	if p.Pattern.Name!="N" || p.PSKPlacement>=0 { return ErrNotSealed }
+	recipient,err := f.ReadFrame(256)
+	if err!=nil { return err }
+	if !bytes.Equal(recipient,key.Public) { return ErrWrongRecipient }
+	nc := noise.Config{CipherSuite:p.CipherSuite,Pattern:noise.HandshakeN,StaticKeypair:key,Prologue:filePrologue(name,recipient)}
+	_,_,dec,err := runHandshake(f,nc,nil,nil,nil,nil)
+	if err!=nil { return err }
+	r := NewFramedReader(f,dec,fileOptions)
+	_,err = io.Copy(dst,r.NextMessage())
+	r.Burn()
+	return err
+}
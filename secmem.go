@@ -0,0 +1,92 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "github.com/flynn/noise"
+
+var ErrMlockUnsupported = errors.New("seep: locked memory is not supported on this platform")
+
+/*
+A SecureBuffer is a fixed size buffer outside of the Go heap, locked into
+memory (mlock), so that it is never written to swap. It is meant for private
+keys of deployments with strict key handling requirements. Locked memory is
+supported on Linux and macOS only; elsewhere NewSecureBuffer returns
+ErrMlockUnsupported.
+
+Note, that only the key itself is protected: the ephemeral keys and the
+cipher states of a session are allocated by the noise package on the Go heap
+(see Burn for wiping them).
+*/
+type SecureBuffer struct{
+	b []byte
+}
+
+/*
+Allocates a locked buffer of n bytes. The size of locked memory is usually
+limited per process (RLIMIT_MEMLOCK), exceeding it returns an error.
+*/
+func NewSecureBuffer(n int) (*SecureBuffer,error) {
+	b,err := lockedAlloc(n)
+	if err!=nil { return nil,err }
+	return &SecureBuffer{b},nil
+}
+
+/*
+Returns the contents of the buffer. They must not be used after Destroy.
+*/
+func (s *SecureBuffer) Bytes() []byte { return s.b }
+
+/*
+Wipes the buffer, unlocks and releases it.
+*/
+func (s *SecureBuffer) Destroy() error {
+	if s.b==nil { return nil }
+	Wipe(s.b)
+	b := s.b
+	s.b = nil
+	return lockedFree(b)
+}
+
+/*
+Moves the private key of k into a SecureBuffer: the key is copied into locked
+memory, the original is wiped, and k.Private is pointed to the copy. The
+buffer must be destroyed, once the key is no longer needed.
+
+	kp,err := cs.GenerateKeypair(rand.Reader)
+	// ... check error
+	sb,err := seep.LockKey(&kp)
+	// ... check error
+	defer sb.Destroy()
+	cfg.StaticKeypair = kp
+*/
+func LockKey(k *noise.DHKey) (*SecureBuffer,error) {
+	s,err := NewSecureBuffer(len(k.Private))
+	if err!=nil { return nil,err }
+	copy(s.b,k.Private)
+	Wipe(k.Private)
+	k.Private = s.b
+	return s,nil
+}
@@ -0,0 +1,46 @@
+// +build darwin linux
+
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "syscall"
+
+func lockedAlloc(n int) ([]byte,error) {
+	if n==0 { return []byte{},nil }
+	b,err := syscall.Mmap(-1,0,n,syscall.PROT_READ|syscall.PROT_WRITE,syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err!=nil { return nil,err }
+	err = syscall.Mlock(b)
+	if err!=nil {
+		syscall.Munmap(b)
+		return nil,err
+	}
+	return b,nil
+}
+func lockedFree(b []byte) error {
+	if len(b)==0 { return nil }
+	syscall.Munlock(b)
+	return syscall.Munmap(b)
+}
@@ -0,0 +1,196 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "strings"
+import "github.com/flynn/noise"
+
+/*
+The security properties of a handshake or transport payload, as defined in
+section 7.7 of the Noise specification.
+
+Authentication (source properties):
+
+	0: no authentication, the payload may have been sent by any party.
+	1: sender authentication, vulnerable to key-compromise impersonation.
+	2: sender authentication, resistant to key-compromise impersonation.
+
+Confidentiality (destination properties):
+
+	0: no confidentiality, the payload is sent in cleartext.
+	1: encryption to an ephemeral recipient, forward secret against passive
+	   attackers only; the recipient is not authenticated.
+	2: encryption to a known recipient, forward secrecy for sender
+	   compromise only, vulnerable to replay.
+	3: encryption to a known recipient, weak forward secrecy.
+	4: encryption to a known recipient, weak forward secrecy, if the sender's
+	   private key has been compromised.
+	5: encryption to a known recipient, strong forward secrecy.
+*/
+type PayloadSecurity struct{
+	Authentication int
+	Confidentiality int
+}
+
+/*
+The payload of a handshake message, see Connection.Payloads.
+*/
+type HandshakePayload struct{
+	Outgoing bool
+	Payload []byte
+	Security PayloadSecurity
+}
+
+/*
+Reports, whether the payload is authenticated as coming from the sender's
+static key.
+*/
+func (p PayloadSecurity) SenderAuthenticated() bool { return p.Authentication>0 }
+
+/*
+Reports, whether the payload can only be read by the owner of a known static
+key.
+*/
+func (p PayloadSecurity) RecipientAuthenticated() bool { return p.Confidentiality>=2 }
+
+/*
+Reports, whether the payload is protected by an ephemeral-ephemeral DH, so
+that a later compromise of the static keys doesn't reveal it to a passive
+attacker.
+*/
+func (p PayloadSecurity) ForwardSecret() bool { return p.Confidentiality==1 || p.Confidentiality>=3 }
+
+/*
+The properties of the payloads of the fundamental patterns, from the Noise
+specification. Messages past the end of a list have the properties of the
+last message of the same direction.
+*/
+var payloadSecurity = map[string][]PayloadSecurity{
+	"N":  {{0,2}},
+	"K":  {{1,2}},
+	"X":  {{1,2}},
+	"NN": {{0,0},{0,1},{0,1}},
+	"NK": {{0,2},{2,1},{0,5}},
+	"NX": {{0,0},{2,1},{0,5}},
+	"XN": {{0,0},{0,1},{2,1},{0,5}},
+	"XK": {{0,2},{2,1},{2,5},{2,5}},
+	"XX": {{0,0},{2,1},{2,5},{2,5}},
+	"KN": {{0,0},{0,3},{2,1},{0,5}},
+	"KK": {{1,2},{2,4},{2,5},{2,5}},
+	"KX": {{0,0},{2,3},{2,5},{2,5}},
+	"IN": {{0,0},{0,3},{2,1},{0,5}},
+	"IK": {{1,2},{2,4},{2,5},{2,5}},
+	"IX": {{0,0},{2,3},{2,5},{2,5}},
+}
+
+/*
+Returns the security properties of the payload of message i (counting from 0,
+handshake messages first, followed by the transport messages) of a session
+using the pattern p. For one-way patterns, all messages are sent by the
+initiator; otherwise the sides alternate, starting with the initiator.
+
+Preshared keys are not taken into account, so the result is a lower bound for
+patterns with a psk modifier. For unknown patterns, the zero value is
+returned.
+*/
+func PayloadProperties(p noise.HandshakePattern, i int) PayloadSecurity {
+	l := payloadSecurity[p.Name]
+	if len(l)==0 { return PayloadSecurity{} }
+	if len(l)==1 { return l[0] }
+	for i>=len(l) { i-=2 }
+	return l[i]
+}
+
+var ErrUnauthenticatedPattern = errors.New("seep: handshake pattern leaves the peer unauthenticated")
+var ErrNoVerifier = errors.New("seep: peer authentication required, but no VerifyPeer configured")
+var ErrSuiteNotAllowed = newError(ErrHandshakeFailed,"seep: cipher suite not allowed in FIPS mode")
+
+/*
+The ciphers and hashes allowed by Options.FIPS.
+*/
+var fipsCiphers = map[string]bool{"AESGCM":true}
+var fipsHashes = map[string]bool{"SHA256":true,"SHA512":true}
+
+/*
+Enforces Options.FIPS.
+*/
+func checkSuite(o Options, nc noise.Config) error {
+	if !o.FIPS { return nil }
+	if nc.CipherSuite==nil { return ErrSuiteNotAllowed }
+	// The name is DH_Cipher_Hash.
+	parts := strings.Split(string(nc.CipherSuite.Name()),"_")
+	if len(parts)!=3 || !fipsCiphers[parts[1]] || !fipsHashes[parts[2]] { return ErrSuiteNotAllowed }
+	return nil
+}
+
+/*
+Reports, whether the handshake pattern p authenticates the static key of the
+peer of the given side, that is, whether the peer has a static key, that is
+either known in advance or transmitted during the handshake.
+*/
+func PeerAuthenticated(p noise.HandshakePattern, initiator bool) bool {
+	pre := p.ResponderPreMessages
+	if !initiator { pre = p.InitiatorPreMessages }
+	for _,m := range pre {
+		if m==noise.MessagePatternS { return true }
+	}
+	for i,msg := range p.Messages {
+		// Skip our own messages.
+		if (i%2==0)==initiator { continue }
+		for _,m := range msg {
+			if m==noise.MessagePatternS { return true }
+		}
+	}
+	return false
+}
+
+/*
+Enforces Options.FIPS and Options.RequirePeerAuth before a handshake.
+*/
+func checkConfig(o Options, nc noise.Config) error {
+	err := checkSuite(o,nc)
+	if err!=nil { return err }
+	if o.InnerPSK!=nil && len(o.InnerPSK)!=32 { return ErrInnerKey }
+	if !o.RequirePeerAuth { return nil }
+	if !PeerAuthenticated(nc.Pattern,nc.Initiator) { return ErrUnauthenticatedPattern }
+	if o.VerifyPeer==nil && !peerPinned(nc) { return ErrNoVerifier }
+	return nil
+}
+
+/*
+Reports, whether the pattern takes the peer's static key from a pre-message
+(as the initiator of NK, KK, IK or XK does), and nc supplies it: the handshake
+then fails with any other key, so the key is pinned without VerifyPeer.
+*/
+func peerPinned(nc noise.Config) bool {
+	if len(nc.PeerStatic)==0 { return false }
+	pre := nc.Pattern.ResponderPreMessages
+	if !nc.Initiator { pre = nc.Pattern.InitiatorPreMessages }
+	for _,m := range pre {
+		if m==noise.MessagePatternS { return true }
+	}
+	return false
+}
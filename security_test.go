@@ -0,0 +1,67 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "crypto/rand"
+import "testing"
+import "github.com/flynn/noise"
+
+func TestRequirePeerAuth(t *testing.T) {
+	key,_ := testSuite.GenerateKeypair(rand.Reader)
+	o := Options{RequirePeerAuth:true}
+	for _,c := range []struct{
+		name string
+		nc noise.Config
+		err error
+	}{
+		{"NN",noise.Config{Pattern:noise.HandshakeNN,Initiator:true},ErrUnauthenticatedPattern},
+		{"XX without verifier",noise.Config{Pattern:noise.HandshakeXX,Initiator:true},ErrNoVerifier},
+		{"IK pinned",noise.Config{Pattern:noise.HandshakeIK,Initiator:true,PeerStatic:key.Public},nil},
+		{"NK pinned",noise.Config{Pattern:noise.HandshakeNK,Initiator:true,PeerStatic:key.Public},nil},
+		{"KK responder pinned",noise.Config{Pattern:noise.HandshakeKK,PeerStatic:key.Public},nil},
+		{"IK responder",noise.Config{Pattern:noise.HandshakeIK},ErrNoVerifier},
+		// The peer's key of XX is transmitted, not pinned.
+		{"XX with PeerStatic",noise.Config{Pattern:noise.HandshakeXX,Initiator:true,PeerStatic:key.Public},ErrNoVerifier},
+	} {
+		c.nc.CipherSuite = testSuite
+		if err := checkConfig(o,c.nc); err!=c.err { t.Errorf("%s: got %v, want %v",c.name,err,c.err) }
+	}
+	o.VerifyPeer = PinnedPeer(key.Public)
+	if err := checkConfig(o,noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeXX,Initiator:true}); err!=nil { t.Error(err) }
+}
+
+func TestPadRandom(t *testing.T) {
+	pad := PadRandom(3)
+	var seen [4]int
+	for i := 0; i<1000; i++ {
+		n := pad(100)-100
+		if n<0 || n>3 { t.Fatalf("padding %d out of range",n) }
+		seen[n]++
+	}
+	for n,c := range seen {
+		if c==0 { t.Errorf("padding %d never drawn",n) }
+	}
+	if PadRandom(0)(7)!=7 { t.Error("PadRandom(0) padded") }
+}
@@ -0,0 +1,220 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Package seepbench contains reusable benchmarks for the Reader/Writer and the RPC
+codecs of SEEP, so that regressions can be measured in downstream projects:
+
+	func BenchmarkThroughput(b *testing.B) {
+		seepbench.Throughput(b,seepbench.TCP,nil,16384)
+	}
+
+All benchmarks report allocations.
+*/
+package seepbench
+
+import "crypto/rand"
+import "io"
+import "io/ioutil"
+import "net"
+import "net/rpc"
+import "testing"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+/*
+The transport, the benchmarks run over.
+*/
+type Transport int
+const (
+	// An in-memory net.Pipe.
+	Pipe Transport = iota
+	// A TCP connection over localhost.
+	TCP
+)
+
+func (t Transport) String() string {
+	if t==TCP { return "tcp" }
+	return "pipe"
+}
+
+var suite = noise.NewCipherSuite(noise.DH25519,noise.CipherChaChaPoly,noise.HashBLAKE2s)
+
+/*
+Returns the configurations of both sides of an NN handshake.
+*/
+func configs() (noise.Config,noise.Config) {
+	c := noise.Config{CipherSuite:suite,Pattern:noise.HandshakeNN,Initiator:true,Random:rand.Reader}
+	s := noise.Config{CipherSuite:suite,Pattern:noise.HandshakeNN,Random:rand.Reader}
+	return c,s
+}
+
+/*
+Returns both ends of a connection over the given transport.
+*/
+func Dial(t Transport) (net.Conn,net.Conn,error) {
+	if t==Pipe {
+		a,b := net.Pipe()
+		return a,b,nil
+	}
+	l,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { return nil,nil,err }
+	defer l.Close()
+	ch := make(chan net.Conn,1)
+	go func() {
+		c,_ := l.Accept()
+		ch <- c
+	}()
+	a,err := net.Dial("tcp",l.Addr().String())
+	if err!=nil { return nil,nil,err }
+	b := <-ch
+	if b==nil {
+		a.Close()
+		return nil,nil,io.ErrUnexpectedEOF
+	}
+	return a,b,nil
+}
+
+/*
+Returns two Connections, that completed the handshake over t.
+*/
+func Connections(t Transport, o *seep.Options) (*seep.Connection,*seep.Connection,func(),error) {
+	a,b,err := Dial(t)
+	if err!=nil { return nil,nil,nil,err }
+	cc,sc := configs()
+	c1 := &seep.Connection{Options:o}
+	c2 := &seep.Connection{Options:o}
+	c1.Init()
+	c2.Init()
+	ch := make(chan error,1)
+	go func() { ch <- c2.HandshakeStream(b,b,sc) }()
+	err = c1.HandshakeStream(a,a,cc)
+	if e := <-ch; err==nil { err = e }
+	closer := func() {
+		a.Close()
+		b.Close()
+	}
+	if err!=nil {
+		closer()
+		return nil,nil,nil,err
+	}
+	return c1,c2,closer,nil
+}
+
+/*
+Measures the throughput of writes of size bytes from one Connection to the
+other.
+*/
+func Throughput(b *testing.B, t Transport, o *seep.Options, size int) {
+	c1,c2,closer,err := Connections(t,o)
+	if err!=nil { b.Fatal(err) }
+	defer closer()
+	buf := make([]byte,size)
+	done := make(chan error,1)
+	go func() {
+		_,err := io.CopyN(ioutil.Discard,c2,int64(b.N)*int64(size))
+		done <- err
+	}()
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i:=0; i<b.N; i++ {
+		_,err = c1.Write(buf)
+		if err!=nil { b.Fatal(err) }
+	}
+	err = c1.Flush()
+	if err!=nil { b.Fatal(err) }
+	err = <-done
+	if err!=nil { b.Fatal(err) }
+}
+
+/*
+Measures the round trip time of a message of size bytes, that is echoed by
+the peer.
+*/
+func Latency(b *testing.B, t Transport, o *seep.Options, size int) {
+	c1,c2,closer,err := Connections(t,o)
+	if err!=nil { b.Fatal(err) }
+	defer closer()
+	go func() {
+		buf := make([]byte,size)
+		for {
+			_,err := io.ReadFull(c2,buf)
+			if err!=nil { return }
+			_,err = c2.Write(buf)
+			if err!=nil { return }
+			c2.Flush()
+		}
+	}()
+	buf := make([]byte,size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i:=0; i<b.N; i++ {
+		_,err = c1.Write(buf)
+		if err!=nil { b.Fatal(err) }
+		err = c1.Flush()
+		if err!=nil { b.Fatal(err) }
+		_,err = io.ReadFull(c1,buf)
+		if err!=nil { b.Fatal(err) }
+	}
+}
+
+type Echo struct{}
+func (Echo) Echo(req []byte, resp *[]byte) error {
+	*resp = req
+	return nil
+}
+
+/*
+Measures net/rpc calls with a payload of size bytes over the RPC codecs using
+the given format (nil means XDR).
+*/
+func RPC(b *testing.B, t Transport, f *seep.RpcFormat, o *seep.Options, size int) {
+	a,c,err := Dial(t)
+	if err!=nil { b.Fatal(err) }
+	defer a.Close()
+	defer c.Close()
+	cc,sc := configs()
+	srv := rpc.NewServer()
+	srv.Register(Echo{})
+	go func() {
+		codec,err := seep.NewStreamRpcSource(c,c,sc,nil,f,o)
+		if err!=nil { return }
+		srv.ServeCodec(codec)
+	}()
+	codec,err := seep.NewStreamRpcClient(a,a,cc,nil,f,o)
+	if err!=nil { b.Fatal(err) }
+	client := rpc.NewClientWithCodec(codec)
+	defer client.Close()
+	req := make([]byte,size)
+	var resp []byte
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i:=0; i<b.N; i++ {
+		err = client.Call("Echo.Echo",req,&resp)
+		if err!=nil { b.Fatal(err) }
+	}
+}
@@ -0,0 +1,159 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+HTTP over SEEP. A Proxy is a reverse proxy, that accepts plaintext or TLS HTTP
+like any http.Handler and forwards the requests to upstreams over SEEP, so
+that SEEP can be introduced within a service mesh one hop at a time:
+
+	p := &seephttp.Proxy{
+		Config: noise.Config{CipherSuite:cs,Pattern:noise.HandshakeXX,StaticKeypair:key,Random:rand.Reader},
+		Upstreams: []seephttp.Upstream{{Addr:"10.0.0.5:7000",Key:upstreamKey}},
+	}
+	err := http.ListenAndServeTLS(":443","cert.pem","key.pem",p)
+
+A Server serves net/http (including h2c) and other application protocols on a
+SEEP listener, selecting the protocol in the handshake.
+*/
+package seephttp
+
+import "context"
+import "errors"
+import "net"
+import "net/http"
+import "net/http/httputil"
+import "sync"
+import "sync/atomic"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+var ErrNoUpstream = errors.New("seephttp: no upstream")
+
+/*
+An upstream of a Proxy.
+*/
+type Upstream struct{
+	// The address of the upstream's SEEP listener.
+	Addr string
+	// The static public key, the upstream must authenticate with. If nil,
+	// the upstream is not verified (see Options.VerifyPeer).
+	Key []byte
+}
+
+/*
+A reverse proxy to upstreams, that speak HTTP over SEEP, such as a Server
+(see Serve) or seep-proxy -reverse in front of a plaintext service. The
+requests are spread over the upstreams in turn; the SEEP connections are kept
+alive and reused, as http.Transport does.
+*/
+type Proxy struct{
+	Upstreams []Upstream
+	// The handshake configuration. Initiator is set, PeerStatic is set to
+	// the key of the upstream, where the pattern needs it.
+	Config noise.Config
+	// The options of the connections. VerifyPeer is replaced by the check
+	// of the upstream's key, if it has one.
+	Options *seep.Options
+	// If not 0, handshakes, that take longer, are aborted.
+	HandshakeTimeout time.Duration
+	// If not nil, called to modify the request, after it was directed to
+	// the upstream, see httputil.ReverseProxy.Director.
+	Director func(*http.Request)
+	// If not nil, handles the errors of the upstreams, see
+	// httputil.ReverseProxy.ErrorHandler.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+	once sync.Once
+	proxy *httputil.ReverseProxy
+	next uint32
+}
+
+func (p *Proxy) init() {
+	t := &http.Transport{
+		DialContext: p.DialContext,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout: 90*time.Second,
+	}
+	p.proxy = &httputil.ReverseProxy{Director:p.direct,Transport:t,ErrorHandler:p.ErrorHandler}
+}
+
+func (p *Proxy) direct(r *http.Request) {
+	if len(p.Upstreams)>0 {
+		u := p.Upstreams[int(atomic.AddUint32(&p.next,1)-1)%len(p.Upstreams)]
+		r.URL.Host = u.Addr
+	}
+	r.URL.Scheme = "http"
+	if _,ok := r.Header["User-Agent"]; !ok {
+		// Not to add the default User-Agent of net/http.
+		r.Header.Set("User-Agent","")
+	}
+	if p.Director!=nil { p.Director(r) }
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.once.Do(p.init)
+	p.proxy.ServeHTTP(w,r)
+}
+
+/*
+Connects to the upstream with the address addr and runs the handshake. It is
+the DialContext of the Proxy's http.Transport.
+*/
+func (p *Proxy) DialContext(ctx context.Context, network, addr string) (net.Conn,error) {
+	var up *Upstream
+	for i := range p.Upstreams {
+		if p.Upstreams[i].Addr==addr { up = &p.Upstreams[i]; break }
+	}
+	if up==nil { return nil,ErrNoUpstream }
+	var d net.Dialer
+	conn,err := d.DialContext(ctx,network,addr)
+	if err!=nil { return nil,err }
+	dl,ok := ctx.Deadline()
+	if p.HandshakeTimeout>0 {
+		if t := time.Now().Add(p.HandshakeTimeout); !ok || t.Before(dl) { dl,ok = t,true }
+	}
+	if ok { conn.SetDeadline(dl) }
+	c,err := seep.NewConn(conn,p.config(up),p.options(up))
+	if err!=nil {
+		conn.Close()
+		return nil,err
+	}
+	conn.SetDeadline(time.Time{})
+	return c,nil
+}
+
+func (p *Proxy) config(up *Upstream) noise.Config {
+	nc := p.Config
+	nc.Initiator = true
+	if len(nc.Pattern.ResponderPreMessages)>0 { nc.PeerStatic = up.Key }
+	return nc
+}
+
+func (p *Proxy) options(up *Upstream) *seep.Options {
+	var o seep.Options
+	if p.Options!=nil { o = *p.Options }
+	if up.Key!=nil { o.VerifyPeer = seep.PinnedPeer(up.Key) }
+	return &o
+}
@@ -0,0 +1,307 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seephttp
+
+import "errors"
+import "io"
+import "net"
+import "net/http"
+import "sync"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "golang.org/x/net/http2"
+
+/*
+Application protocol tokens, see Server.
+*/
+const (
+	ProtoHTTP1 = "http/1.1"
+	// HTTP/2 with prior knowledge, as h2c.
+	ProtoH2 = "h2"
+)
+
+/*
+Returned by Handshake, if an offered protocol token is empty or longer than
+255 bytes.
+*/
+var ErrProtocolToken = errors.New("seephttp: invalid protocol token")
+
+/*
+Returned by the listener of the HTTP/1.1 connections, once the Server is
+closed.
+*/
+var ErrServerClosed = errors.New("seephttp: server closed")
+
+/*
+Encodes a list of protocol tokens, each preceded by its one byte length, as
+in TLS ALPN.
+*/
+func encodeTokens(tokens ...string) ([]byte,error) {
+	var b []byte
+	for _,t := range tokens {
+		if len(t)==0 || len(t)>255 { return nil,ErrProtocolToken }
+		b = append(append(b,byte(len(t))),t...)
+	}
+	return b,nil
+}
+
+func decodeTokens(b []byte) ([]string,bool) {
+	var tokens []string
+	for len(b)>0 {
+		n := int(b[0])
+		if n==0 || len(b)<1+n { return nil,false }
+		tokens = append(tokens,string(b[1:1+n]))
+		b = b[1+n:]
+	}
+	return tokens,true
+}
+
+/*
+Serves net/http, including HTTP/2 without TLS (h2c), and other application
+protocols on a single SEEP listener. The client offers the protocols, it
+speaks, in the payload of the first handshake message (see Handshake), and
+the Server answers with the one selected in the payload of the second, like
+TLS ALPN does, so no round trip is spent on the selection.
+
+	s := &seephttp.Server{Handler:mux}
+	err := s.ListenAndServe(&seep.Server{Config:cfg},"tcp",":7000")
+
+The offer is a list of tokens, each preceded by its one byte length; the
+answer is the selected token, preceded by its length (0, if the Server
+speaks none of them). Clients, that make no offer, get no answer and are
+served the Default protocol. With patterns, whose first message is sent in
+the clear (XX, NN, ...), the offer is visible to observers, as ALPN is.
+*/
+type Server struct{
+	// Serves ProtoHTTP1 and ProtoH2.
+	Handler http.Handler
+	// The handlers of further protocols, by token. They own the
+	// connection and must close it.
+	Protocols map[string]func(*seep.Conn)
+	// The protocol of the clients, that make no offer, ProtoHTTP1 if empty.
+	Default string
+	// If not nil, the configuration of the HTTP servers (timeouts, limits,
+	// ...). Its Handler is ignored.
+	HTTP *http.Server
+
+	once sync.Once
+	h1 *http.Server
+	h2 *http2.Server
+	conns chan net.Conn
+	done chan struct{}
+	lck sync.Mutex
+	closed bool
+}
+
+func (s *Server) init() {
+	s.h1 = new(http.Server)
+	if s.HTTP!=nil {
+		s.h1.ReadTimeout = s.HTTP.ReadTimeout
+		s.h1.ReadHeaderTimeout = s.HTTP.ReadHeaderTimeout
+		s.h1.WriteTimeout = s.HTTP.WriteTimeout
+		s.h1.IdleTimeout = s.HTTP.IdleTimeout
+		s.h1.MaxHeaderBytes = s.HTTP.MaxHeaderBytes
+		s.h1.ErrorLog = s.HTTP.ErrorLog
+		s.h1.ConnState = s.HTTP.ConnState
+	}
+	s.h1.Handler = s.Handler
+	s.h2 = new(http2.Server)
+	s.conns = make(chan net.Conn)
+	s.done = make(chan struct{})
+	go s.h1.Serve(&connListener{s})
+}
+
+func (s *Server) speaks(token string) bool {
+	if token==ProtoHTTP1 || token==ProtoH2 { return s.Handler!=nil }
+	return s.Protocols[token]!=nil
+}
+
+/*
+Selects the protocol for an offer: the first token of it, the Server
+speaks. ok is false, if there is no offer.
+*/
+func (s *Server) choose(offer []byte) (token string, ok bool) {
+	if len(offer)==0 { return "",false }
+	tokens,_ := decodeTokens(offer)
+	for _,t := range tokens {
+		if s.speaks(t) { return t,true }
+	}
+	return "",true
+}
+
+/*
+Answers the offer of a client, for Options.Respond. ListenAndServe sets it.
+*/
+func (s *Server) Respond(offer []byte) []byte {
+	token,ok := s.choose(offer)
+	if !ok { return nil }
+	return append([]byte{byte(len(token))},token...)
+}
+
+/*
+Starts l with Options.Respond set to s.Respond, on the given address, and
+serves the connections.
+*/
+func (s *Server) ListenAndServe(l *seep.Server, network, addr string) error {
+	var o seep.Options
+	if l.Options!=nil { o = *l.Options }
+	o.Respond = s.Respond
+	l.Options = &o
+	err := l.Listen(network,addr)
+	if err!=nil { return err }
+	return s.Serve(l)
+}
+
+/*
+Serves the connections accepted by l, a seep.Server, whose Options.Respond is
+s.Respond. Connections, that are not *seep.Conn, are closed.
+*/
+func (s *Server) Serve(l net.Listener) error {
+	s.once.Do(s.init)
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go s.ServeConn(sc)
+	}
+}
+
+/*
+Serves a single connection with the protocol selected in its handshake.
+*/
+func (s *Server) ServeConn(c *seep.Conn) {
+	s.once.Do(s.init)
+	var offer []byte
+	if len(c.Payloads)>0 { offer = c.Payloads[0].Payload }
+	token,ok := s.choose(offer)
+	if !ok { token = s.Default }
+	if token=="" && !ok { token = ProtoHTTP1 }
+	switch {
+	case token==ProtoH2 && s.Handler!=nil:
+		s.h2.ServeConn(c,&http2.ServeConnOpts{Handler:s.Handler,BaseConfig:s.h1})
+		c.Close()
+	case token==ProtoHTTP1 && s.Handler!=nil:
+		select {
+		case s.conns <- &onceConn{Conn:c}:
+		case <-s.done:
+			c.Close()
+		}
+	case s.Protocols[token]!=nil:
+		s.Protocols[token](c)
+	default:
+		c.Close()
+	}
+}
+
+/*
+Stops serving HTTP/1.1 and closes the idle HTTP connections. The listener
+passed to Serve must be closed separately.
+*/
+func (s *Server) Close() error {
+	s.once.Do(s.init)
+	s.lck.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	s.lck.Unlock()
+	return s.h1.Close()
+}
+
+/*
+Hands the HTTP/1.1 connections to the http.Server.
+*/
+type connListener struct{
+	s *Server
+}
+func (l *connListener) Accept() (net.Conn,error) {
+	select {
+	case c := <-l.s.conns: return c,nil
+	case <-l.s.done: return nil,ErrServerClosed
+	}
+}
+func (l *connListener) Close() error { return nil }
+func (l *connListener) Addr() net.Addr { return seepAddr{} }
+
+/*
+A Conn, that tolerates concurrent and repeated calls to Close, as the
+http.Server closes its connections both from Close and from the goroutine
+serving them.
+*/
+type onceConn struct{
+	*seep.Conn
+	once sync.Once
+	err error
+}
+func (c *onceConn) Close() error {
+	c.once.Do(func() { c.err = c.Conn.Close() })
+	return c.err
+}
+
+type seepAddr struct{}
+func (seepAddr) Network() string { return "seep" }
+func (seepAddr) String() string { return "seep" }
+
+/* ------------------------------------------------------------------------- */
+
+/*
+Runs the handshake over conn as the initiator, offering the given protocols,
+and returns the Conn and the protocol selected by the Server, "" if it speaks
+none of them (or there is no response, as with one-way patterns).
+*/
+func Handshake(conn net.Conn, nc noise.Config, o *seep.Options, protos ...string) (*seep.Conn,string,error) {
+	offer,err := encodeTokens(protos...)
+	if err!=nil { return nil,"",err }
+	c := &seep.Connection{Options:o}
+	c.Init()
+	c.Write(offer)
+	nc.Initiator = true
+	err = c.HandshakeStream(conn,conn,nc)
+	if err!=nil { return nil,"",err }
+	if len(protos)==0 || len(c.Payloads)<2 || len(c.Payloads[1].Payload)==0 {
+		return seep.WrapConn(c,conn),"",nil
+	}
+	// The answer is at the start of the early data of the response.
+	var token string
+	p := c.Payloads[1].Payload
+	n := int(p[0])
+	if len(p)>=1+n { token = string(p[1:1+n]) }
+	buf := make([]byte,1+n)
+	_,err = io.ReadFull(c,buf)
+	if err!=nil { return nil,"",err }
+	return seep.WrapConn(c,conn),token,nil
+}
+
+/*
+Connects to addr and runs Handshake.
+*/
+func Dial(network, addr string, nc noise.Config, o *seep.Options, protos ...string) (*seep.Conn,string,error) {
+	conn,err := net.Dial(network,addr)
+	if err!=nil { return nil,"",err }
+	c,token,err := Handshake(conn,nc,o,protos...)
+	if err!=nil { conn.Close() }
+	return c,token,err
+}
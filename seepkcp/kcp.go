@@ -0,0 +1,272 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+SEEP over KCP, a reliable protocol on top of UDP, that retransmits sooner and
+more aggressively than TCP does and thereby trades bandwidth for latency on
+lossy or congested links. The KCP sessions are plain byte streams (KCP's
+stream mode), so the SEEP framing above them is unchanged; KCP's own
+encryption is not used, SEEP provides it.
+
+	l,err := seepkcp.Listen(":7000",&seepkcp.Fast)
+	// ... check error
+	s := &seep.Server{Config:cfg}
+	s.Start(l)
+
+	c,err := seepkcp.Dial("example.com:7000",cfg,nil,&seepkcp.Fast)
+
+Both ends must use the same DataShards and ParityShards. KCP has no close
+handshake and does not notice a peer, that disappeared, by itself: a read
+from such a session blocks until its deadline. Use Options.Typed, so that
+the ends send close frames, read deadlines on long lived connections and
+Server.HandshakeTimeout, as stray packets of a closed session open a new one
+on the Listener.
+*/
+package seepkcp
+
+import "net"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+import "github.com/xtaci/kcp-go/v5"
+
+/*
+The tuning of the KCP sessions. The zero Config is KCP's defaults, which
+behave much like TCP; see Fast.
+*/
+type Config struct{
+	// Enables KCP's nodelay mode: the retransmission timeout grows by half
+	// instead of doubling and starts lower.
+	NoDelay bool
+	// The interval of KCP's internal clock, that paces sending, acks and
+	// retransmissions. 0 is KCP's default of 100ms.
+	Interval time.Duration
+	// If not 0, a segment is retransmitted at once, when this many later
+	// segments were acknowledged (fast retransmit).
+	Resend int
+	// Disables the congestion control; the rate is bounded by the windows
+	// only.
+	NoCongestion bool
+	// The send and receive windows, in segments. 0 is KCP's default of 32.
+	SendWindow int
+	RecvWindow int
+	// The size of the UDP payloads. 0 is KCP's default of 1400.
+	MTU int
+	// Acknowledges every segment at once, instead of on the next tick.
+	AckNoDelay bool
+	// If not 0, Reed-Solomon forward error correction adds ParityShards
+	// parity packets to every DataShards packets, so that lost packets can
+	// be restored without a retransmission.
+	DataShards int
+	ParityShards int
+	// If not 0, handshakes of Dial and DialPacket, that take longer, are
+	// aborted. Over UDP, nothing else notices an unreachable peer.
+	HandshakeTimeout time.Duration
+	// How long a closed session goes on retransmitting the data, that the
+	// peer has not acknowledged yet, such as the close frame. Close
+	// returns at once. 0 is DefaultLinger; if negative, the data is
+	// dropped.
+	Linger time.Duration
+}
+
+/*
+The Linger of the Configs, that leave it 0.
+*/
+const DefaultLinger = 10*time.Second
+
+/*
+A Config for low latency: KCP's "fast" mode with larger windows.
+*/
+var Fast = Config{
+	NoDelay: true,
+	Interval: 10*time.Millisecond,
+	Resend: 2,
+	NoCongestion: true,
+	SendWindow: 1024,
+	RecvWindow: 1024,
+	AckNoDelay: true,
+	HandshakeTimeout: 10*time.Second,
+}
+
+func (c *Config) get() Config {
+	if c==nil { return Config{} }
+	return *c
+}
+
+func (c Config) tune(s *kcp.UDPSession) net.Conn {
+	s.SetStreamMode(true)
+	s.SetWriteDelay(false)
+	nodelay,interval,nc := 0,100,0
+	if c.NoDelay { nodelay = 1 }
+	if c.Interval>0 { interval = int(c.Interval/time.Millisecond) }
+	if c.NoCongestion { nc = 1 }
+	s.SetNoDelay(nodelay,interval,c.Resend,nc)
+	snd,rcv := c.SendWindow,c.RecvWindow
+	if snd<=0 { snd = 32 }
+	if rcv<=0 { rcv = 32 }
+	s.SetWindowSize(snd,rcv)
+	if c.MTU>0 { s.SetMtu(c.MTU) }
+	s.SetACKNoDelay(c.AckNoDelay)
+	linger := c.Linger
+	if linger==0 { linger = DefaultLinger }
+	return &session{UDPSession:s,linger:linger,closed:make(chan struct{})}
+}
+
+/*
+A KCP session, that lingers after Close (see Config.Linger). The UDPSession
+discards the queued data, once it is closed.
+*/
+type session struct{
+	*kcp.UDPSession
+	linger time.Duration
+	once sync.Once
+	closed chan struct{}
+}
+func (s *session) isClosed() bool {
+	select {
+	case <-s.closed: return true
+	default: return false
+	}
+}
+func (s *session) Read(p []byte) (n int, err error) {
+	if s.isClosed() { return 0,net.ErrClosed }
+	n,err = s.UDPSession.Read(p)
+	if err!=nil && s.isClosed() { err = net.ErrClosed }
+	return
+}
+func (s *session) Write(p []byte) (n int, err error) {
+	if s.isClosed() { return 0,net.ErrClosed }
+	return s.UDPSession.Write(p)
+}
+func (s *session) Close() error {
+	err := net.ErrClosed
+	s.once.Do(func() {
+		close(s.closed)
+		err = nil
+		if s.linger<0 {
+			err = s.UDPSession.Close()
+			return
+		}
+		// Wakes up blocked readers.
+		s.UDPSession.SetReadDeadline(time.Now())
+		time.AfterFunc(s.linger,func() { s.UDPSession.Close() })
+	})
+	return err
+}
+
+/*
+Accepts KCP sessions. Start a seep.Server with it, to perform the handshakes.
+The sessions share the Listener's socket; closing the Listener ends them.
+*/
+type Listener struct{
+	l *kcp.Listener
+	cfg Config
+}
+
+/*
+Listens for KCP sessions on the given UDP address.
+*/
+func Listen(addr string, cfg *Config) (*Listener,error) {
+	c := cfg.get()
+	l,err := kcp.ListenWithOptions(addr,nil,c.DataShards,c.ParityShards)
+	if err!=nil { return nil,err }
+	return &Listener{l,c},nil
+}
+
+/*
+Accepts KCP sessions from the packets of conn, for instance a shaped one (see
+seeptest.Shape.WrapPacket). Closing the Listener does not close conn.
+*/
+func NewListener(conn net.PacketConn, cfg *Config) (*Listener,error) {
+	c := cfg.get()
+	l,err := kcp.ServeConn(nil,c.DataShards,c.ParityShards,conn)
+	if err!=nil { return nil,err }
+	return &Listener{l,c},nil
+}
+
+/*
+Returns the next KCP session. Implements net.Listener.
+*/
+func (l *Listener) Accept() (net.Conn,error) {
+	s,err := l.l.AcceptKCP()
+	if err!=nil { return nil,err }
+	return l.cfg.tune(s),nil
+}
+func (l *Listener) Close() error { return l.l.Close() }
+func (l *Listener) Addr() net.Addr { return l.l.Addr() }
+
+/*
+Opens a KCP session to the given UDP address, without a handshake.
+*/
+func DialKCP(addr string, cfg *Config) (net.Conn,error) {
+	c := cfg.get()
+	s,err := kcp.DialWithOptions(addr,nil,c.DataShards,c.ParityShards)
+	if err!=nil { return nil,err }
+	return c.tune(s),nil
+}
+
+/*
+Opens a KCP session to the given UDP address and performs the initiator side
+of the handshake on it.
+*/
+func Dial(addr string, nc noise.Config, o *seep.Options, cfg *Config) (*seep.Conn,error) {
+	conn,err := DialKCP(addr,cfg)
+	if err!=nil { return nil,err }
+	return handshake(conn,nc,o,cfg.get())
+}
+
+/*
+Opens a KCP session to raddr over the packets of conn, without a handshake.
+The session reads all packets from conn. Closing it does not close conn.
+*/
+func DialPacketKCP(conn net.PacketConn, raddr net.Addr, cfg *Config) (net.Conn,error) {
+	c := cfg.get()
+	s,err := kcp.NewConn2(raddr,nil,c.DataShards,c.ParityShards,conn)
+	if err!=nil { return nil,err }
+	return c.tune(s),nil
+}
+
+/*
+Opens a KCP session to raddr over the packets of conn and performs the
+initiator side of the handshake on it. Closing the connection does not close
+conn.
+*/
+func DialPacket(conn net.PacketConn, raddr net.Addr, nc noise.Config, o *seep.Options, cfg *Config) (*seep.Conn,error) {
+	s,err := DialPacketKCP(conn,raddr,cfg)
+	if err!=nil { return nil,err }
+	return handshake(s,nc,o,cfg.get())
+}
+
+func handshake(conn net.Conn, nc noise.Config, o *seep.Options, cfg Config) (*seep.Conn,error) {
+	if cfg.HandshakeTimeout>0 { conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout)) }
+	nc.Initiator = true
+	c,err := seep.NewConn(conn,nc,o)
+	if err!=nil {
+		conn.Close()
+		return nil,err
+	}
+	if cfg.HandshakeTimeout>0 { conn.SetDeadline(time.Time{}) }
+	return c,nil
+}
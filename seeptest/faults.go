@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seeptest
+
+import "errors"
+import "io"
+import "math/rand"
+import "net"
+import "sync"
+import "time"
+
+/*
+Returned by a faulty connection after the injected reset.
+*/
+var ErrInjectedReset = errors.New("seeptest: injected connection reset")
+
+/*
+A schedule of faults, that a wrapped connection injects. All random choices
+are drawn from a source seeded with Seed, so a schedule replays the same
+faults, as long as the calls to Read and Write are the same. Data faults
+(bit flips, short reads and the truncation) affect the data read from the
+connection; wrap the other end to corrupt the opposite direction. A bit
+flipped in a length prefix may leave both peers waiting for data, that never
+comes, so set deadlines.
+
+	c,s := net.Pipe()
+	f := seeptest.Faults{Seed:1,BitFlip:0.1}
+	go server(s)
+	err := client(f.Wrap(c))
+	// ... check, that err is handled
+*/
+type Faults struct{
+	Seed int64
+	// The probability, for each Read, that one random bit of the data is
+	// flipped.
+	BitFlip float64
+	// The probability, for each Read, that it returns fewer bytes, than
+	// it could.
+	ShortRead float64
+	// Each Read and Write is delayed by a random duration up to Delay.
+	Delay time.Duration
+	// If not 0, the stream ends (Read returns io.EOF) after this many
+	// bytes were read.
+	TruncateAfter int64
+	// If not 0, the connection is closed after this many bytes were read
+	// and written in total, for instance in the middle of a handshake;
+	// Read and Write return ErrInjectedReset from then on.
+	ResetAfter int64
+}
+
+/*
+Returns c with the faults of the schedule injected.
+*/
+func (f Faults) Wrap(c net.Conn) net.Conn {
+	return &faultConn{Conn:c,f:f,rnd:rand.New(rand.NewSource(f.Seed))}
+}
+
+type faultConn struct{
+	net.Conn
+	f Faults
+	lck sync.Mutex
+	rnd *rand.Rand
+	read, total int64
+	reset bool
+}
+
+/*
+Draws the delay of the next call, and reserves up to n bytes of the reset
+budget. Returns the number of bytes, that may be transferred, and whether
+the connection has been reset.
+*/
+func (c *faultConn) start(n int) (time.Duration,int,bool) {
+	c.lck.Lock(); defer c.lck.Unlock()
+	var d time.Duration
+	if c.f.Delay>0 { d = time.Duration(c.rnd.Int63n(int64(c.f.Delay))) }
+	if c.reset { return d,0,true }
+	if c.f.ResetAfter>0 && c.total+int64(n)>c.f.ResetAfter {
+		n = int(c.f.ResetAfter-c.total)
+		if n<=0 {
+			c.resetLocked()
+			return d,0,true
+		}
+	}
+	return d,n,false
+}
+func (c *faultConn) resetLocked() {
+	c.reset = true
+	c.Conn.Close()
+}
+
+func (c *faultConn) Read(p []byte) (int,error) {
+	d,n,reset := c.start(len(p))
+	time.Sleep(d)
+	if reset { return 0,ErrInjectedReset }
+	c.lck.Lock()
+	if c.f.TruncateAfter>0 {
+		if c.read>=c.f.TruncateAfter { c.lck.Unlock(); return 0,io.EOF }
+		if rest := c.f.TruncateAfter-c.read; int64(n)>rest { n = int(rest) }
+	}
+	if n>1 && c.rnd.Float64()<c.f.ShortRead { n = 1+c.rnd.Intn(n-1) }
+	c.lck.Unlock()
+	n,err := c.Conn.Read(p[:n])
+	c.lck.Lock(); defer c.lck.Unlock()
+	c.read += int64(n)
+	c.total += int64(n)
+	if n>0 && c.rnd.Float64()<c.f.BitFlip {
+		i := c.rnd.Intn(n*8)
+		p[i/8] ^= 1<<uint(i%8)
+	}
+	return n,err
+}
+
+func (c *faultConn) Write(p []byte) (int,error) {
+	d,n,reset := c.start(len(p))
+	time.Sleep(d)
+	if reset { return 0,ErrInjectedReset }
+	n,err := c.Conn.Write(p[:n])
+	c.lck.Lock(); defer c.lck.Unlock()
+	c.total += int64(n)
+	if err==nil && n<len(p) {
+		// The reset budget ran out in the middle of p.
+		c.resetLocked()
+		return n,ErrInjectedReset
+	}
+	return n,err
+}
@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Package seeptest provides fixtures for testing code, that uses SEEP:
+deterministic key pairs, Connections, that completed their handshake over a
+net.Pipe, and an RPC client connected to a server.
+
+	func TestEcho(t *testing.T) {
+		c,s := seeptest.Pipe(t,nil)
+		go io.Copy(s,s)
+		// ... use c
+	}
+
+	func TestService(t *testing.T) {
+		client := seeptest.RPC(t,new(MyService),nil,nil)
+		err := client.Call("MyService.Method",args,&reply)
+		// ...
+	}
+
+Everything is torn down by t.Cleanup.
+*/
+package seeptest
+
+import "bytes"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/binary"
+import "net"
+import "net/rpc"
+import "testing"
+import "github.com/flynn/noise"
+import "github.com/mad-day/seep"
+
+/*
+The cipher suite of the fixtures.
+*/
+var Suite = noise.NewCipherSuite(noise.DH25519,noise.CipherChaChaPoly,noise.HashBLAKE2s)
+
+/*
+Returns the n-th of a fixed sequence of static key pairs: the same n always
+yields the same key pair, in every run and on every host. Never use them
+outside tests.
+*/
+func Keypair(n int) noise.DHKey {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:],uint64(n))
+	seed := sha256.Sum256(append([]byte("seeptest keypair "),b[:]...))
+	k,err := Suite.GenerateKeypair(bytes.NewReader(seed[:]))
+	if err!=nil { panic(err) }
+	return k
+}
+
+/*
+Returns the configurations of the client (the initiator, with Keypair(0)) and
+the server (with Keypair(1)) for a handshake using the given pattern. The
+peer's static key is set, where the pattern has it as a pre-message. The
+ephemeral keys are random.
+*/
+func Configs(pattern noise.HandshakePattern) (noise.Config,noise.Config) {
+	ck,sk := Keypair(0),Keypair(1)
+	c := noise.Config{CipherSuite:Suite,Pattern:pattern,Initiator:true,StaticKeypair:ck,Random:rand.Reader}
+	s := noise.Config{CipherSuite:Suite,Pattern:pattern,StaticKeypair:sk,Random:rand.Reader}
+	if len(pattern.ResponderPreMessages)>0 { c.PeerStatic = sk.Public }
+	if len(pattern.InitiatorPreMessages)>0 { s.PeerStatic = ck.Public }
+	return c,s
+}
+
+/*
+Returns a client and a server Connection, that completed an XX handshake over
+a net.Pipe, using Options o (nil for the defaults) on both sides. Fails t, if
+the handshake fails. Note that writes to a net.Pipe block until the peer
+reads.
+*/
+func Pipe(t testing.TB, o *seep.Options) (*seep.Connection,*seep.Connection) {
+	t.Helper()
+	cc,sc := Configs(noise.HandshakeXX)
+	a,b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	c := &seep.Connection{Options:o}
+	s := &seep.Connection{Options:o}
+	c.Init()
+	s.Init()
+	ch := make(chan error,1)
+	go func() { ch <- s.HandshakeStream(b,b,sc) }()
+	err := c.HandshakeStream(a,a,cc)
+	if e := <-ch; err==nil { err = e }
+	if err!=nil { t.Fatal("seeptest: handshake failed: ",err) }
+	return c,s
+}
+
+/*
+Returns an RPC client connected over a net.Pipe to a server, that serves rcvr
+(see rpc.Server.Register), using the RPC format f (nil means XDR) and Options
+o on both sides. Fails t, if rcvr can't be registered or the handshake fails.
+*/
+func RPC(t testing.TB, rcvr interface{}, f *seep.RpcFormat, o *seep.Options) *rpc.Client {
+	t.Helper()
+	srv := rpc.NewServer()
+	err := srv.Register(rcvr)
+	if err!=nil { t.Fatal("seeptest: ",err) }
+	cc,sc := Configs(noise.HandshakeXX)
+	a,b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	go func() {
+		codec,err := seep.NewStreamRpcSource(b,b,sc,nil,f,o)
+		if err!=nil { return }
+		srv.ServeCodec(codec)
+	}()
+	codec,err := seep.NewStreamRpcClient(a,a,cc,nil,f,o)
+	if err!=nil { t.Fatal("seeptest: handshake failed: ",err) }
+	client := rpc.NewClientWithCodec(codec)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,202 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seeptest
+
+import "math/rand"
+import "net"
+import "sync"
+import "time"
+
+/*
+The network conditions emulated by a shaped connection, as in WAN links. A
+shaped connection delays the data it writes by half of RTT, plus a random
+jitter, and limits the rate at which it writes to Bandwidth; wrap both ends
+to shape both directions. Write returns, once the data left the emulated
+link's sender, so a slow link pushes back on the writer, but the delay does
+not. The random choices are drawn from a source seeded with Seed.
+
+	c,s := net.Pipe()
+	wan := seeptest.Shape{RTT:80*time.Millisecond,Jitter:5*time.Millisecond,Bandwidth:1<<20}
+	go server(wan.Wrap(s))
+	client(wan.Wrap(c))
+*/
+type Shape struct{
+	Seed int64
+	// The round trip time; each direction adds half of it.
+	RTT time.Duration
+	// A random delay up to Jitter is added to the delay of each write. On
+	// stream connections, the order of the data is kept.
+	Jitter time.Duration
+	// The bandwidth in bytes per second, or 0 for no limit.
+	Bandwidth int64
+	// The probability, that a datagram is lost; see WrapPacket. Streams
+	// do not lose data.
+	Loss float64
+}
+
+/*
+The state of a shaped sender.
+*/
+type shaper struct{
+	s Shape
+	lck sync.Mutex
+	rnd *rand.Rand
+	// When the link is free to send again, and when the last data arrives.
+	free, last time.Time
+}
+
+func newShaper(s Shape) *shaper {
+	return &shaper{s:s,rnd:rand.New(rand.NewSource(s.Seed))}
+}
+
+/*
+Reserves the link for n bytes. Returns when they are sent, when they arrive,
+and whether they are lost. If ordered is set, they arrive after all data
+sent before.
+*/
+func (s *shaper) schedule(n int, ordered bool) (time.Time,time.Time,bool) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	now := time.Now()
+	if s.free.Before(now) { s.free = now }
+	if s.s.Bandwidth>0 {
+		s.free = s.free.Add(time.Duration(int64(n)*int64(time.Second)/s.s.Bandwidth))
+	}
+	d := s.s.RTT/2
+	if s.s.Jitter>0 { d += time.Duration(s.rnd.Int63n(int64(s.s.Jitter))) }
+	at := s.free.Add(d)
+	if ordered {
+		if at.Before(s.last) { at = s.last }
+		s.last = at
+	}
+	return s.free,at,s.rnd.Float64()<s.s.Loss
+}
+
+/*
+Returns c with the network conditions of s.
+*/
+func (s Shape) Wrap(c net.Conn) net.Conn {
+	sc := &shapedConn{Conn:c,sh:newShaper(s),queue:make(chan shapedData,64)}
+	go sc.deliver()
+	return sc
+}
+
+type shapedData struct{
+	b []byte
+	at time.Time
+}
+
+type shapedConn struct{
+	net.Conn
+	sh *shaper
+	queue chan shapedData
+	// Held for reading by Write, so that Close does not close the queue
+	// under it.
+	lck sync.RWMutex
+	closed bool
+	// The error of the connection, that ended the delivery.
+	errLck sync.Mutex
+	err error
+}
+
+/*
+Writes the queued data to the connection, each at its time of arrival. After
+Close, the rest of the queue is still delivered, before the connection is
+closed.
+*/
+func (c *shapedConn) deliver() {
+	defer c.Conn.Close()
+	for d := range c.queue {
+		time.Sleep(time.Until(d.at))
+		_,err := c.Conn.Write(d.b)
+		if err!=nil {
+			c.errLck.Lock()
+			c.err = err
+			c.errLck.Unlock()
+			for range c.queue {}
+			return
+		}
+	}
+}
+
+func (c *shapedConn) Write(p []byte) (int,error) {
+	c.errLck.Lock()
+	err := c.err
+	c.errLck.Unlock()
+	if err!=nil { return 0,err }
+	c.lck.RLock()
+	if c.closed { c.lck.RUnlock(); return 0,net.ErrClosed }
+	sent,at,_ := c.sh.schedule(len(p),true)
+	c.queue <- shapedData{append([]byte(nil),p...),at}
+	c.lck.RUnlock()
+	time.Sleep(time.Until(sent))
+	return len(p),nil
+}
+
+func (c *shapedConn) Read(p []byte) (int,error) {
+	c.lck.RLock()
+	closed := c.closed
+	c.lck.RUnlock()
+	if closed { return 0,net.ErrClosed }
+	return c.Conn.Read(p)
+}
+
+/*
+Closes the connection, once the data in flight is delivered. Close does not
+wait for it.
+*/
+func (c *shapedConn) Close() error {
+	c.lck.Lock(); defer c.lck.Unlock()
+	if c.closed { return net.ErrClosed }
+	c.closed = true
+	close(c.queue)
+	return nil
+}
+
+/*
+Returns c with the network conditions of s. Each datagram written is lost
+with the probability Loss, and may overtake the ones sent before, if Jitter
+is set.
+*/
+func (s Shape) WrapPacket(c net.PacketConn) net.PacketConn {
+	return &shapedPacketConn{PacketConn:c,sh:newShaper(s)}
+}
+
+type shapedPacketConn struct{
+	net.PacketConn
+	sh *shaper
+}
+
+func (c *shapedPacketConn) WriteTo(p []byte, addr net.Addr) (int,error) {
+	sent,at,lost := c.sh.schedule(len(p),false)
+	if !lost {
+		b := append([]byte(nil),p...)
+		time.AfterFunc(time.Until(at),func() {
+			// A datagram, that cannot be written, is lost.
+			c.PacketConn.WriteTo(b,addr)
+		})
+	}
+	time.Sleep(time.Until(sent))
+	return len(p),nil
+}
@@ -0,0 +1,267 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "errors"
+import "net"
+import "sync"
+import "time"
+import "github.com/flynn/noise"
+
+var ErrBanned = newError(ErrHandshakeFailed,"seep: peer is temporarily banned")
+var ErrServerClosed = errors.New("seep: server closed")
+
+/*
+A Conn is an established Connection over a net.Conn, that implements net.Conn
+itself.
+*/
+type Conn struct{
+	*Connection
+	conn net.Conn
+	// The Server, that accepted the connection, see Server.Stats.
+	server *Server
+}
+
+/*
+Performs the handshake over conn and returns the established Conn.
+*/
+func NewConn(conn net.Conn, nc noise.Config, o *Options) (*Conn,error) {
+	c := &Connection{Options:o}
+	c.Init()
+	err := c.HandshakeStream(conn,conn,nc)
+	if err!=nil { return nil,err }
+	return &Conn{Connection:c,conn:conn},nil
+}
+
+/*
+Returns the Conn of a Connection, that completed its handshake over conn, for
+instance with early data written before the handshake.
+*/
+func WrapConn(c *Connection, conn net.Conn) *Conn {
+	return &Conn{Connection:c,conn:conn}
+}
+
+/*
+Connects to the given address and performs the handshake as initiator.
+*/
+func Dial(network, addr string, nc noise.Config, o *Options) (*Conn,error) {
+	conn,err := net.Dial(network,addr)
+	if err!=nil { return nil,err }
+	nc.Initiator = true
+	c,err := NewConn(conn,nc,o)
+	if err!=nil { conn.Close() }
+	return c,err
+}
+
+/*
+Flushes buffered data, sends a close frame (with Options.Typed), closes the
+underlying connection and burns the keys (see Connection.Burn).
+*/
+func (c *Conn) Close() error {
+	if w,ok := c.Writer.(*Writer); ok {
+		w.Flush()
+		if w.opts.Typed { w.Close() }
+	}
+	err := c.conn.Close()
+	if c.server!=nil { c.server.forget(c) }
+	c.Burn()
+	return err
+}
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+/*
+A Server accepts connections from a net.Listener and performs the responder
+side of the handshake on each of them in its own goroutine, so that slow
+peers don't hold up others. Once started, it is a net.Listener, whose Accept
+returns the established connections as *Conn.
+
+	s := &seep.Server{Config:cfg,Limiter:new(seep.FailureLimiter)}
+	err := s.Listen("tcp",":7000")
+	// ... check error
+	for {
+		c,err := s.Accept()
+		// ...
+	}
+*/
+type Server struct{
+	// The handshake configuration. Initiator is ignored.
+	Config noise.Config
+	Options *Options
+	// If not 0, handshakes, that take longer, are aborted.
+	HandshakeTimeout time.Duration
+	// If not nil, failures are recorded per remote IP address and per
+	// static key fingerprint (see Fingerprint), and banned peers are
+	// refused: addresses before the handshake, keys as soon as the
+	// handshake reveals them.
+	Limiter *FailureLimiter
+	// If not 0, a failed handshake is not closed before this long after it
+	// started, so that the time it takes does not reveal, which check
+	// failed. Should exceed the time a handshake normally takes.
+	FailureDelay time.Duration
+	// Bounds the memory held by handshakes in progress.
+	Pending PendingLimits
+
+	l net.Listener
+	opts Options
+	pending *pendingTable
+	conns chan *Conn
+	done chan struct{}
+	lck sync.Mutex
+	err error
+	// The connections established, see Stats.
+	live map[*Conn]struct{}
+	accepted uint64
+	closed Counters
+}
+
+/*
+Listens on the given address and starts the Server.
+*/
+func (s *Server) Listen(network, addr string) error {
+	l,err := net.Listen(network,addr)
+	if err!=nil { return err }
+	s.Start(l)
+	return nil
+}
+
+/*
+Starts accepting connections from l.
+*/
+func (s *Server) Start(l net.Listener) {
+	s.l = l
+	s.opts = s.Options.get()
+	s.pending = newPendingTable(s.Pending,s.opts)
+	s.conns = make(chan *Conn)
+	s.done = make(chan struct{})
+	if s.Limiter!=nil { s.limit() }
+	go s.run()
+}
+
+/*
+Hooks the Limiter into the Audit and VerifyPeer callbacks of the Options.
+*/
+func (s *Server) limit() {
+	audit,verify := s.opts.Audit,s.opts.VerifyPeer
+	s.opts.Audit = func(a *HandshakeAudit) {
+		// Evicted handshakes are not the peer's fault.
+		if a.Err!=nil && a.Err!=ErrHandshakeEvicted {
+			if a.RemoteAddr!=nil { s.Limiter.Fail(addrKey(a.RemoteAddr)) }
+			if a.PeerStatic!=nil { s.Limiter.Fail(Fingerprint(a.PeerStatic)) }
+		}
+		if audit!=nil { audit(a) }
+	}
+	// Without a verifier of its own, RequirePeerAuth must still refuse.
+	if verify==nil && s.opts.RequirePeerAuth { return }
+	s.opts.VerifyPeer = func(static []byte) error {
+		if len(static)>0 && s.Limiter.Banned(Fingerprint(static)) { return ErrBanned }
+		if verify!=nil { return verify(static) }
+		return nil
+	}
+}
+
+/*
+The key of an address for the Limiter: its IP address, without the port.
+*/
+func addrKey(a net.Addr) string {
+	host,_,err := net.SplitHostPort(a.String())
+	if err!=nil { return a.String() }
+	return host
+}
+
+func (s *Server) run() {
+	for {
+		conn,err := s.l.Accept()
+		if err!=nil {
+			if ne,ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5*time.Millisecond)
+				continue
+			}
+			s.shutdown(err)
+			return
+		}
+		go s.handshake(conn)
+	}
+}
+func (s *Server) handshake(conn net.Conn) {
+	if s.Limiter!=nil && s.Limiter.Banned(addrKey(conn.RemoteAddr())) {
+		conn.Close()
+		return
+	}
+	start := time.Now()
+	if s.HandshakeTimeout>0 { conn.SetDeadline(start.Add(s.HandshakeTimeout)) }
+	nc := s.Config
+	nc.Initiator = false
+	p := s.pending.add(conn)
+	c,err := NewConn(p,nc,&s.opts)
+	s.pending.remove(p)
+	if err!=nil {
+		if d := s.FailureDelay-time.Since(start); d>0 {
+			select {
+			case <-time.After(d):
+			case <-s.done:
+			}
+		}
+		conn.Close()
+		return
+	}
+	if s.HandshakeTimeout>0 { conn.SetDeadline(time.Time{}) }
+	s.track(c)
+	select {
+	case s.conns <- c:
+	case <-s.done:
+		c.Close()
+	}
+}
+func (s *Server) shutdown(err error) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	if s.err!=nil { return }
+	s.err = err
+	close(s.done)
+}
+
+/*
+Returns the next established connection. Implements net.Listener.
+*/
+func (s *Server) Accept() (net.Conn,error) {
+	select {
+	case c := <-s.conns: return c,nil
+	case <-s.done:
+	}
+	s.lck.Lock(); defer s.lck.Unlock()
+	return nil,s.err
+}
+
+/*
+Closes the listener. Connections already accepted stay open.
+*/
+func (s *Server) Close() error {
+	s.shutdown(ErrServerClosed)
+	return s.l.Close()
+}
+func (s *Server) Addr() net.Addr { return s.l.Addr() }
@@ -31,47 +31,366 @@ loosely based on SEEP and an encoding format (XDR and GOB are supported).
 package seep
 
 import "io"
+import "net"
 import "sync"
+import "time"
 import "bytes"
+import "context"
 import "github.com/flynn/noise"
 import "github.com/davecgh/go-xdr/xdr2"
 
 type Reader struct{
 	lck sync.Mutex
-	src *xdr.Decoder
-	dec *noise.CipherState
+	frameReader
+	// Data received during the handshake.
 	buf bytes.Buffer
+	// The unread rest of the current frame, and the pooled buffer holding it.
+	cur []byte
+	pooled *[]byte
 }
+
+/*
+Buffers, the Reader decrypts frames into. The frames of an RPC codec are never
+decrypted into pooled buffers, as formats may retain them.
+*/
+var readPool = sync.Pool{New:func() interface{} {
+	b := make([]byte,0,noise.MaxMsgLen)
+	return &b
+}}
+
+/*
+If no more than this many bytes of a frame, that has been decrypted into a
+pooled buffer, are left unread, they are copied out, so that the pooled buffer
+can be returned. An idle connection thus never pins a full-sized buffer.
+*/
+const retainMax = 4096
+
+func (r *Reader) release() {
+	r.cur = nil
+	if r.pooled!=nil {
+		readPool.Put(r.pooled)
+		r.pooled = nil
+	}
+}
+/*
+Reads decrypted data. Unless Options.ReadFull is set, Read returns at most the
+rest of the current frame.
+*/
 func (r *Reader) Read(p []byte) (n int, err error){
 	r.lck.Lock(); defer r.lck.Unlock()
-	if r.buf.Len()==0 {
-		buf,_,e := r.src.DecodeOpaque()
-		if e!=nil { err = e; return }
-		buf,e = r.dec.Decrypt(nil,nil,buf)
-		if e!=nil { err = e; return }
-		r.buf.Write(buf)
+	if !r.opts.ReadFull { return r.read(p) }
+	for n<len(p) {
+		var m int
+		m,err = r.read(p[n:])
+		n += m
+		if err!=nil {
+			if err==io.EOF && n>0 { err = io.ErrUnexpectedEOF }
+			return
+		}
 	}
-	return r.buf.Read(p)
+	return
+}
+func (r *Reader) read(p []byte) (n int, err error){
+	if r.buf.Len()>0 {
+		n,err = r.buf.Read(p)
+		// Drop the buffer, the handshake data is never read again.
+		if r.buf.Len()==0 { r.buf = bytes.Buffer{} }
+		return
+	}
+	for len(r.cur)==0 {
+		r.release()
+		if r.ahead!=nil {
+			a := r.wait()
+			if a.err!=nil { return 0,a.err }
+			r.cur,r.pooled = a.buf,a.b
+			if r.opts.ReadAhead { r.prefetch() }
+			continue
+		}
+		// Frames, that fit into p, are decrypted into it directly, others
+		// into a pooled buffer.
+		b := readPool.Get().(*[]byte)
+		direct := false
+		dst := func(l int) []byte {
+			direct = l<=len(p)
+			if direct { return p[:0] }
+			return (*b)[:0]
+		}
+		buf,e := r.readFrameTo(dst)
+		if e!=nil || direct { readPool.Put(b) }
+		if e!=nil {
+			err = e
+			return
+		}
+		if r.opts.ReadAhead { r.prefetch() }
+		if !direct {
+			r.cur,r.pooled = buf,b
+			continue
+		}
+		// buf lies in p, unless it has been decompressed.
+		n = copy(p,buf)
+		r.cur = buf[n:]
+		if n>0 { return }
+	}
+	n = copy(p,r.cur)
+	r.cur = r.cur[n:]
+	if len(r.cur)==0 {
+		r.release()
+	} else if r.pooled!=nil && len(r.cur)<=retainMax {
+		rest := append([]byte(nil),r.cur...)
+		r.release()
+		r.cur = rest
+	}
+	return
+}
+/*
+Writes the decrypted data to w until the peer closes the stream, decrypting
+into pooled buffers. Implements io.WriterTo.
+*/
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	r.lck.Lock(); defer r.lck.Unlock()
+	if r.buf.Len()>0 {
+		n,err = r.buf.WriteTo(w)
+		if err!=nil { return }
+		r.buf = bytes.Buffer{}
+	}
+	b := readPool.Get().(*[]byte)
+	defer readPool.Put(b)
+	for {
+		buf := r.cur
+		if len(buf)==0 {
+			r.release()
+			buf,err = r.readFrameTo(func(int) []byte { return (*b)[:0] })
+			if err==io.EOF { return n,nil }
+			if err!=nil { return }
+		}
+		r.cur = nil
+		m,e := w.Write(buf)
+		n += int64(m)
+		if e!=nil { return n,e }
+	}
+}
+
+/*
+Reads the next message written with WriteMessage, that is, the payload of the
+next data frame. If a previous Read left a part of a frame unconsumed, that
+part is returned first.
+*/
+func (r *Reader) ReadMessage() ([]byte,error) {
+	r.lck.Lock(); defer r.lck.Unlock()
+	if r.buf.Len()>0 {
+		p := append([]byte(nil),r.buf.Bytes()...)
+		r.buf = bytes.Buffer{}
+		return p,nil
+	}
+	if len(r.cur)>0 {
+		p := append([]byte(nil),r.cur...)
+		r.release()
+		return p,nil
+	}
+	return r.readFrame()
 }
 func NewReader(src *xdr.Decoder,dec *noise.CipherState) *Reader {
-	return &Reader{src:src,dec:dec}
+	return NewReaderOptions(src,dec,nil)
+}
+func NewReaderOptions(src *xdr.Decoder,dec *noise.CipherState,o *Options) *Reader {
+	return &Reader{frameReader:frameReader{src:&xdrFramer{src:src},dec:dec,opts:o.get()}}
+}
+
+/*
+Creates a Reader, that reads frames from f.
+*/
+func NewFramedReader(f Framer,dec *noise.CipherState,o *Options) *Reader {
+	return &Reader{frameReader:frameReader{src:f,dec:dec,opts:o.get()}}
+}
+
+/*
+Creates a Reader, that reads frames from r, delimited according to
+o.Framing.
+*/
+func NewStreamReader(r io.Reader,dec *noise.CipherState,o *Options) *Reader {
+	return &Reader{frameReader:frameReader{src:newFramer(r,nil,o.get()),dec:dec,opts:o.get()}}
 }
 
 type Writer struct{
 	lck sync.Mutex
-	dst *xdr.Encoder
-	enc *noise.CipherState
+	frameWriter
+	// Data buffered by Write, if Options.WriteBuffer is set.
+	wbuf []byte
+	// The pending auto-flush (see Options.FlushDelay) and the error of the
+	// last one.
+	timer *time.Timer
+	ferr error
 }
 func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.opts.WriteBuffer<=0 { return w.WriteCompressed(p,w.opts.Compressor) }
+	w.lck.Lock(); defer w.lck.Unlock()
+	size := w.opts.WriteBuffer
+	if max := w.chunkSize(); size>max { size = max }
+	if w.ferr!=nil { return 0,w.ferr }
+	w.wbuf = append(w.wbuf,p...)
+	if len(w.wbuf)>=size {
+		err = w.flush()
+	} else if w.opts.FlushDelay>0 && w.timer==nil {
+		w.timer = time.AfterFunc(w.opts.FlushDelay,w.autoFlush)
+	}
+	return len(p),err
+}
+func (w *Writer) autoFlush() {
+	w.lck.Lock(); defer w.lck.Unlock()
+	w.timer = nil
+	if err := w.flush(); err!=nil { w.ferr = err }
+}
+
+/*
+Writes the data buffered by Write, see Options.WriteBuffer. With
+Options.Pipeline, Flush waits until all frames have been written.
+*/
+func (w *Writer) Flush() error {
+	w.lck.Lock(); defer w.lck.Unlock()
+	err := w.flush()
+	if err!=nil { return err }
+	if w.pipe!=nil { return w.pipe.drain() }
+	return nil
+}
+func (w *Writer) flush() error {
+	if w.timer!=nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.ferr!=nil { return w.ferr }
+	if len(w.wbuf)==0 { return nil }
+	_,err := w.writeChunks(w.wbuf,w.opts.Compressor)
+	w.wbuf = w.wbuf[:0]
+	return err
+}
+
+/*
+Writes p using the given compressor instead of the default one. Has no effect
+on the compression, if the Writer's Options don't enable Compression.
+
+Writes, that exceed Options.ChunkSize (by default the maximum Noise message
+size), are split into multiple frames.
+*/
+func (w *Writer) WriteCompressed(p []byte, comp uint8) (n int, err error) {
 	w.lck.Lock(); defer w.lck.Unlock()
-	buf := w.enc.Encrypt(nil,nil,p)
-	_,e := w.dst.EncodeOpaque(buf)
-	if e!=nil { err = e; return }
-	n = len(p)
+	err = w.flush()
+	if err!=nil { return }
+	return w.writeChunks(p,comp)
+}
+func (w *Writer) writeChunks(p []byte, comp uint8) (n int, err error) {
+	max := w.chunkSize()
+	for len(p)>0 {
+		chunk := p
+		if len(chunk)>max { chunk = chunk[:max] }
+		e := w.writeFrame(chunk,comp)
+		if e!=nil { err = e; return }
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
 	return
 }
+/*
+Reads from r until io.EOF and writes the data in frames of Options.ChunkSize.
+Implements io.ReaderFrom.
+*/
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	w.lck.Lock(); defer w.lck.Unlock()
+	err = w.flush()
+	if err!=nil { return }
+	b := readPool.Get().(*[]byte)
+	defer readPool.Put(b)
+	buf := (*b)[:w.chunkSize()]
+	for {
+		m,e := r.Read(buf)
+		if m>0 {
+			err = w.writeFrame(buf[:m],w.opts.Compressor)
+			if err!=nil { return }
+			n += int64(m)
+		}
+		if e==io.EOF { return n,nil }
+		if e!=nil { return n,e }
+	}
+}
+
+/*
+Writes p as exactly one frame, so that the peer receives it as a whole from
+ReadMessage. Messages exceeding the frame size are rejected with
+ErrFrameTooLarge.
+*/
+func (w *Writer) WriteMessage(p []byte) error {
+	w.lck.Lock(); defer w.lck.Unlock()
+	if len(p)>w.maxPayload() { return ErrFrameTooLarge }
+	err := w.flush()
+	if err!=nil { return err }
+	return w.writeFrame(p,w.opts.Compressor)
+}
+
+/*
+Writes a control frame of the given type. Requires Options.Typed.
+*/
+func (w *Writer) WriteControl(typ uint8, p []byte) error {
+	w.lck.Lock(); defer w.lck.Unlock()
+	err := w.flush()
+	if err!=nil { return err }
+	return w.writeControl(typ,p)
+}
+
+/*
+Sends a keepalive frame, that is silently dropped by the peer.
+*/
+func (w *Writer) Keepalive() error {
+	return w.WriteControl(FrameKeepalive,nil)
+}
+
+/*
+Replaces the sending key with a new one derived from it. The peer's Reader
+follows automatically.
+*/
+func (w *Writer) Rekey() error {
+	return w.WriteControl(FrameRekey,nil)
+}
+
+/*
+Sends a close frame and burns the Writer (see Burn). The peer's Reader returns
+io.EOF after it. The underlying stream is not closed.
+*/
+func (w *Writer) Close() error {
+	return w.closeWith(FrameClose,nil)
+}
+/*
+Sends an error frame carrying code and msg and burns the Writer. The peer's
+Reader returns a *PeerError after it. The underlying stream is not closed.
+*/
+func (w *Writer) CloseWithError(code uint32, msg string) error {
+	return w.closeWith(FrameError,(&PeerError{code,msg}).encode())
+}
+func (w *Writer) closeWith(typ uint8, p []byte) error {
+	err := w.WriteControl(typ,p)
+	if err!=nil { return err }
+	w.logClose(typ)
+	w.Burn()
+	return nil
+}
 func NewWriter(dst *xdr.Encoder,enc *noise.CipherState) *Writer {
-	return &Writer{dst:dst,enc:enc}
+	return NewWriterOptions(dst,enc,nil)
+}
+func NewWriterOptions(dst *xdr.Encoder,enc *noise.CipherState,o *Options) *Writer {
+	return &Writer{frameWriter:frameWriter{dst:&xdrFramer{dst:dst},enc:enc,opts:o.get()}}
+}
+
+/*
+Creates a Writer, that writes frames to f.
+*/
+func NewFramedWriter(f Framer,enc *noise.CipherState,o *Options) *Writer {
+	return &Writer{frameWriter:frameWriter{dst:f,enc:enc,opts:o.get()}}
+}
+
+/*
+Creates a Writer, that writes frames to w, delimited according to
+o.Framing.
+*/
+func NewStreamWriter(w io.Writer,enc *noise.CipherState,o *Options) *Writer {
+	return &Writer{frameWriter:frameWriter{dst:newFramer(nil,w,o.get()),enc:enc,opts:o.get()}}
 }
 
 /*
@@ -89,9 +408,35 @@ using a private key, so no secrecy for this data is given.
 	// use c as Reader and Writer
 */
 type Connection struct {
+	// First, to be 64-bit aligned for the atomic operations. See ID.
+	id uint64
+	
 	io.Writer
 	io.Reader
 	
+	// Options for the encrypted connection. Must be set before Handshake.
+	Options *Options
+	
+	// After Handshake, the payloads of the handshake messages in the order,
+	// they were sent or received, with their security properties.
+	Payloads []HandshakePayload
+	
+	// The address of the peer, reported to Options.Audit. HandshakeStream
+	// sets it, if the reader has a RemoteAddr method, as net.Conn does.
+	RemoteAddr net.Addr
+	
+	// The context of the Connection, passed to Options.Trace. After a
+	// successful handshake, it is replaced by the context of the session
+	// returned by Options.Trace. Nil means context.Background().
+	Context context.Context
+	
+	// The handshake hash of the current session.
+	hash []byte
+	// Whether this side initiated the handshake.
+	initiator bool
+	// The record of the handshake, see Session.
+	session *HandshakeAudit
+	
 	outbuf *bytes.Buffer
 	inbuf  *bytes.Buffer
 }
@@ -101,42 +446,110 @@ func (c *Connection) Init() {
 	c.Writer = c.outbuf
 	c.Reader = c.inbuf
 }
+/*
+Flushes the data buffered by the Writer, see Options.WriteBuffer.
+*/
+func (c *Connection) Flush() error {
+	if w,ok := c.Writer.(*Writer); ok { return w.Flush() }
+	return nil
+}
+
+/*
+Implements io.ReaderFrom, so that io.Copy uses the fast path of the Writer.
+*/
+func (c *Connection) ReadFrom(r io.Reader) (int64,error) {
+	return io.Copy(c.Writer,r)
+}
+
+/*
+Implements io.WriterTo, so that io.Copy uses the fast path of the Reader.
+*/
+func (c *Connection) WriteTo(w io.Writer) (int64,error) {
+	return io.Copy(w,c.Reader)
+}
 func (c *Connection) Handshake(src *xdr.Decoder, dst *xdr.Encoder,nc noise.Config) error {
-	var o,i *noise.CipherState
-	state := nc.Initiator
+	return c.handshake(&xdrFramer{src:src,dst:dst},nc)
+}
+
+/*
+Like Handshake, but reads from r and writes to w directly, using the framing
+selected by c.Options.Framing.
+*/
+func (c *Connection) HandshakeStream(r io.Reader, w io.Writer,nc noise.Config) error {
+	if a := addrOf(r); a!=nil { c.RemoteAddr = a }
+	return c.handshake(newFramer(r,w,c.Options.get()),nc)
+}
+/*
+Like Handshake, but exchanges the handshake messages and frames using f.
+*/
+func (c *Connection) HandshakeFramer(f Framer,nc noise.Config) error {
+	return c.handshake(f,nc)
+}
+func (c *Connection) handshake(f Framer,nc noise.Config) error {
 	l := c.outbuf.Len()
 	if l>0x1000 {
 		nm := len(nc.Pattern.Messages)
-		if state { nm++ }
+		if nc.Initiator { nm++ }
 		nm/=2
 		l2 := nm*0x1000
 		if l2<l { l = (l/nm)+1 } else { l = 0x1000 }
 	}
-	hs := noise.NewHandshakeState(nc)
-	for {
-		if state {
-			buf := c.outbuf.Next(l)
-			buf,o,i = hs.WriteMessage(nil,buf)
-			_,e := dst.EncodeOpaque(buf)
-			if e!=nil { return e }
-			state = false
-			if o!=nil { break }
+	c.Payloads = nil
+	opts := c.Options.get()
+	annotate := func(out bool, b []byte) {
+		c.Payloads = append(c.Payloads,HandshakePayload{out,append([]byte(nil),b...),PayloadProperties(nc.Pattern,len(c.Payloads))})
+	}
+	// The response to the initiation, see Options.Respond.
+	var resp []byte
+	payload := func() []byte {
+		b := c.outbuf.Next(l)
+		if resp!=nil {
+			b = append(resp,b...)
+			resp = nil
+		}
+		annotate(true,b)
+		return b
+	}
+	recv := func(b []byte) {
+		annotate(false,b)
+		if opts.Respond!=nil && !nc.Initiator && len(c.Payloads)==1 {
+			resp = opts.Respond(b)
+			return
 		}
-		buf,_,e := src.DecodeOpaque()
-		if e!=nil { return e }
-		buf,i,o,e = hs.ReadMessage(nil,buf)
-		if e!=nil { return e }
-		c.inbuf.Write(buf)
-		state = true
-		if o!=nil { break }
-	}
-	w := NewWriter(dst,o)
-	r := NewReader(src,i)
+		c.inbuf.Write(b)
+	}
+	err := checkConfig(opts,nc)
+	if err!=nil { return err }
+	audit,verify := startAudit(opts,c.ID(),c.RemoteAddr,nc)
+	trace := c.trace(opts,audit)
+	hs,o,i,err := runHandshake(f,nc,payload,recv,verify,opts.ReplayCache)
+	log := audit.finish(opts,hs,err)
+	trace(audit)
+	if err!=nil { return err }
+	logKeys(opts.KeyLogWriter,opts.KeyLogStatic,hs,nc)
+	c.hash = hs.ChannelBinding()
+	burnHandshake(hs)
+	c.initiator = nc.Initiator
+	c.session = audit
+	sess := opts.Metrics.start()
+	w := &Writer{frameWriter:frameWriter{dst:f,enc:o,opts:opts,log:log,sess:sess,conn:c.ID()}}
+	r := &Reader{frameReader:frameReader{src:f,dec:i,opts:opts,audit:audit,log:log,sess:sess,conn:c.ID()}}
+	setInner(&w.frameWriter,&r.frameReader,opts,nc,c.hash)
 	r.buf.ReadFrom(c.inbuf)
 	c.Writer = w
 	c.Reader = r
+	// The early data has been sent, or copied to the Reader.
+	wipeBuffer(c.inbuf)
+	wipeBuffer(c.outbuf)
 	c.inbuf = nil
 	c.outbuf = nil
+	if opts.Password!=nil {
+		err = c.AuthenticatePassword(opts.Password)
+		if err!=nil {
+			opts.Metrics.handshake(err)
+			sess.end()
+		}
+		return err
+	}
 	return nil
 }
-
@@ -0,0 +1,117 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "sync/atomic"
+import "time"
+
+/*
+A snapshot of the state of a session, see Connection.Stats.
+*/
+type Stats struct{
+	// The ID of the Connection, see Connection.ID.
+	ID uint64
+	// When the handshake of the current session (see Connection.Upgrade)
+	// completed. Zero, if the Connection was not established by a
+	// handshake of its own.
+	Established time.Time
+	// When the last frame was read or written, zero, if none was.
+	LastRead, LastWrite time.Time
+	// The frames exchanged, including the rekeys.
+	Counters
+	// The nonces of the current keys, that is, the number of frames
+	// encrypted and successfully decrypted with them. A key must be
+	// replaced by a new handshake before its nonce reaches 2^64-1.
+	NonceOut, NonceIn uint64
+}
+
+func unixTime(n int64) time.Time {
+	if n==0 { return time.Time{} }
+	return time.Unix(0,n)
+}
+
+/*
+Returns a snapshot of the state of the session. May be called concurrently
+with reads and writes. Apart from the ID, zero before the handshake.
+*/
+func (c *Connection) Stats() (s Stats) {
+	s.ID = c.ID()
+	if c.session!=nil && c.session.Err==nil { s.Established = c.session.Start.Add(c.session.Duration) }
+	if w,ok := c.Writer.(*Writer); ok {
+		s.Counters.add(w.Counters())
+		s.LastWrite = unixTime(atomic.LoadInt64(&w.last))
+		s.NonceOut = atomic.LoadUint64(&w.nonces)
+	}
+	if r,ok := c.Reader.(*Reader); ok {
+		s.Counters.add(r.Counters())
+		s.LastRead = unixTime(atomic.LoadInt64(&r.last))
+		s.NonceIn = atomic.LoadUint64(&r.nonces)
+	}
+	return
+}
+
+/*
+A snapshot of the connections of a Server, see Server.Stats.
+*/
+type ServerStats struct{
+	// Connections established, that have not been closed yet.
+	Active int
+	// Connections established since the Server started.
+	Accepted uint64
+	// The frames exchanged over all connections, including the closed ones.
+	Counters
+	// The latest LastRead and LastWrite of the open connections.
+	LastRead, LastWrite time.Time
+}
+
+/*
+Returns a snapshot of the connections, that the Server established.
+Connections are counted as closed, once their Close method is called.
+*/
+func (s *Server) Stats() ServerStats {
+	s.lck.Lock(); defer s.lck.Unlock()
+	st := ServerStats{Active:len(s.live),Accepted:s.accepted,Counters:s.closed}
+	for c := range s.live {
+		cs := c.Stats()
+		st.Counters.add(cs.Counters)
+		if cs.LastRead.After(st.LastRead) { st.LastRead = cs.LastRead }
+		if cs.LastWrite.After(st.LastWrite) { st.LastWrite = cs.LastWrite }
+	}
+	return st
+}
+
+func (s *Server) track(c *Conn) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	if s.live==nil { s.live = make(map[*Conn]struct{}) }
+	s.live[c] = struct{}{}
+	s.accepted++
+	c.server = s
+}
+func (s *Server) forget(c *Conn) {
+	s.lck.Lock(); defer s.lck.Unlock()
+	if _,ok := s.live[c]; !ok { return }
+	delete(s.live,c)
+	s.closed.add(c.Counters())
+}
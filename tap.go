@@ -0,0 +1,67 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "fmt"
+import "io"
+import "sync"
+
+/*
+A TapFrame describes a single frame, as seen by Options.Tap.
+*/
+type TapFrame struct{
+	// True for frames sent, false for frames received.
+	Outgoing bool
+	// The ID of the Connection (or RPC codec), see Connection.ID.
+	ConnID uint64
+	// The number of the frame in its direction, starting at 0.
+	Seq uint64
+	// The plaintext of the frame, without padding. If Options.Typed or
+	// Options.Compression are set, it starts with the frame type and the
+	// compressor id respectively, and the payload may still be compressed.
+	Plaintext []byte
+	// The frame as written to or read from the Framer. Neither it nor
+	// Plaintext may be retained after the call.
+	Ciphertext []byte
+}
+
+func (t *TapFrame) String() string {
+	dir := "<-"
+	if t.Outgoing { dir = "->" }
+	return fmt.Sprintf("%s #%d %d bytes (%d encrypted): %q",dir,t.Seq,len(t.Plaintext),len(t.Ciphertext),t.Plaintext)
+}
+
+/*
+Returns a tap for Options.Tap, that writes a line for every frame to w.
+
+	o := &seep.Options{Tap: seep.TapWriter(os.Stderr)}
+*/
+func TapWriter(w io.Writer) func(*TapFrame) {
+	var lck sync.Mutex
+	return func(t *TapFrame) {
+		lck.Lock(); defer lck.Unlock()
+		fmt.Fprintln(w,t)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+OpenTelemetry tracing of seep handshakes. It lives in a package of its own,
+so that the seep package doesn't depend on the OpenTelemetry API.
+
+	import seepotel "github.com/mad-day/seep/tracing/otel"
+
+	o := &seep.Options{Trace:seepotel.Trace(otel.GetTracerProvider())}
+	c := &seep.Connection{Options:o,Context:ctx}
+	err := c.HandshakeStream(conn,conn,cfg)
+	// ... check error
+	// c.Context carries the span of the handshake; spans started from it
+	// are its children.
+*/
+package otel
+
+import "context"
+import "strings"
+import "github.com/mad-day/seep"
+import "go.opentelemetry.io/otel/attribute"
+import "go.opentelemetry.io/otel/codes"
+import "go.opentelemetry.io/otel/trace"
+
+const tracerName = "github.com/mad-day/seep"
+
+/*
+Returns a hook for Options.Trace, that records every handshake as a span
+named "seep.handshake", using a tracer of tp. The span has the attributes
+
+	seep.conn         the ID of the connection, see seep.Connection.ID
+	seep.protocol     the Noise protocol name
+	seep.pattern      the handshake pattern, e.g. "XX"
+	seep.suite        the cipher suite, e.g. "25519_ChaChaPoly_BLAKE2s"
+	seep.initiator    whether this side initiated the handshake
+	net.peer.address  the address of the peer, if known
+	seep.peer         the fingerprint of the peer's static key, if any
+
+and, if the handshake failed, the status Error and the error as an event.
+The duration of the handshake is the duration of the span.
+*/
+func Trace(tp trace.TracerProvider) func(context.Context, *seep.HandshakeAudit) func(*seep.HandshakeAudit) context.Context {
+	tracer := tp.Tracer(tracerName)
+	return func(ctx context.Context, a *seep.HandshakeAudit) func(*seep.HandshakeAudit) context.Context {
+		kind := trace.SpanKindServer
+		if a.Initiator { kind = trace.SpanKindClient }
+		attrs := []attribute.KeyValue{
+			attribute.Int64("seep.conn",int64(a.ConnID)),
+			attribute.String("seep.protocol",a.Protocol),
+			attribute.Bool("seep.initiator",a.Initiator),
+		}
+		if parts := strings.SplitN(a.Protocol,"_",3); len(parts)==3 {
+			attrs = append(attrs,attribute.String("seep.pattern",parts[1]),attribute.String("seep.suite",parts[2]))
+		}
+		if a.RemoteAddr!=nil { attrs = append(attrs,attribute.String("net.peer.address",a.RemoteAddr.String())) }
+		ctx,span := tracer.Start(ctx,"seep.handshake",trace.WithSpanKind(kind),trace.WithTimestamp(a.Start),trace.WithAttributes(attrs...))
+		return func(a *seep.HandshakeAudit) context.Context {
+			if a.PeerStatic!=nil { span.SetAttributes(attribute.String("seep.peer",seep.Fingerprint(a.PeerStatic))) }
+			if a.Err!=nil {
+				span.RecordError(a.Err)
+				span.SetStatus(codes.Error,a.Err.Error())
+			}
+			span.End(trace.WithTimestamp(a.Start.Add(a.Duration)))
+			return ctx
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+File transfer over SEEP sessions. The content is sent as a single message of
+the message API (see seep.Writer.NewMessage), so every chunk is authenticated
+on its own, the key is ratcheted after every chunk, and a transfer, that is cut
+short, is detected. A transfer, that was interrupted, resumes from the data the
+receiver already has, once the sender verified it against the hash of the
+same part of its file.
+
+	// On the sending side:
+	f,err := os.Open("backup.tar")
+	// ... check error
+	fi,_ := f.Stat()
+	err = new(transfer.Sender).Send(c.Connection,"backup.tar",f,fi.Size())
+
+	// On the receiving side:
+	r := &transfer.Receiver{Open: func(name string, size int64) (transfer.File,error) {
+		return os.OpenFile(filepath.Join(dir,filepath.Base(name)),os.O_RDWR|os.O_CREATE,0600)
+	}}
+	name,err := r.Receive(c.Connection)
+
+Both sides of the session must not use it for anything else during a
+transfer.
+*/
+package transfer
+
+import "crypto/sha256"
+import "encoding/binary"
+import "errors"
+import "io"
+import "github.com/mad-day/seep"
+
+var ErrRejected = errors.New("transfer: file rejected by the receiver")
+var ErrProtocol = errors.New("transfer: protocol violation")
+
+/*
+Returned, if the data received does not add up to the size of the file.
+*/
+var ErrSize = errors.New("transfer: size mismatch")
+
+/*
+The destination of a received file, such as an *os.File.
+*/
+type File interface{
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+const (
+	statusOK uint8 = iota
+	statusRejected
+)
+
+/*
+The size of the buffer, through which the content is copied, and thus the
+granularity of the progress reports.
+*/
+const bufSize = 64<<10
+
+/*
+Sends files. The zero value is ready to use.
+*/
+type Sender struct{
+	// If not nil, called as the content is sent, with the bytes the
+	// receiver has so far, including the part, the transfer resumed after.
+	Progress func(name string, done, total int64)
+}
+
+/*
+Sends the file with the given name (which is only passed on to the receiver)
+and size, whose content is read from f. Returns, once the receiver has
+confirmed it.
+*/
+func (s *Sender) Send(c *seep.Connection, name string, f io.ReaderAt, size int64) error {
+	w,r := c.Writer.(*seep.Writer),c.Reader.(*seep.Reader)
+	// The offer: the size, followed by the name.
+	offer := binary.BigEndian.AppendUint64(nil,uint64(size))
+	err := w.WriteMessage(append(offer,name...))
+	if err!=nil { return err }
+	// The answer: the status, the size of the part, the receiver has, and
+	// its hash.
+	p,err := r.ReadMessage()
+	if err!=nil { return err }
+	if len(p)!=1+8+sha256.Size { return ErrProtocol }
+	if p[0]!=statusOK { return ErrRejected }
+	off := int64(binary.BigEndian.Uint64(p[1:]))
+	if off<0 || off>size || !prefixMatches(f,off,p[9:]) { off = 0 }
+	err = w.WriteMessage(binary.BigEndian.AppendUint64(nil,uint64(off)))
+	if err!=nil { return err }
+	mw := w.NewMessage()
+	buf := make([]byte,bufSize)
+	var ferr error
+	for off<size && err==nil {
+		n := int64(len(buf))
+		if size-off<n { n = size-off }
+		var m int
+		m,ferr = f.ReadAt(buf[:n],off)
+		if m==int(n) { ferr = nil }
+		if ferr!=nil { break }
+		_,err = mw.Write(buf[:n])
+		off += n
+		if err==nil && s.Progress!=nil { s.Progress(name,off,size) }
+	}
+	if cerr := mw.Close(); err==nil { err = cerr }
+	if err!=nil { return err }
+	p,err = r.ReadMessage()
+	if ferr!=nil {
+		// The receiver found the content short and rejected it.
+		return ferr
+	}
+	if err!=nil { return err }
+	if len(p)!=1 { return ErrProtocol }
+	if p[0]!=statusOK { return ErrSize }
+	return nil
+}
+
+/*
+Reports, whether the first n bytes of f hash to sum.
+*/
+func prefixMatches(f io.ReaderAt, n int64, sum []byte) bool {
+	h := sha256.New()
+	_,err := io.Copy(h,io.NewSectionReader(f,0,n))
+	return err==nil && string(h.Sum(nil))==string(sum)
+}
+
+/*
+Receives files.
+*/
+type Receiver struct{
+	// Opens the destination of a file offered by the sender. The data, that
+	// is already in it, is kept, if it matches the beginning of the file,
+	// and the transfer resumes after it. An error rejects the file.
+	Open func(name string, size int64) (File,error)
+	// If not nil, called as the content is received, like Sender.Progress.
+	Progress func(name string, done, total int64)
+}
+
+/*
+Receives a file and returns its name. The File returned by Open is left open;
+a transfer, that fails, can be resumed.
+*/
+func (rc *Receiver) Receive(c *seep.Connection) (string,error) {
+	w,r := c.Writer.(*seep.Writer),c.Reader.(*seep.Reader)
+	p,err := r.ReadMessage()
+	if err!=nil { return "",err }
+	if len(p)<8 { return "",ErrProtocol }
+	size := int64(binary.BigEndian.Uint64(p))
+	name := string(p[8:])
+	if size<0 { return name,ErrProtocol }
+	f,err := rc.Open(name,size)
+	if err!=nil {
+		w.WriteMessage(append([]byte{statusRejected},make([]byte,8+sha256.Size)...))
+		return name,err
+	}
+	have,err := f.Seek(0,io.SeekEnd)
+	if err!=nil { return name,err }
+	if have>size { have = size }
+	h := sha256.New()
+	_,err = f.Seek(0,io.SeekStart)
+	if err==nil { _,err = io.CopyN(h,f,have) }
+	if err!=nil { return name,err }
+	answer := binary.BigEndian.AppendUint64([]byte{statusOK},uint64(have))
+	err = w.WriteMessage(h.Sum(answer))
+	if err!=nil { return name,err }
+	p,err = r.ReadMessage()
+	if err!=nil { return name,err }
+	if len(p)!=8 { return name,ErrProtocol }
+	off := int64(binary.BigEndian.Uint64(p))
+	if off<0 || off>have { return name,ErrProtocol }
+	err = f.Truncate(off)
+	if err==nil { _,err = f.Seek(off,io.SeekStart) }
+	if err!=nil { return name,err }
+	mr := r.NextMessage()
+	buf := make([]byte,bufSize)
+	for {
+		n,rerr := mr.Read(buf)
+		if off+int64(n)>size { return name,ErrSize }
+		if n>0 {
+			_,err = f.Write(buf[:n])
+			if err!=nil { return name,err }
+			off += int64(n)
+			if rc.Progress!=nil { rc.Progress(name,off,size) }
+		}
+		if rerr==io.EOF { break }
+		if rerr!=nil { return name,rerr }
+	}
+	status := statusOK
+	if off!=size { status = statusRejected }
+	err = w.WriteMessage([]byte{status})
+	if err!=nil { return name,err }
+	if off!=size { return name,ErrSize }
+	return name,nil
+}
@@ -0,0 +1,306 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tunnel
+
+import "encoding/binary"
+import "errors"
+import "io"
+import "net"
+import "os"
+import "strconv"
+import "syscall"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/mux"
+
+/*
+The metadata of a Stream, that asks the Exit to connect: "D", followed by the
+destination address. The Exit answers with a single byte, a SOCKS5 reply code.
+*/
+const metaDial = 'D'
+
+/*
+SOCKS5 reply codes (RFC 1928), also used between SOCKS and Exit.
+*/
+const (
+	socksOK uint8 = iota
+	socksFailure
+	socksNotAllowed
+	socksNetUnreachable
+	socksHostUnreachable
+	socksRefused
+	socksTTLExpired
+	socksCommandUnsupported
+	socksAddressUnsupported
+)
+
+/*
+Returned by SOCKS.Dial, if the Exit could not connect. The value is a SOCKS5
+reply code.
+*/
+type ExitError uint8
+
+var exitErrors = []string{
+	socksFailure: "general failure",
+	socksNotAllowed: "connection not allowed",
+	socksNetUnreachable: "network unreachable",
+	socksHostUnreachable: "host unreachable",
+	socksRefused: "connection refused",
+	socksTTLExpired: "TTL expired",
+	socksCommandUnsupported: "command not supported",
+	socksAddressUnsupported: "address type not supported",
+}
+
+func (e ExitError) Error() string {
+	if int(e)<len(exitErrors) && exitErrors[e]!="" { return "tunnel: exit: "+exitErrors[e] }
+	return "tunnel: exit: error "+strconv.Itoa(int(e))
+}
+
+var ErrSOCKSVersion = errors.New("tunnel: not a SOCKS5 client")
+
+/*
+Returned, if the SOCKS client offers no authentication method, but "none".
+*/
+var ErrSOCKSAuth = errors.New("tunnel: no acceptable SOCKS5 authentication method")
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The exit node: connects to the destinations, that the SOCKS side asks for, and
+relays the connections.
+*/
+type Exit struct{
+	// Decides, whether the peer with the static key peer (nil, if it has
+	// none) may connect to addr. Nil allows every peer to connect to any
+	// destination, including those, that only the exit node can reach.
+	Allow func(peer []byte, addr string) error
+	// Connects to the destinations, net.Dial, if nil.
+	Dial func(network, addr string) (net.Conn,error)
+}
+
+/*
+Accepts sessions from l, typically a seep.Server, and serves each of them, until
+l fails. Connections, that are not *seep.Conn, are closed.
+*/
+func (e *Exit) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go e.ServeConn(sc)
+	}
+}
+
+/*
+Serves the connection requests of a SOCKS side, until the session ends.
+*/
+func (e *Exit) ServeConn(c *seep.Conn) error {
+	peer := c.Session().PeerStatic
+	sess := mux.New(c)
+	for {
+		st,err := sess.Accept()
+		if err!=nil { return sess.Err() }
+		meta := st.Meta()
+		if len(meta)==0 || meta[0]!=metaDial {
+			st.Close()
+			continue
+		}
+		go e.connect(st,peer,string(meta[1:]))
+	}
+}
+
+func (e *Exit) connect(st *mux.Stream, peer []byte, addr string) {
+	var err error
+	if e.Allow!=nil { err = e.Allow(peer,addr) }
+	if err!=nil {
+		st.Write([]byte{socksNotAllowed})
+		st.Close()
+		return
+	}
+	dial := e.Dial
+	if dial==nil { dial = net.Dial }
+	conn,err := dial("tcp",addr)
+	if err!=nil {
+		st.Write([]byte{replyCode(err)})
+		st.Close()
+		return
+	}
+	_,err = st.Write([]byte{socksOK})
+	if err!=nil { conn.Close(); st.Close(); return }
+	join(st,conn)
+}
+
+/*
+Maps an error of Dial to a SOCKS5 reply code.
+*/
+func replyCode(err error) uint8 {
+	var dns *net.DNSError
+	switch {
+	case errors.Is(err,syscall.ECONNREFUSED): return socksRefused
+	case errors.Is(err,syscall.ENETUNREACH): return socksNetUnreachable
+	case errors.Is(err,syscall.EHOSTUNREACH), errors.As(err,&dns): return socksHostUnreachable
+	case errors.Is(err,os.ErrDeadlineExceeded): return socksTTLExpired
+	}
+	return socksFailure
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The SOCKS side: a SOCKS5 server (CONNECT only, without authentication), whose
+connections are carried through the session to an Exit, that connects them to
+their destinations.
+
+	c,err := seep.Dial("tcp","exit.example.com:7000",cfg,nil)
+	// ... check error
+	l,err := net.Listen("tcp","127.0.0.1:1080")
+	// ... check error
+	err = tunnel.NewSOCKS(c).Serve(l)
+*/
+type SOCKS struct{
+	sess *mux.Session
+}
+
+/*
+Starts a SOCKS side on an established session with the Exit. The SOCKS owns c
+from now on.
+*/
+func NewSOCKS(c *seep.Conn) *SOCKS {
+	return &SOCKS{sess:mux.New(c)}
+}
+
+/*
+Connects to addr through the Exit. Only "tcp" is supported.
+*/
+func (s *SOCKS) Dial(network, addr string) (net.Conn,error) {
+	if network!="tcp" { return nil,ExitError(socksCommandUnsupported) }
+	st,err := s.sess.Open(append([]byte{metaDial},addr...))
+	if err!=nil { return nil,err }
+	var code [1]byte
+	_,err = io.ReadFull(st,code[:])
+	if err!=nil { st.Close(); return nil,err }
+	if code[0]!=socksOK { st.Close(); return nil,ExitError(code[0]) }
+	return st,nil
+}
+
+/*
+Serves SOCKS5 clients from l, until l or the session fails.
+*/
+func (s *SOCKS) Serve(l net.Listener) error {
+	go func() {
+		<-s.sess.Done()
+		l.Close()
+	}()
+	for {
+		c,err := l.Accept()
+		if err!=nil {
+			if serr := s.sess.Err(); serr!=nil { return serr }
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+/*
+Serves a single SOCKS5 client, and closes c.
+*/
+func (s *SOCKS) ServeConn(c net.Conn) error {
+	addr,err := socksRequest(c)
+	if err!=nil {
+		var code ExitError
+		if errors.As(err,&code) { socksReply(c,uint8(code)) }
+		c.Close()
+		return err
+	}
+	st,err := s.Dial("tcp",addr)
+	if err!=nil {
+		code := ExitError(socksFailure)
+		errors.As(err,&code)
+		socksReply(c,uint8(code))
+		c.Close()
+		return err
+	}
+	err = socksReply(c,socksOK)
+	if err!=nil { c.Close(); st.Close(); return err }
+	join(c,st)
+	return nil
+}
+
+/*
+Reads the method negotiation and the request of a SOCKS5 client, and returns
+the destination.
+*/
+func socksRequest(c net.Conn) (string,error) {
+	var b [262]byte
+	_,err := io.ReadFull(c,b[:2])
+	if err!=nil { return "",err }
+	if b[0]!=5 { return "",ErrSOCKSVersion }
+	methods := b[2:2+int(b[1])]
+	_,err = io.ReadFull(c,methods)
+	if err!=nil { return "",err }
+	none := false
+	for _,m := range methods { if m==0 { none = true } }
+	if !none {
+		c.Write([]byte{5,0xff})
+		return "",ErrSOCKSAuth
+	}
+	_,err = c.Write([]byte{5,0})
+	if err!=nil { return "",err }
+	// VER CMD RSV ATYP
+	_,err = io.ReadFull(c,b[:4])
+	if err!=nil { return "",err }
+	if b[0]!=5 { return "",ErrSOCKSVersion }
+	var host string
+	switch b[3] {
+	case 1, 4:
+		n := net.IPv4len
+		if b[3]==4 { n = net.IPv6len }
+		_,err = io.ReadFull(c,b[4:4+n])
+		host = net.IP(b[4:4+n]).String()
+	case 3:
+		_,err = io.ReadFull(c,b[4:5])
+		if err==nil {
+			_,err = io.ReadFull(c,b[5:5+int(b[4])])
+			host = string(b[5:5+int(b[4])])
+		}
+	default:
+		return "",ExitError(socksAddressUnsupported)
+	}
+	if err!=nil { return "",err }
+	var port [2]byte
+	_,err = io.ReadFull(c,port[:])
+	if err!=nil { return "",err }
+	// Only CONNECT.
+	if b[1]!=1 { return "",ExitError(socksCommandUnsupported) }
+	return net.JoinHostPort(host,strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))),nil
+}
+
+/*
+Sends a reply to a SOCKS5 request, with an unspecified bound address.
+*/
+func socksReply(c net.Conn, code uint8) error {
+	_,err := c.Write([]byte{5,code,0,1,0,0,0,0,0,0})
+	return err
+}
@@ -0,0 +1,304 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Remote port forwarding over SEEP, like "ssh -R": a client, that may sit behind
+a NAT, opens a session to a public Server and asks it to listen on a port;
+every connection to that port is tunneled back through the session, and the
+client connects it to a local address.
+
+	// On the public host:
+	srv := &tunnel.Server{Allow: func(peer []byte, addr string) error { ... }}
+	go srv.Serve(seepServer)
+
+	// Behind the NAT:
+	c,err := seep.Dial("tcp","public.example.com:7000",cfg,nil)
+	// ... check error
+	client := tunnel.NewClient(c)
+	f,err := client.Forward(":8080","localhost:80")
+
+The connections are carried by Streams of a mux.Session. A forwarding is
+requested on a Stream of its own, whose metadata is "L" and the address to
+listen on; the Server answers with a line "ok <bound address>" or
+"error <reason>", and stops listening, once the Stream ends. The Streams of
+the tunneled connections have "C", the bound address, a zero byte and the
+address of the originator as metadata.
+
+The same way, an Exit connects to the destinations of a local SOCKS5 server
+(see SOCKS), so that a session makes an encrypted proxy.
+*/
+package tunnel
+
+import "bufio"
+import "bytes"
+import "errors"
+import "io"
+import "net"
+import "strings"
+import "sync"
+import "github.com/mad-day/seep"
+import "github.com/mad-day/seep/mux"
+
+/*
+A reason for Server.Allow to refuse a forwarding.
+*/
+var ErrDenied = errors.New("tunnel: forwarding denied")
+
+/*
+Returned by Forward with the reason, the Server gave for refusing it.
+*/
+type ServerError string
+func (e ServerError) Error() string { return "tunnel: server error: "+string(e) }
+
+const (
+	metaListen = 'L'
+	metaConn = 'C'
+)
+
+/*
+Ends the sending direction of c, if it can.
+*/
+func closeWrite(c net.Conn) {
+	if cw,ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+/*
+Copies between a and b in both directions, passing half-closes on, until both
+are done, and closes them.
+*/
+func join(a, b net.Conn) {
+	done := make(chan struct{},2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst,src)
+		closeWrite(dst)
+		done <- struct{}{}
+	}
+	go cp(a,b)
+	go cp(b,a)
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The public side of the tunnels.
+*/
+type Server struct{
+	// Decides, whether the peer with the static key peer (nil, if it has
+	// none) may listen on addr. Nil allows every peer to listen on any
+	// address; set it, unless all peers are trusted.
+	Allow func(peer []byte, addr string) error
+	// The network of the listeners, "tcp" if empty.
+	Network string
+}
+
+/*
+Accepts sessions from l, typically a seep.Server, and serves each of them, until
+l fails. Connections, that are not *seep.Conn, are closed.
+*/
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c,err := l.Accept()
+		if err!=nil { return err }
+		sc,ok := c.(*seep.Conn)
+		if !ok { c.Close(); continue }
+		go s.ServeConn(sc)
+	}
+}
+
+/*
+Serves the forwarding requests of a client, until the session ends, and
+stops all of its listeners.
+*/
+func (s *Server) ServeConn(c *seep.Conn) error {
+	peer := c.Session().PeerStatic
+	sess := mux.New(c)
+	for {
+		st,err := sess.Accept()
+		if err!=nil { return sess.Err() }
+		meta := st.Meta()
+		if len(meta)==0 || meta[0]!=metaListen {
+			st.Close()
+			continue
+		}
+		go s.listen(sess,st,peer,string(meta[1:]))
+	}
+}
+
+func (s *Server) listen(sess *mux.Session, ctl *mux.Stream, peer []byte, addr string) {
+	defer ctl.Close()
+	var err error
+	if s.Allow!=nil { err = s.Allow(peer,addr) }
+	var l net.Listener
+	if err==nil {
+		network := s.Network
+		if network=="" { network = "tcp" }
+		l,err = net.Listen(network,addr)
+	}
+	if err!=nil {
+		io.WriteString(ctl,"error "+strings.ReplaceAll(err.Error(),"\n"," ")+"\n")
+		ctl.CloseWrite()
+		io.Copy(io.Discard,ctl)
+		return
+	}
+	bound := l.Addr().String()
+	_,err = io.WriteString(ctl,"ok "+bound+"\n")
+	if err!=nil { l.Close(); return }
+	go func() {
+		// The forwarding ends with the control Stream, or the session.
+		io.Copy(io.Discard,ctl)
+		l.Close()
+	}()
+	for {
+		conn,err := l.Accept()
+		if err!=nil { return }
+		go func() {
+			meta := append([]byte{metaConn},bound...)
+			meta = append(append(meta,0),conn.RemoteAddr().String()...)
+			st,err := sess.Open(meta)
+			if err!=nil { conn.Close(); return }
+			join(conn,st)
+		}()
+	}
+}
+
+/* ------------------------------------------------------------------------- */
+
+/*
+The side of the tunnels behind the NAT.
+*/
+type Client struct{
+	sess *mux.Session
+	// The network of the local connections, "tcp" if empty. Set it before
+	// the first call to Forward.
+	Network string
+	lck sync.Mutex
+	// The local addresses, by the bound addresses of the forwardings.
+	forwards map[string]string
+}
+
+/*
+A forwarding established by Client.Forward.
+*/
+type Forward struct{
+	// The address, the Server listens on.
+	Addr string
+	// The address, the connections are forwarded to.
+	Local string
+	c *Client
+	ctl *mux.Stream
+}
+
+/*
+Starts a Client on an established session with the Server. The Client owns c
+from now on.
+*/
+func NewClient(c *seep.Conn) *Client {
+	cl := &Client{sess:mux.New(c),forwards:make(map[string]string)}
+	go cl.accept()
+	return cl
+}
+
+func (c *Client) accept() {
+	for {
+		st,err := c.sess.Accept()
+		if err!=nil { return }
+		meta := st.Meta()
+		i := bytes.IndexByte(meta,0)
+		if len(meta)==0 || meta[0]!=metaConn || i<0 {
+			st.Close()
+			continue
+		}
+		c.lck.Lock()
+		local,ok := c.forwards[string(meta[1:i])]
+		c.lck.Unlock()
+		if !ok {
+			st.Close()
+			continue
+		}
+		go func() {
+			network := c.Network
+			if network=="" { network = "tcp" }
+			conn,err := net.Dial(network,local)
+			if err!=nil { st.Close(); return }
+			join(st,conn)
+		}()
+	}
+}
+
+/*
+Asks the Server to listen on remote, and to tunnel the connections to it back
+to this side, where they are connected to local.
+*/
+func (c *Client) Forward(remote, local string) (*Forward,error) {
+	st,err := c.sess.Open(append([]byte{metaListen},remote...))
+	if err!=nil { return nil,err }
+	line,err := bufio.NewReader(st).ReadString('\n')
+	if err!=nil {
+		st.Close()
+		return nil,err
+	}
+	line = strings.TrimSuffix(line,"\n")
+	if !strings.HasPrefix(line,"ok ") {
+		st.Close()
+		return nil,ServerError(strings.TrimPrefix(line,"error "))
+	}
+	f := &Forward{Addr:line[3:],Local:local,c:c,ctl:st}
+	c.lck.Lock()
+	c.forwards[f.Addr] = local
+	c.lck.Unlock()
+	return f,nil
+}
+
+/*
+Stops the forwarding. Connections already tunneled stay open.
+*/
+func (f *Forward) Close() error {
+	f.c.lck.Lock()
+	delete(f.c.forwards,f.Addr)
+	f.c.lck.Unlock()
+	return f.ctl.Close()
+}
+
+/*
+Waits for the session to end, and returns the reason.
+*/
+func (c *Client) Wait() error {
+	<-c.sess.Done()
+	return c.sess.Err()
+}
+
+/*
+Ends the session, with all forwardings and tunneled connections.
+*/
+func (c *Client) Close() error {
+	return c.sess.Close()
+}
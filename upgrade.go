@@ -0,0 +1,99 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "sync/atomic"
+import "time"
+import "github.com/flynn/noise"
+
+/*
+Exchanges handshake messages as data frames of an established session.
+*/
+type tunnelFramer struct{
+	w *frameWriter
+	r *frameReader
+}
+func (t *tunnelFramer) ReadFrame(max int) ([]byte,error) {
+	buf,err := t.r.readFrame()
+	if err!=nil { return nil,err }
+	if max>0 && len(buf)>max { return nil,ErrFrameTooLarge }
+	return buf,nil
+}
+func (t *tunnelFramer) WriteFrame(p []byte) error {
+	return t.w.writeFrame(p,CompressionNone)
+}
+
+/*
+Upgrades an established session in-band: a new handshake described by nc
+(for instance XX, after an anonymous NN handshake) is performed inside the
+existing encrypted channel, and both directions switch to the new keys
+afterwards. The new handshake is bound to the current one by prefixing its
+prologue with the current handshake hash.
+
+Both sides must call Upgrade at the same position in the stream, which is up
+to the application protocol, and neither Read nor Write may be used while
+the upgrade is in progress. Data read from the peer before, but not yet
+consumed, remains readable. The Payloads of the Connection are not updated.
+*/
+func (c *Connection) Upgrade(nc noise.Config) error {
+	w,ok := c.Writer.(*Writer)
+	if !ok { return ErrNotEstablished }
+	r,ok := c.Reader.(*Reader)
+	if !ok { return ErrNotEstablished }
+	w.lck.Lock(); defer w.lck.Unlock()
+	r.lck.Lock(); defer r.lck.Unlock()
+	err := w.flush()
+	if err!=nil { return err }
+	prologue := append([]byte("seep-upgrade"),c.hash...)
+	nc.Prologue = append(prologue,nc.Prologue...)
+	err = checkConfig(w.opts,nc)
+	if err!=nil { return err }
+	audit,verify := startAudit(w.opts,c.ID(),c.RemoteAddr,nc)
+	trace := c.trace(w.opts,audit)
+	hs,enc,dec,err := runHandshake(&tunnelFramer{&w.frameWriter,&r.frameReader},nc,nil,nil,verify,nil)
+	log := audit.finish(w.opts,hs,err)
+	trace(audit)
+	if err!=nil { return err }
+	logKeys(w.opts.KeyLogWriter,w.opts.KeyLogStatic,hs,nc)
+	burnEphemeral(hs)
+	c.hash = hs.ChannelBinding()
+	setInner(&w.frameWriter,&r.frameReader,w.opts,nc,c.hash)
+	c.initiator = nc.Initiator
+	c.session = audit
+	w.enc = enc
+	w.nonce = enc.Nonce()
+	atomic.StoreUint64(&w.nonces,0)
+	w.keyTime = time.Now()
+	r.dec = dec
+	r.nonce = dec.Nonce()
+	r.gen++
+	if r.opener!=nil { r.opener.guess(&r.frameReader) }
+	atomic.StoreUint64(&r.nonces,0)
+	r.keyTime = w.keyTime
+	r.audit = audit
+	w.log,r.log = log,log
+	r.failures = 0
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package seep
+
+import "bytes"
+import "crypto/rand"
+import "io"
+import "testing"
+import "github.com/davecgh/go-xdr/xdr2"
+import "github.com/flynn/noise"
+
+/*
+Returns the CipherStates of both directions after an NN handshake: the
+initiator's sending state and the responder's receiving state, and the
+other way round.
+*/
+func testCipherStates(t *testing.T) (ienc,rdec,renc,idec *noise.CipherState) {
+	t.Helper()
+	hi := newHandshakeState(noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader,Initiator:true})
+	hr := newHandshakeState(noise.Config{CipherSuite:testSuite,Pattern:noise.HandshakeNN,Random:rand.Reader})
+	m,_,_ := hi.WriteMessage(nil,nil)
+	_,_,_,err := hr.ReadMessage(nil,m)
+	if err!=nil { t.Fatal(err) }
+	m,rc1,rc2 := hr.WriteMessage(nil,nil)
+	_,ic1,ic2,err := hi.ReadMessage(nil,m)
+	if err!=nil { t.Fatal(err) }
+	return ic1,rc1,rc2,ic2
+}
+
+/*
+Writers and Readers with CryptoWorkers over CipherStates, that have been used
+before, continue with their nonces.
+*/
+func TestWorkersUsedCipherState(t *testing.T) {
+	for _,c := range []struct{ w,r Options }{
+		{Options{CryptoWorkers:2},Options{}},
+		{Options{},Options{CryptoWorkers:2}},
+		{Options{CryptoWorkers:2},Options{CryptoWorkers:3}},
+	} {
+		enc,dec,_,_ := testCipherStates(t)
+		// The first nonce is used up outside of the Writer and Reader. A side
+		// reusing it fails to talk to a side without CryptoWorkers.
+		ct := enc.Encrypt(nil,nil,[]byte("used"))
+		_,err := dec.Decrypt(nil,nil,ct)
+		if err!=nil { t.Fatal(err) }
+		var buf bytes.Buffer
+		w := NewWriterOptions(xdr.NewEncoder(&buf),enc,&c.w)
+		msgs := [][]byte{testData(100),testData(5000),testData(1)}
+		for _,m := range msgs {
+			_,err = w.Write(m)
+			if err!=nil { t.Fatal(err) }
+		}
+		err = w.Flush()
+		if err!=nil { t.Fatal(err) }
+		r := NewReaderOptions(xdr.NewDecoder(&buf),dec,&c.r)
+		for i,m := range msgs {
+			got := make([]byte,len(m))
+			_,err = io.ReadFull(r,got)
+			if err!=nil || !bytes.Equal(got,m) { t.Fatalf("%+v: message %d: %v",c,i,err) }
+		}
+	}
+}
+
+/*
+Round trips data, rekeys and chunked messages over every combination of
+CryptoWorkers on either side, Sequenced and ReadAhead.
+*/
+func TestWorkersRoundTrip(t *testing.T) {
+	type step struct{
+		data []byte
+		msg bool
+		rekey bool
+	}
+	steps := []step{
+		{data:testData(100)},
+		{rekey:true},
+		{data:testData(300000)},
+		{data:testData(150000),msg:true},
+		{data:testData(7)},
+		{rekey:true},
+		{data:testData(70000),msg:true},
+		{rekey:true},
+		{data:nil,msg:true},
+		{data:testData(5000)},
+	}
+	for _,wo := range []int{0,2,4} {
+		for _,ro := range []int{0,2,4} {
+			for _,seq := range []bool{false,true} {
+				for _,ahead := range []bool{false,true} {
+					ow := Options{Typed:true,Sequenced:seq,CryptoWorkers:wo}
+					or := Options{Typed:true,Sequenced:seq,CryptoWorkers:ro,ReadAhead:ahead}
+					a,b := testPair(t,ow,or)
+					errs := make(chan error,1)
+					go func() {
+						w := a.Writer.(*Writer)
+						var err error
+						for _,s := range steps {
+							switch {
+							case s.rekey: err = w.Rekey()
+							case s.msg:
+								mw := w.NewMessage()
+								_,err = mw.Write(s.data)
+								if err==nil { err = mw.Close() }
+							default: _,err = w.Write(s.data)
+							}
+							if err==nil { err = w.Flush() }
+							if err!=nil { break }
+						}
+						errs <- err
+					}()
+					r := b.Reader.(*Reader)
+					for i,s := range steps {
+						var got []byte
+						var err error
+						switch {
+						case s.rekey: continue
+						case s.msg: got,err = io.ReadAll(r.NextMessage())
+						default:
+							got = make([]byte,len(s.data))
+							_,err = io.ReadFull(r,got)
+						}
+						if err!=nil || !bytes.Equal(got,s.data) { t.Fatalf("%+v/%+v: step %d: %v",ow,or,i,err) }
+					}
+					if err := <-errs; err!=nil { t.Fatalf("%+v/%+v: %v",ow,or,err) }
+				}
+			}
+		}
+	}
+}